@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	stdLog "log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"go.uber.org/zap"
 
@@ -16,6 +19,13 @@ import (
 
 var configPath = flag.String("config", "", "path to config file")
 
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -config <path> [migrate up|down|status|version]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
 func main() {
 	flag.Parse()
 
@@ -43,13 +53,62 @@ func main() {
 	traced := log.NewZapTracedLogger(sLog)
 
 	bootstrapCtx := ac.WithTraceID(context.Background(), "bootstrap")
+	if flag.Arg(0) == "migrate" {
+		os.Exit(migrate(bootstrapCtx, conf, traced))
+	}
+
 	if a, err = app.NewApp(bootstrapCtx, conf, traced); err != nil {
 		traced.Errorw(bootstrapCtx, "Create app", err)
 		os.Exit(1)
 	}
 
-	if err = a.Run(ac.WithTraceID(context.Background(), "runtime")); err != nil {
+	runCtx, stop := signal.NotifyContext(ac.WithTraceID(context.Background(), "runtime"), os.Interrupt, syscall.SIGTERM)
+	err = a.Run(runCtx)
+	stop()
+	if err != nil {
 		traced.Errorw(bootstrapCtx, "Run", err)
 		os.Exit(1)
 	}
 }
+
+// migrate runs "migrate" command and returns exit code
+func migrate(ctx context.Context, conf config.App, traced log.TracedLogger) int {
+	if flag.NArg() != 2 {
+		flag.Usage()
+		return 2
+	}
+
+	command := flag.Arg(1)
+	version, err := app.Migrate(ctx, conf.DB, command, traced)
+	if err != nil {
+		traced.Errorw(ctx, "Migrate", "command", command, err)
+		return 1
+	}
+
+	if command == app.MigrateVersion {
+		fmt.Println(version.Version)
+		return 0
+	}
+
+	switch {
+	case version.Dirty:
+		fmt.Printf("schema version %d is dirty, migration failed and has to be fixed manually\n", version.Version)
+	case version.Version == version.Latest:
+		fmt.Printf("schema version %d is up to date\n", version.Version)
+	default:
+		fmt.Printf("schema version %d, latest migration is %d\n", version.Version, version.Latest)
+	}
+	if command == app.MigrateStatus {
+		for _, m := range version.Migrations {
+			state := "pending"
+			switch {
+			case m.Version == version.Version && version.Dirty:
+				state = "failed"
+			case m.Version <= version.Version:
+				state = "applied"
+			}
+			fmt.Printf("%-8s %d %s\n", state, m.Version, m.Name)
+		}
+	}
+	return 0
+}
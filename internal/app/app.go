@@ -2,7 +2,6 @@ package app
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
@@ -10,6 +9,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/Roma7-7-7/shared-clipboard/internal/config"
 	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
@@ -18,24 +18,27 @@ import (
 	"github.com/Roma7-7-7/shared-clipboard/internal/handle/cookie"
 	"github.com/Roma7-7-7/shared-clipboard/internal/handle/jwt"
 	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+	"github.com/Roma7-7-7/shared-clipboard/internal/mailer"
 )
 
 type (
 	App struct {
-		port int
-		mux  *chi.Mux
-		log  log.TracedLogger
+		port              int
+		mux               *chi.Mux
+		streams           *handle.Streams
+		clipboardNotifier *domain.ClipboardNotifier
+		log               log.TracedLogger
 	}
 )
 
 func NewApp(ctx context.Context, conf config.App, traced log.TracedLogger) (*App, error) {
-	dbURL := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		conf.DB.Host, conf.DB.Port, conf.DB.User, conf.DB.Password, conf.DB.Name, conf.DB.SSLMode,
-	)
 	traced.Infow(ctx, "Initializing SQL DB", "host", conf.DB.Host, "port", conf.DB.Port, "name", conf.DB.Name)
-	sqlDB, err := sql.Open(conf.DB.Driver, dbURL)
+	sqlDB, err := openDB(conf.DB)
 	if err != nil {
-		return nil, fmt.Errorf("open sql db: %w", err)
+		return nil, err
+	}
+	if err = prepareSchema(ctx, sqlDB, conf.DB.AutoMigrate, traced); err != nil {
+		return nil, err
 	}
 
 	redis := redis.NewClient(&redis.Options{
@@ -55,32 +58,193 @@ func NewApp(ctx context.Context, conf config.App, traced log.TracedLogger) (*App
 	if err != nil {
 		return nil, fmt.Errorf("create session repository: %w", err)
 	}
+	memberRepo, err := dal.NewSessionMemberRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create session member repository: %w", err)
+	}
+	clipboardRepo, err := dal.NewClipboardRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create clipboard repository: %w", err)
+	}
+	shareLinkRepo, err := dal.NewShareLinkRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create share link repository: %w", err)
+	}
+	apiTokenRepo, err := dal.NewAPITokenRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create api token repository: %w", err)
+	}
+	refreshTokenRepo, err := dal.NewRefreshTokenRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create refresh token repository: %w", err)
+	}
+	loginRepo, err := dal.NewLoginRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create login repository: %w", err)
+	}
+	twoFactorRepo, err := dal.NewTwoFactorRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create two factor repository: %w", err)
+	}
+	userIdentityRepo, err := dal.NewUserIdentityRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create user identity repository: %w", err)
+	}
+	passwordResetRepo, err := dal.NewPasswordResetRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create password reset repository: %w", err)
+	}
+	invitationRepo, err := dal.NewInvitationRepository(sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation repository: %w", err)
+	}
 	traced.Infow(ctx, "Initializing services")
-	userService := domain.NewUserService(userRpo, traced)
+	passwordHashers := domain.NewPasswordHashers(
+		domain.NewArgon2idHasher(domain.Argon2idParams{
+			MemoryKiB:   conf.Password.MemoryKiB,
+			Iterations:  conf.Password.Iterations,
+			Parallelism: conf.Password.Parallelism,
+		}),
+		domain.NewBcryptHasher(bcrypt.DefaultCost),
+	)
+	userService := domain.NewUserService(userRpo, invitationRepo, conf.Registration.Mode, passwordHashers, traced)
+	refreshTokenService := domain.NewRefreshTokenService(refreshTokenRepo, userRpo, time.Duration(conf.RefreshToken.ExpireInHours)*time.Hour, traced)
+	jtiService := domain.NewJTIService(redis, traced)
+	loginService := domain.NewLoginService(loginRepo, refreshTokenRepo, userRpo, jtiService, traced)
+	var mail domain.Mailer
+	switch conf.Mail.Driver {
+	case config.MailDriverSMTP:
+		mail = mailer.NewSMTP(conf.Mail.SMTP, conf.Mail.From)
+	case config.MailDriverLog:
+		traced.Infow(ctx, "Mail is written to the log instead of being sent")
+		mail = mailer.NewLog(traced)
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %q", conf.Mail.Driver)
+	}
+	passwordResetService := domain.NewPasswordResetService(
+		passwordResetRepo, userRpo, userService, loginService, mail,
+		conf.PasswordReset.URL, time.Duration(conf.PasswordReset.ExpireInMinutes)*time.Minute, traced,
+	)
+	twoFactorService := domain.NewTwoFactorService(
+		twoFactorRepo, redis, conf.TwoFactor.Issuer, time.Duration(conf.TwoFactor.ChallengeExpireInMinutes)*time.Minute, traced,
+	)
+	signInLimiter := domain.NewSignInLimiter(redis, domain.SignInLimiterConfig{
+		FreeAttemptsPerName: int64(conf.SignIn.FreeAttemptsPerName),
+		FreeAttemptsPerIP:   int64(conf.SignIn.FreeAttemptsPerIP),
+		MaxLockout:          time.Duration(conf.SignIn.MaxLockoutMinutes) * time.Minute,
+		Window:              time.Duration(conf.SignIn.FailuresWindowMinutes) * time.Minute,
+	})
+	oidcProviders := make([]domain.OIDCProviderConfig, 0, len(conf.OIDC.Providers))
+	for _, p := range conf.OIDC.Providers {
+		oidcProviders = append(oidcProviders, domain.OIDCProviderConfig{
+			Name:         p.Name,
+			IssuerURL:    p.IssuerURL,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Scopes:       p.Scopes,
+		})
+	}
+	oidcService := domain.NewOIDCService(
+		oidcProviders, conf.OIDC.CallbackURL, conf.Registration.Mode == config.RegistrationModeOpen, userIdentityRepo, userRpo, redis, traced,
+	)
 
 	traced.Infow(ctx, "Initializing components")
-	jwtProcessor := jwt.NewProcessor(conf.JWT)
+	jwtProcessor, err := jwt.NewProcessor(conf.JWT)
+	if err != nil {
+		return nil, fmt.Errorf("create jwt processor: %w", err)
+	}
+	if len(conf.JWT.Keys) == 0 {
+		if !conf.Dev {
+			// shipped secret is public, anyone could sign access tokens with it
+			return nil, errors.New("JWT keys are not configured, signing with HS256 secret is only allowed in dev mode")
+		}
+		traced.Infow(ctx, "JWT keys are not configured, signing access tokens with HS256 secret")
+	}
 	cookieProcessor := cookie.NewProcessor(jwtProcessor, conf.Cookie)
 
-	sessionService := domain.NewSessionService(sessionRepo, traced)
+	maxRetention := time.Duration(conf.Clipboard.MaxRetentionHours) * time.Hour
+	clipboardNotifier := domain.NewClipboardNotifier(redis, traced)
+	contentPolicy, err := domain.NewContentPolicy(conf.Clipboard.AllowedContentTypes, conf.Clipboard.MaxContentBytes)
+	if err != nil {
+		return nil, fmt.Errorf("create content policy: %w", err)
+	}
+	var clipboardStore domain.ClipboardStore
+	switch conf.Clipboard.Storage {
+	case config.ClipboardStorageRedis:
+		clipboardStore = domain.NewRedisClipboardStore(redis, conf.Clipboard.MaxHistory, traced)
+	case config.ClipboardStoragePostgres:
+		clipboardStore = domain.NewPostgresClipboardStore(clipboardRepo, conf.Clipboard.MaxHistory, traced)
+	case config.ClipboardStorageCached:
+		clipboardStore = domain.NewCachedClipboardStore(
+			domain.NewPostgresClipboardStore(clipboardRepo, conf.Clipboard.MaxHistory, traced),
+			domain.NewRedisClipboardCache(redis, traced),
+			traced,
+		)
+	default:
+		return nil, fmt.Errorf("unknown clipboard storage: %q", conf.Clipboard.Storage)
+	}
+	sessionService := domain.NewSessionService(sessionRepo, memberRepo, userRpo, clipboardStore, maxRetention, traced)
+	shareLinkService := domain.NewShareLinkService(
+		shareLinkRepo, sessionRepo, sessionService, redis,
+		time.Duration(conf.ShareLink.DefaultExpireInMinutes)*time.Minute, time.Duration(conf.ShareLink.MaxExpireInMinutes)*time.Minute,
+		conf.ShareLink.MaxPasswordFailures, traced,
+	)
+	accountRemover := domain.NewAccountRemover(userRpo, clipboardStore, traced)
+	adminService := domain.NewAdminService(userRpo, sessionRepo, clipboardStore, loginService, passwordResetService, accountRemover, traced)
+	for _, name := range conf.Admin.Names {
+		// failure is not fatal, admins are promoted on the next start
+		if err = adminService.Promote(ctx, name); err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				traced.Infow(ctx, "Admin user has not signed up yet", "name", name)
+				continue
+			}
+			traced.Errorw(ctx, "Failed to promote admin user", "name", name, err)
+		}
+	}
+	invitationService := domain.NewInvitationService(invitationRepo, adminService, domain.InvitationConfig{
+		UsersCanInvite: conf.Registration.UsersCanInvite,
+		MaxUses:        conf.Registration.InvitationMaxUses,
+		MaxExpireIn:    time.Duration(conf.Registration.InvitationMaxExpireInHours) * time.Hour,
+	}, traced)
+	accountService := domain.NewAccountService(
+		userService, accountRemover, userRpo, userIdentityRepo, twoFactorRepo, apiTokenRepo, loginRepo, sessionRepo, invitationRepo,
+		clipboardStore, traced,
+	)
+	streams := handle.NewStreams()
 
 	traced.Infow(ctx, "Creating router")
 	h, err := handle.NewRouter(ctx, handle.Dependencies{
-		Config:           conf,
-		CookieProcessor:  cookieProcessor,
-		UserService:      userService,
-		JTIService:       domain.NewJTIService(redis, traced),
-		SessionService:   sessionService,
-		ClipboardService: domain.NewClipboardService(redis, traced),
+		Config:               conf,
+		Streams:              streams,
+		CookieProcessor:      cookieProcessor,
+		PublicKeysProvider:   jwtProcessor,
+		UserService:          userService,
+		JTIService:           jtiService,
+		RefreshTokenService:  refreshTokenService,
+		LoginService:         loginService,
+		TwoFactorService:     twoFactorService,
+		SignInLimiter:        signInLimiter,
+		OIDCService:          oidcService,
+		PasswordResetService: passwordResetService,
+		AccountService:       accountService,
+		AdminService:         adminService,
+		InvitationService:    invitationService,
+		SessionService:       sessionService,
+		ClipboardService:     domain.NewClipboardService(clipboardStore, sessionService, shareLinkService, clipboardNotifier, contentPolicy, maxRetention, traced),
+		ClipboardSubscriber:  clipboardNotifier,
+		ShareLinkService:     shareLinkService,
+		APITokenService:      domain.NewAPITokenService(apiTokenRepo, traced),
 	}, traced)
 	if err != nil {
 		return nil, fmt.Errorf("create router: %w", err)
 	}
 
 	return &App{
-		port: conf.Port,
-		mux:  h,
-		log:  traced,
+		port:              conf.Port,
+		mux:               h,
+		streams:           streams,
+		clipboardNotifier: clipboardNotifier,
+		log:               traced,
 	}, nil
 }
 
@@ -94,8 +258,15 @@ func (a *App) Run(ctx context.Context) error {
 		Handler:     a.mux,
 		ReadTimeout: 30 * time.Second,
 	}
+	s.RegisterOnShutdown(a.streams.Close)
 
+	notifierCtx, cancelNotifier := context.WithCancel(ctx)
+	defer cancelNotifier()
+	go a.clipboardNotifier.Run(notifierCtx)
+
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		select {
 		case <-done:
 			return
@@ -106,6 +277,9 @@ func (a *App) Run(ctx context.Context) error {
 			if err := s.Shutdown(ctx); err != nil {
 				a.log.Errorw(ctx, "Shutdown server", err)
 			}
+			if err := a.streams.Wait(ctx); err != nil {
+				a.log.Errorw(ctx, "Close streams", err)
+			}
 			a.log.Infow(ctx, "Server stopped")
 			return
 		}
@@ -115,6 +289,7 @@ func (a *App) Run(ctx context.Context) error {
 	if err := s.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("server listen: %w", err)
 	}
+	<-stopped
 	a.log.Infow(ctx, "Server stopped")
 
 	return nil
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/config"
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	MigrateUp      = "up"
+	MigrateDown    = "down"
+	MigrateStatus  = "status"
+	MigrateVersion = "version"
+)
+
+// Migrate runs migrate command and returns schema version after it along with embedded migrations,
+// status and version commands change nothing
+func Migrate(ctx context.Context, conf config.DB, command string, traced log.TracedLogger) (*dal.SchemaVersion, error) {
+	switch command {
+	case MigrateUp, MigrateDown, MigrateStatus, MigrateVersion:
+	default:
+		return nil, fmt.Errorf("unknown migrate command: %q", command)
+	}
+
+	sqlDB, err := openDB(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlDB.Close()
+
+	migrator, err := dal.NewMigrator(ctx, sqlDB)
+	if err != nil {
+		return nil, fmt.Errorf("create migrator: %w", err)
+	}
+	defer migrator.Close()
+
+	switch command {
+	case MigrateUp:
+		traced.Infow(ctx, "Applying migrations")
+		err = migrator.Up()
+	case MigrateDown:
+		traced.Infow(ctx, "Rolling back the latest migration")
+		err = migrator.Down()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return migrator.Version()
+}
+
+func openDB(conf config.DB) (*sql.DB, error) {
+	dbURL := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		conf.Host, conf.Port, conf.User, conf.Password, conf.Name, conf.SSLMode,
+	)
+	res, err := sql.Open(conf.Driver, dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("open sql db: %w", err)
+	}
+	return res, nil
+}
+
+// prepareSchema applies migrations if autoMigrate is set and refuses to start if schema is not of the version
+// the app is built for
+func prepareSchema(ctx context.Context, sqlDB *sql.DB, autoMigrate bool, traced log.TracedLogger) error {
+	migrator, err := dal.NewMigrator(ctx, sqlDB)
+	if err != nil {
+		return fmt.Errorf("create migrator: %w", err)
+	}
+	defer migrator.Close()
+
+	if autoMigrate {
+		traced.Infow(ctx, "Applying migrations")
+		if err = migrator.Up(); err != nil {
+			return err
+		}
+	}
+
+	if err = migrator.CheckVersion(); err != nil {
+		return fmt.Errorf("check schema version, apply migrations with \"migrate up\" command or enable auto migrate: %w", err)
+	}
+
+	return nil
+}
@@ -4,33 +4,156 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"strings"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
+const (
+	ClipboardStorageRedis    = "redis"
+	ClipboardStoragePostgres = "postgres"
+	// ClipboardStorageCached keeps clipboards in postgres and caches the latest ones in redis
+	ClipboardStorageCached = "cached"
+
+	MailDriverSMTP = "smtp"
+	// MailDriverLog writes messages to the app log, it is only meant for development
+	MailDriverLog = "log"
+
+	RegistrationModeOpen       = "open"
+	RegistrationModeInviteOnly = "invite_only"
+	RegistrationModeClosed     = "closed"
+)
+
 type (
 	App struct {
-		Dev    bool   `json:"dev" envconfig:"APP_DEV_ENV"`
-		Port   int    `json:"port"`
-		CORS   CORS   `json:"cors"`
-		Cookie Cookie `json:"cookie"`
-		JWT    JWT    `json:"jwt"`
-		DB     DB     `json:"db"`
-		Redis  Redis  `json:"redis"`
+		Dev           bool          `json:"dev" envconfig:"APP_DEV_ENV"`
+		Port          int           `json:"port"`
+		CORS          CORS          `json:"cors"`
+		Security      Security      `json:"security"`
+		Admin         Admin         `json:"admin"`
+		Registration  Registration  `json:"registration"`
+		Cookie        Cookie        `json:"cookie"`
+		JWT           JWT           `json:"jwt"`
+		RefreshToken  RefreshToken  `json:"refresh_token"`
+		SignIn        SignIn        `json:"sign_in"`
+		Password      Password      `json:"password"`
+		PasswordReset PasswordReset `json:"password_reset"`
+		Mail          Mail          `json:"mail"`
+		TwoFactor     TwoFactor     `json:"two_factor"`
+		OIDC          OIDC          `json:"oidc"`
+		DB            DB            `json:"db"`
+		Redis         Redis         `json:"redis"`
+		Clipboard     Clipboard     `json:"clipboard"`
+		ShareLink     ShareLink     `json:"share_link"`
+	}
+
+	Clipboard struct {
+		Storage    string `json:"storage" envconfig:"APP_CLIPBOARD_STORAGE"`
+		MaxHistory int    `json:"max_history"`
+		// MaxRetentionHours bounds clipboard lifetime of any session retention, 0 means unbounded
+		MaxRetentionHours   int      `json:"max_retention_hours"`
+		MaxContentBytes     int64    `json:"max_content_bytes"`
+		AllowedContentTypes []string `json:"allowed_content_types"`
+	}
+
+	ShareLink struct {
+		DefaultExpireInMinutes int `json:"default_expire_in_minutes"`
+		MaxExpireInMinutes     int `json:"max_expire_in_minutes"`
+		// MaxPasswordFailures is how many wrong passwords lock password protected link for the rest of its life
+		MaxPasswordFailures int64 `json:"max_password_failures"`
 	}
 
 	Cookie struct {
 		Path   string `json:"path"`
 		Domain string `json:"domain" envconfig:"APP_COOKIE_DOMAIN"`
+		// Secure cookies are only sent over HTTPS, browsers make an exception for http://localhost
+		Secure bool `json:"secure" envconfig:"APP_COOKIE_SECURE"`
+		// SameSite is "strict", "lax" or "none", it is "lax" if empty. Refresh token cookie is always strict.
+		SameSite string `json:"same_site" envconfig:"APP_COOKIE_SAME_SITE"`
 	}
 
 	JWT struct {
-		Issuer          string   `json:"issuer"`
+		Issuer string `json:"issuer"`
+		// Audience of issued tokens, the first one identifies the API and is required in tokens it accepts
 		Audience        []string `json:"audience" envconfig:"APP_JWT_AUDIENCE"`
 		ExpireInMinutes uint64   `json:"expire_in_minutes"`
-		Secret          string   `json:"secret"`
+		// Secret signs tokens with HS256 if no keys are configured, which is only allowed in dev mode
+		Secret string `json:"secret" envconfig:"APP_JWT_SECRET"`
+		// Keys verify tokens, the one with SigningKeyID also signs them. Previous keys are kept after rotation
+		// so tokens signed with them stay valid until they expire.
+		Keys         []JWTKey `json:"keys"`
+		SigningKeyID string   `json:"signing_key_id" envconfig:"APP_JWT_SIGNING_KEY_ID"`
+	}
+
+	// JWTKey is a PEM file with RSA or Ed25519 key, it may be a public key if it is not used for signing
+	JWTKey struct {
+		ID   string `json:"id"`
+		Path string `json:"path"`
+	}
+
+	RefreshToken struct {
+		ExpireInHours int `json:"expire_in_hours"`
+	}
+
+	// SignIn limits failed sign in attempts, sign in is locked for exponentially growing time after free attempts are used
+	SignIn struct {
+		FreeAttemptsPerName int `json:"free_attempts_per_name"`
+		FreeAttemptsPerIP   int `json:"free_attempts_per_ip"`
+		MaxLockoutMinutes   int `json:"max_lockout_minutes"`
+		// FailuresWindowMinutes is how long failed attempts are counted for since the first one
+		FailuresWindowMinutes int `json:"failures_window_minutes"`
+	}
+
+	// Password is argon2id parameters passwords are hashed with. Changing them rehashes passwords on sign in.
+	Password struct {
+		MemoryKiB   uint32 `json:"memory_kib"`
+		Iterations  uint32 `json:"iterations"`
+		Parallelism uint8  `json:"parallelism"`
+	}
+
+	PasswordReset struct {
+		// URL is web app page reset token is passed to as token query parameter
+		URL             string `json:"url" envconfig:"APP_PASSWORD_RESET_URL"`
+		ExpireInMinutes int    `json:"expire_in_minutes"`
+	}
+
+	Mail struct {
+		Driver string `json:"driver" envconfig:"APP_MAIL_DRIVER"`
+		From   string `json:"from" envconfig:"APP_MAIL_FROM"`
+		SMTP   SMTP   `json:"smtp"`
+	}
+
+	SMTP struct {
+		Host     string `json:"host" envconfig:"APP_SMTP_HOST"`
+		Port     int    `json:"port" envconfig:"APP_SMTP_PORT"`
+		Username string `json:"username" envconfig:"APP_SMTP_USERNAME"`
+		Password string `json:"password" envconfig:"APP_SMTP_PASSWORD"`
+	}
+
+	TwoFactor struct {
+		// Issuer is shown for the account in authenticator apps
+		Issuer                   string `json:"issuer"`
+		ChallengeExpireInMinutes int    `json:"challenge_expire_in_minutes"`
+	}
+
+	OIDC struct {
+		// CallbackURL is public URL of /auth/oidc/callback, it must be registered as redirect URI at providers
+		CallbackURL string `json:"callback_url" envconfig:"APP_OIDC_CALLBACK_URL"`
+		// RedirectURL is web app URL users are sent to when sign in with provider is completed or failed
+		RedirectURL string         `json:"redirect_url" envconfig:"APP_OIDC_REDIRECT_URL"`
+		Providers   []OIDCProvider `json:"providers"`
+	}
+
+	// OIDCProvider is OpenID Connect provider users can sign in with, its endpoints are discovered from IssuerURL
+	OIDCProvider struct {
+		Name         string `json:"name"`
+		IssuerURL    string `json:"issuer_url"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		// Scopes are requested in addition to openid scope
+		Scopes []string `json:"scopes"`
 	}
 
 	CORS struct {
@@ -42,6 +165,36 @@ type (
 		AllowCredentials bool     `json:"allow_credentials"`
 	}
 
+	Admin struct {
+		// Names of users granted admin role on start, users who have not signed up yet are promoted on the next start
+		Names []string `json:"names" envconfig:"APP_ADMIN_NAMES"`
+	}
+
+	Registration struct {
+		// Mode is "open", "invite_only" or "closed"
+		Mode string `json:"mode" envconfig:"APP_REGISTRATION_MODE"`
+		// UsersCanInvite lets users who are not admins create invitations
+		UsersCanInvite             bool `json:"users_can_invite" envconfig:"APP_REGISTRATION_USERS_CAN_INVITE"`
+		InvitationMaxUses          int  `json:"invitation_max_uses"`
+		InvitationMaxExpireInHours int  `json:"invitation_max_expire_in_hours"`
+	}
+
+	// Security is response headers protecting browsers, headers with empty values are not sent
+	Security struct {
+		// HSTSMaxAgeSeconds is how long browsers only connect over HTTPS, 0 disables Strict-Transport-Security
+		HSTSMaxAgeSeconds     int  `json:"hsts_max_age_seconds" envconfig:"APP_SECURITY_HSTS_MAX_AGE_SECONDS"`
+		HSTSIncludeSubdomains bool `json:"hsts_include_subdomains"`
+		// FrameOptions is "DENY" or "SAMEORIGIN", CSP frame-ancestors supersedes it in modern browsers
+		FrameOptions   string `json:"frame_options"`
+		ReferrerPolicy string `json:"referrer_policy"`
+		// ContentSecurityPolicy is sent with every response
+		ContentSecurityPolicy string `json:"content_security_policy" envconfig:"APP_SECURITY_CSP"`
+		// ClipboardContentSecurityPolicy replaces ContentSecurityPolicy for clipboard content, which is controlled by users
+		ClipboardContentSecurityPolicy string `json:"clipboard_content_security_policy" envconfig:"APP_SECURITY_CLIPBOARD_CSP"`
+		// TrustedProxies are CIDRs of proxies in front of the app, X-Forwarded-For is only trusted if request comes from them
+		TrustedProxies []string `json:"trusted_proxies" envconfig:"APP_SECURITY_TRUSTED_PROXIES"`
+	}
+
 	Bolt struct {
 		Path string `json:"path"`
 	}
@@ -54,6 +207,8 @@ type (
 		User     string `json:"user"`
 		Password string `json:"password"`
 		SSLMode  string `json:"ssl_mode"`
+		// AutoMigrate applies migrations on start, otherwise they are applied with "app migrate up"
+		AutoMigrate bool `json:"auto_migrate" envconfig:"APP_DB_AUTO_MIGRATE"`
 	}
 
 	Redis struct {
@@ -102,6 +257,131 @@ func validateApp(app App) error {
 	if app.Port <= 0 || app.Port > 65535 {
 		return fmt.Errorf("invalid port: %d", app.Port)
 	}
+	if app.JWT.ExpireInMinutes == 0 {
+		res = append(res, "invalid JWT expire in minutes")
+	}
+	if app.JWT.Issuer == "" {
+		res = append(res, "empty JWT issuer")
+	}
+	if len(app.JWT.Keys) == 0 && app.JWT.Secret == "" {
+		res = append(res, "either JWT keys or JWT secret must be set")
+	}
+	for _, k := range app.JWT.Keys {
+		if k.ID == "" || k.Path == "" {
+			res = append(res, "JWT key must have id and path")
+			break
+		}
+	}
+	if len(app.JWT.Keys) > 0 && app.JWT.SigningKeyID == "" {
+		res = append(res, "empty JWT signing key id")
+	}
+	if app.RefreshToken.ExpireInHours <= 0 {
+		res = append(res, "invalid refresh token expire in hours")
+	}
+	switch strings.ToLower(app.Cookie.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if !app.Cookie.Secure {
+			res = append(res, "cookie same site none requires secure cookie")
+		}
+	default:
+		res = append(res, "invalid cookie same site")
+	}
+	if app.Security.HSTSMaxAgeSeconds < 0 {
+		res = append(res, "invalid security HSTS max age seconds")
+	}
+	switch strings.ToUpper(app.Security.FrameOptions) {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		res = append(res, "invalid security frame options")
+	}
+	if app.Security.ClipboardContentSecurityPolicy == "" {
+		res = append(res, "empty security clipboard content security policy")
+	}
+	for _, proxy := range app.Security.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			res = append(res, fmt.Sprintf("invalid security trusted proxy %q", proxy))
+		}
+	}
+	switch app.Registration.Mode {
+	case RegistrationModeOpen, RegistrationModeInviteOnly, RegistrationModeClosed:
+	default:
+		res = append(res, "invalid registration mode")
+	}
+	if app.Registration.InvitationMaxUses <= 0 {
+		res = append(res, "invalid registration invitation max uses")
+	}
+	if app.Registration.InvitationMaxExpireInHours <= 0 {
+		res = append(res, "invalid registration invitation max expire in hours")
+	}
+	if app.SignIn.FreeAttemptsPerName <= 0 {
+		res = append(res, "invalid sign in free attempts per name")
+	}
+	if app.SignIn.FreeAttemptsPerIP <= 0 {
+		res = append(res, "invalid sign in free attempts per ip")
+	}
+	if app.SignIn.MaxLockoutMinutes <= 0 {
+		res = append(res, "invalid sign in max lockout minutes")
+	}
+	if app.SignIn.FailuresWindowMinutes <= 0 {
+		res = append(res, "invalid sign in failures window minutes")
+	}
+	if app.Password.MemoryKiB == 0 {
+		res = append(res, "invalid password memory kib")
+	}
+	if app.Password.Iterations == 0 {
+		res = append(res, "invalid password iterations")
+	}
+	if app.Password.Parallelism == 0 {
+		res = append(res, "invalid password parallelism")
+	}
+	if app.PasswordReset.URL == "" {
+		res = append(res, "empty password reset url")
+	}
+	if app.PasswordReset.ExpireInMinutes <= 0 {
+		res = append(res, "invalid password reset expire in minutes")
+	}
+	switch app.Mail.Driver {
+	case MailDriverSMTP:
+		if app.Mail.SMTP.Host == "" {
+			res = append(res, "empty SMTP host")
+		}
+		if app.Mail.SMTP.Port <= 0 || app.Mail.SMTP.Port > 65535 {
+			res = append(res, "invalid SMTP port")
+		}
+	case MailDriverLog:
+	default:
+		res = append(res, "invalid mail driver")
+	}
+	if app.Mail.From == "" {
+		res = append(res, "empty mail from")
+	}
+	if app.TwoFactor.Issuer == "" {
+		res = append(res, "empty two factor issuer")
+	}
+	if app.TwoFactor.ChallengeExpireInMinutes <= 0 {
+		res = append(res, "invalid two factor challenge expire in minutes")
+	}
+	if len(app.OIDC.Providers) > 0 {
+		if app.OIDC.CallbackURL == "" {
+			res = append(res, "empty OIDC callback url")
+		}
+		if app.OIDC.RedirectURL == "" {
+			res = append(res, "empty OIDC redirect url")
+		}
+	}
+	oidcProviders := make(map[string]bool, len(app.OIDC.Providers))
+	for _, p := range app.OIDC.Providers {
+		if p.Name == "" || p.IssuerURL == "" || p.ClientID == "" {
+			res = append(res, "OIDC provider must have name, issuer url and client id")
+			break
+		}
+		if oidcProviders[p.Name] {
+			res = append(res, fmt.Sprintf("duplicate OIDC provider %q", p.Name))
+			break
+		}
+		oidcProviders[p.Name] = true
+	}
 	if app.Redis.Addr == "" {
 		res = append(res, "empty redis addr")
 	}
@@ -126,6 +406,32 @@ func validateApp(app App) error {
 	if app.DB.SSLMode == "" {
 		res = append(res, "empty DB ssl mode")
 	}
+	switch app.Clipboard.Storage {
+	case ClipboardStorageRedis, ClipboardStoragePostgres, ClipboardStorageCached:
+	default:
+		res = append(res, "invalid clipboard storage")
+	}
+	if app.Clipboard.MaxHistory <= 0 {
+		res = append(res, "invalid clipboard max history")
+	}
+	if app.Clipboard.MaxRetentionHours < 0 {
+		res = append(res, "invalid clipboard max retention hours")
+	}
+	if app.Clipboard.MaxContentBytes <= 0 {
+		res = append(res, "invalid clipboard max content bytes")
+	}
+	if len(app.Clipboard.AllowedContentTypes) == 0 {
+		res = append(res, "empty clipboard allowed content types")
+	}
+	if app.ShareLink.MaxExpireInMinutes <= 0 {
+		res = append(res, "invalid share link max expire in minutes")
+	}
+	if app.ShareLink.DefaultExpireInMinutes <= 0 || app.ShareLink.DefaultExpireInMinutes > app.ShareLink.MaxExpireInMinutes {
+		res = append(res, "invalid share link default expire in minutes")
+	}
+	if app.ShareLink.MaxPasswordFailures <= 0 {
+		res = append(res, "invalid share link max password failures")
+	}
 
 	if len(res) != 0 {
 		return fmt.Errorf("invalid app config: [%s]", strings.Join(res, "; "))
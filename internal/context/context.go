@@ -2,6 +2,7 @@ package context
 
 import (
 	"context"
+	"slices"
 
 	"github.com/Roma7-7-7/shared-clipboard/tools"
 )
@@ -10,9 +11,18 @@ type (
 	Authority struct {
 		UserID   uint64
 		UserName string
+		// TokenID is JTI of access token request was authenticated with, it is empty for API tokens
+		TokenID string
+	}
+
+	// Scope restricts requests authenticated with API token. Nil SessionIDs or Permissions mean no restriction.
+	Scope struct {
+		SessionIDs  []uint64
+		Permissions []string
 	}
 
 	authorityContextKey struct{}
+	scopeContextKey     struct{}
 	traceIDCtxKey       struct{}
 )
 
@@ -35,3 +45,33 @@ func AuthorityFrom(ctx context.Context) (*Authority, bool) {
 func WithAuthority(ctx context.Context, authority *Authority) context.Context {
 	return context.WithValue(ctx, authorityContextKey{}, authority)
 }
+
+// AllowsSession checks if scope covers the session
+func (s *Scope) AllowsSession(sessionID uint64) bool {
+	return s.SessionIDs == nil || slices.Contains(s.SessionIDs, sessionID)
+}
+
+// AllowsAllSessions checks if scope is not restricted to specific sessions
+func (s *Scope) AllowsAllSessions() bool {
+	return s.SessionIDs == nil
+}
+
+// Allows checks if scope grants the permission, empty permission is granted by any scope
+func (s *Scope) Allows(permission string) bool {
+	return s.Permissions == nil || permission == "" || slices.Contains(s.Permissions, permission)
+}
+
+// IsRestricted checks if scope restricts anything
+func (s *Scope) IsRestricted() bool {
+	return s.SessionIDs != nil || s.Permissions != nil
+}
+
+// ScopeFrom returns scope of the request, requests without scope are not restricted
+func ScopeFrom(ctx context.Context) (*Scope, bool) {
+	scope, ok := ctx.Value(scopeContextKey{}).(*Scope)
+	return scope, ok
+}
+
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+	return context.WithValue(ctx, scopeContextKey{}, scope)
+}
@@ -0,0 +1,194 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	apiTokenColumns = "t.api_token_id, t.user_id, u.name, t.name, t.session_ids, t.permissions, t.expires_at, t.last_used_at, t.created_at"
+	// apiTokenLastUsedPrecision limits how often last usage of the token is written
+	apiTokenLastUsedPrecision = "1 minute"
+)
+
+type (
+	APIToken struct {
+		ID       uint64
+		UserID   uint64
+		UserName string
+		Name     string
+		// SessionIDs restrict token to the sessions, nil means all sessions
+		SessionIDs []uint64
+		// Permissions restrict token to the permissions, nil means all permissions
+		Permissions []string
+		// ExpiresAt is zero if token never expires
+		ExpiresAt time.Time
+		// LastUsedAt is zero if token was never used
+		LastUsedAt time.Time
+		CreatedAt  time.Time
+	}
+
+	APITokenRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewAPITokenRepository(db *sql.DB) (*APITokenRepository, error) {
+	return &APITokenRepository{
+		db: db,
+	}, nil
+}
+
+func (r *APITokenRepository) GetAllByUserID(userID uint64) ([]*APIToken, error) {
+	res := make([]*APIToken, 0, 10)
+
+	rows, err := r.db.Query("SELECT "+apiTokenColumns+" FROM api_tokens t JOIN users u ON u.user_id = t.user_id "+
+		"WHERE t.user_id = $1 ORDER BY t.created_at DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("get api tokens by user_id=%d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+
+		res = append(res, token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate api tokens: %w", err)
+	}
+
+	return res, nil
+}
+
+// GetActiveByTokenHash returns token if it is not expired and its user is not disabled
+func (r *APITokenRepository) GetActiveByTokenHash(tokenHash string) (*APIToken, error) {
+	res, err := scanAPIToken(r.db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens t JOIN users u ON u.user_id = t.user_id "+
+		"WHERE t.token_hash = $1 AND (t.expires_at IS NULL OR t.expires_at > now()) AND u.disabled_at IS NULL", tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("active api token not found: %w", ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get api token by token hash: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *APITokenRepository) Create(
+	userID uint64, name, tokenHash string, sessionIDs []uint64, permissions []string, expiresAt time.Time,
+) (*APIToken, error) {
+	var (
+		id  uint64
+		ids pq.Int64Array
+	)
+	if sessionIDs != nil {
+		ids = make(pq.Int64Array, 0, len(sessionIDs))
+		for _, sid := range sessionIDs {
+			ids = append(ids, int64(sid))
+		}
+	}
+
+	if err := r.db.QueryRow("INSERT INTO api_tokens (user_id, name, token_hash, session_ids, permissions, expires_at, created_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, now()) RETURNING api_token_id",
+		userID,
+		name,
+		tokenHash,
+		ids,
+		pq.StringArray(permissions),
+		nullTime(expiresAt),
+	).Scan(&id); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			return nil, fmt.Errorf("create api token with user_id=%d and name=%q: %w", userID, name, ErrConflictUnique)
+		}
+
+		return nil, fmt.Errorf("create api token: %w", err)
+	}
+
+	return r.get(userID, id)
+}
+
+// UpdateLastUsedAt marks token as used now, unless it was already marked within last minute
+func (r *APITokenRepository) UpdateLastUsedAt(id uint64) error {
+	if _, err := r.db.Exec("UPDATE api_tokens SET last_used_at = now() WHERE api_token_id = $1 "+
+		"AND (last_used_at IS NULL OR last_used_at < now() - interval '"+apiTokenLastUsedPrecision+"')", id); err != nil {
+		return fmt.Errorf("update api token last used at: %w", err)
+	}
+
+	return nil
+}
+
+func (r *APITokenRepository) Delete(userID, id uint64) error {
+	execRes, err := r.db.Exec("DELETE FROM api_tokens WHERE api_token_id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("delete api token: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("api token with id=%d and user_id=%d not found: %w", id, userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *APITokenRepository) get(userID, id uint64) (*APIToken, error) {
+	res, err := scanAPIToken(r.db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens t JOIN users u ON u.user_id = t.user_id "+
+		"WHERE t.api_token_id = $1 AND t.user_id = $2", id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("api token with id=%d and user_id=%d not found: %w", id, userID, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get api token by id=%d: %w", id, err)
+	}
+
+	return res, nil
+}
+
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	var (
+		res         APIToken
+		sessionIDs  pq.Int64Array
+		permissions pq.StringArray
+		expiresAt   sql.NullTime
+		lastUsedAt  sql.NullTime
+	)
+
+	if err := row.Scan(
+		&res.ID,
+		&res.UserID,
+		&res.UserName,
+		&res.Name,
+		&sessionIDs,
+		&permissions,
+		&expiresAt,
+		&lastUsedAt,
+		&res.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if sessionIDs != nil {
+		res.SessionIDs = make([]uint64, 0, len(sessionIDs))
+		for _, sid := range sessionIDs {
+			res.SessionIDs = append(res.SessionIDs, uint64(sid))
+		}
+	}
+	res.Permissions = permissions
+	res.ExpiresAt = expiresAt.Time
+	res.LastUsedAt = lastUsedAt.Time
+	return &res, nil
+}
@@ -0,0 +1,315 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const notExpiredCondition = "(expires_at IS NULL OR expires_at > now())"
+
+type (
+	ClipboardRepresentation struct {
+		ContentType string
+		Content     []byte
+	}
+
+	Clipboard struct {
+		SessionID       uint64
+		Version         uint64
+		AuthorID        uint64
+		Representations []ClipboardRepresentation
+		Reads           int
+		// ExpiresAt is zero if clipboard never expires
+		ExpiresAt time.Time
+		UpdatedAt time.Time
+	}
+
+	ClipboardRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewClipboardRepository(db *sql.DB) (*ClipboardRepository, error) {
+	return &ClipboardRepository{
+		db: db,
+	}, nil
+}
+
+func (r *ClipboardRepository) GetLatest(sessionID uint64) (*Clipboard, error) {
+	var (
+		res       Clipboard
+		expiresAt sql.NullTime
+	)
+
+	if err := r.db.QueryRow("SELECT session_id, version, author_id, reads, expires_at, updated_at FROM clipboards WHERE session_id = $1 AND "+notExpiredCondition+" ORDER BY version DESC LIMIT 1", sessionID).
+		Scan(
+			&res.SessionID,
+			&res.Version,
+			&res.AuthorID,
+			&res.Reads,
+			&expiresAt,
+			&res.UpdatedAt,
+		); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("clipboard with session_id=%d not found: %w", sessionID, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get latest clipboard by session_id=%d: %w", sessionID, err)
+	}
+
+	res.ExpiresAt = expiresAt.Time
+
+	if err := r.fillRepresentations(sessionID, []*Clipboard{&res}); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (r *ClipboardRepository) GetByVersion(sessionID, version uint64) (*Clipboard, error) {
+	var (
+		res       Clipboard
+		expiresAt sql.NullTime
+	)
+
+	if err := r.db.QueryRow("SELECT session_id, version, author_id, reads, expires_at, updated_at FROM clipboards WHERE session_id = $1 AND version = $2 AND "+notExpiredCondition, sessionID, version).
+		Scan(
+			&res.SessionID,
+			&res.Version,
+			&res.AuthorID,
+			&res.Reads,
+			&expiresAt,
+			&res.UpdatedAt,
+		); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("clipboard with session_id=%d and version=%d not found: %w", sessionID, version, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get clipboard by session_id=%d and version=%d: %w", sessionID, version, err)
+	}
+
+	res.ExpiresAt = expiresAt.Time
+
+	if err := r.fillRepresentations(sessionID, []*Clipboard{&res}); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (r *ClipboardRepository) GetHistory(sessionID uint64, limit, offset int) ([]*Clipboard, int, error) {
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM clipboards WHERE session_id = $1 AND "+notExpiredCondition, sessionID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count clipboards by session_id=%d: %w", sessionID, err)
+	}
+
+	rows, err := r.db.Query("SELECT session_id, version, author_id, reads, expires_at, updated_at FROM clipboards WHERE session_id = $1 AND "+notExpiredCondition+" ORDER BY version DESC OFFSET $2 LIMIT $3",
+		sessionID, offset, limit,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get clipboards by session_id=%d: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	res := make([]*Clipboard, 0, limit)
+	for rows.Next() {
+		var (
+			c         Clipboard
+			expiresAt sql.NullTime
+		)
+
+		if err = rows.Scan(
+			&c.SessionID,
+			&c.Version,
+			&c.AuthorID,
+			&c.Reads,
+			&expiresAt,
+			&c.UpdatedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("scan clipboard: %w", err)
+		}
+		c.ExpiresAt = expiresAt.Time
+
+		res = append(res, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate clipboards: %w", err)
+	}
+
+	if err = r.fillRepresentations(sessionID, res); err != nil {
+		return nil, 0, err
+	}
+
+	return res, total, nil
+}
+
+// Add stores clipboard as the next version of the session clipboard and removes versions beyond maxHistory.
+// All versions of the session share the expiration of the latest one.
+// Session row is locked for the time of transaction, so concurrent writers get sequential versions.
+func (r *ClipboardRepository) Add(sessionID, authorID uint64, representations []ClipboardRepresentation, expiresAt time.Time, maxHistory int) (*Clipboard, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res := &Clipboard{
+		SessionID:       sessionID,
+		AuthorID:        authorID,
+		Representations: representations,
+		ExpiresAt:       expiresAt,
+	}
+	// version is kept on session, so it keeps growing even after all clipboards expired
+	if err = tx.QueryRow("UPDATE sessions SET clipboard_version = clipboard_version + 1 WHERE session_id = $1 RETURNING clipboard_version", sessionID).
+		Scan(&res.Version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("session with session_id=%d not found: %w", sessionID, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("increment clipboard version of session_id=%d: %w", sessionID, err)
+	}
+
+	if _, err = tx.Exec("DELETE FROM clipboards WHERE session_id = $1 AND NOT "+notExpiredCondition, sessionID); err != nil {
+		return nil, fmt.Errorf("delete expired clipboards: %w", err)
+	}
+	if _, err = tx.Exec("UPDATE clipboards SET expires_at = $1 WHERE session_id = $2", nullTime(expiresAt), sessionID); err != nil {
+		return nil, fmt.Errorf("update clipboards expiration: %w", err)
+	}
+
+	if err = tx.QueryRow("INSERT INTO clipboards (session_id, version, author_id, expires_at, updated_at) VALUES ($1, $2, $3, $4, now()) RETURNING updated_at",
+		sessionID,
+		res.Version,
+		authorID,
+		nullTime(expiresAt),
+	).Scan(
+		&res.UpdatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("insert clipboard: %w", err)
+	}
+
+	for i, rep := range representations {
+		if _, err = tx.Exec("INSERT INTO clipboard_representations (session_id, version, position, content_type, content) VALUES ($1, $2, $3, $4, $5)",
+			sessionID, res.Version, i, rep.ContentType, rep.Content,
+		); err != nil {
+			return nil, fmt.Errorf("insert clipboard representation: %w", err)
+		}
+	}
+
+	if _, err = tx.Exec("DELETE FROM clipboards WHERE session_id = $1 AND version <= $2", sessionID, int64(res.Version)-int64(maxHistory)); err != nil {
+		return nil, fmt.Errorf("delete old clipboards: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return res, nil
+}
+
+// RecordRead increments reads of the clipboard version and returns the updated number of reads.
+// If expiresAt is not zero, expiration of all session clipboards is moved to it.
+func (r *ClipboardRepository) RecordRead(sessionID, version uint64, expiresAt time.Time) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var reads int
+	if err = tx.QueryRow("UPDATE clipboards SET reads = reads + 1 WHERE session_id = $1 AND version = $2 AND "+notExpiredCondition+" RETURNING reads",
+		sessionID,
+		version,
+	).Scan(&reads); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("clipboard with session_id=%d and version=%d not found: %w", sessionID, version, ErrNotFound)
+		}
+
+		return 0, fmt.Errorf("increment clipboard reads: %w", err)
+	}
+
+	if !expiresAt.IsZero() {
+		if _, err = tx.Exec("UPDATE clipboards SET expires_at = $1 WHERE session_id = $2", expiresAt, sessionID); err != nil {
+			return 0, fmt.Errorf("update clipboards expiration: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return reads, nil
+}
+
+func (r *ClipboardRepository) DeleteBySessionID(sessionID uint64) error {
+	if _, err := r.db.Exec("DELETE FROM clipboards WHERE session_id = $1", sessionID); err != nil {
+		return fmt.Errorf("delete clipboards by session_id=%d: %w", sessionID, err)
+	}
+
+	return nil
+}
+
+// DeleteByAuthor deletes versions of the session clipboard the user is the author of
+func (r *ClipboardRepository) DeleteByAuthor(sessionID, authorID uint64) error {
+	if _, err := r.db.Exec("DELETE FROM clipboards WHERE session_id = $1 AND author_id = $2", sessionID, authorID); err != nil {
+		return fmt.Errorf("delete clipboards by session_id=%d and author_id=%d: %w", sessionID, authorID, err)
+	}
+
+	return nil
+}
+
+func (r *ClipboardRepository) fillRepresentations(sessionID uint64, clipboards []*Clipboard) error {
+	if len(clipboards) == 0 {
+		return nil
+	}
+
+	byVersion := make(map[uint64]*Clipboard, len(clipboards))
+	versions := make([]int64, 0, len(clipboards))
+	for _, c := range clipboards {
+		byVersion[c.Version] = c
+		versions = append(versions, int64(c.Version))
+	}
+
+	rows, err := r.db.Query("SELECT version, content_type, content FROM clipboard_representations WHERE session_id = $1 AND version = ANY($2) ORDER BY version, position",
+		sessionID, pq.Array(versions),
+	)
+	if err != nil {
+		return fmt.Errorf("get clipboard representations by session_id=%d: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version uint64
+			rep     ClipboardRepresentation
+		)
+
+		if err = rows.Scan(
+			&version,
+			&rep.ContentType,
+			&rep.Content,
+		); err != nil {
+			return fmt.Errorf("scan clipboard representation: %w", err)
+		}
+
+		if c, ok := byVersion[version]; ok {
+			c.Representations = append(c.Representations, rep)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterate clipboard representations: %w", err)
+	}
+
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -1,12 +1,23 @@
 package dal
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	pgConflictErrorCode = "23505"
+
+	usersEmailIndex = "users_email_idx"
 )
 
 var (
 	ErrNotFound       = errors.New("not found")
 	ErrConflictUnique = errors.New("conflict unique")
+	// ErrConflictUniqueEmail is ErrConflictUnique caused by email of another user
+	ErrConflictUniqueEmail = fmt.Errorf("%w: email", ErrConflictUnique)
+	// ErrConflictUniqueIdentity is ErrConflictUnique caused by identity linked to a user already
+	ErrConflictUniqueIdentity = fmt.Errorf("%w: identity", ErrConflictUnique)
+	// ErrLastSessionOwner is returned if change would leave session without owners
+	ErrLastSessionOwner = errors.New("last session owner")
 )
@@ -0,0 +1,186 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const invitationColumns = "i.invitation_id, i.created_by, u.name, i.max_uses, i.uses, i.expires_at, i.revoked_at, i.created_at"
+
+type (
+	Invitation struct {
+		ID            uint64
+		CreatedBy     uint64
+		CreatedByName string
+		MaxUses       int
+		Uses          int
+		ExpiresAt     time.Time
+		// RevokedAt is zero if invitation was not revoked
+		RevokedAt time.Time
+		CreatedAt time.Time
+	}
+
+	InvitationRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewInvitationRepository(db *sql.DB) (*InvitationRepository, error) {
+	return &InvitationRepository{
+		db: db,
+	}, nil
+}
+
+// GetAll returns invitations created by the user, newest first. Zero createdBy returns invitations of all users.
+func (r *InvitationRepository) GetAll(createdBy uint64) ([]*Invitation, error) {
+	res := make([]*Invitation, 0, 10)
+
+	rows, err := r.db.Query("SELECT "+invitationColumns+" FROM invitations i JOIN users u ON u.user_id = i.created_by "+
+		"WHERE $1 = 0 OR i.created_by = $1 ORDER BY i.created_at DESC", createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("get invitations by created_by=%d: %w", createdBy, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan invitation: %w", err)
+		}
+		res = append(res, invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate invitations: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *InvitationRepository) Create(createdBy uint64, codeHash string, maxUses int, expiresAt time.Time) (*Invitation, error) {
+	var id uint64
+
+	if err := r.db.QueryRow("INSERT INTO invitations (created_by, code_hash, max_uses, expires_at, created_at) "+
+		"VALUES ($1, $2, $3, $4, now()) RETURNING invitation_id",
+		createdBy, codeHash, maxUses, expiresAt,
+	).Scan(&id); err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	return r.get(id)
+}
+
+// Revoke revokes invitation created by the user, zero createdBy revokes invitation of any user
+func (r *InvitationRepository) Revoke(createdBy, id uint64) error {
+	execRes, err := r.db.Exec("UPDATE invitations SET revoked_at = COALESCE(revoked_at, now()) "+
+		"WHERE invitation_id = $1 AND ($2 = 0 OR created_by = $2)", id, createdBy)
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("invitation with id=%d and created_by=%d not found: %w", id, createdBy, ErrNotFound)
+	}
+
+	return nil
+}
+
+// CreateUser uses invitation with the code and creates user in one transaction, so invitation is not used up
+// if user can not be created. It returns ErrNotFound if invitation is expired, revoked or used up,
+// ErrConflictUniqueEmail if email is taken and ErrConflictUnique if name is taken.
+func (r *InvitationRepository) CreateUser(codeHash, name, password, passwordSalt, email string) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var invitationID uint64
+	if err = tx.QueryRow("UPDATE invitations SET uses = uses + 1 "+
+		"WHERE code_hash = $1 AND revoked_at IS NULL AND expires_at > now() AND uses < max_uses RETURNING invitation_id", codeHash,
+	).Scan(&invitationID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("valid invitation not found: %w", ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("use invitation: %w", err)
+	}
+
+	res := User{
+		Name:         name,
+		Password:     password,
+		PasswordSalt: passwordSalt,
+		Email:        email,
+	}
+	if err = tx.QueryRow("INSERT INTO users (name, password, password_salt, email) VALUES ($1, $2, $3, $4) RETURNING user_id, role, token_generation, created_at, updated_at",
+		name, password, passwordSalt, nullString(email),
+	).Scan(
+		&res.ID,
+		&res.Role,
+		&res.TokenGeneration,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+	); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			if pqErr.Constraint == usersEmailIndex {
+				return nil, fmt.Errorf("create user with name=%q: %w", name, ErrConflictUniqueEmail)
+			}
+			return nil, fmt.Errorf("create user with name=%q: %w", name, ErrConflictUnique)
+		}
+
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return &res, nil
+}
+
+func (r *InvitationRepository) get(id uint64) (*Invitation, error) {
+	res, err := scanInvitation(r.db.QueryRow("SELECT "+invitationColumns+" FROM invitations i JOIN users u ON u.user_id = i.created_by "+
+		"WHERE i.invitation_id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("invitation with id=%d not found: %w", id, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get invitation by id=%d: %w", id, err)
+	}
+
+	return res, nil
+}
+
+func scanInvitation(row rowScanner) (*Invitation, error) {
+	var (
+		res       Invitation
+		revokedAt sql.NullTime
+	)
+
+	if err := row.Scan(
+		&res.ID,
+		&res.CreatedBy,
+		&res.CreatedByName,
+		&res.MaxUses,
+		&res.Uses,
+		&res.ExpiresAt,
+		&revokedAt,
+		&res.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	res.RevokedAt = revokedAt.Time
+
+	return &res, nil
+}
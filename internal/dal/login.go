@@ -0,0 +1,142 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	loginColumns = "l.login_id, l.user_id, l.family_id, l.jti, l.access_expires_at, l.user_agent, l.ip, l.device_name, l.created_at, l.last_seen_at"
+	// activeLoginCondition matches logins which refresh token family still has a token to exchange
+	activeLoginCondition = "EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = l.family_id " +
+		"AND t.used_at IS NULL AND t.revoked_at IS NULL AND t.expires_at > now())"
+)
+
+type (
+	Login struct {
+		ID     uint64
+		UserID uint64
+		// FamilyID is refresh token family the login is authenticated with
+		FamilyID string
+		// JTI is ID of the latest access token issued for the login
+		JTI             string
+		AccessExpiresAt time.Time
+		UserAgent       string
+		IP              string
+		DeviceName      string
+		CreatedAt       time.Time
+		LastSeenAt      time.Time
+	}
+
+	LoginRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewLoginRepository(db *sql.DB) (*LoginRepository, error) {
+	return &LoginRepository{
+		db: db,
+	}, nil
+}
+
+// GetAllActiveByUserID returns logins which were neither signed out nor expired
+func (r *LoginRepository) GetAllActiveByUserID(userID uint64) ([]*Login, error) {
+	res := make([]*Login, 0, 10)
+
+	rows, err := r.db.Query("SELECT "+loginColumns+" FROM logins l WHERE l.user_id = $1 AND "+activeLoginCondition+
+		" ORDER BY l.last_seen_at DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("get logins by user_id=%d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		login, err := scanLogin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan login: %w", err)
+		}
+
+		res = append(res, login)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate logins: %w", err)
+	}
+
+	return res, nil
+}
+
+// GetActive returns login if it was neither signed out nor expired
+func (r *LoginRepository) GetActive(userID, id uint64) (*Login, error) {
+	res, err := scanLogin(r.db.QueryRow("SELECT "+loginColumns+" FROM logins l WHERE l.login_id = $1 AND l.user_id = $2 AND "+activeLoginCondition, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("active login with id=%d and user_id=%d not found: %w", id, userID, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get login by id=%d: %w", id, err)
+	}
+
+	return res, nil
+}
+
+func (r *LoginRepository) Create(
+	userID uint64, familyID, jti string, accessExpiresAt time.Time, userAgent, ip, deviceName string,
+) (*Login, error) {
+	res, err := scanLogin(r.db.QueryRow("INSERT INTO logins AS l (user_id, family_id, jti, access_expires_at, user_agent, ip, device_name, created_at, last_seen_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, now(), now()) RETURNING "+loginColumns,
+		userID,
+		familyID,
+		jti,
+		accessExpiresAt,
+		userAgent,
+		ip,
+		deviceName,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create login: %w", err)
+	}
+
+	return res, nil
+}
+
+// UpdateAccessToken replaces access token of the login, which happens every time its refresh token is exchanged
+func (r *LoginRepository) UpdateAccessToken(familyID, jti string, accessExpiresAt time.Time, ip string) error {
+	execRes, err := r.db.Exec("UPDATE logins SET jti = $2, access_expires_at = $3, ip = $4, last_seen_at = now() WHERE family_id = $1",
+		familyID, jti, accessExpiresAt, ip)
+	if err != nil {
+		return fmt.Errorf("update login access token: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("login with family_id=%q not found: %w", familyID, ErrNotFound)
+	}
+
+	return nil
+}
+
+func scanLogin(row rowScanner) (*Login, error) {
+	var res Login
+
+	if err := row.Scan(
+		&res.ID,
+		&res.UserID,
+		&res.FamilyID,
+		&res.JTI,
+		&res.AccessExpiresAt,
+		&res.UserAgent,
+		&res.IP,
+		&res.DeviceName,
+		&res.CreatedAt,
+		&res.LastSeenAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
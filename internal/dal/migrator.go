@@ -0,0 +1,165 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+
+	"github.com/Roma7-7-7/shared-clipboard/migrations"
+)
+
+// migrationLockTimeout is how long replica waits for migrations applied by another one
+const migrationLockTimeout = 5 * time.Minute
+
+var ErrSchemaVersionMismatch = errors.New("schema version mismatch")
+
+type (
+	// SchemaVersion is the latest applied migration, Dirty is set if it failed and the schema has to be fixed manually
+	SchemaVersion struct {
+		Version uint
+		Dirty   bool
+		// Latest is the latest migration embedded into the binary
+		Latest uint
+		// Migrations are all migrations embedded into the binary in order they are applied
+		Migrations []*Migration
+	}
+
+	Migration struct {
+		Version uint
+		Name    string
+	}
+
+	// Migrator applies migrations embedded into the binary. Version is kept in schema_migrations table, the same way
+	// migrate CLI does it, and every change is made under postgres advisory lock, so replicas started at the same
+	// time wait for each other instead of applying migrations twice.
+	Migrator struct {
+		m          *migrate.Migrate
+		migrations []*Migration
+	}
+)
+
+// NewMigrator takes a connection of db for its lifetime, it has to be closed to return the connection
+func NewMigrator(ctx context.Context, db *sql.DB) (*Migrator, error) {
+	src, err := iofs.New(migrations.FS, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("open embedded migrations: %w", err)
+	}
+	migrations, err := readMigrations(src)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get db connection: %w", err)
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("create migrate driver: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		_ = driver.Close()
+		return nil, fmt.Errorf("create migrate: %w", err)
+	}
+	m.LockTimeout = migrationLockTimeout
+
+	return &Migrator{
+		m:          m,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies all migrations which are not applied yet
+func (m *Migrator) Up() error {
+	if err := m.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migrate up: %w", err)
+	}
+	return nil
+}
+
+// Down rolls back the latest applied migration
+func (m *Migrator) Down() error {
+	if err := m.m.Steps(-1); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migrate down: %w", err)
+	}
+	return nil
+}
+
+// Version returns zero version if no migration is applied yet
+func (m *Migrator) Version() (*SchemaVersion, error) {
+	res := &SchemaVersion{Migrations: m.migrations}
+	if len(m.migrations) != 0 {
+		res.Latest = m.migrations[len(m.migrations)-1].Version
+	}
+
+	version, dirty, err := m.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, fmt.Errorf("get schema version: %w", err)
+	}
+	res.Version, res.Dirty = version, dirty
+
+	return res, nil
+}
+
+// CheckVersion returns ErrSchemaVersionMismatch unless all migrations embedded into the binary are applied cleanly.
+// Schema of a newer version is refused too, since the binary does not know what was changed.
+func (m *Migrator) CheckVersion() error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+
+	if version.Dirty {
+		return fmt.Errorf("%w: migration %d failed and has to be fixed manually", ErrSchemaVersionMismatch, version.Version)
+	}
+	if version.Version != version.Latest {
+		return fmt.Errorf("%w: schema version is %d, expected %d", ErrSchemaVersionMismatch, version.Version, version.Latest)
+	}
+
+	return nil
+}
+
+// Close returns the connection to db
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+func readMigrations(src source.Driver) ([]*Migration, error) {
+	version, err := src.First()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get first migration: %w", err)
+	}
+
+	var res []*Migration
+	for {
+		r, name, err := src.ReadUp(version)
+		if err != nil {
+			return nil, fmt.Errorf("read migration %d: %w", version, err)
+		}
+		_ = r.Close()
+		res = append(res, &Migration{Version: version, Name: name})
+
+		next, err := src.Next(version)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return res, nil
+			}
+			return nil, fmt.Errorf("get migration after %d: %w", version, err)
+		}
+		version = next
+	}
+}
@@ -0,0 +1,61 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type PasswordResetRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) (*PasswordResetRepository, error) {
+	return &PasswordResetRepository{
+		db: db,
+	}, nil
+}
+
+func (r *PasswordResetRepository) Create(userID uint64, tokenHash string, expiresAt time.Time) error {
+	if _, err := r.db.Exec("INSERT INTO password_resets (user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, now())",
+		userID, tokenHash, expiresAt,
+	); err != nil {
+		return fmt.Errorf("create password reset: %w", err)
+	}
+
+	return nil
+}
+
+// Use marks unused and not expired reset as used along with other resets of the user and returns ID of the user.
+// It returns ErrNotFound if there is no such reset, so a reset can only be used once.
+func (r *PasswordResetRepository) Use(tokenHash string) (uint64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var userID uint64
+	if err = tx.QueryRow("UPDATE password_resets SET used_at = now() WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now() RETURNING user_id",
+		tokenHash,
+	).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("valid password reset not found: %w", ErrNotFound)
+		}
+
+		return 0, fmt.Errorf("use password reset: %w", err)
+	}
+
+	if _, err = tx.Exec("UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		return 0, fmt.Errorf("use other password resets: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return userID, nil
+}
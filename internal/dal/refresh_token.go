@@ -0,0 +1,123 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const refreshTokenColumns = "refresh_token_id, user_id, family_id, expires_at, used_at, revoked_at, created_at"
+
+type (
+	RefreshToken struct {
+		ID     uint64
+		UserID uint64
+		// FamilyID is shared by all tokens rotated from the same sign in
+		FamilyID  string
+		ExpiresAt time.Time
+		// UsedAt is zero if token was not exchanged yet
+		UsedAt time.Time
+		// RevokedAt is zero if token was not revoked
+		RevokedAt time.Time
+		CreatedAt time.Time
+	}
+
+	RefreshTokenRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewRefreshTokenRepository(db *sql.DB) (*RefreshTokenRepository, error) {
+	return &RefreshTokenRepository{
+		db: db,
+	}, nil
+}
+
+// GetByTokenHash returns token regardless it was used, revoked or expired
+func (r *RefreshTokenRepository) GetByTokenHash(tokenHash string) (*RefreshToken, error) {
+	res, err := scanRefreshToken(r.db.QueryRow("SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash = $1", tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("refresh token not found: %w", ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get refresh token by token hash: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *RefreshTokenRepository) Create(userID uint64, familyID, tokenHash string, expiresAt time.Time) (*RefreshToken, error) {
+	res, err := scanRefreshToken(r.db.QueryRow("INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at) "+
+		"VALUES ($1, $2, $3, $4, now()) RETURNING "+refreshTokenColumns,
+		userID,
+		familyID,
+		tokenHash,
+		expiresAt,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create refresh token: %w", err)
+	}
+
+	return res, nil
+}
+
+// MarkUsed marks token as exchanged. It returns ErrNotFound if token was already used or revoked,
+// so only one of concurrent exchanges of the same token succeeds.
+func (r *RefreshTokenRepository) MarkUsed(id uint64) error {
+	execRes, err := r.db.Exec("UPDATE refresh_tokens SET used_at = now() WHERE refresh_token_id = $1 AND used_at IS NULL AND revoked_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("mark refresh token used: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("unused refresh token with id=%d not found: %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(familyID string) error {
+	if _, err := r.db.Exec("UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL", familyID); err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeAllByUserID(userID uint64) error {
+	if _, err := r.db.Exec("UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		return fmt.Errorf("revoke refresh tokens of user_id=%d: %w", userID, err)
+	}
+
+	return nil
+}
+
+func scanRefreshToken(row rowScanner) (*RefreshToken, error) {
+	var (
+		res       RefreshToken
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+
+	if err := row.Scan(
+		&res.ID,
+		&res.UserID,
+		&res.FamilyID,
+		&res.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+		&res.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	res.UsedAt = usedAt.Time
+	res.RevokedAt = revokedAt.Time
+	return &res, nil
+}
@@ -8,6 +8,16 @@ import (
 	"time"
 )
 
+const (
+	sessionOwnerRole = "owner"
+
+	// ownedSessionsQuery selects ids of sessions user $1 is an owner of, sessions with other owners are skipped if $3 is set.
+	// $2 is the owner role.
+	ownedSessionsQuery = "SELECT m.session_id FROM session_members m WHERE m.user_id = $1 AND m.role = $2 " +
+		"AND (NOT $3 OR NOT EXISTS (SELECT 1 FROM session_members o WHERE o.session_id = m.session_id AND o.role = $2 AND o.user_id <> $1)) " +
+		"ORDER BY m.session_id"
+)
+
 type (
 	SessionFilter struct {
 		userID          uint64
@@ -19,9 +29,13 @@ type (
 	}
 
 	Session struct {
-		ID        uint64
-		Name      string
-		UserID    uint64
+		ID              uint64
+		Name            string
+		UserID          uint64
+		RetentionPolicy string
+		RetentionValue  int
+		// Role is a role of the user sessions were queried for, it is empty if sessions were not queried by user
+		Role      string
 		CreatedAt time.Time
 		UpdatedAt time.Time
 	}
@@ -119,11 +133,13 @@ func NewSessionRepository(db *sql.DB) (*SessionRepository, error) {
 func (r *SessionRepository) GetByID(id uint64) (*Session, error) {
 	var res Session
 
-	if err := r.db.QueryRow("SELECT session_id, user_id, name, created_at, updated_at FROM sessions WHERE session_id = $1", id).
+	if err := r.db.QueryRow("SELECT session_id, user_id, name, retention_policy, retention_value, created_at, updated_at FROM sessions WHERE session_id = $1", id).
 		Scan(
 			&res.ID,
 			&res.UserID,
 			&res.Name,
+			&res.RetentionPolicy,
+			&res.RetentionValue,
 			&res.CreatedAt,
 			&res.UpdatedAt,
 		); err != nil {
@@ -140,7 +156,8 @@ func (r *SessionRepository) GetByID(id uint64) (*Session, error) {
 func (r *SessionRepository) GetAllByUserID(userID uint64) ([]*Session, error) {
 	res := make([]*Session, 0, 10)
 
-	rows, err := r.db.Query("SELECT session_id, user_id, name, created_at, updated_at FROM sessions WHERE user_id = $1 ORDER BY updated_at DESC", userID)
+	rows, err := r.db.Query("SELECT s.session_id, s.user_id, s.name, s.retention_policy, s.retention_value, m.role, s.created_at, s.updated_at FROM sessions s "+
+		"JOIN session_members m ON m.session_id = s.session_id AND m.user_id = $1 ORDER BY s.updated_at DESC", userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -157,6 +174,9 @@ func (r *SessionRepository) GetAllByUserID(userID uint64) ([]*Session, error) {
 			&s.ID,
 			&s.UserID,
 			&s.Name,
+			&s.RetentionPolicy,
+			&s.RetentionValue,
+			&s.Role,
 			&s.CreatedAt,
 			&s.UpdatedAt,
 		); err != nil {
@@ -173,9 +193,9 @@ func (r *SessionRepository) FilterBy(filter SessionFilter) ([]*Session, int, err
 	var (
 		totalCount      = 0
 		res             = make([]*Session, 0, 10)
-		filterQuery     = "user_id = $1 AND ($2 = '' OR name LIKE $2)"
-		totalCountQuery = "SELECT COUNT(*) FROM sessions WHERE " + filterQuery
-		query           = fmt.Sprintf("SELECT session_id, user_id, name, created_at, updated_at FROM sessions WHERE "+filterQuery+" ORDER BY %s %s OFFSET $3 LIMIT $4", filter.SortBy(), filter.SortByDirection())
+		fromQuery       = "FROM sessions s JOIN session_members m ON m.session_id = s.session_id AND m.user_id = $1 WHERE ($2 = '' OR s.name LIKE $2)"
+		totalCountQuery = "SELECT COUNT(*) " + fromQuery
+		query           = fmt.Sprintf("SELECT s.session_id, s.user_id, s.name, s.retention_policy, s.retention_value, m.role, s.created_at, s.updated_at "+fromQuery+" ORDER BY s.%s %s OFFSET $3 LIMIT $4", filter.SortBy(), filter.SortByDirection())
 		totalCountErr   error
 		queryErr        error
 	)
@@ -213,6 +233,9 @@ func (r *SessionRepository) FilterBy(filter SessionFilter) ([]*Session, int, err
 				&s.ID,
 				&s.UserID,
 				&s.Name,
+				&s.RetentionPolicy,
+				&s.RetentionValue,
+				&s.Role,
 				&s.CreatedAt,
 				&s.UpdatedAt,
 			); err != nil {
@@ -238,17 +261,54 @@ func (r *SessionRepository) FilterBy(filter SessionFilter) ([]*Session, int, err
 	return res, totalCount, nil
 }
 
+// GetOwnedIDs returns ids of sessions the user is an owner of. If soleOwner is set, sessions with other owners are skipped.
+func (r *SessionRepository) GetOwnedIDs(userID uint64, soleOwner bool) ([]uint64, error) {
+	rows, err := r.db.Query(ownedSessionsQuery, userID, sessionOwnerRole, soleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("get owned sessions by user_id=%d: %w", userID, err)
+	}
+
+	return scanSessionIDs(rows)
+}
+
+// scanSessionIDs reads and closes rows of session ids
+func scanSessionIDs(rows *sql.Rows) ([]uint64, error) {
+	defer rows.Close()
+
+	res := make([]uint64, 0, 10)
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan session id: %w", err)
+		}
+		res = append(res, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate sessions: %w", err)
+	}
+
+	return res, nil
+}
+
+// Create creates session and makes the user its owner
 func (r *SessionRepository) Create(name string, userID uint64) (*Session, error) {
 	res := &Session{
 		UserID: userID,
 		Name:   name,
+		Role:   sessionOwnerRole,
 	}
 
-	if err := r.db.QueryRow("INSERT INTO sessions (name, user_id, created_at, updated_at) VALUES ($1, $2, now(), now()) RETURNING session_id, created_at, updated_at",
+	if err := r.db.QueryRow("WITH s AS (INSERT INTO sessions (name, user_id, created_at, updated_at) VALUES ($1, $2, now(), now()) "+
+		"RETURNING session_id, retention_policy, retention_value, created_at, updated_at), "+
+		"m AS (INSERT INTO session_members (session_id, user_id, role, created_at, updated_at) SELECT session_id, $2, $3, created_at, updated_at FROM s) "+
+		"SELECT session_id, retention_policy, retention_value, created_at, updated_at FROM s",
 		name,
 		userID,
+		sessionOwnerRole,
 	).Scan(
 		&res.ID,
+		&res.RetentionPolicy,
+		&res.RetentionValue,
 		&res.CreatedAt,
 		&res.UpdatedAt,
 	); err != nil {
@@ -258,9 +318,11 @@ func (r *SessionRepository) Create(name string, userID uint64) (*Session, error)
 	return res, nil
 }
 
-func (r *SessionRepository) Update(id uint64, name string) (*Session, error) {
-	execRes, err := r.db.Exec("UPDATE sessions SET name = $1, updated_at = now() WHERE session_id = $2",
+func (r *SessionRepository) Update(id uint64, name, retentionPolicy string, retentionValue int) (*Session, error) {
+	execRes, err := r.db.Exec("UPDATE sessions SET name = $1, retention_policy = $2, retention_value = $3, updated_at = now() WHERE session_id = $4",
 		name,
+		retentionPolicy,
+		retentionValue,
 		id,
 	)
 	if err != nil {
@@ -0,0 +1,187 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type (
+	SessionMember struct {
+		SessionID uint64
+		UserID    uint64
+		UserName  string
+		Role      string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	SessionMemberRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewSessionMemberRepository(db *sql.DB) (*SessionMemberRepository, error) {
+	return &SessionMemberRepository{
+		db: db,
+	}, nil
+}
+
+func (r *SessionMemberRepository) Get(sessionID, userID uint64) (*SessionMember, error) {
+	var res SessionMember
+
+	if err := r.db.QueryRow("SELECT m.session_id, m.user_id, u.name, m.role, m.created_at, m.updated_at FROM session_members m "+
+		"JOIN users u ON u.user_id = m.user_id WHERE m.session_id = $1 AND m.user_id = $2", sessionID, userID).
+		Scan(
+			&res.SessionID,
+			&res.UserID,
+			&res.UserName,
+			&res.Role,
+			&res.CreatedAt,
+			&res.UpdatedAt,
+		); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("member with session_id=%d and user_id=%d not found: %w", sessionID, userID, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get member by session_id=%d and user_id=%d: %w", sessionID, userID, err)
+	}
+
+	return &res, nil
+}
+
+func (r *SessionMemberRepository) GetAllBySessionID(sessionID uint64) ([]*SessionMember, error) {
+	res := make([]*SessionMember, 0, 10)
+
+	rows, err := r.db.Query("SELECT m.session_id, m.user_id, u.name, m.role, m.created_at, m.updated_at FROM session_members m "+
+		"JOIN users u ON u.user_id = m.user_id WHERE m.session_id = $1 ORDER BY m.created_at", sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("get members by session_id=%d: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m SessionMember
+
+		if err = rows.Scan(
+			&m.SessionID,
+			&m.UserID,
+			&m.UserName,
+			&m.Role,
+			&m.CreatedAt,
+			&m.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan member: %w", err)
+		}
+
+		res = append(res, &m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate members: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *SessionMemberRepository) Create(sessionID, userID uint64, role string) (*SessionMember, error) {
+	if _, err := r.db.Exec("INSERT INTO session_members (session_id, user_id, role, created_at, updated_at) VALUES ($1, $2, $3, now(), now())",
+		sessionID,
+		userID,
+		role,
+	); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			return nil, fmt.Errorf("create member with session_id=%d and user_id=%d: %w", sessionID, userID, ErrConflictUnique)
+		}
+
+		return nil, fmt.Errorf("create member: %w", err)
+	}
+
+	return r.Get(sessionID, userID)
+}
+
+// UpdateRole returns ErrLastSessionOwner if the only owner is demoted
+func (r *SessionMemberRepository) UpdateRole(sessionID, userID uint64, role string) (*SessionMember, error) {
+	if err := r.withOwnersLocked(sessionID, userID, role != sessionOwnerRole, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec("UPDATE session_members SET role = $1, updated_at = now() WHERE session_id = $2 AND user_id = $3", role, sessionID, userID)
+	}); err != nil {
+		return nil, fmt.Errorf("update member: %w", err)
+	}
+
+	return r.Get(sessionID, userID)
+}
+
+// Delete returns ErrLastSessionOwner if the only owner is removed
+func (r *SessionMemberRepository) Delete(sessionID, userID uint64) error {
+	if err := r.withOwnersLocked(sessionID, userID, true, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec("DELETE FROM session_members WHERE session_id = $1 AND user_id = $2", sessionID, userID)
+	}); err != nil {
+		return fmt.Errorf("delete member: %w", err)
+	}
+
+	return nil
+}
+
+// withOwnersLocked changes the member in a transaction holding locks on owners of the session, so concurrent changes
+// of owners are checked one after another. If leavesOwners is set, the change is refused when the member is the only owner.
+func (r *SessionMemberRepository) withOwnersLocked(sessionID, userID uint64, leavesOwners bool, change func(*sql.Tx) (sql.Result, error)) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.Query("SELECT user_id FROM session_members WHERE session_id = $1 AND role = $2 ORDER BY user_id FOR UPDATE",
+		sessionID, sessionOwnerRole)
+	if err != nil {
+		return fmt.Errorf("lock owners of session with id=%d: %w", sessionID, err)
+	}
+	owners, err := scanUserIDs(rows)
+	if err != nil {
+		return err
+	}
+	if leavesOwners && len(owners) == 1 && owners[0] == userID {
+		return fmt.Errorf("member with session_id=%d and user_id=%d: %w", sessionID, userID, ErrLastSessionOwner)
+	}
+
+	execRes, err := change(tx)
+	if err != nil {
+		return err
+	}
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("member with session_id=%d and user_id=%d not found: %w", sessionID, userID, ErrNotFound)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// scanUserIDs reads and closes rows of user ids
+func scanUserIDs(rows *sql.Rows) ([]uint64, error) {
+	defer rows.Close()
+
+	var res []uint64
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan user id: %w", err)
+		}
+		res = append(res, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate users: %w", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,163 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	shareLinkColumns = "share_link_id, session_id, created_by, password, single_use, access_count, expires_at, revoked_at, last_accessed_at, created_at"
+	// activeShareLinkCondition matches links that are neither revoked, expired nor used up
+	activeShareLinkCondition = "revoked_at IS NULL AND expires_at > now() AND (NOT single_use OR access_count = 0)"
+)
+
+type (
+	ShareLink struct {
+		ID        uint64
+		SessionID uint64
+		CreatedBy uint64
+		// Password is a hash of link password, it is empty if link is not protected
+		Password    string
+		SingleUse   bool
+		AccessCount int
+		ExpiresAt   time.Time
+		// RevokedAt is zero if link was not revoked
+		RevokedAt time.Time
+		// LastAccessedAt is zero if link was never accessed
+		LastAccessedAt time.Time
+		CreatedAt      time.Time
+	}
+
+	ShareLinkRepository struct {
+		db *sql.DB
+	}
+
+	rowScanner interface {
+		Scan(dest ...any) error
+	}
+)
+
+func NewShareLinkRepository(db *sql.DB) (*ShareLinkRepository, error) {
+	return &ShareLinkRepository{
+		db: db,
+	}, nil
+}
+
+func (r *ShareLinkRepository) GetAllBySessionID(sessionID uint64) ([]*ShareLink, error) {
+	res := make([]*ShareLink, 0, 10)
+
+	rows, err := r.db.Query("SELECT "+shareLinkColumns+" FROM share_links WHERE session_id = $1 ORDER BY created_at DESC", sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("get share links by session_id=%d: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan share link: %w", err)
+		}
+
+		res = append(res, link)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate share links: %w", err)
+	}
+
+	return res, nil
+}
+
+// GetActiveByTokenHash returns link if it can still be accessed
+func (r *ShareLinkRepository) GetActiveByTokenHash(tokenHash string) (*ShareLink, error) {
+	res, err := scanShareLink(r.db.QueryRow("SELECT "+shareLinkColumns+" FROM share_links WHERE token_hash = $1 AND "+activeShareLinkCondition, tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("active share link not found: %w", ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get share link by token hash: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *ShareLinkRepository) Create(sessionID, createdBy uint64, tokenHash, password string, singleUse bool, expiresAt time.Time) (*ShareLink, error) {
+	res, err := scanShareLink(r.db.QueryRow("INSERT INTO share_links (session_id, created_by, token_hash, password, single_use, expires_at, created_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, now()) RETURNING "+shareLinkColumns,
+		sessionID,
+		createdBy,
+		tokenHash,
+		sql.NullString{String: password, Valid: password != ""},
+		singleUse,
+		expiresAt,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create share link: %w", err)
+	}
+
+	return res, nil
+}
+
+// RecordAccess increments access count of the link if it is still active.
+// It returns ErrNotFound if link was revoked, expired or used up in the meantime.
+func (r *ShareLinkRepository) RecordAccess(id uint64) (*ShareLink, error) {
+	res, err := scanShareLink(r.db.QueryRow("UPDATE share_links SET access_count = access_count + 1, last_accessed_at = now() "+
+		"WHERE share_link_id = $1 AND "+activeShareLinkCondition+" RETURNING "+shareLinkColumns, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("active share link with id=%d not found: %w", id, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("record share link access: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *ShareLinkRepository) Revoke(sessionID, id uint64) error {
+	execRes, err := r.db.Exec("UPDATE share_links SET revoked_at = now() WHERE share_link_id = $1 AND session_id = $2 AND revoked_at IS NULL", id, sessionID)
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("share link with id=%d and session_id=%d not found: %w", id, sessionID, ErrNotFound)
+	}
+
+	return nil
+}
+
+func scanShareLink(row rowScanner) (*ShareLink, error) {
+	var (
+		res            ShareLink
+		password       sql.NullString
+		revokedAt      sql.NullTime
+		lastAccessedAt sql.NullTime
+	)
+
+	if err := row.Scan(
+		&res.ID,
+		&res.SessionID,
+		&res.CreatedBy,
+		&password,
+		&res.SingleUse,
+		&res.AccessCount,
+		&res.ExpiresAt,
+		&revokedAt,
+		&lastAccessedAt,
+		&res.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	res.Password = password.String
+	res.RevokedAt = revokedAt.Time
+	res.LastAccessedAt = lastAccessedAt.Time
+	return &res, nil
+}
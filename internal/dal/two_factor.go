@@ -0,0 +1,205 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type (
+	TwoFactor struct {
+		UserID uint64
+		// Secret is base32 encoded TOTP secret, it is empty if user did not enroll
+		Secret string
+		// ConfirmedAt is zero until user confirms enrollment with a code, two-factor authentication is enabled after that
+		ConfirmedAt time.Time
+		// LastStep is the latest TOTP time step used, codes of it and previous steps can not be used again
+		LastStep int64
+		// RecoveryCodesLeft is number of unused recovery codes
+		RecoveryCodesLeft int
+	}
+
+	TwoFactorRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewTwoFactorRepository(db *sql.DB) (*TwoFactorRepository, error) {
+	return &TwoFactorRepository{
+		db: db,
+	}, nil
+}
+
+func (r *TwoFactorRepository) GetByUserID(userID uint64) (*TwoFactor, error) {
+	var (
+		res         = TwoFactor{UserID: userID}
+		secret      sql.NullString
+		confirmedAt sql.NullTime
+	)
+
+	if err := r.db.QueryRow("SELECT u.totp_secret, u.totp_confirmed_at, u.totp_last_step, "+
+		"(SELECT count(*) FROM recovery_codes c WHERE c.user_id = u.user_id AND c.used_at IS NULL) "+
+		"FROM users u WHERE u.user_id = $1", userID).Scan(
+		&secret,
+		&confirmedAt,
+		&res.LastStep,
+		&res.RecoveryCodesLeft,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user with id=%d not found: %w", userID, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get two factor of user_id=%d: %w", userID, err)
+	}
+
+	res.Secret = secret.String
+	res.ConfirmedAt = confirmedAt.Time
+	return &res, nil
+}
+
+// SetPendingSecret starts enrollment with the secret, unless two-factor authentication is already enabled
+func (r *TwoFactorRepository) SetPendingSecret(userID uint64, secret string) error {
+	execRes, err := r.db.Exec("UPDATE users SET totp_secret = $2, totp_last_step = 0 WHERE user_id = $1 AND totp_confirmed_at IS NULL", userID, secret)
+	if err != nil {
+		return fmt.Errorf("set pending totp secret: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user with id=%d and pending two factor not found: %w", userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+// Confirm enables two-factor authentication and replaces recovery codes
+func (r *TwoFactorRepository) Confirm(userID uint64, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	execRes, err := tx.Exec("UPDATE users SET totp_confirmed_at = now(), totp_last_step = $2 "+
+		"WHERE user_id = $1 AND totp_secret IS NOT NULL AND totp_confirmed_at IS NULL AND totp_last_step < $2", userID, step)
+	if err != nil {
+		return fmt.Errorf("confirm totp: %w", err)
+	}
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user with id=%d and pending two factor not found: %w", userID, ErrNotFound)
+	}
+
+	if err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseStep records TOTP step as used. It returns ErrNotFound if the step or a later one was already used,
+// so a code can not be replayed.
+func (r *TwoFactorRepository) UseStep(userID uint64, step int64) error {
+	execRes, err := r.db.Exec("UPDATE users SET totp_last_step = $2 WHERE user_id = $1 AND totp_last_step < $2", userID, step)
+	if err != nil {
+		return fmt.Errorf("use totp step: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("unused totp step=%d of user_id=%d not found: %w", step, userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks recovery code as used. It returns ErrNotFound if there is no such unused code.
+func (r *TwoFactorRepository) UseRecoveryCode(userID uint64, codeHash string) error {
+	execRes, err := r.db.Exec("UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("unused recovery code of user_id=%d not found: %w", userID, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(userID uint64, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err = replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Delete disables two-factor authentication and removes recovery codes
+func (r *TwoFactorRepository) Delete(userID uint64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err = tx.Exec("UPDATE users SET totp_secret = NULL, totp_confirmed_at = NULL, totp_last_step = 0 WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("delete totp secret: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID uint64, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, now())", userID, hash); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
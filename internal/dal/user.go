@@ -4,19 +4,37 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+const userColumns = "user_id, name, password, password_salt, email, role, disabled_at, token_generation, created_at, updated_at"
+
 type (
 	User struct {
 		ID           uint64
 		Name         string
 		Password     string
 		PasswordSalt string
-		CreatedAt    time.Time
-		UpdatedAt    time.Time
+		// Email is empty if user did not set it
+		Email string
+		Role  string
+		// DisabledAt is zero if user is not disabled
+		DisabledAt time.Time
+		// TokenGeneration is incremented to invalidate all access tokens of the user
+		TokenGeneration uint64
+		CreatedAt       time.Time
+		UpdatedAt       time.Time
+	}
+
+	// UserDeletion is outcome of UserRepository.Delete
+	UserDeletion struct {
+		// DeletedSessionIDs are sessions only the user owned
+		DeletedSessionIDs []uint64
+		// KeptSessionIDs are sessions of other owners the user was a member of
+		KeptSessionIDs []uint64
 	}
 
 	UserRepository struct {
@@ -31,16 +49,8 @@ func NewUserRepository(db *sql.DB) (*UserRepository, error) {
 }
 
 func (r *UserRepository) GetByID(id uint64) (*User, error) {
-	var res User
-
-	if err := r.db.QueryRow("SELECT user_id, name, password, password_salt, created_at, updated_at FROM users WHERE user_id = $1", id).Scan(
-		&res.ID,
-		&res.Name,
-		&res.Password,
-		&res.PasswordSalt,
-		&res.CreatedAt,
-		&res.UpdatedAt,
-	); err != nil {
+	res, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE user_id = $1", id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("user with id=%d not found: %w", id, ErrNotFound)
 		}
@@ -48,20 +58,12 @@ func (r *UserRepository) GetByID(id uint64) (*User, error) {
 		return nil, fmt.Errorf("get user by user_id=%d: %w", id, err)
 	}
 
-	return &res, nil
+	return res, nil
 }
 
 func (r *UserRepository) GetByName(name string) (*User, error) {
-	var res User
-
-	if err := r.db.QueryRow("SELECT user_id, name, password, password_salt, created_at, updated_at FROM users WHERE name = $1", name).Scan(
-		&res.ID,
-		&res.Name,
-		&res.Password,
-		&res.PasswordSalt,
-		&res.CreatedAt,
-		&res.UpdatedAt,
-	); err != nil {
+	res, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE name = $1", name))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("user with name=\"%s\" not found: %w", name, ErrNotFound)
 		}
@@ -69,23 +71,46 @@ func (r *UserRepository) GetByName(name string) (*User, error) {
 		return nil, fmt.Errorf("get user by name=\"%s\": %w", name, err)
 	}
 
-	return &res, nil
+	return res, nil
 }
 
-func (r *UserRepository) Create(name, password, passwordSalt string) (*User, error) {
+// GetByEmail looks user up by case-insensitive email
+func (r *UserRepository) GetByEmail(email string) (*User, error) {
+	res, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE lower(email) = lower($1)", email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user with email not found: %w", ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get user by email: %w", err)
+	}
+
+	return res, nil
+}
+
+// Create returns ErrConflictUniqueEmail if email is taken and ErrConflictUnique if name is taken
+func (r *UserRepository) Create(name, password, passwordSalt, email string) (*User, error) {
 	res := User{
 		Name:         name,
 		Password:     password,
 		PasswordSalt: passwordSalt,
+		Email:        email,
 	}
 
-	if err := r.db.QueryRow("INSERT INTO users (name, password, password_salt) VALUES ($1, $2, $3) RETURNING user_id, created_at, updated_at", name, password, passwordSalt).Scan(
+	if err := r.db.QueryRow("INSERT INTO users (name, password, password_salt, email) VALUES ($1, $2, $3, $4) RETURNING user_id, role, token_generation, created_at, updated_at",
+		name, password, passwordSalt, nullString(email),
+	).Scan(
 		&res.ID,
+		&res.Role,
+		&res.TokenGeneration,
 		&res.CreatedAt,
 		&res.UpdatedAt,
 	); err != nil {
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			if pqErr.Constraint == usersEmailIndex {
+				return nil, fmt.Errorf("create user with name=\"%s\": %w", name, ErrConflictUniqueEmail)
+			}
 			return nil, fmt.Errorf("create user with name=\"%s\": %w", name, ErrConflictUnique)
 		}
 
@@ -95,3 +120,244 @@ func (r *UserRepository) Create(name, password, passwordSalt string) (*User, err
 	return &res, nil
 
 }
+
+// UpdateEmail sets email of the user, empty email removes it. It returns ErrConflictUniqueEmail if email is taken.
+func (r *UserRepository) UpdateEmail(id uint64, email string) error {
+	execRes, err := r.db.Exec("UPDATE users SET email = $2, updated_at = now() WHERE user_id = $1", id, nullString(email))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			return fmt.Errorf("update email of user with id=%d: %w", id, ErrConflictUniqueEmail)
+		}
+
+		return fmt.Errorf("update email: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user with id=%d not found: %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UpdatePassword(id uint64, password, passwordSalt string) error {
+	execRes, err := r.db.Exec("UPDATE users SET password = $2, password_salt = $3, updated_at = now() WHERE user_id = $1", id, password, passwordSalt)
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user with id=%d not found: %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+// GetTokenGeneration returns ErrNotFound for disabled users too, so none of their access tokens are valid
+func (r *UserRepository) GetTokenGeneration(id uint64) (uint64, error) {
+	var res uint64
+
+	if err := r.db.QueryRow("SELECT token_generation FROM users WHERE user_id = $1 AND disabled_at IS NULL", id).Scan(&res); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("user with id=%d not found: %w", id, ErrNotFound)
+		}
+
+		return 0, fmt.Errorf("get token generation by user_id=%d: %w", id, err)
+	}
+
+	return res, nil
+}
+
+func (r *UserRepository) IncrementTokenGeneration(id uint64) error {
+	execRes, err := r.db.Exec("UPDATE users SET token_generation = token_generation + 1, updated_at = now() WHERE user_id = $1", id)
+	if err != nil {
+		return fmt.Errorf("increment token generation: %w", err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user with id=%d not found: %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+// Search returns page of users whose name or email contains the query, ordered by id, along with total number of them
+func (r *UserRepository) Search(query string, limit, offset int) ([]*User, int, error) {
+	var (
+		total   int
+		res     = make([]*User, 0, limit)
+		pattern = "%" + escapeLike(query) + "%"
+		where   = " FROM users WHERE name ILIKE $1 OR email ILIKE $1"
+	)
+
+	if err := r.db.QueryRow("SELECT COUNT(*)"+where, pattern).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count users: %w", err)
+	}
+
+	rows, err := r.db.Query("SELECT "+userColumns+where+" ORDER BY user_id OFFSET $2 LIMIT $3", pattern, offset, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("search users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan user: %w", err)
+		}
+		res = append(res, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate users: %w", err)
+	}
+
+	return res, total, nil
+}
+
+func (r *UserRepository) UpdateRole(id uint64, role string) error {
+	return r.update(id, "UPDATE users SET role = $2, updated_at = now() WHERE user_id = $1", role)
+}
+
+// SetDisabled disables or enables the user, disabling user which is already disabled keeps the original time
+func (r *UserRepository) SetDisabled(id uint64, disabled bool) error {
+	if disabled {
+		return r.update(id, "UPDATE users SET disabled_at = COALESCE(disabled_at, now()), updated_at = now() WHERE user_id = $1")
+	}
+	return r.update(id, "UPDATE users SET disabled_at = NULL, updated_at = now() WHERE user_id = $1")
+}
+
+// Delete deletes the user along with everything referencing it, including clipboards the user is the author of, and
+// sessions only the user owns in one transaction. Memberships in sessions of the user are locked first, so other members can not
+// be demoted or leave until the user is deleted. Other sessions created by the user are passed to another owner.
+func (r *UserRepository) Delete(id uint64) (*UserDeletion, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err = tx.Exec("SELECT 1 FROM session_members WHERE session_id IN "+
+		"(SELECT session_id FROM session_members WHERE user_id = $1 UNION SELECT session_id FROM sessions WHERE user_id = $1) "+
+		"ORDER BY session_id, user_id FOR UPDATE", id); err != nil {
+		return nil, fmt.Errorf("lock members of sessions of user with id=%d: %w", id, err)
+	}
+
+	rows, err := tx.Query(ownedSessionsQuery, id, sessionOwnerRole, true)
+	if err != nil {
+		return nil, fmt.Errorf("get sessions only user with id=%d owns: %w", id, err)
+	}
+	sessionIDs, err := scanSessionIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(sessionIDs))
+	for _, sid := range sessionIDs {
+		ids = append(ids, int64(sid))
+	}
+	if rows, err = tx.Query("SELECT session_id FROM session_members WHERE user_id = $1 AND NOT session_id = ANY($2) ORDER BY session_id",
+		id, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("get sessions of other owners user with id=%d is a member of: %w", id, err)
+	}
+	keptIDs, err := scanSessionIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.Exec("DELETE FROM sessions WHERE session_id = ANY($1)", pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("delete sessions of user with id=%d: %w", id, err)
+	}
+	if _, err = tx.Exec("UPDATE sessions s SET user_id = o.user_id FROM "+
+		"(SELECT DISTINCT ON (session_id) session_id, user_id FROM session_members WHERE role = $2 AND user_id <> $1 ORDER BY session_id, created_at) o "+
+		"WHERE s.session_id = o.session_id AND s.user_id = $1", id, sessionOwnerRole); err != nil {
+		return nil, fmt.Errorf("pass sessions of user with id=%d to other owners: %w", id, err)
+	}
+
+	// sessions reference users, so the user is not deleted if any session is left without another owner
+	execRes, err := tx.Exec("DELETE FROM users WHERE user_id = $1", id)
+	if err != nil {
+		return nil, fmt.Errorf("delete user with id=%d: %w", id, err)
+	}
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return nil, fmt.Errorf("user with id=%d not found: %w", id, ErrNotFound)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return &UserDeletion{
+		DeletedSessionIDs: sessionIDs,
+		KeptSessionIDs:    keptIDs,
+	}, nil
+}
+
+func (r *UserRepository) update(id uint64, query string, args ...any) error {
+	execRes, err := r.db.Exec(query, append([]any{id}, args...)...)
+	if err != nil {
+		return fmt.Errorf("update user with id=%d: %w", id, err)
+	}
+
+	affected, err := execRes.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("user with id=%d not found: %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var (
+		res        User
+		email      sql.NullString
+		disabledAt sql.NullTime
+	)
+
+	if err := row.Scan(
+		&res.ID,
+		&res.Name,
+		&res.Password,
+		&res.PasswordSalt,
+		&email,
+		&res.Role,
+		&disabledAt,
+		&res.TokenGeneration,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	res.Email = email.String
+	res.DisabledAt = disabledAt.Time
+
+	return &res, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// escapeLike escapes wildcards of LIKE patterns, so they match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,150 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const userIdentityColumns = "user_identity_id, user_id, provider, subject, email, created_at"
+
+type (
+	// UserIdentity links user to the account at external identity provider
+	UserIdentity struct {
+		ID       uint64
+		UserID   uint64
+		Provider string
+		// Subject identifies the account at the provider
+		Subject   string
+		Email     string
+		CreatedAt time.Time
+	}
+
+	UserIdentityRepository struct {
+		db *sql.DB
+	}
+)
+
+func NewUserIdentityRepository(db *sql.DB) (*UserIdentityRepository, error) {
+	return &UserIdentityRepository{
+		db: db,
+	}, nil
+}
+
+func (r *UserIdentityRepository) GetByProviderSubject(provider, subject string) (*UserIdentity, error) {
+	res, err := scanUserIdentity(r.db.QueryRow("SELECT "+userIdentityColumns+" FROM user_identities WHERE provider = $1 AND subject = $2", provider, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user identity with provider=%q and subject=%q not found: %w", provider, subject, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("get user identity: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *UserIdentityRepository) GetAllByUserID(userID uint64) ([]*UserIdentity, error) {
+	res := make([]*UserIdentity, 0, 2)
+
+	rows, err := r.db.Query("SELECT "+userIdentityColumns+" FROM user_identities WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user identities by user_id=%d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		identity, err := scanUserIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user identity: %w", err)
+		}
+
+		res = append(res, identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate user identities: %w", err)
+	}
+
+	return res, nil
+}
+
+// Create links identity to existing user, it returns ErrConflictUniqueIdentity if identity is already linked
+func (r *UserIdentityRepository) Create(userID uint64, provider, subject, email string) (*UserIdentity, error) {
+	res, err := scanUserIdentity(r.db.QueryRow("INSERT INTO user_identities (user_id, provider, subject, email, created_at) "+
+		"VALUES ($1, $2, $3, $4, now()) RETURNING "+userIdentityColumns, userID, provider, subject, email))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			return nil, fmt.Errorf("create user identity with provider=%q and subject=%q: %w", provider, subject, ErrConflictUniqueIdentity)
+		}
+
+		return nil, fmt.Errorf("create user identity: %w", err)
+	}
+
+	return res, nil
+}
+
+// CreateUser provisions user without password signing in with the identity.
+// It returns ErrConflictUnique if user name is taken and ErrConflictUniqueIdentity if identity is already linked.
+func (r *UserIdentityRepository) CreateUser(name, provider, subject, email string) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// empty password never matches bcrypt comparison, so user can not sign in with password
+	res := User{Name: name}
+	if err = tx.QueryRow("INSERT INTO users (name, password, password_salt) VALUES ($1, '', '') RETURNING user_id, role, token_generation, created_at, updated_at", name).Scan(
+		&res.ID,
+		&res.Role,
+		&res.TokenGeneration,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+	); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			return nil, fmt.Errorf("create user with name=%q: %w", name, ErrConflictUnique)
+		}
+
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
+	if _, err = tx.Exec("INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES ($1, $2, $3, $4, now())",
+		res.ID, provider, subject, email); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgConflictErrorCode {
+			return nil, fmt.Errorf("create user identity with provider=%q and subject=%q: %w", provider, subject, ErrConflictUniqueIdentity)
+		}
+
+		return nil, fmt.Errorf("create user identity: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return &res, nil
+}
+
+func scanUserIdentity(row rowScanner) (*UserIdentity, error) {
+	var res UserIdentity
+
+	if err := row.Scan(
+		&res.ID,
+		&res.UserID,
+		&res.Provider,
+		&res.Subject,
+		&res.Email,
+		&res.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
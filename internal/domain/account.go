@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const exportPageSize = 20
+
+type (
+	PasswordChecker interface {
+		CheckPassword(ctx context.Context, userID uint64, password string) error
+	}
+
+	// AccountExport is everything stored about the user except clipboard contents, which can be large and are
+	// read with ExportClipboards
+	AccountExport struct {
+		User        *User
+		Identities  []*UserIdentity
+		TwoFactor   *TwoFactorStatus
+		APITokens   []*APIToken
+		Logins      []*Login
+		Sessions    []*Session
+		Invitations []*Invitation
+	}
+
+	// AccountService lets users delete their accounts and export their personal data
+	AccountService struct {
+		passwords      PasswordChecker
+		remover        *AccountRemover
+		userRepo       UserRepository
+		identityRepo   UserIdentityRepository
+		twoFactorRepo  TwoFactorRepository
+		apiTokenRepo   APITokenRepository
+		loginRepo      LoginRepository
+		sessionRepo    SessionRepository
+		invitationRepo InvitationRepository
+		store          ClipboardStore
+		log            log.TracedLogger
+	}
+)
+
+func NewAccountService(
+	passwords PasswordChecker, remover *AccountRemover, userRepo UserRepository, identityRepo UserIdentityRepository,
+	twoFactorRepo TwoFactorRepository, apiTokenRepo APITokenRepository, loginRepo LoginRepository, sessionRepo SessionRepository,
+	invitationRepo InvitationRepository, store ClipboardStore, log log.TracedLogger,
+) *AccountService {
+	return &AccountService{
+		passwords:      passwords,
+		remover:        remover,
+		userRepo:       userRepo,
+		identityRepo:   identityRepo,
+		twoFactorRepo:  twoFactorRepo,
+		apiTokenRepo:   apiTokenRepo,
+		loginRepo:      loginRepo,
+		sessionRepo:    sessionRepo,
+		invitationRepo: invitationRepo,
+		store:          store,
+		log:            log,
+	}
+}
+
+// Delete deletes account of the user if password is verified, see AccountRemover.Remove. Users signing in with
+// identity providers only have to set password first, e.g. with password reset.
+func (s *AccountService) Delete(ctx context.Context, userID uint64, password string) error {
+	s.log.Debugw(ctx, "delete account", "userID", userID)
+
+	if err := s.passwords.CheckPassword(ctx, userID, password); err != nil {
+		return err
+	}
+
+	return s.remover.Remove(ctx, userID)
+}
+
+// Export returns everything stored about the user, secrets like password hash, token hashes and TOTP secret are left out
+func (s *AccountService) Export(ctx context.Context, userID uint64) (*AccountExport, error) {
+	s.log.Debugw(ctx, "export account", "userID", userID)
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get user by id=%d: %w", userID, err)
+	}
+	res := &AccountExport{User: toDomainUser(user)}
+
+	identities, err := s.identityRepo.GetAllByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user identities: %w", err)
+	}
+	for _, i := range identities {
+		res.Identities = append(res.Identities, &UserIdentity{
+			ID:        i.ID,
+			Provider:  i.Provider,
+			Email:     i.Email,
+			CreatedAt: i.CreatedAt,
+		})
+	}
+
+	tf, err := s.twoFactorRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get two factor: %w", err)
+	}
+	res.TwoFactor = &TwoFactorStatus{
+		Enabled:           !tf.ConfirmedAt.IsZero(),
+		RecoveryCodesLeft: tf.RecoveryCodesLeft,
+	}
+
+	tokens, err := s.apiTokenRepo.GetAllByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get api tokens: %w", err)
+	}
+	for _, t := range tokens {
+		res.APITokens = append(res.APITokens, toAPIToken(t))
+	}
+
+	logins, err := s.loginRepo.GetAllActiveByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get logins: %w", err)
+	}
+	for _, l := range logins {
+		res.Logins = append(res.Logins, toLogin(l))
+	}
+
+	sessions, err := s.sessionRepo.GetAllByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get sessions: %w", err)
+	}
+	for _, ss := range sessions {
+		res.Sessions = append(res.Sessions, toSession(ss))
+	}
+
+	invitations, err := s.invitationRepo.GetAll(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get invitations: %w", err)
+	}
+	for _, i := range invitations {
+		res.Invitations = append(res.Invitations, toInvitation(i))
+	}
+
+	return res, nil
+}
+
+// ExportClipboards calls fn with every stored clipboard version the user is the author of in the sessions,
+// clipboards of other members are not personal data of the user. AccountRemover deletes the same versions.
+func (s *AccountService) ExportClipboards(ctx context.Context, userID uint64, sessions []*Session, fn func(*Clipboard) error) error {
+	for _, session := range sessions {
+		for offset := 0; ; offset += exportPageSize {
+			clipboards, total, err := s.store.GetHistory(ctx, session.ID, exportPageSize, offset)
+			if err != nil {
+				return fmt.Errorf("get clipboard history of session with id=%d: %w", session.ID, err)
+			}
+			for _, c := range clipboards {
+				if c.AuthorID != userID {
+					continue
+				}
+				if err = fn(c); err != nil {
+					return err
+				}
+			}
+			if len(clipboards) == 0 || offset+len(clipboards) >= total {
+				break
+			}
+		}
+	}
+
+	return nil
+}
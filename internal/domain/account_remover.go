@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+type (
+	// UserDeleter deletes the user and sessions only the user owns in one transaction, see dal.UserRepository.Delete
+	UserDeleter interface {
+		Delete(id uint64) (*dal.UserDeletion, error)
+	}
+
+	// AccountRemover deletes users along with sessions only they own and clipboards of those sessions.
+	// Sessions with other owners are kept, the user is just removed from their members and clipboard versions
+	// the user is the author of are deleted, the same ones AccountService.ExportClipboards exports.
+	AccountRemover struct {
+		userRepo UserDeleter
+		store    ClipboardStore
+		log      log.TracedLogger
+	}
+)
+
+func NewAccountRemover(userRepo UserDeleter, store ClipboardStore, log log.TracedLogger) *AccountRemover {
+	return &AccountRemover{
+		userRepo: userRepo,
+		store:    store,
+		log:      log,
+	}
+}
+
+// Remove returns ErrNotFound if there is no such user. The user and sessions are deleted in one transaction before
+// clipboards, so clipboards of sessions are never deleted while the sessions are still there. Clipboards the user
+// is the author of are deleted from postgres in the same transaction, clipboard store is cleaned up afterward and its
+// failures are only logged, since the account is already gone.
+func (r *AccountRemover) Remove(ctx context.Context, userID uint64) error {
+	r.log.Debugw(ctx, "remove account", "userID", userID)
+
+	deletion, err := r.userRepo.Delete(userID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return ErrNotFound
+		}
+
+		return fmt.Errorf("delete user: %w", err)
+	}
+
+	for _, id := range deletion.DeletedSessionIDs {
+		if err = r.store.Delete(ctx, id); err != nil {
+			r.log.Errorw(ctx, "Failed to delete clipboard of removed session", "sessionID", id, err)
+		}
+	}
+	for _, id := range deletion.KeptSessionIDs {
+		if err = r.store.DeleteByAuthor(ctx, id, userID); err != nil {
+			r.log.Errorw(ctx, "Failed to delete clipboards of removed user", "sessionID", id, err)
+		}
+	}
+
+	r.log.Infow(ctx, "Account removed", "userID", userID, "sessions", len(deletion.DeletedSessionIDs))
+	return nil
+}
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+)
+
+// fakeUserDeleter deletes sessions of the user unless err is set, kept are sessions of other owners the user is a member of
+type fakeUserDeleter struct {
+	sessions *fakeSessionRepository
+	kept     []uint64
+	err      error
+}
+
+func (d *fakeUserDeleter) Delete(id uint64) (*dal.UserDeletion, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	sessionIDs, _ := d.sessions.GetOwnedIDs(id, true)
+	for _, sid := range sessionIDs {
+		delete(d.sessions.sessions, sid)
+	}
+	return &dal.UserDeletion{DeletedSessionIDs: sessionIDs, KeptSessionIDs: d.kept}, nil
+}
+
+func TestAccountRemover_Remove(t *testing.T) {
+	store := newTestClipboardStore()
+	_, sessions := newTestSessionServiceWithStore(t, store)
+	remover := NewAccountRemover(&fakeUserDeleter{sessions: sessions}, store, newTestLogger())
+
+	if err := remover.Remove(context.Background(), testOwnerID); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, ok := sessions.sessions[testSessionID]; ok {
+		t.Error("expected owned session to be deleted")
+	}
+	if _, ok := store.history[testSessionID]; ok {
+		t.Error("expected clipboard of owned session to be deleted")
+	}
+}
+
+func TestAccountRemover_RemoveDeletesAuthoredClipboardsOfKeptSessions(t *testing.T) {
+	ctx := context.Background()
+	store := newTestClipboardStore()
+	_, sessions := newTestSessionServiceWithStore(t, store)
+	if _, err := store.Add(ctx, testSessionID, testEditorID, []Representation{{ContentType: "text/plain", Content: []byte("editor")}}, time.Time{}); err != nil {
+		t.Fatalf("add clipboard: %v", err)
+	}
+	remover := NewAccountRemover(&fakeUserDeleter{sessions: sessions, kept: []uint64{testSessionID}}, store, newTestLogger())
+
+	if err := remover.Remove(ctx, testEditorID); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, ok := sessions.sessions[testSessionID]; !ok {
+		t.Fatal("expected session of another owner to be kept")
+	}
+	history := store.history[testSessionID]
+	if len(history) != 1 || history[0].AuthorID != testOwnerID {
+		t.Errorf("expected only clipboard of the owner to be kept, got %d versions", len(history))
+	}
+}
+
+func TestAccountRemover_KeepsClipboardsIfUserIsNotDeleted(t *testing.T) {
+	store := newTestClipboardStore()
+	_, sessions := newTestSessionServiceWithStore(t, store)
+	remover := NewAccountRemover(&fakeUserDeleter{sessions: sessions, err: errors.New("connection refused")}, store, newTestLogger())
+
+	if err := remover.Remove(context.Background(), testOwnerID); err == nil {
+		t.Fatal("expected remove to fail")
+	}
+	if _, ok := store.history[testSessionID]; !ok {
+		t.Error("expected clipboard to stay while session is there")
+	}
+}
@@ -0,0 +1,220 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	maxUsersPageSize = 100
+	// usagePageSize is how many clipboard versions are loaded at once to measure storage usage
+	usagePageSize = 20
+)
+
+type (
+	// UserUsage is storage used by sessions the user owns, sessions with several owners count for each of them
+	UserUsage struct {
+		Sessions   int
+		Clipboards int
+		Bytes      int64
+	}
+
+	AdminUserRepository interface {
+		GetByID(id uint64) (*dal.User, error)
+		GetByName(name string) (*dal.User, error)
+		Search(query string, limit, offset int) ([]*dal.User, int, error)
+		UpdateRole(id uint64, role string) error
+		SetDisabled(id uint64, disabled bool) error
+	}
+
+	PasswordResetForcer interface {
+		Force(ctx context.Context, userID uint64) (*ForcedPasswordReset, error)
+	}
+
+	UserRemover interface {
+		Remove(ctx context.Context, userID uint64) error
+	}
+
+	// AdminService lets admins manage users. Admins can not disable or delete themselves, so there is always
+	// someone left to manage users.
+	AdminService struct {
+		repo        AdminUserRepository
+		sessionRepo SessionRepository
+		store       ClipboardStore
+		logins      LoginsRevoker
+		resets      PasswordResetForcer
+		remover     UserRemover
+		log         log.TracedLogger
+	}
+)
+
+func NewAdminService(
+	repo AdminUserRepository, sessionRepo SessionRepository, store ClipboardStore, logins LoginsRevoker,
+	resets PasswordResetForcer, remover UserRemover, log log.TracedLogger,
+) *AdminService {
+	return &AdminService{
+		repo:        repo,
+		sessionRepo: sessionRepo,
+		store:       store,
+		logins:      logins,
+		resets:      resets,
+		remover:     remover,
+		log:         log,
+	}
+}
+
+// IsAdmin checks if the user has admin role, unknown users are not admins
+func (s *AdminService) IsAdmin(ctx context.Context, userID uint64) (bool, error) {
+	user, err := s.repo.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "user not found", "userID", userID)
+			return false, nil
+		}
+
+		return false, fmt.Errorf("get user by id=%d: %w", userID, err)
+	}
+
+	return user.Role == UserRoleAdmin, nil
+}
+
+// Promote grants admin role to the user with the name, it is used to bootstrap the first admins
+func (s *AdminService) Promote(ctx context.Context, name string) error {
+	user, err := s.repo.GetByName(name)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return ErrNotFound
+		}
+
+		return fmt.Errorf("get user by name: %w", err)
+	}
+	if user.Role == UserRoleAdmin {
+		return nil
+	}
+
+	if err = s.repo.UpdateRole(user.ID, UserRoleAdmin); err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+
+	s.log.Infow(ctx, "User promoted to admin", "userID", user.ID)
+	return nil
+}
+
+// Search returns page of users whose name or email contains the query, empty query matches everyone
+func (s *AdminService) Search(ctx context.Context, query string, limit, offset int) ([]*User, int, error) {
+	s.log.Debugw(ctx, "search users", "query", query, "limit", limit, "offset", offset)
+
+	users, total, err := s.repo.Search(query, min(max(limit, 1), maxUsersPageSize), max(offset, 0))
+	if err != nil {
+		return nil, 0, fmt.Errorf("search users: %w", err)
+	}
+
+	res := make([]*User, 0, len(users))
+	for _, u := range users {
+		res = append(res, toDomainUser(u))
+	}
+	return res, total, nil
+}
+
+// SetDisabled disables or enables the user. Disabled user is signed out everywhere and can not sign in,
+// personal API tokens of the user are not accepted either.
+func (s *AdminService) SetDisabled(ctx context.Context, adminID, userID uint64, disabled bool) (*User, error) {
+	s.log.Debugw(ctx, "set user disabled", "userID", userID, "disabled", disabled)
+
+	if adminID == userID {
+		return nil, selfActionError("Admins can not disable themselves")
+	}
+
+	if err := s.repo.SetDisabled(userID, disabled); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("set disabled: %w", err)
+	}
+	if disabled {
+		if err := s.logins.RevokeAll(ctx, userID); err != nil {
+			return nil, fmt.Errorf("revoke logins: %w", err)
+		}
+	}
+
+	user, err := s.repo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user by id=%d: %w", userID, err)
+	}
+
+	s.log.Infow(ctx, "User disabled changed", "adminID", adminID, "userID", userID, "disabled", disabled)
+	return toDomainUser(user), nil
+}
+
+func (s *AdminService) ForcePasswordReset(ctx context.Context, adminID, userID uint64) (*ForcedPasswordReset, error) {
+	res, err := s.resets.Force(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infow(ctx, "Admin forced password reset", "adminID", adminID, "userID", userID)
+	return res, nil
+}
+
+// Delete deletes the user along with sessions only the user owns and their clipboards
+func (s *AdminService) Delete(ctx context.Context, adminID, userID uint64) error {
+	if adminID == userID {
+		return selfActionError("Admins can not delete themselves")
+	}
+
+	if err := s.remover.Remove(ctx, userID); err != nil {
+		return err
+	}
+
+	s.log.Infow(ctx, "Admin deleted user", "adminID", adminID, "userID", userID)
+	return nil
+}
+
+// GetUsage sums sizes of all stored clipboard versions of sessions the user owns
+func (s *AdminService) GetUsage(ctx context.Context, userID uint64) (*UserUsage, error) {
+	s.log.Debugw(ctx, "get user usage", "userID", userID)
+
+	if _, err := s.repo.GetByID(userID); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get user by id=%d: %w", userID, err)
+	}
+
+	sessionIDs, err := s.sessionRepo.GetOwnedIDs(userID, false)
+	if err != nil {
+		return nil, fmt.Errorf("get sessions owned by user: %w", err)
+	}
+
+	res := &UserUsage{Sessions: len(sessionIDs)}
+	for _, id := range sessionIDs {
+		for offset := 0; ; offset += usagePageSize {
+			clipboards, total, err := s.store.GetHistory(ctx, id, usagePageSize, offset)
+			if err != nil {
+				return nil, fmt.Errorf("get clipboard history of session with id=%d: %w", id, err)
+			}
+			for _, c := range clipboards {
+				res.Clipboards++
+				res.Bytes += int64(c.Size())
+			}
+			if len(clipboards) == 0 || offset+len(clipboards) >= total {
+				break
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func selfActionError(message string) *RenderableError {
+	return &RenderableError{
+		Code:    ErrorCodeAdminSelfAction,
+		Message: message,
+	}
+}
@@ -0,0 +1,224 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	// APITokenPrefix makes personal access tokens recognizable, e.g. by secret scanners
+	APITokenPrefix = "scp_"
+
+	// PermissionClipboardRead allows reading session clipboards and their history
+	PermissionClipboardRead = "clipboard:read"
+	// PermissionClipboardWrite allows setting session clipboards
+	PermissionClipboardWrite = "clipboard:write"
+	// PermissionSessionsManage allows creating, updating and deleting sessions and managing their members and share links
+	PermissionSessionsManage = "sessions:manage"
+
+	apiTokenMaxNameLength = 256
+)
+
+var (
+	ErrAPITokenNotFound = errors.New("api token not found")
+
+	apiTokenPermissions = []string{PermissionClipboardRead, PermissionClipboardWrite, PermissionSessionsManage}
+)
+
+type (
+	APIToken struct {
+		ID       uint64
+		UserID   uint64
+		UserName string
+		Name     string
+		// Token is only known right after it is created, only its hash is stored
+		Token string
+		// SessionIDs restrict token to the sessions, nil means all sessions
+		SessionIDs []uint64
+		// Permissions restrict token to the permissions, nil means all permissions
+		Permissions []string
+		// ExpiresAt is zero if token never expires
+		ExpiresAt time.Time
+		// LastUsedAt is zero if token was never used
+		LastUsedAt time.Time
+		CreatedAt  time.Time
+	}
+
+	APITokenRepository interface {
+		GetAllByUserID(userID uint64) ([]*dal.APIToken, error)
+		GetActiveByTokenHash(tokenHash string) (*dal.APIToken, error)
+		Create(userID uint64, name, tokenHash string, sessionIDs []uint64, permissions []string, expiresAt time.Time) (*dal.APIToken, error)
+		UpdateLastUsedAt(id uint64) error
+		Delete(userID, id uint64) error
+	}
+
+	// APITokenService manages personal access tokens users authenticate scripts and CLIs with
+	APITokenService struct {
+		repo APITokenRepository
+		log  log.TracedLogger
+	}
+)
+
+func NewAPITokenService(repo APITokenRepository, log log.TracedLogger) *APITokenService {
+	return &APITokenService{
+		repo: repo,
+		log:  log,
+	}
+}
+
+// Create generates a new token for the user. Zero expireIn means token never expires.
+// Nil sessionIDs or permissions mean token is not restricted by them.
+func (s *APITokenService) Create(
+	ctx context.Context, userID uint64, name string, sessionIDs []uint64, permissions []string, expireIn time.Duration,
+) (*APIToken, error) {
+	s.log.Debugw(ctx, "create api token", "userID", userID, "name", name, "expireIn", expireIn)
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > apiTokenMaxNameLength {
+		return nil, &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: fmt.Sprintf("Name must not be empty or longer than %d bytes", apiTokenMaxNameLength),
+		}
+	}
+	if expireIn < 0 {
+		return nil, &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: "Expiration must not be negative",
+		}
+	}
+
+	if err := validateAPITokenScope(sessionIDs, permissions); err != nil {
+		return nil, err
+	}
+
+	token, err := newSecretToken(APITokenPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("generate api token: %w", err)
+	}
+
+	var expiresAt time.Time
+	if expireIn > 0 {
+		expiresAt = time.Now().Add(expireIn)
+	}
+
+	created, err := s.repo.Create(userID, name, hashSecretToken(token), sessionIDs, permissions, expiresAt)
+	if err != nil {
+		if errors.Is(err, dal.ErrConflictUnique) {
+			s.log.Debugw(ctx, "api token with this name already exists", "userID", userID, "name", name)
+			return nil, &RenderableError{
+				Code:    ErrorCodeAPITokenConflict,
+				Message: "Token with specified name already exists",
+			}
+		}
+
+		return nil, fmt.Errorf("create api token: %w", err)
+	}
+
+	s.log.Debugw(ctx, "api token created", "userID", userID, "tokenID", created.ID)
+	res := toAPIToken(created)
+	res.Token = token
+	return res, nil
+}
+
+func (s *APITokenService) GetAll(ctx context.Context, userID uint64) ([]*APIToken, error) {
+	s.log.Debugw(ctx, "get api tokens", "userID", userID)
+
+	tokens, err := s.repo.GetAllByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get api tokens by userID=%d: %w", userID, err)
+	}
+
+	res := make([]*APIToken, 0, len(tokens))
+	for _, t := range tokens {
+		res = append(res, toAPIToken(t))
+	}
+	return res, nil
+}
+
+func (s *APITokenService) Revoke(ctx context.Context, userID, tokenID uint64) error {
+	s.log.Debugw(ctx, "revoke api token", "userID", userID, "tokenID", tokenID)
+
+	if err := s.repo.Delete(userID, tokenID); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return ErrAPITokenNotFound
+		}
+
+		return fmt.Errorf("delete api token: %w", err)
+	}
+
+	s.log.Debugw(ctx, "api token revoked", "userID", userID, "tokenID", tokenID)
+	return nil
+}
+
+// Authenticate returns token the request was made with.
+// It returns ErrAPITokenNotFound if token does not exist, was revoked or expired.
+func (s *APITokenService) Authenticate(ctx context.Context, token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		s.log.Debugw(ctx, "api token has unknown format")
+		return nil, ErrAPITokenNotFound
+	}
+
+	found, err := s.repo.GetActiveByTokenHash(hashSecretToken(token))
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "active api token not found")
+			return nil, ErrAPITokenNotFound
+		}
+
+		return nil, fmt.Errorf("get api token: %w", err)
+	}
+
+	if err = s.repo.UpdateLastUsedAt(found.ID); err != nil {
+		// failing to track usage must not fail the request
+		s.log.Errorw(ctx, "Failed to update api token last used at", "tokenID", found.ID, err)
+	}
+
+	return toAPIToken(found), nil
+}
+
+func validateAPITokenScope(sessionIDs []uint64, permissions []string) error {
+	if sessionIDs != nil && len(sessionIDs) == 0 {
+		return &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: "Session IDs must not be empty, omit them to allow all sessions",
+		}
+	}
+	if permissions != nil && len(permissions) == 0 {
+		return &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: "Permissions must not be empty, omit them to allow all permissions",
+		}
+	}
+	for _, p := range permissions {
+		if !slices.Contains(apiTokenPermissions, p) {
+			return &RenderableError{
+				Code:    ErrorBadRequest,
+				Message: fmt.Sprintf("Unknown permission %q", p),
+				Details: apiTokenPermissions,
+			}
+		}
+	}
+
+	return nil
+}
+
+func toAPIToken(token *dal.APIToken) *APIToken {
+	return &APIToken{
+		ID:          token.ID,
+		UserID:      token.UserID,
+		UserName:    token.UserName,
+		Name:        token.Name,
+		SessionIDs:  token.SessionIDs,
+		Permissions: token.Permissions,
+		ExpiresAt:   token.ExpiresAt,
+		LastUsedAt:  token.LastUsedAt,
+		CreatedAt:   token.CreatedAt,
+	}
+}
@@ -2,85 +2,295 @@ package domain
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"strconv"
 	"time"
 
-	"github.com/redis/go-redis/v9"
-
 	"github.com/Roma7-7-7/shared-clipboard/internal/log"
 )
 
 type (
-	Clipboard struct {
-		SessionID   uint64
+	Representation struct {
 		ContentType string
 		Content     []byte
-		UpdatedAt   time.Time
 	}
 
+	Clipboard struct {
+		SessionID uint64
+		Version   uint64
+		AuthorID  uint64
+		// Representations hold the same copy in different formats, the first one is the primary
+		Representations []Representation
+		// ExpiresAt is zero if clipboard never expires
+		ExpiresAt time.Time
+		UpdatedAt time.Time
+	}
+
+	// ClipboardStore keeps versioned clipboards of sessions, methods return ErrNotFound if there is nothing stored
+	ClipboardStore interface {
+		Get(ctx context.Context, sessionID uint64) (*Clipboard, error)
+		// Add stores representations as the next clipboard version, all stored versions expire at expiresAt
+		Add(ctx context.Context, sessionID, authorID uint64, representations []Representation, expiresAt time.Time) (*Clipboard, error)
+		GetHistory(ctx context.Context, sessionID uint64, limit, offset int) ([]*Clipboard, int, error)
+		GetVersion(ctx context.Context, sessionID, version uint64) (*Clipboard, error)
+		// RecordRead returns number of reads of the version including this one and moves expiration to expiresAt if it is not zero
+		RecordRead(ctx context.Context, sessionID, version uint64, expiresAt time.Time) (int, error)
+		Delete(ctx context.Context, sessionID uint64) error
+		// DeleteByAuthor deletes versions the user is the author of, the rest of history is kept
+		DeleteByAuthor(ctx context.Context, sessionID, authorID uint64) error
+	}
+
+	ClipboardEventPublisher interface {
+		Publish(ctx context.Context, event *ClipboardEvent) error
+	}
+
+	// SessionAuthorizer checks that user has at least required role in the session.
+	// It returns ErrSessionNotFound if session does not exist or user is not its member and ErrSessionPermissionDenied
+	// if member lacks the role.
+	SessionAuthorizer interface {
+		Authorize(ctx context.Context, userID, sessionID uint64, role string) (*Session, error)
+	}
+
+	// ShareLinkOpener checks share link and resolves it to the session it gives read access to once access is recorded.
+	// It returns ErrShareLinkNotFound if link does not exist or can not be accessed anymore.
+	ShareLinkOpener interface {
+		Open(ctx context.Context, token, password string) (*ShareLink, error)
+		RecordAccess(ctx context.Context, linkID uint64) (*Session, error)
+	}
+
+	// ClipboardService gives access to session clipboards on behalf of users, according to their session roles
 	ClipboardService struct {
-		client RedisClient
-		log    log.TracedLogger
+		store         ClipboardStore
+		sessions      SessionAuthorizer
+		shareLinks    ShareLinkOpener
+		publisher     ClipboardEventPublisher
+		contentPolicy *ContentPolicy
+		maxRetention  time.Duration
+		log           log.TracedLogger
 	}
 )
 
-func NewClipboardService(client RedisClient, log log.TracedLogger) *ClipboardService {
+func (c *Clipboard) ContentTypes() []string {
+	res := make([]string, 0, len(c.Representations))
+	for _, r := range c.Representations {
+		res = append(res, r.ContentType)
+	}
+	return res
+}
+
+func (c *Clipboard) Size() int {
+	size := 0
+	for _, r := range c.Representations {
+		size += len(r.Content)
+	}
+	return size
+}
+
+func NewClipboardService(
+	store ClipboardStore, sessions SessionAuthorizer, shareLinks ShareLinkOpener, publisher ClipboardEventPublisher,
+	contentPolicy *ContentPolicy, maxRetention time.Duration, log log.TracedLogger,
+) *ClipboardService {
 	return &ClipboardService{
-		client: client,
-		log:    log,
+		store:         store,
+		sessions:      sessions,
+		shareLinks:    shareLinks,
+		publisher:     publisher,
+		contentPolicy: contentPolicy,
+		maxRetention:  maxRetention,
+		log:           log,
 	}
 }
 
-func (s *ClipboardService) GetBySessionID(ctx context.Context, id uint64) (*Clipboard, error) {
-	key := clipboardKey(id)
-	s.log.Debugw(ctx, "Getting clipboard", "key", key)
+func (s *ClipboardService) GetBySessionID(ctx context.Context, userID, id uint64) (*Clipboard, error) {
+	s.log.Debugw(ctx, "Getting clipboard", "sessionID", id)
+
+	if _, err := s.sessions.Authorize(ctx, userID, id, SessionRoleViewer); err != nil {
+		return nil, fmt.Errorf("authorize clipboard read: %w", err)
+	}
+
+	clipboard, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get clipboard by sessionID=%d: %w", id, err)
+	}
+
+	return clipboard, nil
+}
+
+// ReadBySessionID returns clipboard for its content to be delivered to a reader, so the read counts toward session retention.
+func (s *ClipboardService) ReadBySessionID(ctx context.Context, userID, id uint64) (*Clipboard, error) {
+	s.log.Debugw(ctx, "Reading clipboard", "sessionID", id)
+
+	session, err := s.sessions.Authorize(ctx, userID, id, SessionRoleViewer)
+	if err != nil {
+		return nil, fmt.Errorf("authorize clipboard read: %w", err)
+	}
+
+	return s.read(ctx, session)
+}
+
+// ReadByShareLink returns clipboard of the session share link gives access to, the read counts toward session retention.
+func (s *ClipboardService) ReadByShareLink(ctx context.Context, token, password string) (*Clipboard, error) {
+	s.log.Debugw(ctx, "Reading clipboard by share link")
+
+	link, err := s.shareLinks.Open(ctx, token, password)
+	if err != nil {
+		return nil, fmt.Errorf("open share link: %w", err)
+	}
+
+	// single use link must not be used up by opening it while session has no clipboard
+	if _, err = s.store.Get(ctx, link.SessionID); err != nil {
+		return nil, fmt.Errorf("get clipboard by sessionID=%d: %w", link.SessionID, err)
+	}
+
+	session, err := s.shareLinks.RecordAccess(ctx, link.ID)
+	if err != nil {
+		return nil, fmt.Errorf("record share link access: %w", err)
+	}
+
+	return s.read(ctx, session)
+}
+
+func (s *ClipboardService) read(ctx context.Context, session *Session) (*Clipboard, error) {
+	id := session.ID
+	clipboard, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get clipboard by sessionID=%d: %w", id, err)
+	}
 
-	cmd := s.client.Get(ctx, key)
-	if cmd.Err() != nil {
-		if errors.Is(cmd.Err(), redis.Nil) {
-			s.log.Debugw(ctx, "Clipboard not found", "key", key)
+	retention := session.Retention
+	switch retention.Policy {
+	case RetentionIdle:
+		expiresAt := retention.ExpiresAt(time.Now(), s.maxRetention)
+		if _, err = s.store.RecordRead(ctx, id, clipboard.Version, expiresAt); err != nil {
+			return nil, fmt.Errorf("record clipboard read by sessionID=%d: %w", id, err)
+		}
+		clipboard.ExpiresAt = expiresAt
+	case RetentionReads:
+		reads, err := s.store.RecordRead(ctx, id, clipboard.Version, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("record clipboard read by sessionID=%d: %w", id, err)
+		}
+		if reads > retention.Value {
+			s.log.Debugw(ctx, "Clipboard reads are exhausted", "sessionID", id, "reads", reads)
 			return nil, ErrNotFound
 		}
+		if reads == retention.Value {
+			s.log.Debugw(ctx, "Clipboard read for the last time, deleting", "sessionID", id, "reads", reads)
+			if err = s.store.Delete(ctx, id); err != nil {
+				return nil, fmt.Errorf("delete clipboard by sessionID=%d: %w", id, err)
+			}
+			clipboard.ExpiresAt = time.Now()
+		}
+	}
+
+	return clipboard, nil
+}
+
+func (s *ClipboardService) SetBySessionID(ctx context.Context, userID, id uint64, representations []Representation) (*Clipboard, error) {
+	s.log.Debugw(ctx, "Setting clipboard", "sessionID", id, "representations", len(representations))
 
-		return nil, fmt.Errorf("get clipboard with key=%q: %w", key, cmd.Err())
+	session, err := s.sessions.Authorize(ctx, userID, id, SessionRoleEditor)
+	if err != nil {
+		return nil, fmt.Errorf("authorize clipboard write: %w", err)
 	}
-	bytes, err := cmd.Bytes()
+
+	return s.set(ctx, userID, session, representations)
+}
+
+// GetHistory returns no entries for sessions with clipboard expiring after reads, their older versions are not readable
+func (s *ClipboardService) GetHistory(ctx context.Context, userID, id uint64, limit, offset int) ([]*Clipboard, int, error) {
+	s.log.Debugw(ctx, "Getting clipboard history", "sessionID", id, "limit", limit, "offset", offset)
+
+	session, err := s.sessions.Authorize(ctx, userID, id, SessionRoleViewer)
 	if err != nil {
-		return nil, fmt.Errorf("get clipboard bytes with key=%q: %w", key, err)
+		return nil, 0, fmt.Errorf("authorize clipboard history read: %w", err)
+	}
+	if session.Retention.Policy == RetentionReads {
+		s.log.Debugw(ctx, "Clipboard history is hidden by retention", "sessionID", id)
+		return nil, 0, nil
 	}
-	var clipboard Clipboard
-	if err = json.Unmarshal(bytes, &clipboard); err != nil {
-		return nil, fmt.Errorf("unmarshal clipboard with key=%q: %w", key, err)
+
+	entries, total, err := s.store.GetHistory(ctx, id, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get clipboard history by sessionID=%d: %w", id, err)
 	}
 
-	return &clipboard, nil
+	return entries, total, nil
 }
 
-func (s *ClipboardService) SetBySessionID(ctx context.Context, id uint64, contentType string, content []byte) (*Clipboard, error) {
-	key := clipboardKey(id)
-	s.log.Debugw(ctx, "Setting clipboard", "key", key)
-	clipboard := &Clipboard{
-		SessionID:   id,
-		ContentType: contentType,
-		Content:     content,
-		UpdatedAt:   time.Now(),
+// GetVersion returns the version for its content to be delivered to a reader. If clipboard expires after reads,
+// only the current version is returned and the read counts toward retention, older ones are reported as not found.
+func (s *ClipboardService) GetVersion(ctx context.Context, userID, id, version uint64) (*Clipboard, error) {
+	s.log.Debugw(ctx, "Getting clipboard version", "sessionID", id, "version", version)
+
+	session, err := s.sessions.Authorize(ctx, userID, id, SessionRoleViewer)
+	if err != nil {
+		return nil, fmt.Errorf("authorize clipboard history read: %w", err)
 	}
-	bytes, err := json.Marshal(clipboard)
+	if session.Retention.Policy == RetentionReads {
+		return s.readVersion(ctx, session, version)
+	}
+
+	clipboard, err := s.store.GetVersion(ctx, id, version)
 	if err != nil {
-		return nil, fmt.Errorf("marshal clipboard: %w", err)
+		return nil, fmt.Errorf("get clipboard by sessionID=%d and version=%d: %w", id, version, err)
 	}
 
-	cmd := s.client.Set(ctx, key, bytes, 24*time.Hour)
-	if cmd.Err() != nil {
-		return nil, fmt.Errorf("set clipboard with key=%q: %w", key, cmd.Err())
+	return clipboard, nil
+}
+
+func (s *ClipboardService) readVersion(ctx context.Context, session *Session, version uint64) (*Clipboard, error) {
+	current, err := s.store.Get(ctx, session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get clipboard by sessionID=%d: %w", session.ID, err)
+	}
+	if current.Version != version {
+		s.log.Debugw(ctx, "Older clipboard version is not readable with reads retention", "sessionID", session.ID, "version", version)
+		return nil, ErrNotFound
 	}
 
+	clipboard, err := s.read(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if clipboard.Version != version {
+		// clipboard was replaced in between, the read counts toward the new version, which was not requested
+		return nil, ErrNotFound
+	}
 	return clipboard, nil
 }
 
-func clipboardKey(id uint64) string {
-	return fmt.Sprintf("clipboard:%s", strconv.FormatUint(id, 10))
+func (s *ClipboardService) Restore(ctx context.Context, userID, id, version uint64) (*Clipboard, error) {
+	s.log.Debugw(ctx, "Restoring clipboard version", "sessionID", id, "version", version)
+
+	session, err := s.sessions.Authorize(ctx, userID, id, SessionRoleEditor)
+	if err != nil {
+		return nil, fmt.Errorf("authorize clipboard write: %w", err)
+	}
+
+	entry, err := s.store.GetVersion(ctx, id, version)
+	if err != nil {
+		return nil, fmt.Errorf("get clipboard by sessionID=%d and version=%d: %w", id, version, err)
+	}
+
+	return s.set(ctx, userID, session, entry.Representations)
+}
+
+func (s *ClipboardService) set(ctx context.Context, userID uint64, session *Session, representations []Representation) (*Clipboard, error) {
+	representations, err := s.contentPolicy.ValidateAll(representations)
+	if err != nil {
+		return nil, fmt.Errorf("validate content: %w", err)
+	}
+
+	expiresAt := session.Retention.ExpiresAt(time.Now(), s.maxRetention)
+	clipboard, err := s.store.Add(ctx, session.ID, userID, representations, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("set clipboard by sessionID=%d: %w", session.ID, err)
+	}
+
+	if err = s.publisher.Publish(ctx, NewClipboardEvent(clipboard)); err != nil {
+		// clipboard is already stored, subscribers will get it on the next read
+		s.log.Errorw(ctx, "Failed to publish clipboard event", "sessionID", session.ID, err)
+	}
+
+	return clipboard, nil
 }
@@ -0,0 +1,566 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	clipboardCacheExpiration = time.Hour
+	clipboardSetMaxRetries   = 5
+)
+
+type (
+	// RedisClipboardStore keeps clipboard history in a redis list, newest version first
+	RedisClipboardStore struct {
+		client     RedisClient
+		maxHistory int
+		log        log.TracedLogger
+	}
+
+	ClipboardRepository interface {
+		GetLatest(sessionID uint64) (*dal.Clipboard, error)
+		GetByVersion(sessionID, version uint64) (*dal.Clipboard, error)
+		GetHistory(sessionID uint64, limit, offset int) ([]*dal.Clipboard, int, error)
+		Add(sessionID, authorID uint64, representations []dal.ClipboardRepresentation, expiresAt time.Time, maxHistory int) (*dal.Clipboard, error)
+		RecordRead(sessionID, version uint64, expiresAt time.Time) (int, error)
+		DeleteBySessionID(sessionID uint64) error
+		DeleteByAuthor(sessionID, authorID uint64) error
+	}
+
+	PostgresClipboardStore struct {
+		repo       ClipboardRepository
+		maxHistory int
+		log        log.TracedLogger
+	}
+
+	// RedisClipboardCache keeps only the latest clipboard of a session
+	RedisClipboardCache struct {
+		client RedisClient
+		log    log.TracedLogger
+	}
+
+	// CachedClipboardStore reads the latest clipboard through the cache and writes it through to the cache
+	CachedClipboardStore struct {
+		store ClipboardStore
+		cache *RedisClipboardCache
+		log   log.TracedLogger
+	}
+)
+
+func NewRedisClipboardStore(client RedisClient, maxHistory int, log log.TracedLogger) *RedisClipboardStore {
+	return &RedisClipboardStore{
+		client:     client,
+		maxHistory: maxHistory,
+		log:        log,
+	}
+}
+
+func (s *RedisClipboardStore) Get(ctx context.Context, sessionID uint64) (*Clipboard, error) {
+	key := clipboardHistoryKey(sessionID)
+
+	cmd := s.client.LIndex(ctx, key, 0)
+	if cmd.Err() != nil {
+		if errors.Is(cmd.Err(), redis.Nil) {
+			s.log.Debugw(ctx, "Clipboard not found", "key", key)
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get clipboard with key=%q: %w", key, cmd.Err())
+	}
+	bytes, err := cmd.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("get clipboard bytes with key=%q: %w", key, err)
+	}
+	var clipboard Clipboard
+	if err = json.Unmarshal(bytes, &clipboard); err != nil {
+		return nil, fmt.Errorf("unmarshal clipboard with key=%q: %w", key, err)
+	}
+
+	return &clipboard, nil
+}
+
+func (s *RedisClipboardStore) Add(ctx context.Context, sessionID, authorID uint64, representations []Representation, expiresAt time.Time) (*Clipboard, error) {
+	var (
+		historyKey = clipboardHistoryKey(sessionID)
+		versionKey = clipboardVersionKey(sessionID)
+		readsKey   = clipboardReadsKey(sessionID)
+		clipboard  *Clipboard
+		err        error
+	)
+
+	// version key is watched, so concurrent writers can't push entries out of order
+	add := func(tx *redis.Tx) error {
+		version, err := tx.Get(ctx, versionKey).Uint64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("get clipboard version with key=%q: %w", versionKey, err)
+		}
+
+		clipboard = &Clipboard{
+			SessionID:       sessionID,
+			Version:         version + 1,
+			AuthorID:        authorID,
+			Representations: representations,
+			ExpiresAt:       expiresAt,
+			UpdatedAt:       time.Now(),
+		}
+		bytes, err := json.Marshal(clipboard)
+		if err != nil {
+			return fmt.Errorf("marshal clipboard: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, versionKey, clipboard.Version, 0)
+			pipe.LPush(ctx, historyKey, bytes)
+			pipe.LTrim(ctx, historyKey, 0, int64(s.maxHistory-1))
+			pipe.Del(ctx, readsKey)
+			// version key never expires, so versions keep growing after clipboard expires and subscribers can resume
+			expireAt(ctx, pipe, expiresAt, historyKey)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < clipboardSetMaxRetries; i++ {
+		if err = s.client.Watch(ctx, add, versionKey); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+		s.log.Debugw(ctx, "Clipboard was concurrently modified, retrying", "key", historyKey, "attempt", i+1)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("add clipboard with key=%q: %w", historyKey, err)
+	}
+
+	return clipboard, nil
+}
+
+func (s *RedisClipboardStore) GetHistory(ctx context.Context, sessionID uint64, limit, offset int) ([]*Clipboard, int, error) {
+	key := clipboardHistoryKey(sessionID)
+
+	total, err := s.client.LLen(ctx, key).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("get clipboard history length with key=%q: %w", key, err)
+	}
+	if limit <= 0 || int64(offset) >= total {
+		return []*Clipboard{}, int(total), nil
+	}
+
+	entries, err := s.getEntries(ctx, key, int64(offset), int64(offset+limit-1))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, int(total), nil
+}
+
+func (s *RedisClipboardStore) GetVersion(ctx context.Context, sessionID, version uint64) (*Clipboard, error) {
+	key := clipboardHistoryKey(sessionID)
+
+	entries, err := s.getEntries(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Version == version {
+			return entry, nil
+		}
+	}
+
+	s.log.Debugw(ctx, "Clipboard version not found", "key", key, "version", version)
+	return nil, ErrNotFound
+}
+
+func (s *RedisClipboardStore) RecordRead(ctx context.Context, sessionID, version uint64, expiresAt time.Time) (int, error) {
+	var (
+		historyKey = clipboardHistoryKey(sessionID)
+		readsKey   = clipboardReadsKey(sessionID)
+	)
+
+	if expiresAt.IsZero() {
+		// reads must not outlive clipboard
+		ttl, err := s.client.PTTL(ctx, historyKey).Result()
+		if err != nil {
+			return 0, fmt.Errorf("get clipboard ttl with key=%q: %w", historyKey, err)
+		}
+		if ttl > 0 {
+			expiresAt = time.Now().Add(ttl)
+		}
+	}
+
+	var reads *redis.IntCmd
+	if _, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		reads = pipe.HIncrBy(ctx, readsKey, strconv.FormatUint(version, 10), 1)
+		expireAt(ctx, pipe, expiresAt, historyKey, readsKey)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("record clipboard read with key=%q: %w", readsKey, err)
+	}
+
+	return int(reads.Val()), nil
+}
+
+func (s *RedisClipboardStore) Delete(ctx context.Context, sessionID uint64) error {
+	// version key is kept, so versions keep growing after clipboard is deleted
+	if err := s.client.Del(ctx, clipboardHistoryKey(sessionID), clipboardReadsKey(sessionID)).Err(); err != nil {
+		return fmt.Errorf("delete clipboard of sessionID=%d: %w", sessionID, err)
+	}
+
+	return nil
+}
+
+func (s *RedisClipboardStore) DeleteByAuthor(ctx context.Context, sessionID, authorID uint64) error {
+	key := clipboardHistoryKey(sessionID)
+
+	// history key is watched, so entries added concurrently are not lost when the list is rewritten
+	deleteByAuthor := func(tx *redis.Tx) error {
+		values, err := tx.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("get clipboard history with key=%q: %w", key, err)
+		}
+		kept := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			var clipboard Clipboard
+			if err = json.Unmarshal([]byte(value), &clipboard); err != nil {
+				return fmt.Errorf("unmarshal clipboard with key=%q: %w", key, err)
+			}
+			if clipboard.AuthorID != authorID {
+				kept = append(kept, value)
+			}
+		}
+		if len(kept) == len(values) {
+			return nil
+		}
+		ttl, err := tx.PTTL(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("get clipboard ttl with key=%q: %w", key, err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			if len(kept) != 0 {
+				pipe.RPush(ctx, key, kept...)
+				if ttl > 0 {
+					pipe.PExpire(ctx, key, ttl)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < clipboardSetMaxRetries; i++ {
+		if err = s.client.Watch(ctx, deleteByAuthor, key); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+		s.log.Debugw(ctx, "Clipboard was concurrently modified, retrying", "key", key, "attempt", i+1)
+	}
+	if err != nil {
+		return fmt.Errorf("delete clipboards of authorID=%d with key=%q: %w", authorID, key, err)
+	}
+
+	return nil
+}
+
+func (s *RedisClipboardStore) getEntries(ctx context.Context, key string, start, stop int64) ([]*Clipboard, error) {
+	values, err := s.client.LRange(ctx, key, start, stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get clipboard history with key=%q: %w", key, err)
+	}
+
+	res := make([]*Clipboard, 0, len(values))
+	for _, value := range values {
+		var clipboard Clipboard
+		if err = json.Unmarshal([]byte(value), &clipboard); err != nil {
+			return nil, fmt.Errorf("unmarshal clipboard with key=%q: %w", key, err)
+		}
+		res = append(res, &clipboard)
+	}
+
+	return res, nil
+}
+
+func NewPostgresClipboardStore(repo ClipboardRepository, maxHistory int, log log.TracedLogger) *PostgresClipboardStore {
+	return &PostgresClipboardStore{
+		repo:       repo,
+		maxHistory: maxHistory,
+		log:        log,
+	}
+}
+
+func (s *PostgresClipboardStore) Get(ctx context.Context, sessionID uint64) (*Clipboard, error) {
+	clipboard, err := s.repo.GetLatest(sessionID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "Clipboard not found", "sessionID", sessionID)
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get latest clipboard: %w", err)
+	}
+
+	return toClipboard(clipboard), nil
+}
+
+func (s *PostgresClipboardStore) Add(ctx context.Context, sessionID, authorID uint64, representations []Representation, expiresAt time.Time) (*Clipboard, error) {
+	dalRepresentations := make([]dal.ClipboardRepresentation, 0, len(representations))
+	for _, r := range representations {
+		dalRepresentations = append(dalRepresentations, dal.ClipboardRepresentation{ContentType: r.ContentType, Content: r.Content})
+	}
+
+	clipboard, err := s.repo.Add(sessionID, authorID, dalRepresentations, expiresAt, s.maxHistory)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "Session not found", "sessionID", sessionID)
+			return nil, ErrSessionNotFound
+		}
+
+		return nil, fmt.Errorf("add clipboard: %w", err)
+	}
+
+	return toClipboard(clipboard), nil
+}
+
+func (s *PostgresClipboardStore) GetHistory(_ context.Context, sessionID uint64, limit, offset int) ([]*Clipboard, int, error) {
+	if limit <= 0 || offset < 0 {
+		return []*Clipboard{}, 0, nil
+	}
+
+	clipboards, total, err := s.repo.GetHistory(sessionID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get clipboard history: %w", err)
+	}
+
+	res := make([]*Clipboard, 0, len(clipboards))
+	for _, c := range clipboards {
+		res = append(res, toClipboard(c))
+	}
+	return res, total, nil
+}
+
+func (s *PostgresClipboardStore) GetVersion(ctx context.Context, sessionID, version uint64) (*Clipboard, error) {
+	clipboard, err := s.repo.GetByVersion(sessionID, version)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "Clipboard version not found", "sessionID", sessionID, "version", version)
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get clipboard version: %w", err)
+	}
+
+	return toClipboard(clipboard), nil
+}
+
+func (s *PostgresClipboardStore) RecordRead(ctx context.Context, sessionID, version uint64, expiresAt time.Time) (int, error) {
+	reads, err := s.repo.RecordRead(sessionID, version, expiresAt)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "Clipboard version not found", "sessionID", sessionID, "version", version)
+			return 0, ErrNotFound
+		}
+
+		return 0, fmt.Errorf("record clipboard read: %w", err)
+	}
+
+	return reads, nil
+}
+
+func (s *PostgresClipboardStore) Delete(_ context.Context, sessionID uint64) error {
+	if err := s.repo.DeleteBySessionID(sessionID); err != nil {
+		return fmt.Errorf("delete clipboards: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresClipboardStore) DeleteByAuthor(_ context.Context, sessionID, authorID uint64) error {
+	if err := s.repo.DeleteByAuthor(sessionID, authorID); err != nil {
+		return fmt.Errorf("delete clipboards of author: %w", err)
+	}
+
+	return nil
+}
+
+func NewRedisClipboardCache(client RedisClient, log log.TracedLogger) *RedisClipboardCache {
+	return &RedisClipboardCache{
+		client: client,
+		log:    log,
+	}
+}
+
+func (c *RedisClipboardCache) Get(ctx context.Context, sessionID uint64) (*Clipboard, error) {
+	key := clipboardCacheKey(sessionID)
+
+	bytes, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get cached clipboard with key=%q: %w", key, err)
+	}
+	var clipboard Clipboard
+	if err = json.Unmarshal(bytes, &clipboard); err != nil {
+		return nil, fmt.Errorf("unmarshal cached clipboard with key=%q: %w", key, err)
+	}
+
+	return &clipboard, nil
+}
+
+func (c *RedisClipboardCache) Set(ctx context.Context, clipboard *Clipboard) error {
+	key := clipboardCacheKey(clipboard.SessionID)
+
+	// cached clipboard must not outlive the stored one
+	expiration := clipboardCacheExpiration
+	if !clipboard.ExpiresAt.IsZero() {
+		expiration = min(expiration, time.Until(clipboard.ExpiresAt))
+		if expiration <= 0 {
+			return nil
+		}
+	}
+
+	bytes, err := json.Marshal(clipboard)
+	if err != nil {
+		return fmt.Errorf("marshal clipboard: %w", err)
+	}
+	if err = c.client.Set(ctx, key, bytes, expiration).Err(); err != nil {
+		return fmt.Errorf("set cached clipboard with key=%q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (c *RedisClipboardCache) Delete(ctx context.Context, sessionID uint64) error {
+	key := clipboardCacheKey(sessionID)
+
+	if err := c.client.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("delete cached clipboard with key=%q: %w", key, err)
+	}
+
+	return nil
+}
+
+func NewCachedClipboardStore(store ClipboardStore, cache *RedisClipboardCache, log log.TracedLogger) *CachedClipboardStore {
+	return &CachedClipboardStore{
+		store: store,
+		cache: cache,
+		log:   log,
+	}
+}
+
+func (s *CachedClipboardStore) Get(ctx context.Context, sessionID uint64) (*Clipboard, error) {
+	clipboard, err := s.cache.Get(ctx, sessionID)
+	if err == nil {
+		return clipboard, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		// cache is an optimization, so the store is still consulted
+		s.log.Errorw(ctx, "Failed to get cached clipboard", "sessionID", sessionID, err)
+	}
+
+	if clipboard, err = s.store.Get(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	if err = s.cache.Set(ctx, clipboard); err != nil {
+		s.log.Errorw(ctx, "Failed to cache clipboard", "sessionID", sessionID, err)
+	}
+	return clipboard, nil
+}
+
+func (s *CachedClipboardStore) Add(ctx context.Context, sessionID, authorID uint64, representations []Representation, expiresAt time.Time) (*Clipboard, error) {
+	clipboard, err := s.store.Add(ctx, sessionID, authorID, representations, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.cache.Set(ctx, clipboard); err != nil {
+		s.log.Errorw(ctx, "Failed to cache clipboard, invalidating", "sessionID", sessionID, err)
+		if err = s.cache.Delete(ctx, sessionID); err != nil {
+			s.log.Errorw(ctx, "Failed to invalidate cached clipboard", "sessionID", sessionID, err)
+		}
+	}
+	return clipboard, nil
+}
+
+func (s *CachedClipboardStore) GetHistory(ctx context.Context, sessionID uint64, limit, offset int) ([]*Clipboard, int, error) {
+	return s.store.GetHistory(ctx, sessionID, limit, offset)
+}
+
+func (s *CachedClipboardStore) GetVersion(ctx context.Context, sessionID, version uint64) (*Clipboard, error) {
+	return s.store.GetVersion(ctx, sessionID, version)
+}
+
+func (s *CachedClipboardStore) RecordRead(ctx context.Context, sessionID, version uint64, expiresAt time.Time) (int, error) {
+	return s.store.RecordRead(ctx, sessionID, version, expiresAt)
+}
+
+func (s *CachedClipboardStore) Delete(ctx context.Context, sessionID uint64) error {
+	if err := s.store.Delete(ctx, sessionID); err != nil {
+		return err
+	}
+
+	return s.cache.Delete(ctx, sessionID)
+}
+
+func (s *CachedClipboardStore) DeleteByAuthor(ctx context.Context, sessionID, authorID uint64) error {
+	if err := s.store.DeleteByAuthor(ctx, sessionID, authorID); err != nil {
+		return err
+	}
+
+	// cached clipboard may be one of deleted versions
+	return s.cache.Delete(ctx, sessionID)
+}
+
+// expireAt makes keys expire at expiresAt or persist if it is zero
+func expireAt(ctx context.Context, pipe redis.Pipeliner, expiresAt time.Time, keys ...string) {
+	for _, key := range keys {
+		if expiresAt.IsZero() {
+			pipe.Persist(ctx, key)
+		} else {
+			pipe.PExpireAt(ctx, key, expiresAt)
+		}
+	}
+}
+
+func toClipboard(clipboard *dal.Clipboard) *Clipboard {
+	representations := make([]Representation, 0, len(clipboard.Representations))
+	for _, r := range clipboard.Representations {
+		representations = append(representations, Representation{ContentType: r.ContentType, Content: r.Content})
+	}
+
+	return &Clipboard{
+		SessionID:       clipboard.SessionID,
+		Version:         clipboard.Version,
+		AuthorID:        clipboard.AuthorID,
+		Representations: representations,
+		ExpiresAt:       clipboard.ExpiresAt,
+		UpdatedAt:       clipboard.UpdatedAt,
+	}
+}
+
+func clipboardHistoryKey(id uint64) string {
+	return fmt.Sprintf("clipboard:%s:history", strconv.FormatUint(id, 10))
+}
+
+func clipboardVersionKey(id uint64) string {
+	return fmt.Sprintf("clipboard:%s:version", strconv.FormatUint(id, 10))
+}
+
+func clipboardReadsKey(id uint64) string {
+	return fmt.Sprintf("clipboard:%s:reads", strconv.FormatUint(id, 10))
+}
+
+func clipboardCacheKey(id uint64) string {
+	return fmt.Sprintf("clipboard:%s:current", strconv.FormatUint(id, 10))
+}
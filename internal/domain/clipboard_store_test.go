@@ -0,0 +1,43 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisClipboardStore_DeleteByAuthor(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := NewRedisClipboardStore(client, 10, newTestLogger())
+
+	for _, authorID := range []uint64{testOwnerID, testEditorID, testOwnerID, testEditorID} {
+		if _, err := store.Add(ctx, testSessionID, authorID, []Representation{{ContentType: "text/plain", Content: []byte("text")}}, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("add clipboard: %v", err)
+		}
+	}
+
+	if err := store.DeleteByAuthor(ctx, testSessionID, testEditorID); err != nil {
+		t.Fatalf("delete by author: %v", err)
+	}
+
+	history, total, err := store.GetHistory(ctx, testSessionID, 10, 0)
+	if err != nil {
+		t.Fatalf("get history: %v", err)
+	}
+	if total != 2 || history[0].Version != 3 || history[1].Version != 1 {
+		t.Fatalf("expected versions 3 and 1 of the owner to be kept in order, got %d versions", total)
+	}
+	if ttl := server.TTL(clipboardHistoryKey(testSessionID)); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("expected history to keep its expiration, got ttl %s", ttl)
+	}
+
+	if clipboard, err := store.Add(ctx, testSessionID, testEditorID, []Representation{{ContentType: "text/plain", Content: []byte("text")}}, time.Time{}); err != nil || clipboard.Version != 5 {
+		t.Errorf("expected versions to keep growing, got %+v, err %v", clipboard, err)
+	}
+}
@@ -0,0 +1,433 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	testSessionID = 1
+	testOwnerID   = 10
+	testEditorID  = 11
+	testViewerID  = 12
+	testOutsideID = 13
+)
+
+type (
+	fakeSessionRepository struct {
+		sessions map[uint64]*dal.Session
+	}
+
+	fakeSessionMemberRepository struct {
+		members map[[2]uint64]*dal.SessionMember
+	}
+
+	fakeClipboardStore struct {
+		history map[uint64][]*Clipboard
+		// reads are counted per session and version
+		reads map[[2]uint64]int
+	}
+
+	fakeClipboardEventPublisher struct{}
+)
+
+func (r *fakeSessionRepository) GetByID(id uint64) (*dal.Session, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, dal.ErrNotFound
+	}
+	return session, nil
+}
+
+func (r *fakeSessionRepository) GetAllByUserID(uint64) ([]*dal.Session, error) {
+	return nil, errors.New("not implemented")
+}
+
+// GetOwnedIDs ignores other owners, fake sessions have only one
+func (r *fakeSessionRepository) GetOwnedIDs(userID uint64, _ bool) ([]uint64, error) {
+	var res []uint64
+	for id, session := range r.sessions {
+		if session.UserID == userID {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+func (r *fakeSessionRepository) FilterBy(dal.SessionFilter) ([]*dal.Session, int, error) {
+	return nil, 0, errors.New("not implemented")
+}
+
+func (r *fakeSessionRepository) Create(string, uint64) (*dal.Session, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeSessionRepository) Update(uint64, string, string, int) (*dal.Session, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeSessionRepository) UpdateUpdatedAt(uint64) error {
+	return errors.New("not implemented")
+}
+
+func (r *fakeSessionRepository) Delete(id uint64) error {
+	if _, ok := r.sessions[id]; !ok {
+		return dal.ErrNotFound
+	}
+	delete(r.sessions, id)
+	return nil
+}
+
+func (r *fakeSessionMemberRepository) Get(sessionID, userID uint64) (*dal.SessionMember, error) {
+	member, ok := r.members[[2]uint64{sessionID, userID}]
+	if !ok {
+		return nil, dal.ErrNotFound
+	}
+	return member, nil
+}
+
+func (r *fakeSessionMemberRepository) GetAllBySessionID(sessionID uint64) ([]*dal.SessionMember, error) {
+	var res []*dal.SessionMember
+	for _, m := range r.members {
+		if m.SessionID == sessionID {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+func (r *fakeSessionMemberRepository) Create(uint64, uint64, string) (*dal.SessionMember, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeSessionMemberRepository) UpdateRole(sessionID, userID uint64, role string) (*dal.SessionMember, error) {
+	member, ok := r.members[[2]uint64{sessionID, userID}]
+	if !ok {
+		return nil, dal.ErrNotFound
+	}
+	if role != SessionRoleOwner && r.isLastOwner(member) {
+		return nil, dal.ErrLastSessionOwner
+	}
+	member.Role = role
+	return member, nil
+}
+
+func (r *fakeSessionMemberRepository) Delete(sessionID, userID uint64) error {
+	member, ok := r.members[[2]uint64{sessionID, userID}]
+	if !ok {
+		return dal.ErrNotFound
+	}
+	if r.isLastOwner(member) {
+		return dal.ErrLastSessionOwner
+	}
+	delete(r.members, [2]uint64{sessionID, userID})
+	return nil
+}
+
+func (r *fakeSessionMemberRepository) isLastOwner(member *dal.SessionMember) bool {
+	if member.Role != SessionRoleOwner {
+		return false
+	}
+	for _, m := range r.members {
+		if m.SessionID == member.SessionID && m.Role == SessionRoleOwner && m.UserID != member.UserID {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *fakeClipboardStore) Get(_ context.Context, sessionID uint64) (*Clipboard, error) {
+	history := s.history[sessionID]
+	if len(history) == 0 {
+		return nil, ErrNotFound
+	}
+	return history[len(history)-1], nil
+}
+
+func (s *fakeClipboardStore) Add(
+	_ context.Context, sessionID, authorID uint64, representations []Representation, expiresAt time.Time,
+) (*Clipboard, error) {
+	clipboard := &Clipboard{
+		SessionID:       sessionID,
+		Version:         uint64(len(s.history[sessionID]) + 1),
+		AuthorID:        authorID,
+		Representations: representations,
+		ExpiresAt:       expiresAt,
+		UpdatedAt:       time.Now(),
+	}
+	s.history[sessionID] = append(s.history[sessionID], clipboard)
+	return clipboard, nil
+}
+
+func (s *fakeClipboardStore) GetHistory(_ context.Context, sessionID uint64, limit, offset int) ([]*Clipboard, int, error) {
+	history := s.history[sessionID]
+	if offset >= len(history) {
+		return nil, len(history), nil
+	}
+	return history[offset:min(offset+limit, len(history))], len(history), nil
+}
+
+func (s *fakeClipboardStore) GetVersion(_ context.Context, sessionID, version uint64) (*Clipboard, error) {
+	for _, c := range s.history[sessionID] {
+		if c.Version == version {
+			return c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *fakeClipboardStore) RecordRead(_ context.Context, sessionID, version uint64, _ time.Time) (int, error) {
+	if s.reads == nil {
+		s.reads = make(map[[2]uint64]int)
+	}
+	s.reads[[2]uint64{sessionID, version}]++
+	return s.reads[[2]uint64{sessionID, version}], nil
+}
+
+func (s *fakeClipboardStore) Delete(_ context.Context, sessionID uint64) error {
+	delete(s.history, sessionID)
+	return nil
+}
+
+func (s *fakeClipboardStore) DeleteByAuthor(_ context.Context, sessionID, authorID uint64) error {
+	kept := s.history[sessionID][:0]
+	for _, c := range s.history[sessionID] {
+		if c.AuthorID != authorID {
+			kept = append(kept, c)
+		}
+	}
+	s.history[sessionID] = kept
+	return nil
+}
+
+func (p *fakeClipboardEventPublisher) Publish(context.Context, *ClipboardEvent) error {
+	return nil
+}
+
+func newTestLogger() log.TracedLogger {
+	return log.NewZapTracedLogger(zap.NewNop().Sugar())
+}
+
+// newTestSessionService returns service of a single session with owner, editor and viewer members
+func newTestSessionService(t *testing.T) *SessionService {
+	t.Helper()
+
+	service, _ := newTestSessionServiceWithStore(t, &fakeClipboardStore{history: map[uint64][]*Clipboard{}})
+	return service
+}
+
+func newTestSessionServiceWithStore(t *testing.T, store ClipboardStore) (*SessionService, *fakeSessionRepository) {
+	t.Helper()
+
+	sessions := &fakeSessionRepository{sessions: map[uint64]*dal.Session{
+		testSessionID: {ID: testSessionID, Name: "test", UserID: testOwnerID, RetentionPolicy: RetentionNever},
+	}}
+	members := &fakeSessionMemberRepository{members: map[[2]uint64]*dal.SessionMember{}}
+	for userID, role := range map[uint64]string{
+		testOwnerID:  SessionRoleOwner,
+		testEditorID: SessionRoleEditor,
+		testViewerID: SessionRoleViewer,
+	} {
+		members.members[[2]uint64{testSessionID, userID}] = &dal.SessionMember{SessionID: testSessionID, UserID: userID, Role: role}
+	}
+
+	return NewSessionService(sessions, members, nil, store, 0, newTestLogger()), sessions
+}
+
+// newTestClipboardStore returns store with clipboard of the test session
+func newTestClipboardStore() *fakeClipboardStore {
+	return &fakeClipboardStore{history: map[uint64][]*Clipboard{
+		testSessionID: {{
+			SessionID:       testSessionID,
+			Version:         1,
+			AuthorID:        testOwnerID,
+			Representations: []Representation{{ContentType: "text/plain", Content: []byte("secret")}},
+		}},
+	}}
+}
+
+func newTestContentPolicy(t *testing.T) *ContentPolicy {
+	t.Helper()
+
+	policy, err := NewContentPolicy([]string{"text/plain"}, 1024)
+	if err != nil {
+		t.Fatalf("create content policy: %v", err)
+	}
+	return policy
+}
+
+func newTestClipboardService(t *testing.T) (*ClipboardService, *fakeClipboardStore) {
+	t.Helper()
+
+	policy := newTestContentPolicy(t)
+	store := newTestClipboardStore()
+	sessions, _ := newTestSessionServiceWithStore(t, store)
+	return NewClipboardService(
+		store, sessions, nil, &fakeClipboardEventPublisher{}, policy, 0, newTestLogger(),
+	), store
+}
+
+func TestClipboardService_NonMemberGetsNotFound(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestClipboardService(t)
+	content := []Representation{{ContentType: "text/plain", Content: []byte("overwritten")}}
+
+	calls := map[string]func() error{
+		"GetBySessionID": func() error {
+			_, err := service.GetBySessionID(ctx, testOutsideID, testSessionID)
+			return err
+		},
+		"ReadBySessionID": func() error {
+			_, err := service.ReadBySessionID(ctx, testOutsideID, testSessionID)
+			return err
+		},
+		"SetBySessionID": func() error {
+			_, err := service.SetBySessionID(ctx, testOutsideID, testSessionID, content)
+			return err
+		},
+		"GetHistory": func() error {
+			_, _, err := service.GetHistory(ctx, testOutsideID, testSessionID, 10, 0)
+			return err
+		},
+		"GetVersion": func() error {
+			_, err := service.GetVersion(ctx, testOutsideID, testSessionID, 1)
+			return err
+		},
+		"Restore": func() error {
+			_, err := service.Restore(ctx, testOutsideID, testSessionID, 1)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("expected ErrSessionNotFound, got %v", err)
+			}
+		})
+	}
+
+	if len(store.history[testSessionID]) != 1 {
+		t.Errorf("expected clipboard to stay unchanged, got %d versions", len(store.history[testSessionID]))
+	}
+}
+
+func TestClipboardService_MissingSessionIsNotWritten(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestClipboardService(t)
+
+	_, err := service.SetBySessionID(ctx, testOwnerID, testSessionID+1, []Representation{{ContentType: "text/plain", Content: []byte("a")}})
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+	if _, ok := store.history[testSessionID+1]; ok {
+		t.Error("expected clipboard of missing session not to be stored")
+	}
+}
+
+func TestClipboardService_ViewerCanNotWrite(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestClipboardService(t)
+
+	if _, err := service.GetBySessionID(ctx, testViewerID, testSessionID); err != nil {
+		t.Fatalf("expected viewer to read clipboard, got %v", err)
+	}
+
+	_, err := service.SetBySessionID(ctx, testViewerID, testSessionID, []Representation{{ContentType: "text/plain", Content: []byte("a")}})
+	if !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on set, got %v", err)
+	}
+	if _, err = service.Restore(ctx, testViewerID, testSessionID, 1); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on restore, got %v", err)
+	}
+
+	if len(store.history[testSessionID]) != 1 {
+		t.Errorf("expected clipboard to stay unchanged, got %d versions", len(store.history[testSessionID]))
+	}
+}
+
+func TestClipboardService_EditorCanWrite(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestClipboardService(t)
+
+	clipboard, err := service.SetBySessionID(ctx, testEditorID, testSessionID, []Representation{{ContentType: "text/plain", Content: []byte("a")}})
+	if err != nil {
+		t.Fatalf("expected editor to set clipboard, got %v", err)
+	}
+	if clipboard.Version != 2 || clipboard.AuthorID != testEditorID {
+		t.Errorf("unexpected clipboard version=%d author=%d", clipboard.Version, clipboard.AuthorID)
+	}
+
+	if _, err = service.Restore(ctx, testEditorID, testSessionID, 1); err != nil {
+		t.Errorf("expected editor to restore clipboard, got %v", err)
+	}
+}
+
+func TestClipboardService_ReadsRetentionCountsVersionReads(t *testing.T) {
+	ctx := context.Background()
+	store := newTestClipboardStore()
+	sessions, sessionRepo := newTestSessionServiceWithStore(t, store)
+	sessionRepo.sessions[testSessionID].RetentionPolicy = RetentionReads
+	sessionRepo.sessions[testSessionID].RetentionValue = 2
+	service := NewClipboardService(store, sessions, nil, &fakeClipboardEventPublisher{}, newTestContentPolicy(t), 0, newTestLogger())
+
+	if _, err := service.SetBySessionID(ctx, testOwnerID, testSessionID, []Representation{{ContentType: "text/plain", Content: []byte("v2")}}); err != nil {
+		t.Fatalf("set clipboard: %v", err)
+	}
+
+	if entries, total, err := service.GetHistory(ctx, testViewerID, testSessionID, 10, 0); err != nil || len(entries) != 0 || total != 0 {
+		t.Errorf("expected history to be hidden, got %d entries of %d, err %v", len(entries), total, err)
+	}
+	if _, err := service.GetVersion(ctx, testViewerID, testSessionID, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected older version not to be readable, got %v", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if _, err := service.GetVersion(ctx, testViewerID, testSessionID, 2); err != nil {
+			t.Fatalf("expected read %d of current version to succeed, got %v", i, err)
+		}
+	}
+	if _, err := service.GetVersion(ctx, testViewerID, testSessionID, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected current version to expire after reads, got %v", err)
+	}
+	if _, err := service.ReadBySessionID(ctx, testViewerID, testSessionID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected clipboard to be gone after reads, got %v", err)
+	}
+}
+
+func TestClipboardService_ReadByShareLinkKeepsSingleUseLinkWithoutClipboard(t *testing.T) {
+	ctx := context.Background()
+	store := newTestClipboardStore()
+	_ = store.Delete(ctx, testSessionID)
+	link := &dal.ShareLink{ID: 1, SessionID: testSessionID, SingleUse: true, ExpiresAt: time.Now().Add(time.Hour)}
+	sessions, sessionRepo := newTestSessionServiceWithStore(t, store)
+	shareLinks := NewShareLinkService(
+		&fakeShareLinkRepository{tokenHash: hashSecretToken(testShareLinkToken), link: link},
+		sessionRepo, sessions, nil, time.Hour, time.Hour, 3, newTestLogger(),
+	)
+	service := NewClipboardService(store, sessions, shareLinks, &fakeClipboardEventPublisher{}, newTestContentPolicy(t), 0, newTestLogger())
+
+	if _, err := service.ReadByShareLink(ctx, testShareLinkToken, ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for session without clipboard, got %v", err)
+	}
+	if link.AccessCount != 0 {
+		t.Fatalf("expected link not to be used up, got %d accesses", link.AccessCount)
+	}
+
+	content := []Representation{{ContentType: "text/plain", Content: []byte("shared")}}
+	if _, err := store.Add(ctx, testSessionID, testOwnerID, content, time.Time{}); err != nil {
+		t.Fatalf("add clipboard: %v", err)
+	}
+	if _, err := service.ReadByShareLink(ctx, testShareLinkToken, ""); err != nil {
+		t.Fatalf("read by share link: %v", err)
+	}
+	if _, err := service.ReadByShareLink(ctx, testShareLinkToken, ""); !errors.Is(err, ErrShareLinkNotFound) {
+		t.Errorf("expected single use link to be used up, got %v", err)
+	}
+}
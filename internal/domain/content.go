@@ -0,0 +1,142 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	contentTypeOctetStream = "application/octet-stream"
+	charsetParam           = "charset"
+)
+
+var (
+	ErrUnsupportedContentType  = errors.New("unsupported content type")
+	ErrContentTypeMismatch     = errors.New("content does not match content type")
+	ErrContentTooLarge         = errors.New("content is too large")
+	ErrNoRepresentations       = errors.New("no representations")
+	ErrDuplicateRepresentation = errors.New("duplicate representation")
+)
+
+type ContentPolicy struct {
+	allowed map[string]struct{}
+	maxSize int64
+}
+
+func NewContentPolicy(allowedTypes []string, maxSize int64) (*ContentPolicy, error) {
+	allowed := make(map[string]struct{}, len(allowedTypes))
+	for _, t := range allowedTypes {
+		mediaType, _, err := mime.ParseMediaType(t)
+		if err != nil {
+			return nil, fmt.Errorf("parse allowed content type %q: %w", t, err)
+		}
+		allowed[mediaType] = struct{}{}
+	}
+
+	return &ContentPolicy{
+		allowed: allowed,
+		maxSize: maxSize,
+	}, nil
+}
+
+// ValidateAll validates every representation and their total size.
+// It returns representations with normalized content types.
+func (p *ContentPolicy) ValidateAll(representations []Representation) ([]Representation, error) {
+	if len(representations) == 0 {
+		return nil, ErrNoRepresentations
+	}
+
+	var (
+		res        = make([]Representation, 0, len(representations))
+		mediaTypes = make(map[string]struct{}, len(representations))
+		size       int64
+	)
+	for _, r := range representations {
+		size += int64(len(r.Content))
+		if size > p.maxSize {
+			return nil, fmt.Errorf("%w: total size exceeds limit of %d bytes", ErrContentTooLarge, p.maxSize)
+		}
+
+		contentType, err := p.Validate(r.ContentType, r.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if _, ok := mediaTypes[mediaType]; ok {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateRepresentation, mediaType)
+		}
+		mediaTypes[mediaType] = struct{}{}
+
+		res = append(res, Representation{ContentType: contentType, Content: r.Content})
+	}
+
+	return res, nil
+}
+
+// Validate checks that content type is allowed and that content looks like declared type.
+// It returns normalized content type, where only charset parameter is preserved.
+func (p *ContentPolicy) Validate(contentType string, content []byte) (string, error) {
+	if int64(len(content)) > p.maxSize {
+		return "", fmt.Errorf("%w: %d bytes exceeds limit of %d bytes", ErrContentTooLarge, len(content), p.maxSize)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: parse %q: %s", ErrUnsupportedContentType, contentType, err)
+	}
+	if _, ok := p.allowed[mediaType]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedContentType, mediaType)
+	}
+
+	normalizedParams := make(map[string]string, 1)
+	charset, hasCharset := params[charsetParam]
+	if hasCharset {
+		if !isTextMediaType(mediaType) {
+			return "", fmt.Errorf("%w: charset is not supported for %q", ErrUnsupportedContentType, mediaType)
+		}
+		normalizedParams[charsetParam] = strings.ToLower(charset)
+	}
+
+	if err = sniff(mediaType, normalizedParams[charsetParam], content); err != nil {
+		return "", err
+	}
+
+	return mime.FormatMediaType(mediaType, normalizedParams), nil
+}
+
+func sniff(mediaType, charset string, content []byte) error {
+	if mediaType == contentTypeOctetStream {
+		return nil
+	}
+
+	sniffed, _, err := mime.ParseMediaType(http.DetectContentType(content))
+	if err != nil {
+		return fmt.Errorf("parse detected content type: %w", err)
+	}
+
+	if isTextMediaType(mediaType) {
+		// markup fragments are often detected as plain text, so any text is accepted for any text type
+		if !isTextMediaType(sniffed) {
+			return fmt.Errorf("%w: declared %q, detected %q", ErrContentTypeMismatch, mediaType, sniffed)
+		}
+		if (charset == "" || charset == "utf-8") && !utf8.Valid(content) {
+			return fmt.Errorf("%w: content is not valid utf-8", ErrContentTypeMismatch)
+		}
+		return nil
+	}
+
+	if sniffed != mediaType {
+		return fmt.Errorf("%w: declared %q, detected %q", ErrContentTypeMismatch, mediaType, sniffed)
+	}
+
+	return nil
+}
+
+func isTextMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/")
+}
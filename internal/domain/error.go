@@ -15,18 +15,54 @@ type (
 var (
 	ErrNotFound = fmt.Errorf("not found")
 
-	ErrorBadRequest              = ErrorCode{"ERR_0400", http.StatusBadRequest}
-	ErrorCodeUnauthorized        = ErrorCode{"ERR_0401", http.StatusUnauthorized}
-	ErrorCodeForbidden           = ErrorCode{"ERR_0403", http.StatusForbidden}
-	ErrorCodeNotFound            = ErrorCode{"ERR_0404", http.StatusNotFound}
-	ErrorCodeMethodNotAllowed    = ErrorCode{"ERR_0405", http.StatusMethodNotAllowed}
-	ErrorCodeInternalServerError = ErrorCode{"ERR_0500", http.StatusInternalServerError}
+	ErrorBadRequest               = ErrorCode{"ERR_0400", http.StatusBadRequest}
+	ErrorCodeUnauthorized         = ErrorCode{"ERR_0401", http.StatusUnauthorized}
+	ErrorCodeForbidden            = ErrorCode{"ERR_0403", http.StatusForbidden}
+	ErrorCodeNotFound             = ErrorCode{"ERR_0404", http.StatusNotFound}
+	ErrorCodeMethodNotAllowed     = ErrorCode{"ERR_0405", http.StatusMethodNotAllowed}
+	ErrorCodeContentTooLarge      = ErrorCode{"ERR_0413", http.StatusRequestEntityTooLarge}
+	ErrorCodeUnsupportedMediaType = ErrorCode{"ERR_0415", http.StatusUnsupportedMediaType}
+	ErrorCodeInternalServerError  = ErrorCode{"ERR_0500", http.StatusInternalServerError}
 
 	ErrorCodeSignupBadRequest   = ErrorCode{"ERR_2101", http.StatusBadRequest}
 	ErrorCodeSignupConflict     = ErrorCode{"ERR_2102", http.StatusBadRequest}
 	ErrorCodeSiginWrongPassword = ErrorCode{"ERR_2103", http.StatusForbidden}
+	ErrorCodeInvalidCredentials = ErrorCode{"ERR_2104", http.StatusUnauthorized}
+	ErrorCodeSignInLocked       = ErrorCode{"ERR_2105", http.StatusTooManyRequests}
+	ErrorCodeUserDisabled       = ErrorCode{"ERR_2106", http.StatusForbidden}
 
 	ErrorCodeUserNotFound = ErrorCode{"ERR_2201", http.StatusBadRequest}
+
+	ErrorCodeAPITokenConflict = ErrorCode{"ERR_2301", http.StatusConflict}
+
+	ErrorCodeTwoFactorEnabled    = ErrorCode{"ERR_2401", http.StatusConflict}
+	ErrorCodeTwoFactorNotEnabled = ErrorCode{"ERR_2402", http.StatusBadRequest}
+	ErrorCodeTwoFactorWrongCode  = ErrorCode{"ERR_2403", http.StatusBadRequest}
+
+	ErrorCodeIdentityConflict = ErrorCode{"ERR_2501", http.StatusConflict}
+
+	ErrorCodePasswordBadRequest   = ErrorCode{"ERR_2601", http.StatusBadRequest}
+	ErrorCodePasswordResetInvalid = ErrorCode{"ERR_2602", http.StatusBadRequest}
+	ErrorCodeEmailBadRequest      = ErrorCode{"ERR_2603", http.StatusBadRequest}
+	ErrorCodeEmailConflict        = ErrorCode{"ERR_2604", http.StatusConflict}
+
+	ErrorCodeCSRFTokenInvalid = ErrorCode{"ERR_2701", http.StatusForbidden}
+
+	ErrorCodeAdminSelfAction = ErrorCode{"ERR_2801", http.StatusBadRequest}
+
+	ErrorCodeRegistrationClosed = ErrorCode{"ERR_2901", http.StatusForbidden}
+	ErrorCodeInvitationInvalid  = ErrorCode{"ERR_2902", http.StatusBadRequest}
+
+	ErrorCodeContentTypeMismatch     = ErrorCode{"ERR_3101", http.StatusBadRequest}
+	ErrorCodeNoRepresentations       = ErrorCode{"ERR_3102", http.StatusBadRequest}
+	ErrorCodeDuplicateRepresentation = ErrorCode{"ERR_3103", http.StatusBadRequest}
+
+	ErrorCodeSessionMemberConflict = ErrorCode{"ERR_3201", http.StatusConflict}
+	ErrorCodeLastSessionOwner      = ErrorCode{"ERR_3202", http.StatusBadRequest}
+
+	ErrorCodeShareLinkPasswordRequired = ErrorCode{"ERR_3301", http.StatusUnauthorized}
+	ErrorCodeShareLinkWrongPassword    = ErrorCode{"ERR_3302", http.StatusUnauthorized}
+	ErrorCodeShareLinkLocked           = ErrorCode{"ERR_3303", http.StatusForbidden}
 )
 
 type RenderableError struct {
@@ -0,0 +1,170 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	// RegistrationModeOpen lets anyone sign up
+	RegistrationModeOpen = "open"
+	// RegistrationModeInviteOnly requires invitation code to sign up
+	RegistrationModeInviteOnly = "invite_only"
+	// RegistrationModeClosed does not let anyone sign up, users are only created before it is closed
+	RegistrationModeClosed = "closed"
+
+	// InvitationCodePrefix makes invitation codes recognizable
+	InvitationCodePrefix = "sci_"
+)
+
+var (
+	ErrInvitationNotFound         = errors.New("invitation not found")
+	ErrInvitationPermissionDenied = errors.New("invitation permission denied")
+	// ErrRegistrationClosed is returned when unknown user signs in with identity provider, but registration is not open
+	ErrRegistrationClosed = errors.New("registration is closed")
+)
+
+type (
+	Invitation struct {
+		ID            uint64
+		CreatedBy     uint64
+		CreatedByName string
+		// Code is only known right after invitation is created, only its hash is stored
+		Code      string
+		MaxUses   int
+		Uses      int
+		ExpiresAt time.Time
+		// RevokedAt is zero if invitation was not revoked
+		RevokedAt time.Time
+		CreatedAt time.Time
+	}
+
+	InvitationRepository interface {
+		GetAll(createdBy uint64) ([]*dal.Invitation, error)
+		Create(createdBy uint64, codeHash string, maxUses int, expiresAt time.Time) (*dal.Invitation, error)
+		Revoke(createdBy, id uint64) error
+		CreateUser(codeHash, name, password, passwordSalt, email string) (*dal.User, error)
+	}
+
+	AdminChecker interface {
+		IsAdmin(ctx context.Context, userID uint64) (bool, error)
+	}
+
+	InvitationConfig struct {
+		// UsersCanInvite lets users who are not admins create invitations
+		UsersCanInvite bool
+		MaxUses        int
+		MaxExpireIn    time.Duration
+	}
+
+	// InvitationService issues invitation codes users sign up with when registration is invite only
+	InvitationService struct {
+		repo   InvitationRepository
+		admins AdminChecker
+		conf   InvitationConfig
+		log    log.TracedLogger
+	}
+)
+
+func NewInvitationService(repo InvitationRepository, admins AdminChecker, conf InvitationConfig, log log.TracedLogger) *InvitationService {
+	return &InvitationService{
+		repo:   repo,
+		admins: admins,
+		conf:   conf,
+		log:    log,
+	}
+}
+
+// Create issues invitation which can be used maxUses times within expireIn
+func (s *InvitationService) Create(ctx context.Context, userID uint64, maxUses int, expireIn time.Duration) (*Invitation, error) {
+	s.log.Debugw(ctx, "create invitation", "userID", userID, "maxUses", maxUses, "expireIn", expireIn)
+
+	if !s.conf.UsersCanInvite {
+		admin, err := s.admins.IsAdmin(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("check admin role: %w", err)
+		}
+		if !admin {
+			return nil, ErrInvitationPermissionDenied
+		}
+	}
+
+	if maxUses < 1 || maxUses > s.conf.MaxUses {
+		return nil, &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: fmt.Sprintf("Max uses must be between 1 and %d", s.conf.MaxUses),
+		}
+	}
+	if expireIn <= 0 || expireIn > s.conf.MaxExpireIn {
+		return nil, &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: fmt.Sprintf("Expiration must be positive and not longer than %d hours", int(s.conf.MaxExpireIn.Hours())),
+		}
+	}
+
+	code, err := newSecretToken(InvitationCodePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("generate invitation code: %w", err)
+	}
+
+	created, err := s.repo.Create(userID, hashSecretToken(code), maxUses, time.Now().Add(expireIn))
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	s.log.Infow(ctx, "Invitation created", "userID", userID, "invitationID", created.ID)
+	res := toInvitation(created)
+	res.Code = code
+	return res, nil
+}
+
+// GetAll returns invitations created by the user, zero createdBy returns invitations of all users
+func (s *InvitationService) GetAll(ctx context.Context, createdBy uint64) ([]*Invitation, error) {
+	s.log.Debugw(ctx, "get invitations", "createdBy", createdBy)
+
+	invitations, err := s.repo.GetAll(createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("get invitations: %w", err)
+	}
+
+	res := make([]*Invitation, 0, len(invitations))
+	for _, i := range invitations {
+		res = append(res, toInvitation(i))
+	}
+	return res, nil
+}
+
+// Revoke revokes invitation created by the user, zero createdBy revokes invitation of any user.
+// Users who already signed up with it are not affected.
+func (s *InvitationService) Revoke(ctx context.Context, createdBy, id uint64) error {
+	s.log.Debugw(ctx, "revoke invitation", "createdBy", createdBy, "invitationID", id)
+
+	if err := s.repo.Revoke(createdBy, id); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return ErrInvitationNotFound
+		}
+
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+
+	s.log.Infow(ctx, "Invitation revoked", "createdBy", createdBy, "invitationID", id)
+	return nil
+}
+
+func toInvitation(invitation *dal.Invitation) *Invitation {
+	return &Invitation{
+		ID:            invitation.ID,
+		CreatedBy:     invitation.CreatedBy,
+		CreatedByName: invitation.CreatedByName,
+		MaxUses:       invitation.MaxUses,
+		Uses:          invitation.Uses,
+		ExpiresAt:     invitation.ExpiresAt,
+		RevokedAt:     invitation.RevokedAt,
+		CreatedAt:     invitation.CreatedAt,
+	}
+}
@@ -0,0 +1,264 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	loginMaxUserAgentLength  = 512
+	loginMaxIPLength         = 64
+	loginMaxDeviceNameLength = 128
+	unknownDeviceName        = "Unknown device"
+)
+
+var ErrLoginNotFound = errors.New("login not found")
+
+type (
+	// Login is a sign in of the user on some device, it lasts while its refresh token family can be exchanged
+	Login struct {
+		ID     uint64
+		UserID uint64
+		// AccessTokenID is JTI of the latest access token issued for the login
+		AccessTokenID string
+		UserAgent     string
+		IP            string
+		DeviceName    string
+		CreatedAt     time.Time
+		LastSeenAt    time.Time
+	}
+
+	// LoginTokens identify tokens login is authenticated with
+	LoginTokens struct {
+		// FamilyID is refresh token family of the login
+		FamilyID        string
+		AccessTokenID   string
+		AccessExpiresAt time.Time
+	}
+
+	LoginRepository interface {
+		GetAllActiveByUserID(userID uint64) ([]*dal.Login, error)
+		GetActive(userID, id uint64) (*dal.Login, error)
+		Create(userID uint64, familyID, jti string, accessExpiresAt time.Time, userAgent, ip, deviceName string) (*dal.Login, error)
+		UpdateAccessToken(familyID, jti string, accessExpiresAt time.Time, ip string) error
+	}
+
+	LoginRefreshTokenRepository interface {
+		RevokeFamily(familyID string) error
+		RevokeAllByUserID(userID uint64) error
+	}
+
+	TokenGenerationRepository interface {
+		GetTokenGeneration(id uint64) (uint64, error)
+		IncrementTokenGeneration(id uint64) error
+	}
+
+	JTIBlocker interface {
+		CreateBlockedJTI(ctx context.Context, jti string, expires time.Time) error
+	}
+
+	// LoginService tracks where users are signed in and signs them out of specific or all devices
+	LoginService struct {
+		repo             LoginRepository
+		refreshTokenRepo LoginRefreshTokenRepository
+		userRepo         TokenGenerationRepository
+		jtiBlocker       JTIBlocker
+		log              log.TracedLogger
+	}
+)
+
+func NewLoginService(
+	repo LoginRepository, refreshTokenRepo LoginRefreshTokenRepository, userRepo TokenGenerationRepository, jtiBlocker JTIBlocker,
+	log log.TracedLogger,
+) *LoginService {
+	return &LoginService{
+		repo:             repo,
+		refreshTokenRepo: refreshTokenRepo,
+		userRepo:         userRepo,
+		jtiBlocker:       jtiBlocker,
+		log:              log,
+	}
+}
+
+// Create records a new sign in of the user
+func (s *LoginService) Create(ctx context.Context, userID uint64, tokens LoginTokens, userAgent, ip string) (*Login, error) {
+	s.log.Debugw(ctx, "create login", "userID", userID)
+
+	created, err := s.repo.Create(
+		userID, tokens.FamilyID, tokens.AccessTokenID, tokens.AccessExpiresAt,
+		truncate(userAgent, loginMaxUserAgentLength), truncate(ip, loginMaxIPLength), deviceName(userAgent),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create login: %w", err)
+	}
+
+	s.log.Debugw(ctx, "login created", "userID", userID, "loginID", created.ID)
+	return toLogin(created), nil
+}
+
+// Touch records access token issued for the login on refresh. Logins created before they were tracked are ignored.
+func (s *LoginService) Touch(ctx context.Context, tokens LoginTokens, ip string) error {
+	if err := s.repo.UpdateAccessToken(tokens.FamilyID, tokens.AccessTokenID, tokens.AccessExpiresAt, truncate(ip, loginMaxIPLength)); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "login to touch not found", "familyID", tokens.FamilyID)
+			return nil
+		}
+
+		return fmt.Errorf("update login access token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *LoginService) GetAll(ctx context.Context, userID uint64) ([]*Login, error) {
+	s.log.Debugw(ctx, "get logins", "userID", userID)
+
+	logins, err := s.repo.GetAllActiveByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get logins by userID=%d: %w", userID, err)
+	}
+
+	res := make([]*Login, 0, len(logins))
+	for _, l := range logins {
+		res = append(res, toLogin(l))
+	}
+	return res, nil
+}
+
+// Revoke signs the login out by blocking its access token and revoking its refresh tokens
+func (s *LoginService) Revoke(ctx context.Context, userID, loginID uint64) error {
+	s.log.Debugw(ctx, "revoke login", "userID", userID, "loginID", loginID)
+
+	login, err := s.repo.GetActive(userID, loginID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return ErrLoginNotFound
+		}
+
+		return fmt.Errorf("get login: %w", err)
+	}
+
+	if login.AccessExpiresAt.After(time.Now()) {
+		if err = s.jtiBlocker.CreateBlockedJTI(ctx, login.JTI, login.AccessExpiresAt); err != nil {
+			return fmt.Errorf("block login access token: %w", err)
+		}
+	}
+	if err = s.refreshTokenRepo.RevokeFamily(login.FamilyID); err != nil {
+		return fmt.Errorf("revoke login refresh tokens: %w", err)
+	}
+
+	s.log.Infow(ctx, "Login revoked", "userID", userID, "loginID", loginID)
+	return nil
+}
+
+// RevokeAll signs the user out everywhere. Bumping token generation invalidates all access tokens issued so far,
+// including ones of logins created before they were tracked. Personal API tokens are not affected.
+func (s *LoginService) RevokeAll(ctx context.Context, userID uint64) error {
+	s.log.Debugw(ctx, "revoke all logins", "userID", userID)
+
+	if err := s.userRepo.IncrementTokenGeneration(userID); err != nil {
+		return fmt.Errorf("increment token generation: %w", err)
+	}
+	if err := s.refreshTokenRepo.RevokeAllByUserID(userID); err != nil {
+		return fmt.Errorf("revoke refresh tokens: %w", err)
+	}
+
+	s.log.Infow(ctx, "All logins revoked", "userID", userID)
+	return nil
+}
+
+// IsCurrentTokenGeneration checks if access token of the generation was issued after the user last signed out everywhere
+func (s *LoginService) IsCurrentTokenGeneration(ctx context.Context, userID, generation uint64) (bool, error) {
+	current, err := s.userRepo.GetTokenGeneration(userID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "user of access token not found", "userID", userID)
+			return false, nil
+		}
+
+		return false, fmt.Errorf("get token generation: %w", err)
+	}
+
+	return current == generation, nil
+}
+
+func toLogin(login *dal.Login) *Login {
+	return &Login{
+		ID:            login.ID,
+		UserID:        login.UserID,
+		AccessTokenID: login.JTI,
+		UserAgent:     login.UserAgent,
+		IP:            login.IP,
+		DeviceName:    login.DeviceName,
+		CreatedAt:     login.CreatedAt,
+		LastSeenAt:    login.LastSeenAt,
+	}
+}
+
+// deviceName makes human friendly name like "Firefox on Windows" out of user agent
+func deviceName(userAgent string) string {
+	var browser, os string
+
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+
+	// non browser clients usually start user agent with their name, e.g. "curl/8.4.0"
+	product, _, _ := strings.Cut(userAgent, " ")
+	product, _, _ = strings.Cut(product, "/")
+	if product == "" {
+		return unknownDeviceName
+	}
+	return truncate(product, loginMaxDeviceNameLength)
+}
+
+// truncate cuts s to at most n bytes without splitting multibyte characters
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
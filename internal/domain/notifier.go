@@ -0,0 +1,153 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	clipboardEventsChannelPrefix = "clipboard-events:"
+	subscriberBufferSize         = 8
+)
+
+type (
+	ClipboardEvent struct {
+		SessionID    uint64    `json:"session_id"`
+		Version      uint64    `json:"version"`
+		AuthorID     uint64    `json:"author_id"`
+		ContentTypes []string  `json:"content_types"`
+		Size         int       `json:"size"`
+		UpdatedAt    time.Time `json:"updated_at"`
+	}
+
+	ClipboardNotifier struct {
+		client RedisClient
+
+		mux         sync.RWMutex
+		subscribers map[uint64]map[chan *ClipboardEvent]struct{}
+
+		log log.TracedLogger
+	}
+)
+
+func NewClipboardEvent(clipboard *Clipboard) *ClipboardEvent {
+	return &ClipboardEvent{
+		SessionID:    clipboard.SessionID,
+		Version:      clipboard.Version,
+		AuthorID:     clipboard.AuthorID,
+		ContentTypes: clipboard.ContentTypes(),
+		Size:         clipboard.Size(),
+		UpdatedAt:    clipboard.UpdatedAt,
+	}
+}
+
+func (e *ClipboardEvent) ID() string {
+	return strconv.FormatUint(e.Version, 10)
+}
+
+func NewClipboardNotifier(client RedisClient, log log.TracedLogger) *ClipboardNotifier {
+	return &ClipboardNotifier{
+		client:      client,
+		subscribers: make(map[uint64]map[chan *ClipboardEvent]struct{}),
+		log:         log,
+	}
+}
+
+func (n *ClipboardNotifier) Publish(ctx context.Context, event *ClipboardEvent) error {
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal clipboard event: %w", err)
+	}
+
+	channel := clipboardEventsChannel(strconv.FormatUint(event.SessionID, 10))
+	if err = n.client.Publish(ctx, channel, bytes).Err(); err != nil {
+		return fmt.Errorf("publish clipboard event to channel=%q: %w", channel, err)
+	}
+
+	return nil
+}
+
+// Run receives clipboard events published by any app replica and dispatches them to local subscribers until ctx is done.
+func (n *ClipboardNotifier) Run(ctx context.Context) {
+	pubSub := n.client.PSubscribe(ctx, clipboardEventsChannel("*"))
+	defer func() {
+		if err := pubSub.Close(); err != nil {
+			n.log.Errorw(ctx, "Close clipboard events subscription", err)
+		}
+	}()
+
+	n.log.Infow(ctx, "Listening for clipboard events")
+	messages := pubSub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var event ClipboardEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				n.log.Errorw(ctx, "Unmarshal clipboard event", "channel", msg.Channel, err)
+				continue
+			}
+			n.dispatch(&event)
+		}
+	}
+}
+
+// Subscribe returns a channel with events of the session and a function that must be called to unsubscribe.
+func (n *ClipboardNotifier) Subscribe(sessionID uint64) (<-chan *ClipboardEvent, func()) {
+	ch := make(chan *ClipboardEvent, subscriberBufferSize)
+
+	n.mux.Lock()
+	subs, ok := n.subscribers[sessionID]
+	if !ok {
+		subs = make(map[chan *ClipboardEvent]struct{})
+		n.subscribers[sessionID] = subs
+	}
+	subs[ch] = struct{}{}
+	n.mux.Unlock()
+
+	return ch, func() {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+
+		delete(subs, ch)
+		if len(subs) == 0 {
+			delete(n.subscribers, sessionID)
+		}
+	}
+}
+
+func (n *ClipboardNotifier) dispatch(event *ClipboardEvent) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	for ch := range n.subscribers[event.SessionID] {
+		select {
+		case ch <- event:
+		default:
+			// subscriber is too slow, drop the oldest event as only the latest clipboard state matters
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+func clipboardEventsChannel(suffix string) string {
+	return clipboardEventsChannelPrefix + suffix
+}
@@ -0,0 +1,438 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+	"github.com/Roma7-7-7/shared-clipboard/tools"
+)
+
+const (
+	// OIDCStateTTL is how long user has to complete sign in at the provider
+	OIDCStateTTL = 10 * time.Minute
+
+	oidcHTTPTimeout = 10 * time.Second
+	// oidcMaxNameAttempts limits attempts to find a free user name for a provisioned user
+	oidcMaxNameAttempts = 5
+	oidcMaxNameLength   = 64
+)
+
+var (
+	ErrOIDCProviderNotFound = errors.New("oidc provider not found")
+	// ErrOIDCStateNotFound is returned if callback state is unknown, expired or was already used
+	ErrOIDCStateNotFound = errors.New("oidc state not found")
+	// ErrOIDCAuthenticationFailed is returned if code can not be exchanged or ID token is not valid
+	ErrOIDCAuthenticationFailed = errors.New("oidc authentication failed")
+)
+
+type (
+	// OIDCProviderConfig is OpenID Connect provider registration, its endpoints are discovered from IssuerURL
+	OIDCProviderConfig struct {
+		Name         string
+		IssuerURL    string
+		ClientID     string
+		ClientSecret string
+		Scopes       []string
+	}
+
+	// OIDCAuthorization is where user is sent to sign in at the provider. State has to be bound to the browser,
+	// callback is only accepted from the browser which started authorization.
+	OIDCAuthorization struct {
+		URL       string
+		State     string
+		ExpiresAt time.Time
+	}
+
+	// OIDCAuthentication is result of the callback, Linked is set if identity was linked to signed-in user
+	// instead of signing in
+	OIDCAuthentication struct {
+		User   *User
+		Linked bool
+	}
+
+	UserIdentity struct {
+		ID        uint64
+		Provider  string
+		Email     string
+		CreatedAt time.Time
+	}
+
+	UserIdentityRepository interface {
+		GetByProviderSubject(provider, subject string) (*dal.UserIdentity, error)
+		GetAllByUserID(userID uint64) ([]*dal.UserIdentity, error)
+		Create(userID uint64, provider, subject, email string) (*dal.UserIdentity, error)
+		CreateUser(name, provider, subject, email string) (*dal.User, error)
+	}
+
+	// OIDCService signs users in with OpenID Connect providers using authorization code flow with PKCE.
+	// Users are provisioned on the first sign in and are looked up by provider and subject afterward,
+	// existing users are never matched by email, they have to link identity explicitly.
+	OIDCService struct {
+		providers   map[string]*oidcProvider
+		callbackURL string
+		// signUpOpen lets users without linked identity sign up with provider
+		signUpOpen bool
+		repo       UserIdentityRepository
+		userRepo   UserRepository
+		client     RedisClient
+		httpClient *http.Client
+		log        log.TracedLogger
+	}
+
+	// oidcProvider discovers provider endpoints and keys on the first use, so the app starts if provider is down
+	oidcProvider struct {
+		conf OIDCProviderConfig
+
+		mx       sync.Mutex
+		oauth2   *oauth2.Config
+		verifier *oidc.IDTokenVerifier
+	}
+
+	// oidcState is kept between authorization and callback
+	oidcState struct {
+		Provider string `json:"provider"`
+		Nonce    string `json:"nonce"`
+		Verifier string `json:"verifier"`
+		// UserID is set if identity is being linked to the signed-in user
+		UserID uint64 `json:"user_id,omitempty"`
+	}
+
+	oidcClaims struct {
+		Subject           string `json:"sub"`
+		Nonce             string `json:"nonce"`
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+)
+
+func NewOIDCService(
+	providers []OIDCProviderConfig, callbackURL string, signUpOpen bool, repo UserIdentityRepository, userRepo UserRepository,
+	client RedisClient, log log.TracedLogger,
+) *OIDCService {
+	res := &OIDCService{
+		providers:   make(map[string]*oidcProvider, len(providers)),
+		callbackURL: callbackURL,
+		signUpOpen:  signUpOpen,
+		repo:        repo,
+		userRepo:    userRepo,
+		client:      client,
+		httpClient:  &http.Client{Timeout: oidcHTTPTimeout},
+		log:         log,
+	}
+	for _, p := range providers {
+		res.providers[p.Name] = &oidcProvider{conf: p}
+	}
+
+	return res
+}
+
+// Providers returns sorted names of configured providers
+func (s *OIDCService) Providers() []string {
+	res := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Authorize starts sign in with the provider. If userID is not 0, identity is linked to the user instead.
+func (s *OIDCService) Authorize(ctx context.Context, providerName string, userID uint64) (*OIDCAuthorization, error) {
+	s.log.Debugw(ctx, "authorize with oidc provider", "provider", providerName, "userID", userID)
+
+	p, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrOIDCProviderNotFound
+	}
+	conf, _, err := p.discover(oidc.ClientContext(context.Background(), s.httpClient), s.callbackURL)
+	if err != nil {
+		return nil, fmt.Errorf("discover oidc provider %q: %w", providerName, err)
+	}
+
+	state, err := newSecretToken("")
+	if err != nil {
+		return nil, fmt.Errorf("generate oidc state: %w", err)
+	}
+	nonce, err := newSecretToken("")
+	if err != nil {
+		return nil, fmt.Errorf("generate oidc nonce: %w", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+	value, err := json.Marshal(&oidcState{
+		Provider: providerName,
+		Nonce:    nonce,
+		Verifier: verifier,
+		UserID:   userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal oidc state: %w", err)
+	}
+
+	key := oidcStateKey(hashSecretToken(state))
+	if err = s.client.Set(ctx, key, value, OIDCStateTTL).Err(); err != nil {
+		return nil, fmt.Errorf("set oidc state with key=%q: %w", key, err)
+	}
+
+	return &OIDCAuthorization{
+		URL:       conf.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)),
+		State:     state,
+		ExpiresAt: time.Now().Add(OIDCStateTTL),
+	}, nil
+}
+
+// Authenticate completes authorization started with Authorize. It exchanges code for ID token, verifies it
+// and returns the user identity belongs to, the user is provisioned if identity is not known yet.
+// State can be used once.
+func (s *OIDCService) Authenticate(ctx context.Context, state, code string) (*OIDCAuthentication, error) {
+	key := oidcStateKey(hashSecretToken(state))
+	value, err := s.client.GetDel(ctx, key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			s.log.Debugw(ctx, "oidc state not found")
+			return nil, ErrOIDCStateNotFound
+		}
+
+		return nil, fmt.Errorf("get oidc state with key=%q: %w", key, err)
+	}
+	var st oidcState
+	if err = json.Unmarshal([]byte(value), &st); err != nil {
+		return nil, fmt.Errorf("unmarshal oidc state: %w", err)
+	}
+
+	p, ok := s.providers[st.Provider]
+	if !ok {
+		// provider was removed from configuration after authorization started
+		return nil, ErrOIDCProviderNotFound
+	}
+	clientCtx := oidc.ClientContext(ctx, s.httpClient)
+	conf, verifier, err := p.discover(oidc.ClientContext(context.Background(), s.httpClient), s.callbackURL)
+	if err != nil {
+		return nil, fmt.Errorf("discover oidc provider %q: %w", st.Provider, err)
+	}
+
+	token, err := conf.Exchange(clientCtx, code, oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: exchange code: %s", ErrOIDCAuthenticationFailed, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id_token", ErrOIDCAuthenticationFailed)
+	}
+	idToken, err := verifier.Verify(clientCtx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: verify id token: %s", ErrOIDCAuthenticationFailed, err)
+	}
+	var claims oidcClaims
+	if err = idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: parse id token claims: %s", ErrOIDCAuthenticationFailed, err)
+	}
+	if claims.Nonce != st.Nonce {
+		return nil, fmt.Errorf("%w: id token nonce does not match", ErrOIDCAuthenticationFailed)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: id token has no subject", ErrOIDCAuthenticationFailed)
+	}
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+
+	if st.UserID != 0 {
+		user, err := s.link(ctx, st.UserID, st.Provider, claims.Subject, email)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCAuthentication{User: user, Linked: true}, nil
+	}
+
+	user, err := s.signIn(ctx, st.Provider, &claims, email)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDisabled() {
+		s.log.Debugw(ctx, "user is disabled", "id", user.ID)
+		return nil, ErrUserDisabled
+	}
+	return &OIDCAuthentication{User: user}, nil
+}
+
+// GetIdentities returns identities linked to the user
+func (s *OIDCService) GetIdentities(ctx context.Context, userID uint64) ([]*UserIdentity, error) {
+	s.log.Debugw(ctx, "get user identities", "userID", userID)
+
+	identities, err := s.repo.GetAllByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user identities: %w", err)
+	}
+
+	res := make([]*UserIdentity, 0, len(identities))
+	for _, i := range identities {
+		res = append(res, &UserIdentity{
+			ID:        i.ID,
+			Provider:  i.Provider,
+			Email:     i.Email,
+			CreatedAt: i.CreatedAt,
+		})
+	}
+	return res, nil
+}
+
+func (s *OIDCService) signIn(ctx context.Context, provider string, claims *oidcClaims, email string) (*User, error) {
+	if user, err := s.getUserByIdentity(provider, claims.Subject); err == nil || !errors.Is(err, ErrNotFound) {
+		return user, err
+	}
+	// invitation codes can not be passed through provider, so only open registration provisions users
+	if !s.signUpOpen {
+		s.log.Debugw(ctx, "identity is not linked and registration is not open", "provider", provider)
+		return nil, ErrRegistrationClosed
+	}
+
+	name := oidcUserName(claims)
+	for attempt := 0; attempt < oidcMaxNameAttempts; attempt++ {
+		candidate := name
+		if attempt > 0 {
+			candidate = fmt.Sprintf("%s-%s", name, strings.ToLower(tools.RandomAlphanumericKey(4)))
+		}
+
+		user, err := s.repo.CreateUser(candidate, provider, claims.Subject, email)
+		if err == nil {
+			s.log.Infow(ctx, "User provisioned with oidc provider", "provider", provider, "userID", user.ID)
+			return toDomainUser(user), nil
+		}
+		if errors.Is(err, dal.ErrConflictUniqueIdentity) {
+			// identity has been created by concurrent callback
+			return s.getUserByIdentity(provider, claims.Subject)
+		}
+		if !errors.Is(err, dal.ErrConflictUnique) {
+			return nil, fmt.Errorf("create user with identity: %w", err)
+		}
+		// the name is taken, try another one
+	}
+
+	return nil, fmt.Errorf("no free user name found for %q", name)
+}
+
+func (s *OIDCService) link(ctx context.Context, userID uint64, provider, subject, email string) (*User, error) {
+	identity, err := s.repo.GetByProviderSubject(provider, subject)
+	if err == nil {
+		if identity.UserID != userID {
+			return nil, identityConflictError()
+		}
+		// identity is already linked to the user
+		return s.getUser(identity.UserID)
+	}
+	if !errors.Is(err, dal.ErrNotFound) {
+		return nil, fmt.Errorf("get user identity: %w", err)
+	}
+
+	if _, err = s.repo.Create(userID, provider, subject, email); err != nil {
+		if errors.Is(err, dal.ErrConflictUniqueIdentity) {
+			return nil, identityConflictError()
+		}
+
+		return nil, fmt.Errorf("create user identity: %w", err)
+	}
+
+	s.log.Infow(ctx, "Identity linked", "provider", provider, "userID", userID)
+	return s.getUser(userID)
+}
+
+func (s *OIDCService) getUserByIdentity(provider, subject string) (*User, error) {
+	identity, err := s.repo.GetByProviderSubject(provider, subject)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get user identity: %w", err)
+	}
+
+	return s.getUser(identity.UserID)
+}
+
+func (s *OIDCService) getUser(id uint64) (*User, error) {
+	user, err := s.userRepo.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("get user by id=%d: %w", id, err)
+	}
+	return toDomainUser(user), nil
+}
+
+// discover returns OAuth2 config and ID token verifier of the provider. Discovery is retried on the next call
+// if it failed. Verifier caches provider keys and fetches them again when token is signed with an unknown key.
+func (p *oidcProvider) discover(ctx context.Context, callbackURL string) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.conf.IssuerURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.conf.ClientID,
+		ClientSecret: p.conf.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  callbackURL,
+		Scopes:       append([]string{oidc.ScopeOpenID}, p.conf.Scopes...),
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.conf.ClientID})
+	return p.oauth2, p.verifier, nil
+}
+
+// oidcUserName derives name of provisioned user from preferred username or email, so it passes checkName
+func oidcUserName(claims *oidcClaims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c >= '0' && c <= '9', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '_' || c == '-' || c == '.':
+			b.WriteRune(c)
+		}
+	}
+	name = b.String()
+
+	if name == "" || !(name[0] >= 'A' && name[0] <= 'Z') && !(name[0] >= 'a' && name[0] <= 'z') {
+		name = "user-" + name
+	}
+	for len(name) < 3 {
+		name += "_"
+	}
+	if len(name) > oidcMaxNameLength {
+		name = name[:oidcMaxNameLength]
+	}
+	return name
+}
+
+func identityConflictError() *RenderableError {
+	return &RenderableError{
+		Code:    ErrorCodeIdentityConflict,
+		Message: "Identity is linked to another user",
+	}
+}
+
+func oidcStateKey(hash string) string {
+	return fmt.Sprintf("oidc_state:%s", hash)
+}
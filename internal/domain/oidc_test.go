@@ -0,0 +1,324 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+)
+
+const (
+	testOIDCProvider = "stub"
+	testOIDCClientID = "shared-clipboard"
+	testOIDCKeyID    = "stub-key"
+)
+
+type (
+	// stubIdP is OpenID Connect provider serving discovery, JWKS and token endpoint. Codes are issued with code
+	// and exchanged for ID token with the subject and nonce they were issued for.
+	stubIdP struct {
+		server *httptest.Server
+		key    *rsa.PrivateKey
+
+		mx    sync.Mutex
+		codes map[string]jwt.MapClaims
+	}
+
+	fakeUserIdentityRepository struct {
+		users      map[uint64]*dal.User
+		identities []*dal.UserIdentity
+		// concurrentUserID links identity to the user on CreateUser as if concurrent callback did it first
+		concurrentUserID uint64
+	}
+
+	// fakeUserRepository only implements GetByID, other methods panic
+	fakeUserRepository struct {
+		UserRepository
+		users map[uint64]*dal.User
+	}
+)
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	res := &stubIdP{
+		key:   key,
+		codes: make(map[string]jwt.MapClaims),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, _ *http.Request) {
+		writeStubJSON(rw, map[string]any{
+			"issuer":                                res.server.URL,
+			"authorization_endpoint":                res.server.URL + "/authorize",
+			"token_endpoint":                        res.server.URL + "/token",
+			"jwks_uri":                              res.server.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, _ *http.Request) {
+		writeStubJSON(rw, map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": testOIDCKeyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, r *http.Request) {
+		res.mx.Lock()
+		claims, ok := res.codes[r.PostFormValue("code")]
+		delete(res.codes, r.PostFormValue("code"))
+		res.mx.Unlock()
+		if !ok {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = testOIDCKeyID
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeStubJSON(rw, map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	res.server = httptest.NewServer(mux)
+	t.Cleanup(res.server.Close)
+
+	return res
+}
+
+// issueCode returns code exchanged for ID token of the subject with the nonce
+func (p *stubIdP) issueCode(subject, nonce string) string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	code := fmt.Sprintf("code-%d", len(p.codes)+1)
+	p.codes[code] = jwt.MapClaims{
+		"iss":                p.server.URL,
+		"aud":                testOIDCClientID,
+		"sub":                subject,
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+	return code
+}
+
+func writeStubJSON(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+func (r *fakeUserIdentityRepository) GetByProviderSubject(provider, subject string) (*dal.UserIdentity, error) {
+	for _, i := range r.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return nil, dal.ErrNotFound
+}
+
+func (r *fakeUserIdentityRepository) GetAllByUserID(userID uint64) ([]*dal.UserIdentity, error) {
+	var res []*dal.UserIdentity
+	for _, i := range r.identities {
+		if i.UserID == userID {
+			res = append(res, i)
+		}
+	}
+	return res, nil
+}
+
+func (r *fakeUserIdentityRepository) Create(userID uint64, provider, subject, email string) (*dal.UserIdentity, error) {
+	if _, err := r.GetByProviderSubject(provider, subject); err == nil {
+		return nil, dal.ErrConflictUniqueIdentity
+	}
+
+	res := &dal.UserIdentity{ID: uint64(len(r.identities) + 1), UserID: userID, Provider: provider, Subject: subject, Email: email}
+	r.identities = append(r.identities, res)
+	return res, nil
+}
+
+func (r *fakeUserIdentityRepository) CreateUser(name, provider, subject, email string) (*dal.User, error) {
+	for _, u := range r.users {
+		if u.Name == name {
+			return nil, dal.ErrConflictUnique
+		}
+	}
+	if r.concurrentUserID != 0 {
+		_, _ = r.Create(r.concurrentUserID, provider, subject, email)
+	}
+	if _, err := r.GetByProviderSubject(provider, subject); err == nil {
+		return nil, dal.ErrConflictUniqueIdentity
+	}
+
+	res := &dal.User{ID: uint64(len(r.users) + 1), Name: name, Role: UserRoleUser}
+	r.users[res.ID] = res
+	if _, err := r.Create(res.ID, provider, subject, email); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *fakeUserRepository) GetByID(id uint64) (*dal.User, error) {
+	if u, ok := r.users[id]; ok {
+		return u, nil
+	}
+	return nil, dal.ErrNotFound
+}
+
+func newTestOIDCService(t *testing.T, idp *stubIdP, repo *fakeUserIdentityRepository) *OIDCService {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return NewOIDCService([]OIDCProviderConfig{{
+		Name:         testOIDCProvider,
+		IssuerURL:    idp.server.URL,
+		ClientID:     testOIDCClientID,
+		ClientSecret: "secret",
+	}}, "http://localhost/v1/oidc/callback", true, repo, &fakeUserRepository{users: repo.users}, client, newTestLogger())
+}
+
+// authorizeOIDC starts authorization and returns its state and nonce sent to the provider
+func authorizeOIDC(t *testing.T, service *OIDCService, userID uint64) (string, string) {
+	t.Helper()
+
+	auth, err := service.Authorize(context.Background(), testOIDCProvider, userID)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	u, err := url.Parse(auth.URL)
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	return auth.State, u.Query().Get("nonce")
+}
+
+func TestOIDCService_Authenticate(t *testing.T) {
+	idp := newStubIdP(t)
+	repo := &fakeUserIdentityRepository{users: map[uint64]*dal.User{}}
+	service := newTestOIDCService(t, idp, repo)
+
+	state, nonce := authorizeOIDC(t, service, 0)
+	auth, err := service.Authenticate(context.Background(), state, idp.issueCode("subject-1", nonce))
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if auth.Linked || auth.User.Name != "alice" {
+		t.Errorf("expected user alice to be provisioned, got %+v", auth.User)
+	}
+
+	state, nonce = authorizeOIDC(t, service, 0)
+	again, err := service.Authenticate(context.Background(), state, idp.issueCode("subject-1", nonce))
+	if err != nil {
+		t.Fatalf("authenticate again: %v", err)
+	}
+	if again.User.ID != auth.User.ID {
+		t.Errorf("expected user %d to sign in, got %d", auth.User.ID, again.User.ID)
+	}
+}
+
+func TestOIDCService_AuthenticateStateReuse(t *testing.T) {
+	idp := newStubIdP(t)
+	service := newTestOIDCService(t, idp, &fakeUserIdentityRepository{users: map[uint64]*dal.User{}})
+
+	state, nonce := authorizeOIDC(t, service, 0)
+	if _, err := service.Authenticate(context.Background(), state, idp.issueCode("subject-1", nonce)); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if _, err := service.Authenticate(context.Background(), state, idp.issueCode("subject-1", nonce)); !errors.Is(err, ErrOIDCStateNotFound) {
+		t.Errorf("expected ErrOIDCStateNotFound on reused state, got %v", err)
+	}
+}
+
+func TestOIDCService_AuthenticateNonceMismatch(t *testing.T) {
+	idp := newStubIdP(t)
+	repo := &fakeUserIdentityRepository{users: map[uint64]*dal.User{}}
+	service := newTestOIDCService(t, idp, repo)
+
+	state, _ := authorizeOIDC(t, service, 0)
+	if _, err := service.Authenticate(context.Background(), state, idp.issueCode("subject-1", "replayed-nonce")); !errors.Is(err, ErrOIDCAuthenticationFailed) {
+		t.Errorf("expected ErrOIDCAuthenticationFailed on nonce mismatch, got %v", err)
+	}
+	if len(repo.users) != 0 {
+		t.Errorf("expected no user to be provisioned, got %d", len(repo.users))
+	}
+}
+
+func TestOIDCService_AuthenticateIdentityLinkedToAnotherUser(t *testing.T) {
+	idp := newStubIdP(t)
+	repo := &fakeUserIdentityRepository{
+		users: map[uint64]*dal.User{
+			1: {ID: 1, Name: "alice"},
+			2: {ID: 2, Name: "bob"},
+		},
+		identities: []*dal.UserIdentity{{ID: 1, UserID: 2, Provider: testOIDCProvider, Subject: "subject-1"}},
+	}
+	service := newTestOIDCService(t, idp, repo)
+
+	state, nonce := authorizeOIDC(t, service, 1)
+	_, err := service.Authenticate(context.Background(), state, idp.issueCode("subject-1", nonce))
+	var rErr *RenderableError
+	if !errors.As(err, &rErr) || rErr.Code != ErrorCodeIdentityConflict {
+		t.Fatalf("expected identity conflict error, got %v", err)
+	}
+	if identities, _ := repo.GetAllByUserID(1); len(identities) != 0 {
+		t.Errorf("expected identity not to be linked, got %d identities", len(identities))
+	}
+}
+
+func TestOIDCService_AuthenticateConcurrentProvisioning(t *testing.T) {
+	idp := newStubIdP(t)
+	repo := &fakeUserIdentityRepository{
+		users:            map[uint64]*dal.User{7: {ID: 7, Name: "alice-1234"}},
+		concurrentUserID: 7,
+	}
+	service := newTestOIDCService(t, idp, repo)
+
+	state, nonce := authorizeOIDC(t, service, 0)
+	auth, err := service.Authenticate(context.Background(), state, idp.issueCode("subject-1", nonce))
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if auth.User.ID != 7 || len(repo.users) != 1 {
+		t.Errorf("expected user provisioned by concurrent callback to sign in, got %+v", auth.User)
+	}
+}
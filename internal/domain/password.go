@@ -0,0 +1,200 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	argon2idPrefix  = "$argon2id$"
+	argon2SaltBytes = 16
+	argon2KeyBytes  = 32
+)
+
+var (
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+	argon2Encoding = base64.RawStdEncoding
+)
+
+type (
+	// PasswordHasher hashes passwords into encoded strings carrying algorithm, its parameters and salt,
+	// so hashes stay verifiable after parameters are changed
+	PasswordHasher interface {
+		// Supports reports whether encoded hash was produced by the algorithm of the hasher
+		Supports(encoded string) bool
+		Hash(password string) (string, error)
+		Verify(password, encoded string) (bool, error)
+		// NeedsRehash reports whether encoded hash was produced with parameters other than the current ones
+		NeedsRehash(encoded string) bool
+	}
+
+	// PasswordHashers hashes passwords with the current hasher and verifies hashes of any of the hashers.
+	// Hashes of legacy hashers are supposed to be replaced with the current ones when password is verified.
+	PasswordHashers struct {
+		current PasswordHasher
+		legacy  []PasswordHasher
+	}
+
+	Argon2idParams struct {
+		MemoryKiB   uint32
+		Iterations  uint32
+		Parallelism uint8
+	}
+
+	// Argon2idHasher encodes hashes in PHC string format, e.g. "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>"
+	Argon2idHasher struct {
+		params Argon2idParams
+	}
+
+	// BcryptHasher verifies hashes created before passwords were hashed with argon2id
+	BcryptHasher struct {
+		cost int
+	}
+)
+
+func NewPasswordHashers(current PasswordHasher, legacy ...PasswordHasher) *PasswordHashers {
+	return &PasswordHashers{
+		current: current,
+		legacy:  legacy,
+	}
+}
+
+func (h *PasswordHashers) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Verify reports whether password matches encoded hash and whether hash has to be replaced with a hash
+// of the current hasher. Empty hash never matches, e.g. of users signing in with identity providers only.
+func (h *PasswordHashers) Verify(password, encoded string) (bool, bool, error) {
+	if encoded == "" {
+		return false, false, nil
+	}
+
+	if h.current.Supports(encoded) {
+		ok, err := h.current.Verify(password, encoded)
+		return ok, ok && h.current.NeedsRehash(encoded), err
+	}
+	for _, l := range h.legacy {
+		if l.Supports(encoded) {
+			ok, err := l.Verify(password, encoded)
+			return ok, ok, err
+		}
+	}
+
+	return false, false, ErrUnknownPasswordHash
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{
+		params: params,
+	}
+}
+
+func (h *Argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.MemoryKiB, h.params.Parallelism, argon2KeyBytes)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.params.MemoryKiB, h.params.Iterations, h.params.Parallelism,
+		argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.MemoryKiB, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	return err != nil || params != h.params || len(salt) != argon2SaltBytes || len(key) != argon2KeyBytes
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{
+		cost: cost,
+	}
+}
+
+func (h *BcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	res, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+// decodeArgon2id parses "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>"
+func decodeArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var (
+		params  Argon2idParams
+		version int
+	)
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("parse argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.MemoryKiB, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("parse argon2id parameters: %w", err)
+	}
+	salt, err := argon2Encoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("decode argon2id salt: %w", err)
+	}
+	key, err := argon2Encoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("decode argon2id hash: %w", err)
+	}
+	if len(key) == 0 {
+		return params, nil, nil, errors.New("empty argon2id hash")
+	}
+
+	return params, salt, key, nil
+}
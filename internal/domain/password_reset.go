@@ -0,0 +1,218 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const passwordResetSubject = "Reset your password"
+
+type (
+	// Mailer sends plain text messages
+	Mailer interface {
+		Send(ctx context.Context, to, subject, body string) error
+	}
+
+	PasswordResetRepository interface {
+		Create(userID uint64, tokenHash string, expiresAt time.Time) error
+		Use(tokenHash string) (uint64, error)
+	}
+
+	PasswordSetter interface {
+		SetPassword(ctx context.Context, userID uint64, password string) error
+		ClearPassword(ctx context.Context, userID uint64) error
+	}
+
+	// LoginsRevoker signs the user out everywhere
+	LoginsRevoker interface {
+		RevokeAll(ctx context.Context, userID uint64) error
+	}
+
+	// ForcedPasswordReset tells how user gets reset link, URL is only set if the user has no email to send it to
+	ForcedPasswordReset struct {
+		EmailSent bool
+		URL       string
+	}
+
+	// PasswordResetService lets users who forgot password set a new one with a single-use link sent to their email
+	PasswordResetService struct {
+		repo      PasswordResetRepository
+		userRepo  UserRepository
+		passwords PasswordSetter
+		logins    LoginsRevoker
+		mailer    Mailer
+		resetURL  string
+		ttl       time.Duration
+		log       log.TracedLogger
+	}
+)
+
+func NewPasswordResetService(
+	repo PasswordResetRepository, userRepo UserRepository, passwords PasswordSetter, logins LoginsRevoker, mailer Mailer,
+	resetURL string, ttl time.Duration, log log.TracedLogger,
+) *PasswordResetService {
+	return &PasswordResetService{
+		repo:      repo,
+		userRepo:  userRepo,
+		passwords: passwords,
+		logins:    logins,
+		mailer:    mailer,
+		resetURL:  resetURL,
+		ttl:       ttl,
+		log:       log,
+	}
+}
+
+// Request sends reset link if there is a user with the email. It succeeds regardless, so it does not reveal
+// which emails are registered.
+func (s *PasswordResetService) Request(ctx context.Context, email string) error {
+	s.log.Debugw(ctx, "request password reset")
+
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil
+	}
+
+	user, err := s.userRepo.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "user with email not found")
+			return nil
+		}
+
+		return fmt.Errorf("get user by email: %w", err)
+	}
+
+	link, err := s.issue(user.ID)
+	if err != nil {
+		return err
+	}
+	s.send(ctx, user, link, false)
+
+	return nil
+}
+
+// Force makes the user set a new password, e.g. if it was compromised. Password is removed and the user is signed
+// out everywhere, reset link is sent to email of the user or returned if there is no email.
+func (s *PasswordResetService) Force(ctx context.Context, userID uint64) (*ForcedPasswordReset, error) {
+	s.log.Debugw(ctx, "force password reset", "userID", userID)
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get user by id=%d: %w", userID, err)
+	}
+
+	if err = s.passwords.ClearPassword(ctx, userID); err != nil {
+		return nil, fmt.Errorf("clear password: %w", err)
+	}
+	if err = s.logins.RevokeAll(ctx, userID); err != nil {
+		return nil, fmt.Errorf("revoke logins: %w", err)
+	}
+
+	link, err := s.issue(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Infow(ctx, "Password reset forced", "userID", userID)
+	if user.Email == "" {
+		return &ForcedPasswordReset{URL: link}, nil
+	}
+	s.send(ctx, user, link, true)
+	return &ForcedPasswordReset{EmailSent: true}, nil
+}
+
+// Confirm sets a new password if token is valid and signs the user out everywhere. Token can be used once.
+func (s *PasswordResetService) Confirm(ctx context.Context, token, password string) error {
+	s.log.Debugw(ctx, "confirm password reset")
+
+	// password is checked before token is used up, so a weak password does not waste the link
+	if err := checkPassword(password); err != nil {
+		return &RenderableError{
+			Code:    ErrorCodePasswordBadRequest,
+			Message: "Bad request",
+			Details: map[string]string{"password": err.Error()},
+		}
+	}
+
+	userID, err := s.repo.Use(hashSecretToken(token))
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "password reset not found")
+			return &RenderableError{
+				Code:    ErrorCodePasswordResetInvalid,
+				Message: "Password reset link is not valid or expired",
+			}
+		}
+
+		return fmt.Errorf("use password reset: %w", err)
+	}
+
+	if err = s.passwords.SetPassword(ctx, userID, password); err != nil {
+		return fmt.Errorf("set password: %w", err)
+	}
+	if err = s.logins.RevokeAll(ctx, userID); err != nil {
+		return fmt.Errorf("revoke logins: %w", err)
+	}
+
+	s.log.Infow(ctx, "Password reset", "userID", userID)
+	return nil
+}
+
+// issue creates reset token for the user and returns link to web app page with it
+func (s *PasswordResetService) issue(userID uint64) (string, error) {
+	token, err := newSecretToken("")
+	if err != nil {
+		return "", fmt.Errorf("generate password reset token: %w", err)
+	}
+	if err = s.repo.Create(userID, hashSecretToken(token), time.Now().Add(s.ttl)); err != nil {
+		return "", fmt.Errorf("create password reset: %w", err)
+	}
+
+	link, err := url.Parse(s.resetURL)
+	if err != nil {
+		return "", fmt.Errorf("parse password reset url: %w", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
+
+// send sends reset link in background, so response time does not reveal whether it was sent.
+// Forced reset is explained differently, since the password is already removed.
+func (s *PasswordResetService) send(ctx context.Context, user *dal.User, link string, forced bool) {
+	reason := "A password reset was requested for your account."
+	ignore := "If you did not request it, ignore this email, your password stays the same."
+	if forced {
+		reason = "An administrator has reset the password of your account and signed you out everywhere."
+		ignore = "You can not sign in with the old password anymore."
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"%s Open the link below to set a new password, it is valid for %d minutes:\n\n"+
+		"%s\n\n"+
+		"%s\n",
+		user.Name, reason, int(s.ttl.Minutes()), link, ignore,
+	)
+
+	go func(ctx context.Context) {
+		if err := s.mailer.Send(ctx, user.Email, passwordResetSubject, body); err != nil {
+			s.log.Errorw(ctx, "Failed to send password reset", "userID", user.ID, err)
+			return
+		}
+		s.log.Infow(ctx, "Password reset sent", "userID", user.ID)
+	}(context.WithoutCancel(ctx))
+}
@@ -0,0 +1,164 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+type (
+	// RefreshToken is an opaque token to get a new access token with, it is rotated on every use
+	RefreshToken struct {
+		UserID uint64
+		// FamilyID identifies the sign in token was rotated from
+		FamilyID  string
+		Token     string
+		ExpiresAt time.Time
+	}
+
+	RefreshTokenRepository interface {
+		GetByTokenHash(tokenHash string) (*dal.RefreshToken, error)
+		Create(userID uint64, familyID, tokenHash string, expiresAt time.Time) (*dal.RefreshToken, error)
+		MarkUsed(id uint64) error
+		RevokeFamily(familyID string) error
+	}
+
+	RefreshTokenService struct {
+		repo     RefreshTokenRepository
+		userRepo UserRepository
+		ttl      time.Duration
+		log      log.TracedLogger
+	}
+)
+
+func NewRefreshTokenService(repo RefreshTokenRepository, userRepo UserRepository, ttl time.Duration, log log.TracedLogger) *RefreshTokenService {
+	return &RefreshTokenService{
+		repo:     repo,
+		userRepo: userRepo,
+		ttl:      ttl,
+		log:      log,
+	}
+}
+
+// Issue creates refresh token starting a new family, which is supposed to be done on sign in
+func (s *RefreshTokenService) Issue(ctx context.Context, userID uint64) (*RefreshToken, error) {
+	s.log.Debugw(ctx, "issue refresh token", "userID", userID)
+
+	return s.create(userID, uuid.New().String())
+}
+
+// Rotate exchanges refresh token for a new one of the same family and returns user it was issued for.
+// Exchanging a token which was already exchanged means it leaked, so the whole family is revoked.
+// It returns ErrInvalidRefreshToken if token can not be exchanged.
+func (s *RefreshTokenService) Rotate(ctx context.Context, token string) (*User, *RefreshToken, error) {
+	found, err := s.repo.GetByTokenHash(hashSecretToken(token))
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "refresh token not found")
+			return nil, nil, ErrInvalidRefreshToken
+		}
+
+		return nil, nil, fmt.Errorf("get refresh token: %w", err)
+	}
+
+	if !found.RevokedAt.IsZero() {
+		s.log.Debugw(ctx, "refresh token is revoked", "familyID", found.FamilyID)
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if !found.UsedAt.IsZero() {
+		return nil, nil, s.revokeReused(ctx, found)
+	}
+	if found.ExpiresAt.Before(time.Now()) {
+		s.log.Debugw(ctx, "refresh token is expired", "familyID", found.FamilyID)
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	if err = s.repo.MarkUsed(found.ID); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			// token was exchanged or revoked by a concurrent request
+			return nil, nil, s.revokeReused(ctx, found)
+		}
+
+		return nil, nil, fmt.Errorf("mark refresh token used: %w", err)
+	}
+
+	user, err := s.userRepo.GetByID(found.UserID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "refresh token user not found", "userID", found.UserID)
+			return nil, nil, ErrInvalidRefreshToken
+		}
+
+		return nil, nil, fmt.Errorf("get user by id=%d: %w", found.UserID, err)
+	}
+	if !user.DisabledAt.IsZero() {
+		s.log.Debugw(ctx, "refresh token user is disabled", "userID", found.UserID)
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	rotated, err := s.create(found.UserID, found.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.log.Debugw(ctx, "refresh token rotated", "userID", found.UserID)
+	return toDomainUser(user), rotated, nil
+}
+
+// Revoke revokes the whole family of refresh token, which is supposed to be done on sign out.
+// Unknown tokens are ignored.
+func (s *RefreshTokenService) Revoke(ctx context.Context, token string) error {
+	found, err := s.repo.GetByTokenHash(hashSecretToken(token))
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "refresh token to revoke not found")
+			return nil
+		}
+
+		return fmt.Errorf("get refresh token: %w", err)
+	}
+
+	if err = s.repo.RevokeFamily(found.FamilyID); err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+
+	s.log.Debugw(ctx, "refresh token family revoked", "familyID", found.FamilyID)
+	return nil
+}
+
+func (s *RefreshTokenService) revokeReused(ctx context.Context, token *dal.RefreshToken) error {
+	s.log.Infow(ctx, "Refresh token reuse detected, revoking its family", "userID", token.UserID, "familyID", token.FamilyID)
+
+	if err := s.repo.RevokeFamily(token.FamilyID); err != nil {
+		return fmt.Errorf("revoke reused refresh token family: %w", err)
+	}
+
+	return ErrInvalidRefreshToken
+}
+
+func (s *RefreshTokenService) create(userID uint64, familyID string) (*RefreshToken, error) {
+	token, err := newSecretToken("")
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token: %w", err)
+	}
+
+	created, err := s.repo.Create(userID, familyID, hashSecretToken(token), time.Now().Add(s.ttl))
+	if err != nil {
+		return nil, fmt.Errorf("create refresh token: %w", err)
+	}
+
+	return &RefreshToken{
+		UserID:    created.UserID,
+		FamilyID:  created.FamilyID,
+		Token:     token,
+		ExpiresAt: created.ExpiresAt,
+	}, nil
+}
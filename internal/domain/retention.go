@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	RetentionNever = "never"
+	// RetentionHours expires clipboard in Value hours after it was set
+	RetentionHours = "hours"
+	// RetentionIdle expires clipboard in Value hours after it was last read or set
+	RetentionIdle = "idle"
+	// RetentionReads expires clipboard after it was read Value times
+	RetentionReads = "reads"
+)
+
+var ErrInvalidRetention = errors.New("invalid retention")
+
+type Retention struct {
+	Policy string
+	Value  int
+}
+
+// Validate checks that retention is supported and does not exceed maxAge if it is not zero.
+func (r Retention) Validate(maxAge time.Duration) error {
+	switch r.Policy {
+	case RetentionNever:
+		if r.Value != 0 {
+			return fmt.Errorf("%w: value is not supported for %q policy", ErrInvalidRetention, r.Policy)
+		}
+	case RetentionHours, RetentionIdle:
+		if r.Value <= 0 {
+			return fmt.Errorf("%w: hours must be positive", ErrInvalidRetention)
+		}
+		if maxAge > 0 && time.Duration(r.Value)*time.Hour > maxAge {
+			return fmt.Errorf("%w: hours exceed server maximum of %d", ErrInvalidRetention, int(maxAge.Hours()))
+		}
+	case RetentionReads:
+		if r.Value <= 0 {
+			return fmt.Errorf("%w: reads must be positive", ErrInvalidRetention)
+		}
+	default:
+		return fmt.Errorf("%w: unknown policy %q", ErrInvalidRetention, r.Policy)
+	}
+
+	return nil
+}
+
+// ExpiresAt returns expiration of clipboard set or read at now. Zero time means clipboard never expires.
+// Policies that are not bound by time are still bound by maxAge if it is not zero.
+func (r Retention) ExpiresAt(now time.Time, maxAge time.Duration) time.Time {
+	var res time.Time
+	switch r.Policy {
+	case RetentionHours, RetentionIdle:
+		res = now.Add(time.Duration(r.Value) * time.Hour)
+	}
+
+	if maxAge > 0 && (res.IsZero() || res.After(now.Add(maxAge))) {
+		res = now.Add(maxAge)
+	}
+	return res
+}
@@ -29,6 +29,9 @@ type (
 		ID        uint64
 		Name      string
 		UserID    uint64
+		Retention Retention
+		// Role is a role of the user the session was requested by
+		Role      string
 		CreatedAt time.Time
 		UpdatedAt time.Time
 	}
@@ -36,45 +39,49 @@ type (
 	SessionRepository interface {
 		GetByID(id uint64) (*dal.Session, error)
 		GetAllByUserID(userID uint64) ([]*dal.Session, error)
+		GetOwnedIDs(userID uint64, soleOwner bool) ([]uint64, error)
 		FilterBy(dal.SessionFilter) ([]*dal.Session, int, error)
 		Create(name string, userID uint64) (*dal.Session, error)
-		Update(id uint64, name string) (*dal.Session, error)
+		Update(id uint64, name, retentionPolicy string, retentionValue int) (*dal.Session, error)
 		UpdateUpdatedAt(id uint64) error
 		Delete(id uint64) error
 	}
 
 	SessionService struct {
-		sessionRepo SessionRepository
+		sessionRepo  SessionRepository
+		memberRepo   SessionMemberRepository
+		userRepo     UserRepository
+		store        ClipboardStore
+		maxRetention time.Duration
 
 		log log.TracedLogger
 	}
 )
 
-func NewSessionService(sessionRepo SessionRepository, log log.TracedLogger) *SessionService {
+func NewSessionService(
+	sessionRepo SessionRepository, memberRepo SessionMemberRepository, userRepo UserRepository, store ClipboardStore,
+	maxRetention time.Duration, log log.TracedLogger,
+) *SessionService {
 	return &SessionService{
-		sessionRepo: sessionRepo,
-		log:         log,
+		sessionRepo:  sessionRepo,
+		memberRepo:   memberRepo,
+		userRepo:     userRepo,
+		store:        store,
+		maxRetention: maxRetention,
+		log:          log,
 	}
 }
 
 func (s *SessionService) GetByID(ctx context.Context, userID, id uint64) (*Session, error) {
 	s.log.Debugw(ctx, "get session by id", "sessionID", id)
 
-	session, err := s.sessionRepo.GetByID(id)
+	session, err := s.Authorize(ctx, userID, id, SessionRoleViewer)
 	if err != nil {
-		if errors.Is(err, dal.ErrNotFound) {
-			s.log.Debugw(ctx, "session not found")
-			return nil, ErrSessionNotFound
-		}
-
-		return nil, fmt.Errorf("get session by id=%d: %w", id, err)
-	}
-	if session.UserID != userID {
-		return nil, ErrSessionPermissionDenied
+		return nil, err
 	}
 
 	s.log.Debugw(ctx, "session found", "session", session)
-	return toSession(session), nil
+	return session, nil
 }
 
 func (s *SessionService) GetByUserID(ctx context.Context, userID uint64) ([]*Session, error) {
@@ -138,33 +145,37 @@ func (s *SessionService) Create(ctx context.Context, userID uint64, name string)
 	return toSession(session), nil
 }
 
-func (s *SessionService) Update(ctx context.Context, userID, sessionID uint64, name string) (*Session, error) {
-	s.log.Debugw(ctx, "update session", "sessionID", sessionID, "name", name)
+// Update updates session name and retention. Retention is kept unchanged if it is nil.
+func (s *SessionService) Update(ctx context.Context, userID, sessionID uint64, name string, retention *Retention) (*Session, error) {
+	s.log.Debugw(ctx, "update session", "sessionID", sessionID, "name", name, "retention", retention)
 
 	if strings.TrimSpace(name) == "" {
 		return nil, fmt.Errorf("name is empty")
 	}
-
-	session, err := s.sessionRepo.GetByID(sessionID)
-	if err != nil {
-		if errors.Is(err, dal.ErrNotFound) {
-			return nil, ErrSessionNotFound
+	if retention != nil {
+		if err := retention.Validate(s.maxRetention); err != nil {
+			return nil, err
 		}
+	}
 
-		return nil, fmt.Errorf("get session by id=%d: %w", sessionID, err)
+	session, err := s.Authorize(ctx, userID, sessionID, SessionRoleOwner)
+	if err != nil {
+		return nil, err
 	}
 
-	if session.UserID != userID {
-		return nil, ErrSessionPermissionDenied
+	if retention == nil {
+		retention = &session.Retention
 	}
 
-	updated, err := s.sessionRepo.Update(sessionID, name)
+	updated, err := s.sessionRepo.Update(sessionID, name, retention.Policy, retention.Value)
 	if err != nil {
 		return nil, fmt.Errorf("update session by id=%q: %w", sessionID, err)
 	}
 
 	s.log.Debugw(ctx, "session updated", "session", updated)
-	return toSession(updated), nil
+	res := toSession(updated)
+	res.Role = session.Role
+	return res, nil
 }
 
 func (s *SessionService) UpdateUpdatedAt(ctx context.Context, sessionID uint64) error {
@@ -181,22 +192,17 @@ func (s *SessionService) UpdateUpdatedAt(ctx context.Context, sessionID uint64)
 func (s *SessionService) Delete(ctx context.Context, userID, sessionID uint64) error {
 	s.log.Debugw(ctx, "delete session", "sessionID", sessionID)
 
-	session, err := s.sessionRepo.GetByID(sessionID)
-	if err != nil {
-		if errors.Is(err, dal.ErrNotFound) {
-			return ErrSessionNotFound
-		}
-
-		return fmt.Errorf("get session by id=%d: %w", sessionID, err)
+	if _, err := s.Authorize(ctx, userID, sessionID, SessionRoleOwner); err != nil {
+		return err
 	}
 
-	if session.UserID != userID {
-		return ErrSessionPermissionDenied
-	}
-
-	if err = s.sessionRepo.Delete(sessionID); err != nil {
+	if err := s.sessionRepo.Delete(sessionID); err != nil {
 		return fmt.Errorf("delete session by id=%d: %w", sessionID, err)
 	}
+	// clipboard is deleted after the session, so it is never left without the session while the session is there
+	if err := s.store.Delete(ctx, sessionID); err != nil {
+		s.log.Errorw(ctx, "Failed to delete clipboard of deleted session", "sessionID", sessionID, err)
+	}
 
 	s.log.Debugw(ctx, "session deleted", "sessionID", sessionID)
 	return nil
@@ -207,6 +213,8 @@ func toSession(session *dal.Session) *Session {
 		ID:        session.ID,
 		Name:      session.Name,
 		UserID:    session.UserID,
+		Retention: Retention{Policy: session.RetentionPolicy, Value: session.RetentionValue},
+		Role:      session.Role,
 		CreatedAt: session.CreatedAt,
 		UpdatedAt: session.UpdatedAt,
 	}
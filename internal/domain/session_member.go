@@ -0,0 +1,228 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+)
+
+const (
+	// SessionRoleViewer can read session and its clipboard
+	SessionRoleViewer = "viewer"
+	// SessionRoleEditor can also write clipboard
+	SessionRoleEditor = "editor"
+	// SessionRoleOwner can also update and delete session and manage its members
+	SessionRoleOwner = "owner"
+)
+
+var (
+	ErrSessionMemberNotFound = errors.New("session member not found")
+
+	sessionRoleRanks = map[string]int{
+		SessionRoleViewer: 1,
+		SessionRoleEditor: 2,
+		SessionRoleOwner:  3,
+	}
+)
+
+type (
+	SessionMember struct {
+		SessionID uint64
+		UserID    uint64
+		UserName  string
+		Role      string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	SessionMemberRepository interface {
+		Get(sessionID, userID uint64) (*dal.SessionMember, error)
+		GetAllBySessionID(sessionID uint64) ([]*dal.SessionMember, error)
+		Create(sessionID, userID uint64, role string) (*dal.SessionMember, error)
+		UpdateRole(sessionID, userID uint64, role string) (*dal.SessionMember, error)
+		Delete(sessionID, userID uint64) error
+	}
+)
+
+// RoleAllows checks if role grants everything the required role does
+func RoleAllows(role, required string) bool {
+	rank, ok := sessionRoleRanks[role]
+	return ok && rank >= sessionRoleRanks[required]
+}
+
+// Authorize returns session if user is its member with at least required role.
+// It returns ErrSessionNotFound if session does not exist or user is not its member, so existence of sessions
+// is not revealed to other users, and ErrSessionPermissionDenied if member lacks the role.
+func (s *SessionService) Authorize(ctx context.Context, userID, sessionID uint64, required string) (*Session, error) {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "session not found", "sessionID", sessionID)
+			return nil, ErrSessionNotFound
+		}
+
+		return nil, fmt.Errorf("get session by id=%d: %w", sessionID, err)
+	}
+
+	member, err := s.memberRepo.Get(sessionID, userID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "user is not a session member", "sessionID", sessionID, "userID", userID)
+			return nil, ErrSessionNotFound
+		}
+
+		return nil, fmt.Errorf("get session member by sessionID=%d and userID=%d: %w", sessionID, userID, err)
+	}
+	if !RoleAllows(member.Role, required) {
+		s.log.Debugw(ctx, "session role is not sufficient", "sessionID", sessionID, "role", member.Role, "required", required)
+		return nil, ErrSessionPermissionDenied
+	}
+
+	res := toSession(session)
+	res.Role = member.Role
+	return res, nil
+}
+
+func (s *SessionService) GetMembers(ctx context.Context, userID, sessionID uint64) ([]*SessionMember, error) {
+	s.log.Debugw(ctx, "get session members", "sessionID", sessionID)
+
+	if _, err := s.Authorize(ctx, userID, sessionID, SessionRoleViewer); err != nil {
+		return nil, err
+	}
+
+	members, err := s.memberRepo.GetAllBySessionID(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("get members by sessionID=%d: %w", sessionID, err)
+	}
+
+	res := make([]*SessionMember, 0, len(members))
+	for _, m := range members {
+		res = append(res, toSessionMember(m))
+	}
+	return res, nil
+}
+
+func (s *SessionService) AddMember(ctx context.Context, userID, sessionID uint64, memberName, role string) (*SessionMember, error) {
+	s.log.Debugw(ctx, "add session member", "sessionID", sessionID, "memberName", memberName, "role", role)
+
+	if err := validateSessionRole(role); err != nil {
+		return nil, err
+	}
+	if _, err := s.Authorize(ctx, userID, sessionID, SessionRoleOwner); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByName(memberName)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "user to add not found", "memberName", memberName)
+			return nil, &RenderableError{
+				Code:    ErrorCodeUserNotFound,
+				Message: "User not found",
+			}
+		}
+
+		return nil, fmt.Errorf("get user by name: %w", err)
+	}
+
+	member, err := s.memberRepo.Create(sessionID, user.ID, role)
+	if err != nil {
+		if errors.Is(err, dal.ErrConflictUnique) {
+			s.log.Debugw(ctx, "user is already a session member", "sessionID", sessionID, "memberID", user.ID)
+			return nil, &RenderableError{
+				Code:    ErrorCodeSessionMemberConflict,
+				Message: "User is already a session member",
+			}
+		}
+
+		return nil, fmt.Errorf("create session member: %w", err)
+	}
+
+	s.log.Debugw(ctx, "session member added", "sessionID", sessionID, "memberID", member.UserID)
+	return toSessionMember(member), nil
+}
+
+func (s *SessionService) UpdateMemberRole(ctx context.Context, userID, sessionID, memberID uint64, role string) (*SessionMember, error) {
+	s.log.Debugw(ctx, "update session member role", "sessionID", sessionID, "memberID", memberID, "role", role)
+
+	if err := validateSessionRole(role); err != nil {
+		return nil, err
+	}
+	if _, err := s.Authorize(ctx, userID, sessionID, SessionRoleOwner); err != nil {
+		return nil, err
+	}
+
+	member, err := s.memberRepo.UpdateRole(sessionID, memberID, role)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrSessionMemberNotFound
+		}
+		if errors.Is(err, dal.ErrLastSessionOwner) {
+			return nil, lastSessionOwnerError()
+		}
+
+		return nil, fmt.Errorf("update session member role: %w", err)
+	}
+
+	s.log.Debugw(ctx, "session member role updated", "sessionID", sessionID, "memberID", memberID)
+	return toSessionMember(member), nil
+}
+
+// RemoveMember removes member from session. Owners can remove anyone, other members can only leave.
+func (s *SessionService) RemoveMember(ctx context.Context, userID, sessionID, memberID uint64) error {
+	s.log.Debugw(ctx, "remove session member", "sessionID", sessionID, "memberID", memberID)
+
+	required := SessionRoleOwner
+	if userID == memberID {
+		required = SessionRoleViewer
+	}
+	if _, err := s.Authorize(ctx, userID, sessionID, required); err != nil {
+		return err
+	}
+
+	if err := s.memberRepo.Delete(sessionID, memberID); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return ErrSessionMemberNotFound
+		}
+		if errors.Is(err, dal.ErrLastSessionOwner) {
+			return lastSessionOwnerError()
+		}
+
+		return fmt.Errorf("delete session member: %w", err)
+	}
+
+	s.log.Debugw(ctx, "session member removed", "sessionID", sessionID, "memberID", memberID)
+	return nil
+}
+
+// lastSessionOwnerError is returned instead of leaving session without owners
+func lastSessionOwnerError() *RenderableError {
+	return &RenderableError{
+		Code:    ErrorCodeLastSessionOwner,
+		Message: "Session must have at least one owner",
+	}
+}
+
+func validateSessionRole(role string) error {
+	if _, ok := sessionRoleRanks[role]; !ok {
+		return &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: fmt.Sprintf("Unknown role %q", role),
+		}
+	}
+	return nil
+}
+
+func toSessionMember(member *dal.SessionMember) *SessionMember {
+	return &SessionMember{
+		SessionID: member.SessionID,
+		UserID:    member.UserID,
+		UserName:  member.UserName,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt,
+		UpdatedAt: member.UpdatedAt,
+	}
+}
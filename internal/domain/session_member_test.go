@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionService_NonMemberGetsNotFound(t *testing.T) {
+	ctx := context.Background()
+	service := newTestSessionService(t)
+
+	if _, err := service.GetByID(ctx, testOutsideID, testSessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound on get, got %v", err)
+	}
+	if _, err := service.GetMembers(ctx, testOutsideID, testSessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound on get members, got %v", err)
+	}
+	if err := service.Delete(ctx, testOutsideID, testSessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound on delete, got %v", err)
+	}
+	if _, err := service.GetByID(ctx, testOwnerID, testSessionID); err != nil {
+		t.Errorf("expected session to stay, got %v", err)
+	}
+}
+
+func TestSessionService_DeleteDeletesClipboard(t *testing.T) {
+	ctx := context.Background()
+	store := newTestClipboardStore()
+	service, _ := newTestSessionServiceWithStore(t, store)
+
+	if err := service.Delete(ctx, testEditorID, testSessionID); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Fatalf("expected ErrSessionPermissionDenied on delete by editor, got %v", err)
+	}
+	if _, ok := store.history[testSessionID]; !ok {
+		t.Fatal("expected clipboard to stay when session is not deleted")
+	}
+
+	if err := service.Delete(ctx, testOwnerID, testSessionID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := store.history[testSessionID]; ok {
+		t.Error("expected clipboard of deleted session to be deleted")
+	}
+}
+
+func TestSessionService_EditorCanNotManageMembers(t *testing.T) {
+	ctx := context.Background()
+	service := newTestSessionService(t)
+
+	if _, err := service.AddMember(ctx, testEditorID, testSessionID, "outsider", SessionRoleViewer); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on add, got %v", err)
+	}
+	if _, err := service.UpdateMemberRole(ctx, testEditorID, testSessionID, testViewerID, SessionRoleOwner); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on role update, got %v", err)
+	}
+	if err := service.RemoveMember(ctx, testEditorID, testSessionID, testViewerID); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on remove, got %v", err)
+	}
+
+	members, err := service.GetMembers(ctx, testViewerID, testSessionID)
+	if err != nil {
+		t.Fatalf("expected viewer to get members, got %v", err)
+	}
+	for _, m := range members {
+		if m.UserID == testViewerID && m.Role != SessionRoleViewer {
+			t.Errorf("expected viewer role to stay, got %q", m.Role)
+		}
+	}
+}
+
+func TestSessionService_MemberCanLeave(t *testing.T) {
+	ctx := context.Background()
+	service := newTestSessionService(t)
+
+	if err := service.RemoveMember(ctx, testViewerID, testSessionID, testViewerID); err != nil {
+		t.Fatalf("expected viewer to leave session, got %v", err)
+	}
+	if _, err := service.GetByID(ctx, testViewerID, testSessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound after leaving, got %v", err)
+	}
+}
+
+func TestSessionService_LastOwnerStays(t *testing.T) {
+	ctx := context.Background()
+	service := newTestSessionService(t)
+
+	var rErr *RenderableError
+	if _, err := service.UpdateMemberRole(ctx, testOwnerID, testSessionID, testOwnerID, SessionRoleEditor); !errors.As(err, &rErr) || rErr.Code != ErrorCodeLastSessionOwner {
+		t.Errorf("expected last owner error on demotion, got %v", err)
+	}
+	if err := service.RemoveMember(ctx, testOwnerID, testSessionID, testOwnerID); !errors.As(err, &rErr) || rErr.Code != ErrorCodeLastSessionOwner {
+		t.Errorf("expected last owner error on leaving, got %v", err)
+	}
+
+	if _, err := service.UpdateMemberRole(ctx, testOwnerID, testSessionID, testEditorID, SessionRoleOwner); err != nil {
+		t.Fatalf("promote editor: %v", err)
+	}
+	if err := service.RemoveMember(ctx, testOwnerID, testSessionID, testOwnerID); err != nil {
+		t.Errorf("expected owner to leave once there is another one, got %v", err)
+	}
+}
+
+func TestShareLinkService_EditorCanNotManageLinks(t *testing.T) {
+	ctx := context.Background()
+	sessions := newTestSessionService(t)
+	service := NewShareLinkService(nil, nil, sessions, nil, time.Hour, time.Hour, 1, newTestLogger())
+
+	if _, err := service.Create(ctx, testEditorID, testSessionID, 0, false, ""); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on create, got %v", err)
+	}
+	if _, err := service.GetAll(ctx, testEditorID, testSessionID); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on get, got %v", err)
+	}
+	if err := service.Revoke(ctx, testEditorID, testSessionID, 1); !errors.Is(err, ErrSessionPermissionDenied) {
+		t.Errorf("expected ErrSessionPermissionDenied on revoke, got %v", err)
+	}
+	if _, err := service.Create(ctx, testOutsideID, testSessionID, 0, false, ""); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound on create by non-member, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+// shareLinkMaxPasswordLength is the longest password bcrypt can hash
+const shareLinkMaxPasswordLength = 72
+
+var ErrShareLinkNotFound = errors.New("share link not found")
+
+type (
+	ShareLink struct {
+		ID        uint64
+		SessionID uint64
+		CreatedBy uint64
+		// Token is only known right after the link is created, only its hash is stored
+		Token             string
+		PasswordProtected bool
+		SingleUse         bool
+		AccessCount       int
+		ExpiresAt         time.Time
+		// RevokedAt is zero if link was not revoked
+		RevokedAt time.Time
+		// LastAccessedAt is zero if link was never accessed
+		LastAccessedAt time.Time
+		CreatedAt      time.Time
+	}
+
+	ShareLinkRepository interface {
+		GetAllBySessionID(sessionID uint64) ([]*dal.ShareLink, error)
+		GetActiveByTokenHash(tokenHash string) (*dal.ShareLink, error)
+		Create(sessionID, createdBy uint64, tokenHash, password string, singleUse bool, expiresAt time.Time) (*dal.ShareLink, error)
+		RecordAccess(id uint64) (*dal.ShareLink, error)
+		Revoke(sessionID, id uint64) error
+	}
+
+	// ShareLinkService lets session owners give anonymous read-only access to session clipboard.
+	// Password protected link is locked for the rest of its life after maxPasswordFailures wrong passwords.
+	ShareLinkService struct {
+		repo                ShareLinkRepository
+		sessionRepo         SessionRepository
+		sessions            SessionAuthorizer
+		client              RedisClient
+		defaultExpire       time.Duration
+		maxExpire           time.Duration
+		maxPasswordFailures int64
+		log                 log.TracedLogger
+	}
+)
+
+func NewShareLinkService(
+	repo ShareLinkRepository, sessionRepo SessionRepository, sessions SessionAuthorizer, client RedisClient,
+	defaultExpire, maxExpire time.Duration, maxPasswordFailures int64, log log.TracedLogger,
+) *ShareLinkService {
+	return &ShareLinkService{
+		repo:                repo,
+		sessionRepo:         sessionRepo,
+		sessions:            sessions,
+		client:              client,
+		defaultExpire:       defaultExpire,
+		maxExpire:           maxExpire,
+		maxPasswordFailures: maxPasswordFailures,
+		log:                 log,
+	}
+}
+
+// Create mints a new link to the session clipboard. Zero expireIn means default expiration, empty password means no password.
+func (s *ShareLinkService) Create(
+	ctx context.Context, userID, sessionID uint64, expireIn time.Duration, singleUse bool, password string,
+) (*ShareLink, error) {
+	s.log.Debugw(ctx, "create share link", "sessionID", sessionID, "expireIn", expireIn, "singleUse", singleUse)
+
+	if expireIn == 0 {
+		expireIn = s.defaultExpire
+	}
+	if expireIn < 0 || expireIn > s.maxExpire {
+		return nil, &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: fmt.Sprintf("Expiration must be positive and not exceed %d minutes", int(s.maxExpire.Minutes())),
+		}
+	}
+	if len(password) > shareLinkMaxPasswordLength {
+		return nil, &RenderableError{
+			Code:    ErrorBadRequest,
+			Message: fmt.Sprintf("Password must not be longer than %d bytes", shareLinkMaxPasswordLength),
+		}
+	}
+
+	if _, err := s.sessions.Authorize(ctx, userID, sessionID, SessionRoleOwner); err != nil {
+		return nil, fmt.Errorf("authorize share link create: %w", err)
+	}
+
+	token, err := newSecretToken("")
+	if err != nil {
+		return nil, fmt.Errorf("generate share link token: %w", err)
+	}
+
+	var hashedPassword string
+	if password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("hash share link password: %w", err)
+		}
+		hashedPassword = string(hashed)
+	}
+
+	link, err := s.repo.Create(sessionID, userID, hashSecretToken(token), hashedPassword, singleUse, time.Now().Add(expireIn))
+	if err != nil {
+		return nil, fmt.Errorf("create share link: %w", err)
+	}
+
+	s.log.Debugw(ctx, "share link created", "sessionID", sessionID, "linkID", link.ID)
+	res := toShareLink(link)
+	res.Token = token
+	return res, nil
+}
+
+func (s *ShareLinkService) GetAll(ctx context.Context, userID, sessionID uint64) ([]*ShareLink, error) {
+	s.log.Debugw(ctx, "get share links", "sessionID", sessionID)
+
+	if _, err := s.sessions.Authorize(ctx, userID, sessionID, SessionRoleOwner); err != nil {
+		return nil, fmt.Errorf("authorize share links read: %w", err)
+	}
+
+	links, err := s.repo.GetAllBySessionID(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("get share links by sessionID=%d: %w", sessionID, err)
+	}
+
+	res := make([]*ShareLink, 0, len(links))
+	for _, l := range links {
+		res = append(res, toShareLink(l))
+	}
+	return res, nil
+}
+
+func (s *ShareLinkService) Revoke(ctx context.Context, userID, sessionID, linkID uint64) error {
+	s.log.Debugw(ctx, "revoke share link", "sessionID", sessionID, "linkID", linkID)
+
+	if _, err := s.sessions.Authorize(ctx, userID, sessionID, SessionRoleOwner); err != nil {
+		return fmt.Errorf("authorize share link revoke: %w", err)
+	}
+
+	if err := s.repo.Revoke(sessionID, linkID); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return ErrShareLinkNotFound
+		}
+
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+
+	s.log.Debugw(ctx, "share link revoked", "sessionID", sessionID, "linkID", linkID)
+	return nil
+}
+
+// Open checks the link token and password and returns the link, the access is not counted until RecordAccess.
+// It returns ErrShareLinkNotFound if link does not exist or can not be accessed anymore.
+func (s *ShareLinkService) Open(ctx context.Context, token, password string) (*ShareLink, error) {
+	link, err := s.repo.GetActiveByTokenHash(hashSecretToken(token))
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "active share link not found")
+			return nil, ErrShareLinkNotFound
+		}
+
+		return nil, fmt.Errorf("get share link: %w", err)
+	}
+
+	if link.Password != "" {
+		if password == "" {
+			return nil, &RenderableError{
+				Code:    ErrorCodeShareLinkPasswordRequired,
+				Message: "Share link is protected with password",
+			}
+		}
+		if err = s.attemptPassword(ctx, link); err != nil {
+			return nil, err
+		}
+		if err = bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(password)); err != nil {
+			s.log.Debugw(ctx, "share link password mismatch", "linkID", link.ID)
+			return nil, &RenderableError{
+				Code:    ErrorCodeShareLinkWrongPassword,
+				Message: "Wrong share link password",
+			}
+		}
+		if err = s.client.Decr(ctx, shareLinkFailuresKey(link.ID)).Err(); err != nil {
+			// not fatal, the attempt just stays counted
+			s.log.Errorw(ctx, "Failed to uncount share link password attempt", "linkID", link.ID, err)
+		}
+	}
+
+	return toShareLink(link), nil
+}
+
+// RecordAccess counts access of the link opened with Open and returns session the link gives access to.
+// It returns ErrShareLinkNotFound if link was revoked, expired or used up since it was opened.
+func (s *ShareLinkService) RecordAccess(ctx context.Context, linkID uint64) (*Session, error) {
+	// access is counted conditionally, so single use link can not be opened twice by concurrent requests
+	accessed, err := s.repo.RecordAccess(linkID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "share link became inactive", "linkID", linkID)
+			return nil, ErrShareLinkNotFound
+		}
+
+		return nil, fmt.Errorf("record share link access: %w", err)
+	}
+	s.log.Debugw(ctx, "share link accessed", "linkID", accessed.ID, "accessCount", accessed.AccessCount)
+
+	session, err := s.sessionRepo.GetByID(accessed.SessionID)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrShareLinkNotFound
+		}
+
+		return nil, fmt.Errorf("get session by id=%d: %w", accessed.SessionID, err)
+	}
+
+	return toSession(session), nil
+}
+
+// attemptPassword counts password attempt as failed in advance, so concurrent attempts can not pass the check before
+// any of them is counted, and refuses it if the link is locked. Failures are kept until the link expires.
+func (s *ShareLinkService) attemptPassword(ctx context.Context, link *dal.ShareLink) error {
+	key := shareLinkFailuresKey(link.ID)
+	var failures *redis.IntCmd
+	if _, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(ctx, key)
+		pipe.ExpireAt(ctx, key, link.ExpiresAt)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("count share link password attempt: %w", err)
+	}
+
+	if failures.Val() > s.maxPasswordFailures {
+		s.log.Debugw(ctx, "share link is locked", "linkID", link.ID)
+		return &RenderableError{
+			Code:    ErrorCodeShareLinkLocked,
+			Message: "Share link is locked after too many wrong passwords",
+		}
+	}
+	return nil
+}
+
+func toShareLink(link *dal.ShareLink) *ShareLink {
+	return &ShareLink{
+		ID:                link.ID,
+		SessionID:         link.SessionID,
+		CreatedBy:         link.CreatedBy,
+		PasswordProtected: link.Password != "",
+		SingleUse:         link.SingleUse,
+		AccessCount:       link.AccessCount,
+		ExpiresAt:         link.ExpiresAt,
+		RevokedAt:         link.RevokedAt,
+		LastAccessedAt:    link.LastAccessedAt,
+		CreatedAt:         link.CreatedAt,
+	}
+}
+
+func shareLinkFailuresKey(id uint64) string {
+	return fmt.Sprintf("share_link_failures:%d", id)
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+)
+
+const (
+	testShareLinkToken    = "share-link-token"
+	testShareLinkPassword = "correct horse"
+)
+
+// fakeShareLinkRepository only implements opening links, other methods panic
+type fakeShareLinkRepository struct {
+	ShareLinkRepository
+	tokenHash string
+	link      *dal.ShareLink
+}
+
+func (r *fakeShareLinkRepository) GetActiveByTokenHash(tokenHash string) (*dal.ShareLink, error) {
+	if tokenHash != r.tokenHash || r.usedUp() {
+		return nil, dal.ErrNotFound
+	}
+	return r.link, nil
+}
+
+func (r *fakeShareLinkRepository) RecordAccess(uint64) (*dal.ShareLink, error) {
+	if r.usedUp() {
+		return nil, dal.ErrNotFound
+	}
+	r.link.AccessCount++
+	return r.link, nil
+}
+
+func (r *fakeShareLinkRepository) usedUp() bool {
+	return r.link.SingleUse && r.link.AccessCount > 0
+}
+
+func newTestShareLinkService(t *testing.T) (*ShareLinkService, *miniredis.Miniredis) {
+	t.Helper()
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(testShareLinkPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	links := &fakeShareLinkRepository{tokenHash: hashSecretToken(testShareLinkToken), link: &dal.ShareLink{
+		ID:        1,
+		SessionID: testSessionID,
+		Password:  string(hashed),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}}
+	sessions, sessionRepo := newTestSessionServiceWithStore(t, newTestClipboardStore())
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return NewShareLinkService(links, sessionRepo, sessions, client, time.Hour, time.Hour, 3, newTestLogger()), server
+}
+
+func openShareLinkErrorCode(t *testing.T, service *ShareLinkService, password string) ErrorCode {
+	t.Helper()
+
+	_, err := service.Open(context.Background(), testShareLinkToken, password)
+	if err == nil {
+		return ErrorCode{}
+	}
+	var rErr *RenderableError
+	if !errors.As(err, &rErr) {
+		t.Fatalf("open share link: %v", err)
+	}
+	return rErr.Code
+}
+
+func TestShareLinkService_OpenLocksAfterWrongPasswords(t *testing.T) {
+	service, server := newTestShareLinkService(t)
+
+	if code := openShareLinkErrorCode(t, service, testShareLinkPassword); code != (ErrorCode{}) {
+		t.Fatalf("expected link to open, got %s", code.Value)
+	}
+	for i := 1; i <= 3; i++ {
+		if code := openShareLinkErrorCode(t, service, "wrong"); code != ErrorCodeShareLinkWrongPassword {
+			t.Fatalf("expected wrong password on attempt %d, got %s", i, code.Value)
+		}
+	}
+	if code := openShareLinkErrorCode(t, service, testShareLinkPassword); code != ErrorCodeShareLinkLocked {
+		t.Fatalf("expected link to be locked, got %s", code.Value)
+	}
+
+	if ttl := server.TTL(shareLinkFailuresKey(1)); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("expected failures to expire with the link, got ttl %s", ttl)
+	}
+}
+
+func TestShareLinkService_OpenDoesNotCountCorrectPassword(t *testing.T) {
+	service, _ := newTestShareLinkService(t)
+
+	for i := 1; i <= 5; i++ {
+		if code := openShareLinkErrorCode(t, service, testShareLinkPassword); code != (ErrorCode{}) {
+			t.Fatalf("expected link to open on attempt %d, got %s", i, code.Value)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// signInBaseDelay is how long sign in is locked after the first failed attempt over free ones, it doubles with each next one
+const signInBaseDelay = time.Second
+
+var (
+	// signInAttemptScript refuses attempt if either subject is locked, otherwise counts it as failed for both of them
+	// and locks those with more failures than free attempts. Check and count are done at once, so concurrent attempts
+	// can not pass the check before any of them is counted.
+	//
+	// KEYS: name lock, IP lock, name failures, IP failures
+	// ARGV: free attempts per name, free attempts per IP, window, base delay and max lockout in milliseconds
+	// Returns milliseconds the attempt is refused for and milliseconds sign in is locked for if the attempt fails.
+	signInAttemptScript = redis.NewScript(`
+local locked = math.max(redis.call('PTTL', KEYS[1]), redis.call('PTTL', KEYS[2]), 0)
+if locked > 0 then
+	return {locked, 0}
+end
+
+local lockout = 0
+for i = 1, 2 do
+	local failures = redis.call('INCR', KEYS[i + 2])
+	if redis.call('PTTL', KEYS[i + 2]) == -1 then
+		redis.call('PEXPIRE', KEYS[i + 2], ARGV[3])
+	end
+
+	local overFree = failures - tonumber(ARGV[i])
+	if overFree > 0 then
+		local delay = math.floor(math.min(tonumber(ARGV[4]) * 2 ^ (overFree - 1), tonumber(ARGV[5])))
+		redis.call('SET', KEYS[i], failures, 'PX', delay)
+		lockout = math.max(lockout, delay)
+	end
+end
+return {0, lockout}
+`)
+
+	// signInSuccessScript forgets failures of the name and uncounts the attempt from the IP, IP lock set by the
+	// attempt is lifted as well.
+	//
+	// KEYS: name failures, name lock, IP failures, IP lock
+	// ARGV: free attempts per IP
+	signInSuccessScript = redis.NewScript(`
+redis.call('DEL', KEYS[1], KEYS[2])
+if redis.call('EXISTS', KEYS[3]) == 1 and redis.call('DECR', KEYS[3]) <= tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[4])
+end
+return 0
+`)
+)
+
+type (
+	SignInLimiterConfig struct {
+		// FreeAttemptsPerName and FreeAttemptsPerIP are failed attempts allowed before sign in is locked
+		FreeAttemptsPerName int64
+		FreeAttemptsPerIP   int64
+		MaxLockout          time.Duration
+		// Window is how long failed attempts are counted for since the first one
+		Window time.Duration
+	}
+
+	// SignInAttempt is outcome of SignInLimiter.Attempt
+	SignInAttempt struct {
+		// LockedFor is not zero if sign in is locked and attempt must be refused
+		LockedFor time.Duration
+		// LockoutOnFailure is for how long sign in is locked after the attempt unless it succeeds
+		LockoutOnFailure time.Duration
+	}
+
+	// SignInLimiter counts failed sign in attempts per user name and per IP and locks sign in for exponentially
+	// growing time once there are more of them than free attempts. Per name lock protects the account from
+	// distributed guessing, per IP lock limits guessing of many accounts from one address.
+	SignInLimiter struct {
+		client RedisClient
+		conf   SignInLimiterConfig
+	}
+)
+
+func NewSignInLimiter(client RedisClient, conf SignInLimiterConfig) *SignInLimiter {
+	return &SignInLimiter{
+		client: client,
+		conf:   conf,
+	}
+}
+
+// Attempt checks that sign in with the name from the IP is not locked and counts the attempt as failed in advance,
+// so it is counted even if it is still in progress when the next one is made. Successful attempts must be reported
+// with Succeed.
+func (l *SignInLimiter) Attempt(ctx context.Context, name, ip string) (*SignInAttempt, error) {
+	nameKey, ipKey := nameSubject(name), ipSubject(ip)
+
+	res, err := signInAttemptScript.Run(ctx, l.client,
+		[]string{signInLockKey(nameKey), signInLockKey(ipKey), signInFailuresKey(nameKey), signInFailuresKey(ipKey)},
+		l.conf.FreeAttemptsPerName, l.conf.FreeAttemptsPerIP, l.conf.Window.Milliseconds(), signInBaseDelay.Milliseconds(), l.conf.MaxLockout.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("count sign in attempt: %w", err)
+	}
+	if len(res) != 2 {
+		return nil, fmt.Errorf("count sign in attempt: unexpected result %v", res)
+	}
+
+	return &SignInAttempt{
+		LockedFor:        time.Duration(res[0]) * time.Millisecond,
+		LockoutOnFailure: time.Duration(res[1]) * time.Millisecond,
+	}, nil
+}
+
+// Succeed forgets failed attempts for the name after successful sign in and uncounts the attempt from the IP,
+// earlier failures of the IP are kept
+func (l *SignInLimiter) Succeed(ctx context.Context, name, ip string) error {
+	nameKey, ipKey := nameSubject(name), ipSubject(ip)
+
+	if err := signInSuccessScript.Run(ctx, l.client,
+		[]string{signInFailuresKey(nameKey), signInLockKey(nameKey), signInFailuresKey(ipKey), signInLockKey(ipKey)},
+		l.conf.FreeAttemptsPerIP,
+	).Err(); err != nil {
+		return fmt.Errorf("reset sign in failures: %w", err)
+	}
+	return nil
+}
+
+// nameSubject hashes the name, so keys are of fixed length and names of any case share the counter
+func nameSubject(name string) string {
+	return "name:" + hashSecretToken(strings.ToLower(name))
+}
+
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+func signInFailuresKey(subject string) string {
+	return fmt.Sprintf("signin_failures:%s", subject)
+}
+
+func signInLockKey(subject string) string {
+	return fmt.Sprintf("signin_lock:%s", subject)
+}
@@ -0,0 +1,128 @@
+package domain
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestSignInLimiter(t *testing.T) (*SignInLimiter, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return NewSignInLimiter(client, SignInLimiterConfig{
+		FreeAttemptsPerName: 3,
+		FreeAttemptsPerIP:   5,
+		MaxLockout:          4 * time.Second,
+		Window:              time.Hour,
+	}), server
+}
+
+func attemptSignIn(t *testing.T, limiter *SignInLimiter, name, ip string) *SignInAttempt {
+	t.Helper()
+
+	attempt, err := limiter.Attempt(context.Background(), name, ip)
+	if err != nil {
+		t.Fatalf("attempt sign in: %v", err)
+	}
+	return attempt
+}
+
+func TestSignInLimiter_LocksAfterFreeAttempts(t *testing.T) {
+	limiter, server := newTestSignInLimiter(t)
+
+	for i := 1; i <= 3; i++ {
+		if attempt := attemptSignIn(t, limiter, "alice", "192.0.2.1"); attempt.LockedFor != 0 || attempt.LockoutOnFailure != 0 {
+			t.Fatalf("expected free attempt %d, got %+v", i, attempt)
+		}
+	}
+	if attempt := attemptSignIn(t, limiter, "Alice", "192.0.2.2"); attempt.LockedFor != 0 || attempt.LockoutOnFailure != time.Second {
+		t.Fatalf("expected attempt over free ones to lock for a second, got %+v", attempt)
+	}
+	if attempt := attemptSignIn(t, limiter, "alice", "192.0.2.3"); attempt.LockedFor <= 0 {
+		t.Fatalf("expected locked attempt, got %+v", attempt)
+	}
+
+	server.FastForward(time.Second)
+	if attempt := attemptSignIn(t, limiter, "alice", "192.0.2.1"); attempt.LockedFor != 0 || attempt.LockoutOnFailure != 2*time.Second {
+		t.Fatalf("expected lockout to double, got %+v", attempt)
+	}
+	server.FastForward(2 * time.Second)
+	attemptSignIn(t, limiter, "alice", "192.0.2.1")
+	server.FastForward(4 * time.Second)
+	if attempt := attemptSignIn(t, limiter, "alice", "192.0.2.1"); attempt.LockoutOnFailure != 4*time.Second {
+		t.Fatalf("expected lockout to be capped, got %+v", attempt)
+	}
+
+	if ttl := server.TTL(signInFailuresKey(nameSubject("alice"))); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("expected failures to expire within window, got ttl %s", ttl)
+	}
+}
+
+func TestSignInLimiter_ConcurrentAttemptsAreCounted(t *testing.T) {
+	limiter, _ := newTestSignInLimiter(t)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, err := limiter.Attempt(context.Background(), "bob", "192.0.2.1")
+			if err != nil {
+				t.Errorf("attempt sign in: %v", err)
+				return
+			}
+			if attempt.LockedFor == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 4 {
+		t.Errorf("expected free attempts and one more to be allowed, got %d", allowed)
+	}
+}
+
+func TestSignInLimiter_Succeed(t *testing.T) {
+	ctx := context.Background()
+	limiter, server := newTestSignInLimiter(t)
+
+	for _, name := range []string{"carol", "carol", "carol", "carol", "erin"} {
+		attemptSignIn(t, limiter, name, "192.0.2.1")
+	}
+	attemptSignIn(t, limiter, "dave", "192.0.2.1")
+	if !server.Exists(signInLockKey(ipSubject("192.0.2.1"))) {
+		t.Fatal("expected IP to be locked")
+	}
+
+	if err := limiter.Succeed(ctx, "dave", "192.0.2.1"); err != nil {
+		t.Fatalf("succeed: %v", err)
+	}
+	if server.Exists(signInLockKey(ipSubject("192.0.2.1"))) {
+		t.Error("expected IP lock set by successful attempt to be lifted")
+	}
+	if failures, _ := server.Get(signInFailuresKey(ipSubject("192.0.2.1"))); failures != "5" {
+		t.Errorf("expected earlier IP failures to be kept, got %q", failures)
+	}
+
+	if err := limiter.Succeed(ctx, "carol", "192.0.2.2"); err != nil {
+		t.Fatalf("succeed: %v", err)
+	}
+	if attempt := attemptSignIn(t, limiter, "carol", "192.0.2.2"); attempt.LockedFor != 0 || attempt.LockoutOnFailure != 0 {
+		t.Errorf("expected failures of the name to be forgotten, got %+v", attempt)
+	}
+}
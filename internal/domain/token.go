@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const secretTokenBytes = 32
+
+// newSecretToken generates random token handed out to clients once, only its hash is supposed to be stored
+func newSecretToken(prefix string) (string, error) {
+	b := make([]byte, secretTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecretToken returns hash to store and look token up by. Tokens are random, so they need no salt and slow hash.
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+)
+
+// testTOTPKey is the SHA-1 key of RFC 6238 test vectors
+var testTOTPKey = []byte("12345678901234567890")
+
+// fakeTwoFactorRepository only implements UseStep, other methods panic
+type fakeTwoFactorRepository struct {
+	TwoFactorRepository
+	lastStep int64
+}
+
+func (r *fakeTwoFactorRepository) UseStep(_ uint64, step int64) error {
+	if step <= r.lastStep {
+		return dal.ErrNotFound
+	}
+	r.lastStep = step
+	return nil
+}
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// codes of RFC 6238 Appendix B are 8 digits long, 6 digit codes are their last digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(testTOTPKey, tt.unix/int64(totpPeriod.Seconds())); got != tt.want {
+			t.Errorf("expected code %s at %d, got %s", tt.want, tt.unix, got)
+		}
+	}
+}
+
+func TestMatchTOTP_Skew(t *testing.T) {
+	var (
+		secret  = totpEncoding.EncodeToString(testTOTPKey)
+		now     = time.Unix(1111111109, 0)
+		current = now.Unix() / int64(totpPeriod.Seconds())
+	)
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if matched, ok := matchTOTP(secret, totpCode(testTOTPKey, step), now); !ok || matched != step {
+			t.Errorf("expected code of step %d to match, got %d, %t", step, matched, ok)
+		}
+	}
+	for _, step := range []int64{current - totpSkew - 1, current + totpSkew + 1} {
+		if _, ok := matchTOTP(secret, totpCode(testTOTPKey, step), now); ok {
+			t.Errorf("expected code of step %d to be refused", step)
+		}
+	}
+	if _, ok := matchTOTP(secret, "81804", now); ok {
+		t.Error("expected code of wrong length to be refused")
+	}
+}
+
+func TestTwoFactorService_CodeCanNotBeReplayed(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		repo    = &fakeTwoFactorRepository{}
+		service = NewTwoFactorService(repo, nil, "shared-clipboard", time.Minute, newTestLogger())
+		tf      = &dal.TwoFactor{UserID: 1, Secret: totpEncoding.EncodeToString(testTOTPKey)}
+		current = time.Now().Unix() / int64(totpPeriod.Seconds())
+	)
+
+	if err := service.verifyCode(ctx, tf, totpCode(testTOTPKey, current)); err != nil {
+		t.Fatalf("expected code to be accepted, got %v", err)
+	}
+
+	for name, step := range map[string]int64{"same code": current, "code of previous step": current - 1} {
+		var rErr *RenderableError
+		if err := service.verifyCode(ctx, tf, totpCode(testTOTPKey, step)); !errors.As(err, &rErr) || rErr.Code != ErrorCodeTwoFactorWrongCode {
+			t.Errorf("expected %s to be refused, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	recoveryCodesCount = 10
+	// recoveryCodeAlphabet avoids characters which are easy to confuse when typed from a printout,
+	// it has 32 characters so random bytes map to them evenly
+	recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+	// twoFactorMaxAttempts limits guessing of codes for a single sign in challenge
+	twoFactorMaxAttempts = 5
+)
+
+var ErrTwoFactorChallengeNotFound = errors.New("two factor challenge not found")
+
+type (
+	TwoFactorStatus struct {
+		Enabled           bool
+		RecoveryCodesLeft int
+	}
+
+	// TOTPEnrollment is what authenticator app is set up with
+	TOTPEnrollment struct {
+		Secret string
+		URI    string
+	}
+
+	// TwoFactorChallenge is handed out after password is verified, sign in is completed by presenting it with a code
+	TwoFactorChallenge struct {
+		Token     string
+		ExpiresAt time.Time
+	}
+
+	TwoFactorRepository interface {
+		GetByUserID(userID uint64) (*dal.TwoFactor, error)
+		SetPendingSecret(userID uint64, secret string) error
+		Confirm(userID uint64, step int64, recoveryCodeHashes []string) error
+		UseStep(userID uint64, step int64) error
+		UseRecoveryCode(userID uint64, codeHash string) error
+		ReplaceRecoveryCodes(userID uint64, codeHashes []string) error
+		Delete(userID uint64) error
+	}
+
+	// TwoFactorService manages TOTP two-factor authentication and its recovery codes
+	TwoFactorService struct {
+		repo         TwoFactorRepository
+		client       RedisClient
+		issuer       string
+		challengeTTL time.Duration
+		log          log.TracedLogger
+	}
+)
+
+func NewTwoFactorService(repo TwoFactorRepository, client RedisClient, issuer string, challengeTTL time.Duration, log log.TracedLogger) *TwoFactorService {
+	return &TwoFactorService{
+		repo:         repo,
+		client:       client,
+		issuer:       issuer,
+		challengeTTL: challengeTTL,
+		log:          log,
+	}
+}
+
+func (s *TwoFactorService) Status(ctx context.Context, userID uint64) (*TwoFactorStatus, error) {
+	s.log.Debugw(ctx, "get two factor status", "userID", userID)
+
+	tf, err := s.repo.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get two factor: %w", err)
+	}
+
+	return &TwoFactorStatus{
+		Enabled:           !tf.ConfirmedAt.IsZero(),
+		RecoveryCodesLeft: tf.RecoveryCodesLeft,
+	}, nil
+}
+
+func (s *TwoFactorService) IsEnabled(ctx context.Context, userID uint64) (bool, error) {
+	status, err := s.Status(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return status.Enabled, nil
+}
+
+// Enroll generates a new secret for the user, two-factor authentication is enabled once it is confirmed with a code.
+// Enrolling again before confirmation replaces the pending secret.
+func (s *TwoFactorService) Enroll(ctx context.Context, userID uint64, account string) (*TOTPEnrollment, error) {
+	s.log.Debugw(ctx, "enroll totp", "userID", userID)
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("generate totp secret: %w", err)
+	}
+
+	if err = s.repo.SetPendingSecret(userID, secret); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, &RenderableError{
+				Code:    ErrorCodeTwoFactorEnabled,
+				Message: "Two-factor authentication is already enabled",
+			}
+		}
+
+		return nil, fmt.Errorf("set pending totp secret: %w", err)
+	}
+
+	s.log.Debugw(ctx, "totp enrollment started", "userID", userID)
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(s.issuer, account, secret),
+	}, nil
+}
+
+// PendingEnrollment returns enrollment which is not confirmed yet, e.g. to render it as QR code
+func (s *TwoFactorService) PendingEnrollment(ctx context.Context, userID uint64, account string) (*TOTPEnrollment, error) {
+	tf, err := s.repo.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get two factor: %w", err)
+	}
+	if tf.Secret == "" || !tf.ConfirmedAt.IsZero() {
+		s.log.Debugw(ctx, "pending totp enrollment not found", "userID", userID)
+		return nil, ErrNotFound
+	}
+
+	return &TOTPEnrollment{
+		Secret: tf.Secret,
+		URI:    totpURI(s.issuer, account, tf.Secret),
+	}, nil
+}
+
+// Confirm enables two-factor authentication if code matches pending secret and returns recovery codes.
+// Recovery codes are only known at this point, only their hashes are stored.
+func (s *TwoFactorService) Confirm(ctx context.Context, userID uint64, code string) ([]string, error) {
+	s.log.Debugw(ctx, "confirm totp", "userID", userID)
+
+	tf, err := s.repo.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get two factor: %w", err)
+	}
+	if !tf.ConfirmedAt.IsZero() {
+		return nil, &RenderableError{
+			Code:    ErrorCodeTwoFactorEnabled,
+			Message: "Two-factor authentication is already enabled",
+		}
+	}
+	if tf.Secret == "" {
+		return nil, &RenderableError{
+			Code:    ErrorCodeTwoFactorNotEnabled,
+			Message: "Two-factor authentication enrollment is not started",
+		}
+	}
+
+	step, ok := matchTOTP(tf.Secret, code, time.Now())
+	if !ok {
+		return nil, wrongTwoFactorCodeError()
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.repo.Confirm(userID, step, hashes); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			// enrollment was confirmed or replaced concurrently
+			return nil, wrongTwoFactorCodeError()
+		}
+
+		return nil, fmt.Errorf("confirm totp: %w", err)
+	}
+
+	s.log.Infow(ctx, "Two-factor authentication enabled", "userID", userID)
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off, it requires a valid code or recovery code
+func (s *TwoFactorService) Disable(ctx context.Context, userID uint64, code string) error {
+	s.log.Debugw(ctx, "disable two factor", "userID", userID)
+
+	if err := s.verifyEnabled(ctx, userID, code); err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(userID); err != nil {
+		return fmt.Errorf("delete two factor: %w", err)
+	}
+
+	s.log.Infow(ctx, "Two-factor authentication disabled", "userID", userID)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces recovery codes with new ones, it requires a valid code or recovery code
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID uint64, code string) ([]string, error) {
+	s.log.Debugw(ctx, "regenerate recovery codes", "userID", userID)
+
+	if err := s.verifyEnabled(ctx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.repo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, fmt.Errorf("replace recovery codes: %w", err)
+	}
+
+	s.log.Infow(ctx, "Recovery codes regenerated", "userID", userID)
+	return codes, nil
+}
+
+// CreateChallenge starts the second step of sign in for the user which password was verified
+func (s *TwoFactorService) CreateChallenge(ctx context.Context, userID uint64) (*TwoFactorChallenge, error) {
+	token, err := newSecretToken("")
+	if err != nil {
+		return nil, fmt.Errorf("generate two factor challenge: %w", err)
+	}
+
+	key := twoFactorChallengeKey(hashSecretToken(token))
+	if err = s.client.Set(ctx, key, strconv.FormatUint(userID, 10), s.challengeTTL).Err(); err != nil {
+		return nil, fmt.Errorf("set two factor challenge with key=%q: %w", key, err)
+	}
+
+	s.log.Debugw(ctx, "two factor challenge created", "userID", userID)
+	return &TwoFactorChallenge{
+		Token:     token,
+		ExpiresAt: time.Now().Add(s.challengeTTL),
+	}, nil
+}
+
+// ChallengeUserID returns ID of the user signing in with the challenge, e.g. to check sign in is not locked for them.
+// It returns ErrTwoFactorChallengeNotFound if challenge is unknown or expired.
+func (s *TwoFactorService) ChallengeUserID(ctx context.Context, token string) (uint64, error) {
+	key := twoFactorChallengeKey(hashSecretToken(token))
+
+	value, err := s.client.Get(ctx, key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			s.log.Debugw(ctx, "two factor challenge not found")
+			return 0, ErrTwoFactorChallengeNotFound
+		}
+
+		return 0, fmt.Errorf("get two factor challenge with key=%q: %w", key, err)
+	}
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse two factor challenge user id: %w", err)
+	}
+
+	return userID, nil
+}
+
+// CompleteChallenge verifies code or recovery code for the challenge and returns ID of the user signing in.
+// Challenge can be completed once and only twoFactorMaxAttempts codes can be tried for it.
+// It returns ErrTwoFactorChallengeNotFound if challenge is unknown, expired or out of attempts.
+func (s *TwoFactorService) CompleteChallenge(ctx context.Context, token, code string) (uint64, error) {
+	var (
+		hash        = hashSecretToken(token)
+		key         = twoFactorChallengeKey(hash)
+		attemptsKey = twoFactorAttemptsKey(hash)
+	)
+
+	userID, err := s.ChallengeUserID(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+
+	attempts, err := s.client.Incr(ctx, attemptsKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("increment two factor challenge attempts with key=%q: %w", attemptsKey, err)
+	}
+	if err = s.client.Expire(ctx, attemptsKey, s.challengeTTL).Err(); err != nil {
+		return 0, fmt.Errorf("expire two factor challenge attempts with key=%q: %w", attemptsKey, err)
+	}
+	if attempts > twoFactorMaxAttempts {
+		s.log.Infow(ctx, "Two factor challenge is out of attempts", "userID", userID)
+		s.deleteChallenge(ctx, key, attemptsKey)
+		return 0, ErrTwoFactorChallengeNotFound
+	}
+
+	tf, err := s.repo.GetByUserID(userID)
+	if err != nil {
+		return 0, fmt.Errorf("get two factor: %w", err)
+	}
+	if tf.ConfirmedAt.IsZero() {
+		// two-factor authentication was disabled after challenge was created, password was verified anyway
+		s.deleteChallenge(ctx, key, attemptsKey)
+		return userID, nil
+	}
+	if err = s.verifyCode(ctx, tf, code); err != nil {
+		return 0, err
+	}
+
+	s.deleteChallenge(ctx, key, attemptsKey)
+	s.log.Debugw(ctx, "two factor challenge completed", "userID", userID)
+	return userID, nil
+}
+
+func (s *TwoFactorService) verifyEnabled(ctx context.Context, userID uint64, code string) error {
+	tf, err := s.repo.GetByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("get two factor: %w", err)
+	}
+	if tf.ConfirmedAt.IsZero() {
+		return &RenderableError{
+			Code:    ErrorCodeTwoFactorNotEnabled,
+			Message: "Two-factor authentication is not enabled",
+		}
+	}
+
+	return s.verifyCode(ctx, tf, code)
+}
+
+// verifyCode checks TOTP code or, if code does not look like one, recovery code. Verified codes can not be used again.
+func (s *TwoFactorService) verifyCode(ctx context.Context, tf *dal.TwoFactor, code string) error {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totpDigits {
+		step, ok := matchTOTP(tf.Secret, code, time.Now())
+		if !ok {
+			s.log.Debugw(ctx, "wrong totp code", "userID", tf.UserID)
+			return wrongTwoFactorCodeError()
+		}
+		if err := s.repo.UseStep(tf.UserID, step); err != nil {
+			if errors.Is(err, dal.ErrNotFound) {
+				s.log.Debugw(ctx, "totp code was already used", "userID", tf.UserID)
+				return wrongTwoFactorCodeError()
+			}
+
+			return fmt.Errorf("use totp step: %w", err)
+		}
+		return nil
+	}
+
+	if err := s.repo.UseRecoveryCode(tf.UserID, hashSecretToken(normalizeRecoveryCode(code))); err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "wrong recovery code", "userID", tf.UserID)
+			return wrongTwoFactorCodeError()
+		}
+
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+
+	s.log.Infow(ctx, "Recovery code used", "userID", tf.UserID)
+	return nil
+}
+
+func (s *TwoFactorService) deleteChallenge(ctx context.Context, keys ...string) {
+	if err := s.client.Del(ctx, keys...).Err(); err != nil {
+		// challenge expires anyway
+		s.log.Errorw(ctx, "Failed to delete two factor challenge", err)
+	}
+}
+
+// newRecoveryCodes generates recovery codes formatted like "abcde-fghjk" and their hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+
+	b := make([]byte, recoveryCodeLength)
+	for i := 0; i < recoveryCodesCount; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		for j := range b {
+			b[j] = recoveryCodeAlphabet[int(b[j])%len(recoveryCodeAlphabet)]
+		}
+
+		code := string(b)
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashes = append(hashes, hashSecretToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func wrongTwoFactorCodeError() *RenderableError {
+	return &RenderableError{
+		Code:    ErrorCodeTwoFactorWrongCode,
+		Message: "Code is not valid",
+	}
+}
+
+func twoFactorChallengeKey(hash string) string {
+	return fmt.Sprintf("2fa_challenge:%s", hash)
+}
+
+func twoFactorAttemptsKey(hash string) string {
+	return fmt.Sprintf("2fa_challenge_attempts:%s", hash)
+}
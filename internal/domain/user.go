@@ -4,63 +4,132 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"github.com/Roma7-7-7/shared-clipboard/internal/dal"
 	"github.com/Roma7-7-7/shared-clipboard/internal/log"
 	"github.com/Roma7-7-7/shared-clipboard/tools"
 )
 
 const (
-	passwordSaltLength = 16
+	maxEmailLength = 320
+
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
 )
 
+// ErrUserDisabled is returned on sign in of user disabled by admin, it is only returned once credentials are verified
+var ErrUserDisabled = errors.New("user is disabled")
+
 type (
 	User struct {
-		ID           uint64
-		Name         string
-		Password     string
+		ID       uint64
+		Name     string
+		Password string
+		// PasswordSalt is only set for legacy bcrypt hashes, which were created from password concatenated with it
 		PasswordSalt string
-		CreatedAt    time.Time
-		UpdatedAt    time.Time
+		// Email is empty if user did not set it
+		Email string
+		Role  string
+		// DisabledAt is zero if user is not disabled
+		DisabledAt time.Time
+		// TokenGeneration must be embedded into access tokens, tokens of other generations are not valid
+		TokenGeneration uint64
+		CreatedAt       time.Time
+		UpdatedAt       time.Time
 	}
 
 	UserRepository interface {
+		GetByID(id uint64) (*dal.User, error)
 		GetByName(name string) (*dal.User, error)
-		Create(name, password, passwordSalt string) (*dal.User, error)
+		GetByEmail(email string) (*dal.User, error)
+		Create(name, password, passwordSalt, email string) (*dal.User, error)
+		UpdatePassword(id uint64, password, passwordSalt string) error
+		UpdateEmail(id uint64, email string) error
+	}
+
+	// InvitedUserCreator creates user using up invitation with the code hash, see dal.InvitationRepository.CreateUser
+	InvitedUserCreator interface {
+		CreateUser(codeHash, name, password, passwordSalt, email string) (*dal.User, error)
 	}
 
 	UserService struct {
-		repo UserRepository
-		log  log.TracedLogger
+		repo             UserRepository
+		invitations      InvitedUserCreator
+		registrationMode string
+		hasher           *PasswordHashers
+		log              log.TracedLogger
+
+		dummyHashOnce sync.Once
+		dummyHash     string
 	}
 )
 
-func NewUserService(repo UserRepository, log log.TracedLogger) *UserService {
+func NewUserService(
+	repo UserRepository, invitations InvitedUserCreator, registrationMode string, hasher *PasswordHashers, log log.TracedLogger,
+) *UserService {
 	return &UserService{
-		repo: repo,
-		log:  log,
+		repo:             repo,
+		invitations:      invitations,
+		registrationMode: registrationMode,
+		hasher:           hasher,
+		log:              log,
 	}
 }
 
-func (s *UserService) Create(ctx context.Context, name, password string) (*User, error) {
+// RegistrationMode tells whether anyone can sign up, only invited users can or nobody can
+func (s *UserService) RegistrationMode() string {
+	return s.registrationMode
+}
+
+// Create signs the user up, email is optional. Invitation code is required and used up if registration is
+// invite only, otherwise it is ignored.
+func (s *UserService) Create(ctx context.Context, name, password, email, invitationCode string) (*User, error) {
 	s.log.Debugw(ctx, "creating user", "name", name)
 
-	if err := validateSignup(name, password); err != nil {
+	switch s.registrationMode {
+	case RegistrationModeOpen:
+	case RegistrationModeInviteOnly:
+		if invitationCode == "" {
+			s.log.Debugw(ctx, "invitation code is missing")
+			return nil, invitationInvalidError()
+		}
+	default:
+		s.log.Debugw(ctx, "registration is closed")
+		return nil, &RenderableError{
+			Code:    ErrorCodeRegistrationClosed,
+			Message: "Registration is closed",
+		}
+	}
+
+	email = strings.TrimSpace(email)
+	if err := validateSignup(name, password, email); err != nil {
 		return nil, fmt.Errorf("validate signup: %w", err)
 	}
 
-	passwordSalt := tools.RandomAlphanumericKey(passwordSaltLength)
-	salted := saltedPassword(password, passwordSalt)
-	hashed, err := bcrypt.GenerateFromPassword([]byte(salted), bcrypt.DefaultCost)
+	hashed, err := s.hasher.Hash(password)
 	if err != nil {
 		return nil, fmt.Errorf("hash password: %w", err)
 	}
 
-	user, err := s.repo.Create(name, string(hashed), passwordSalt)
+	var user *dal.User
+	if s.registrationMode == RegistrationModeInviteOnly {
+		user, err = s.invitations.CreateUser(hashSecretToken(invitationCode), name, hashed, "", email)
+	} else {
+		user, err = s.repo.Create(name, hashed, "", email)
+	}
 	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			s.log.Debugw(ctx, "invitation is not valid")
+			return nil, invitationInvalidError()
+		}
+		if errors.Is(err, dal.ErrConflictUniqueEmail) {
+			s.log.Debugw(ctx, "user with this email already exists")
+			return nil, emailConflictError()
+		}
 		if errors.Is(err, dal.ErrConflictUnique) {
 			s.log.Debugw(ctx, "user with this name already exists")
 			return nil, &RenderableError{
@@ -76,6 +145,23 @@ func (s *UserService) Create(ctx context.Context, name, password string) (*User,
 	return toDomainUser(user), nil
 }
 
+func (s *UserService) GetByID(ctx context.Context, id uint64) (*User, error) {
+	s.log.Debugw(ctx, "get user", "id", id)
+
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("get user by id=%d: %w", id, err)
+	}
+
+	return toDomainUser(user), nil
+}
+
+// VerifyPassword returns the same error whether user does not exist or password is wrong, and takes the same time
+// to return it, so it does not reveal which user names exist
 func (s *UserService) VerifyPassword(ctx context.Context, name, password string) (*User, error) {
 	s.log.Debugw(ctx, "verifying password", "name", name)
 
@@ -83,30 +169,166 @@ func (s *UserService) VerifyPassword(ctx context.Context, name, password string)
 	if err != nil {
 		if errors.Is(err, dal.ErrNotFound) {
 			s.log.Debugw(ctx, "user not found")
-			return nil, &RenderableError{
-				Code:    ErrorCodeUserNotFound,
-				Message: "User not found",
-			}
+			s.verifyDummy(password)
+			return nil, invalidCredentialsError()
 		}
 
 		return nil, fmt.Errorf("get user by name: %w", err)
 	}
+	if user.Password == "" {
+		// user signs in with identity providers only
+		s.log.Debugw(ctx, "user has no password", "id", user.ID)
+		s.verifyDummy(password)
+		return nil, invalidCredentialsError()
+	}
+
+	ok, rehash, err := s.hasher.Verify(saltedPassword(password, user.PasswordSalt), user.Password)
+	if err != nil {
+		return nil, fmt.Errorf("verify password of user with id=%d: %w", user.ID, err)
+	}
+	if !ok {
+		s.log.Debugw(ctx, "wrong password")
+		return nil, invalidCredentialsError()
+	}
+
+	if rehash {
+		s.rehashPassword(ctx, user, password)
+	}
+	if !user.DisabledAt.IsZero() {
+		s.log.Debugw(ctx, "user is disabled", "id", user.ID)
+		return nil, ErrUserDisabled
+	}
+
+	s.log.Debugw(ctx, "password verified", "id", user.ID)
+	return toDomainUser(user), nil
+}
+
+// verifyDummy verifies password against a hash of random password, so sign in of unknown user takes as long
+// as of the existing one
+func (s *UserService) verifyDummy(password string) {
+	s.dummyHashOnce.Do(func() {
+		hash, err := s.hasher.Hash(tools.RandomAlphanumericKey(16))
+		if err == nil {
+			s.dummyHash = hash
+		}
+	})
+
+	_, _, _ = s.hasher.Verify(password, s.dummyHash)
+}
+
+// ChangePassword replaces password of the user if the current one is verified
+func (s *UserService) ChangePassword(ctx context.Context, userID uint64, currentPassword, newPassword string) error {
+	s.log.Debugw(ctx, "changing password", "id", userID)
+
+	if err := s.CheckPassword(ctx, userID, currentPassword); err != nil {
+		return err
+	}
+
+	return s.SetPassword(ctx, userID, newPassword)
+}
+
+// CheckPassword confirms sensitive action of the signed-in user, it fails for users without password
+func (s *UserService) CheckPassword(ctx context.Context, userID uint64, password string) error {
+	user, err := s.repo.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("get user by id=%d: %w", userID, err)
+	}
 
-	salted := saltedPassword(password, user.PasswordSalt)
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(salted)); err != nil {
+	ok, _, err := s.hasher.Verify(saltedPassword(password, user.PasswordSalt), user.Password)
+	if err != nil {
+		return fmt.Errorf("verify password of user with id=%d: %w", user.ID, err)
+	}
+	if !ok {
 		s.log.Debugw(ctx, "wrong password")
-		return nil, &RenderableError{
+		return &RenderableError{
 			Code:    ErrorCodeSiginWrongPassword,
 			Message: "Wrong password",
 		}
 	}
 
-	s.log.Debugw(ctx, "password verified", "id", user.ID)
-	return toDomainUser(user), nil
+	return nil
 }
 
-func validateSignup(name, password string) *RenderableError {
-	details := make(map[string]string, 2)
+// SetPassword replaces password of the user without verifying the current one, e.g. when it is reset
+func (s *UserService) SetPassword(ctx context.Context, userID uint64, password string) error {
+	if err := checkPassword(password); err != nil {
+		return &RenderableError{
+			Code:    ErrorCodePasswordBadRequest,
+			Message: "Bad request",
+			Details: map[string]string{"password": err.Error()},
+		}
+	}
+
+	hashed, err := s.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	if err = s.repo.UpdatePassword(userID, hashed, ""); err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+
+	s.log.Infow(ctx, "Password changed", "id", userID)
+	return nil
+}
+
+// ClearPassword removes password of the user, so the user can only sign in with identity providers or after password reset
+func (s *UserService) ClearPassword(ctx context.Context, userID uint64) error {
+	if err := s.repo.UpdatePassword(userID, "", ""); err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+
+	s.log.Infow(ctx, "Password cleared", "id", userID)
+	return nil
+}
+
+// UpdateEmail sets email password reset links are sent to, empty email removes it
+func (s *UserService) UpdateEmail(ctx context.Context, userID uint64, email string) (*User, error) {
+	s.log.Debugw(ctx, "updating email", "id", userID)
+
+	email = strings.TrimSpace(email)
+	if email != "" {
+		if err := checkEmail(email); err != nil {
+			return nil, &RenderableError{
+				Code:    ErrorCodeEmailBadRequest,
+				Message: "Bad request",
+				Details: map[string]string{"email": err.Error()},
+			}
+		}
+	}
+
+	if err := s.repo.UpdateEmail(userID, email); err != nil {
+		if errors.Is(err, dal.ErrConflictUniqueEmail) {
+			s.log.Debugw(ctx, "user with this email already exists")
+			return nil, emailConflictError()
+		}
+
+		return nil, fmt.Errorf("update email: %w", err)
+	}
+
+	return s.GetByID(ctx, userID)
+}
+
+// rehashPassword replaces legacy hash or hash with outdated parameters with a hash of the current hasher.
+// It is done on sign in, since it is the only time password is known.
+func (s *UserService) rehashPassword(ctx context.Context, user *dal.User, password string) {
+	hashed, err := s.hasher.Hash(password)
+	if err != nil {
+		s.log.Errorw(ctx, "Failed to rehash password", "id", user.ID, err)
+		return
+	}
+
+	if err = s.repo.UpdatePassword(user.ID, hashed, ""); err != nil {
+		// password stays verifiable with the old hash, it is rehashed on the next sign in
+		s.log.Errorw(ctx, "Failed to update rehashed password", "id", user.ID, err)
+		return
+	}
+
+	user.Password, user.PasswordSalt = hashed, ""
+	s.log.Infow(ctx, "Password rehashed", "id", user.ID)
+}
+
+func validateSignup(name, password, email string) *RenderableError {
+	details := make(map[string]string, 3)
 
 	if err := checkName(name); err != nil {
 		details["name"] = err.Error()
@@ -114,6 +336,11 @@ func validateSignup(name, password string) *RenderableError {
 	if err := checkPassword(password); err != nil {
 		details["password"] = err.Error()
 	}
+	if email != "" {
+		if err := checkEmail(email); err != nil {
+			details["email"] = err.Error()
+		}
+	}
 
 	if len(details) > 0 {
 		return &RenderableError{
@@ -126,14 +353,26 @@ func validateSignup(name, password string) *RenderableError {
 	return nil
 }
 
+func (u *User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
+}
+
+func (u *User) IsDisabled() bool {
+	return !u.DisabledAt.IsZero()
+}
+
 func toDomainUser(dalUser *dal.User) *User {
 	return &User{
-		ID:           dalUser.ID,
-		Name:         dalUser.Name,
-		Password:     dalUser.Password,
-		PasswordSalt: dalUser.PasswordSalt,
-		CreatedAt:    dalUser.CreatedAt,
-		UpdatedAt:    dalUser.UpdatedAt,
+		ID:              dalUser.ID,
+		Name:            dalUser.Name,
+		Password:        dalUser.Password,
+		PasswordSalt:    dalUser.PasswordSalt,
+		Email:           dalUser.Email,
+		Role:            dalUser.Role,
+		DisabledAt:      dalUser.DisabledAt,
+		TokenGeneration: dalUser.TokenGeneration,
+		CreatedAt:       dalUser.CreatedAt,
+		UpdatedAt:       dalUser.UpdatedAt,
 	}
 }
 
@@ -168,6 +407,19 @@ func checkName(name string) error {
 	return nil
 }
 
+func checkEmail(email string) error {
+	if len(email) > maxEmailLength {
+		return errors.New("email is too long")
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("email is not valid")
+	}
+
+	return nil
+}
+
 func checkPassword(password string) error {
 	if len(password) < 8 {
 		return errors.New("password must be at least 8 characters long")
@@ -197,6 +449,28 @@ func checkPassword(password string) error {
 	return nil
 }
 
+func invalidCredentialsError() *RenderableError {
+	return &RenderableError{
+		Code:    ErrorCodeInvalidCredentials,
+		Message: "Invalid user name or password",
+	}
+}
+
+func invitationInvalidError() *RenderableError {
+	return &RenderableError{
+		Code:    ErrorCodeInvitationInvalid,
+		Message: "Invitation code is not valid, expired or used up",
+	}
+}
+
+func emailConflictError() *RenderableError {
+	return &RenderableError{
+		Code:    ErrorCodeEmailConflict,
+		Message: "User with specified email already exists",
+	}
+}
+
+// saltedPassword returns what legacy bcrypt hashes were created from, salt is empty for current hashes
 func saltedPassword(password, passwordSalt string) string {
-	return fmt.Sprintf("%s%s", password, passwordSalt)
+	return password + passwordSalt
 }
@@ -0,0 +1,387 @@
+package handle
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+type (
+	changePasswordRequest struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	updateEmailRequest struct {
+		Email string `json:"email"`
+	}
+
+	passwordResetRequest struct {
+		Email string `json:"email"`
+	}
+
+	passwordResetConfirmRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	deleteAccountRequest struct {
+		Password string `json:"password"`
+	}
+
+	// AccountExport is account.json of export archive, clipboard contents are stored in the archive as separate files
+	AccountExport struct {
+		ExportedAtMillis int64                `json:"exported_at_millis"`
+		User             *User                `json:"user"`
+		Identities       []*UserIdentity      `json:"identities"`
+		TwoFactor        *TwoFactorStatus     `json:"two_factor"`
+		APITokens        []*APIToken          `json:"api_tokens"`
+		Logins           []*Login             `json:"logins"`
+		Sessions         []*Session           `json:"sessions"`
+		Invitations      []*Invitation        `json:"invitations"`
+		Clipboards       []*ExportedClipboard `json:"clipboards"`
+	}
+
+	ExportedClipboard struct {
+		SessionID       uint64 `json:"session_id"`
+		Version         uint64 `json:"version"`
+		UpdatedAtMillis int64  `json:"updated_at_millis"`
+		// Files are paths of representations in the archive, in the same order as content types
+		ContentTypes []string `json:"content_types"`
+		Files        []string `json:"files"`
+	}
+
+	PasswordResetService interface {
+		Request(ctx context.Context, email string) error
+		Confirm(ctx context.Context, token, password string) error
+	}
+
+	AccountService interface {
+		Delete(ctx context.Context, userID uint64, password string) error
+		Export(ctx context.Context, userID uint64) (*domain.AccountExport, error)
+		ExportClipboards(ctx context.Context, userID uint64, sessions []*domain.Session, fn func(*domain.Clipboard) error) error
+	}
+
+	// AccountHandler manages credentials of the user, deletes the account and exports its data
+	AccountHandler struct {
+		resp           *responder
+		userService    UserService
+		loginService   LoginService
+		passwordResets PasswordResetService
+		accounts       AccountService
+		auth           *AuthHandler
+		log            log.TracedLogger
+	}
+)
+
+func NewAccountHandler(
+	userService UserService, loginService LoginService, passwordResets PasswordResetService, accounts AccountService, auth *AuthHandler,
+	resp *responder, log log.TracedLogger,
+) *AccountHandler {
+	return &AccountHandler{
+		resp:           resp,
+		userService:    userService,
+		loginService:   loginService,
+		passwordResets: passwordResets,
+		accounts:       accounts,
+		auth:           auth,
+		log:            log,
+	}
+}
+
+// ChangePassword replaces password and signs the user out everywhere else, the current browser gets new tokens
+func (h *AccountHandler) ChangePassword(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	if err := h.userService.ChangePassword(ctx, auth.UserID, req.CurrentPassword, req.NewPassword); err != nil {
+		if h.sendAccountError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to change password", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	if err := h.loginService.RevokeAll(ctx, auth.UserID); err != nil {
+		h.log.Errorw(ctx, "failed to revoke logins", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	// requests authenticated with API token have no login to keep
+	if auth.TokenID != "" {
+		user, err := h.userService.GetByID(ctx, auth.UserID)
+		if err != nil {
+			h.log.Errorw(ctx, "failed to get user", err)
+			h.resp.SendInternalServerError(ctx, rw)
+			return
+		}
+		if !h.auth.setTokens(rw, r, user, nil) {
+			return
+		}
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) UpdateEmail(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	var req updateEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	user, err := h.userService.UpdateEmail(ctx, auth.UserID, req.Email)
+	if err != nil {
+		if h.sendAccountError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to update email", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, userToDTO(user))
+}
+
+// RequestPasswordReset responds with 202 whether the email is registered or not
+func (h *AccountHandler) RequestPasswordReset(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req passwordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	if err := h.passwordResets.Request(ctx, req.Email); err != nil {
+		h.log.Errorw(ctx, "failed to request password reset", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AccountHandler) ConfirmPasswordReset(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req passwordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	if err := h.passwordResets.Confirm(ctx, req.Token, req.Password); err != nil {
+		if h.sendAccountError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to confirm password reset", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Delete deletes account of the user after password is verified and signs the browser out
+func (h *AccountHandler) Delete(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	if err := h.accounts.Delete(ctx, auth.UserID, req.Password); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			h.log.Debugw(ctx, "user not found")
+			h.resp.SendNotFound(ctx, rw, "User not found")
+			return
+		}
+		if h.sendAccountError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to delete account", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Infow(ctx, "Account deleted", "userID", auth.UserID)
+	http.SetCookie(rw, h.auth.cookieProcessor.ExpireAccessToken())
+	http.SetCookie(rw, h.auth.cookieProcessor.ExpireRefreshToken())
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Export streams zip archive with account.json holding everything stored about the user
+// and clipboard versions the user authored as separate files
+func (h *AccountHandler) Export(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	export, err := h.accounts.Export(ctx, auth.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			h.log.Debugw(ctx, "user not found")
+			h.resp.SendNotFound(ctx, rw, "User not found")
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to export account", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	rw.Header().Set(ContentTypeHeader, "application/zip")
+	rw.Header().Set(ContentDispositionHeader, mime.FormatMediaType("attachment", map[string]string{"filename": "clipboard-share-export.zip"}))
+	rw.Header().Set(CacheControlHeader, "no-store")
+	rw.WriteHeader(http.StatusOK)
+
+	// response is already started, so failure can only be logged, client gets truncated archive
+	if err = h.writeExport(ctx, zip.NewWriter(rw), auth.UserID, export); err != nil {
+		h.log.Errorw(ctx, "failed to write account export", err)
+	}
+}
+
+func (h *AccountHandler) writeExport(ctx context.Context, archive *zip.Writer, userID uint64, export *domain.AccountExport) error {
+	res := toAccountExportDTO(export)
+
+	err := h.accounts.ExportClipboards(ctx, userID, export.Sessions, func(c *domain.Clipboard) error {
+		exported := &ExportedClipboard{
+			SessionID:       c.SessionID,
+			Version:         c.Version,
+			UpdatedAtMillis: c.UpdatedAt.UnixMilli(),
+			ContentTypes:    make([]string, 0, len(c.Representations)),
+			Files:           make([]string, 0, len(c.Representations)),
+		}
+		for i, representation := range c.Representations {
+			mediaType, _, err := mime.ParseMediaType(representation.ContentType)
+			if err != nil {
+				mediaType = "application/octet-stream"
+			}
+
+			name := fmt.Sprintf("clipboards/%d/%d/%d%s", c.SessionID, c.Version, i, clipboardFileExtension(mediaType))
+			w, err := archive.Create(name)
+			if err != nil {
+				return fmt.Errorf("create %s: %w", name, err)
+			}
+			if _, err = w.Write(representation.Content); err != nil {
+				return fmt.Errorf("write %s: %w", name, err)
+			}
+
+			exported.ContentTypes = append(exported.ContentTypes, representation.ContentType)
+			exported.Files = append(exported.Files, name)
+		}
+		res.Clipboards = append(res.Clipboards, exported)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("export clipboards: %w", err)
+	}
+
+	w, err := archive.Create("account.json")
+	if err != nil {
+		return fmt.Errorf("create account.json: %w", err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(res); err != nil {
+		return fmt.Errorf("write account.json: %w", err)
+	}
+
+	return archive.Close()
+}
+
+func (h *AccountHandler) sendAccountError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	var re *domain.RenderableError
+	if !errors.As(err, &re) {
+		return false
+	}
+
+	h.log.Debugw(ctx, "account request rejected", err)
+	h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+	return true
+}
+
+func toAccountExportDTO(export *domain.AccountExport) *AccountExport {
+	res := &AccountExport{
+		ExportedAtMillis: time.Now().UnixMilli(),
+		User:             userToDTO(export.User),
+		Identities:       make([]*UserIdentity, 0, len(export.Identities)),
+		TwoFactor: &TwoFactorStatus{
+			Enabled:           export.TwoFactor.Enabled,
+			RecoveryCodesLeft: export.TwoFactor.RecoveryCodesLeft,
+		},
+		APITokens:   make([]*APIToken, 0, len(export.APITokens)),
+		Logins:      make([]*Login, 0, len(export.Logins)),
+		Sessions:    make([]*Session, 0, len(export.Sessions)),
+		Invitations: make([]*Invitation, 0, len(export.Invitations)),
+		Clipboards:  make([]*ExportedClipboard, 0),
+	}
+	for _, i := range export.Identities {
+		res.Identities = append(res.Identities, &UserIdentity{
+			ID:              i.ID,
+			Provider:        i.Provider,
+			Email:           i.Email,
+			CreatedAtMillis: i.CreatedAt.UnixMilli(),
+		})
+	}
+	for _, t := range export.APITokens {
+		res.APITokens = append(res.APITokens, toAPITokenDTO(t))
+	}
+	for _, l := range export.Logins {
+		res.Logins = append(res.Logins, toLoginDTO(l))
+	}
+	for _, s := range export.Sessions {
+		res.Sessions = append(res.Sessions, toDTO(s))
+	}
+	for _, i := range export.Invitations {
+		res.Invitations = append(res.Invitations, toInvitationDTO(i))
+	}
+	return res
+}
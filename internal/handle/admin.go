@@ -0,0 +1,270 @@
+package handle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const defaultUsersLimit = 20
+
+type (
+	AdminUser struct {
+		ID    uint64 `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email,omitempty"`
+		Role  string `json:"role"`
+		// DisabledAtMillis is omitted if user is not disabled
+		DisabledAtMillis int64 `json:"disabled_at_millis,omitempty"`
+		CreatedAtMillis  int64 `json:"created_at_millis"`
+		UpdatedAtMillis  int64 `json:"updated_at_millis"`
+	}
+
+	UserUsage struct {
+		Sessions   int   `json:"sessions"`
+		Clipboards int   `json:"clipboards"`
+		Bytes      int64 `json:"bytes"`
+	}
+
+	ForcedPasswordReset struct {
+		EmailSent bool `json:"email_sent"`
+		// URL is reset link admin has to pass to the user, it is only returned if the user has no email
+		URL string `json:"url,omitempty"`
+	}
+
+	AdminService interface {
+		IsAdmin(ctx context.Context, userID uint64) (bool, error)
+		Search(ctx context.Context, query string, limit, offset int) ([]*domain.User, int, error)
+		SetDisabled(ctx context.Context, adminID, userID uint64, disabled bool) (*domain.User, error)
+		ForcePasswordReset(ctx context.Context, adminID, userID uint64) (*domain.ForcedPasswordReset, error)
+		Delete(ctx context.Context, adminID, userID uint64) error
+		GetUsage(ctx context.Context, userID uint64) (*domain.UserUsage, error)
+	}
+
+	// AdminHandler serves /v1/admin routes, all of them have to be behind Handle
+	AdminHandler struct {
+		resp    *responder
+		service AdminService
+		log     log.TracedLogger
+	}
+)
+
+func NewAdminHandler(service AdminService, resp *responder, log log.TracedLogger) *AdminHandler {
+	return &AdminHandler{
+		resp:    resp,
+		service: service,
+		log:     log,
+	}
+}
+
+// Handle lets only admins through. Role is checked on every request, so revoking it takes effect immediately.
+func (h *AdminHandler) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+		if !ok {
+			return
+		}
+
+		admin, err := h.service.IsAdmin(ctx, auth.UserID)
+		if err != nil {
+			h.log.Errorw(ctx, "failed to check admin role", err)
+			h.resp.SendInternalServerError(ctx, rw)
+			return
+		}
+		if !admin {
+			h.log.Debugw(ctx, "user is not admin", "userID", auth.UserID)
+			h.resp.SendForbidden(ctx, rw, "Admin role is required")
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// SearchUsers returns page of users whose name or email contains query parameter, all users if it is empty
+func (h *AdminHandler) SearchUsers(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, offset, ok := h.resp.parsePagination(rw, r, defaultUsersLimit)
+	if !ok {
+		return
+	}
+
+	users, total, err := h.service.Search(ctx, r.URL.Query().Get("query"), limit, offset)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to search users", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	res := make([]*AdminUser, 0, len(users))
+	for _, u := range users {
+		res = append(res, toAdminUserDTO(u))
+	}
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &paginatedResponse{
+		Items:      res,
+		TotalItems: total,
+	})
+}
+
+func (h *AdminHandler) DisableUser(rw http.ResponseWriter, r *http.Request) {
+	h.setDisabled(rw, r, true)
+}
+
+func (h *AdminHandler) EnableUser(rw http.ResponseWriter, r *http.Request) {
+	h.setDisabled(rw, r, false)
+}
+
+// ForcePasswordReset removes password of the user, signs the user out everywhere and sends reset link
+func (h *AdminHandler) ForcePasswordReset(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	adminID, userID, ok := h.userIDs(rw, r)
+	if !ok {
+		return
+	}
+
+	reset, err := h.service.ForcePasswordReset(ctx, adminID, userID)
+	if err != nil {
+		if h.sendAdminError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to force password reset", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	rw.Header().Set(CacheControlHeader, "no-store")
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &ForcedPasswordReset{
+		EmailSent: reset.EmailSent,
+		URL:       reset.URL,
+	})
+}
+
+// DeleteUser deletes the user with sessions only the user owns and their clipboards
+func (h *AdminHandler) DeleteUser(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	adminID, userID, ok := h.userIDs(rw, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(ctx, adminID, userID); err != nil {
+		if h.sendAdminError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to delete user", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) GetUsage(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	_, userID, ok := h.userIDs(rw, r)
+	if !ok {
+		return
+	}
+
+	usage, err := h.service.GetUsage(ctx, userID)
+	if err != nil {
+		if h.sendAdminError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to get user usage", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &UserUsage{
+		Sessions:   usage.Sessions,
+		Clipboards: usage.Clipboards,
+		Bytes:      usage.Bytes,
+	})
+}
+
+func (h *AdminHandler) setDisabled(rw http.ResponseWriter, r *http.Request, disabled bool) {
+	ctx := r.Context()
+
+	adminID, userID, ok := h.userIDs(rw, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.service.SetDisabled(ctx, adminID, userID, disabled)
+	if err != nil {
+		if h.sendAdminError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to set user disabled", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, toAdminUserDTO(user))
+}
+
+// userIDs returns id of the admin and id of the user from userID path parameter
+func (h *AdminHandler) userIDs(rw http.ResponseWriter, r *http.Request) (uint64, uint64, bool) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return 0, 0, false
+	}
+
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse userID", err)
+		h.resp.SendBadRequest(ctx, rw, "userID param must be a valid uint64 value")
+		return 0, 0, false
+	}
+
+	return auth.UserID, userID, true
+}
+
+func (h *AdminHandler) sendAdminError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	var re *domain.RenderableError
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		h.resp.SendNotFound(ctx, rw, "User not found")
+	case errors.As(err, &re):
+		h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+	default:
+		return false
+	}
+
+	h.log.Debugw(ctx, "admin request rejected", err)
+	return true
+}
+
+func toAdminUserDTO(user *domain.User) *AdminUser {
+	res := &AdminUser{
+		ID:              user.ID,
+		Name:            user.Name,
+		Email:           user.Email,
+		Role:            user.Role,
+		CreatedAtMillis: user.CreatedAt.UnixMilli(),
+		UpdatedAtMillis: user.UpdatedAt.UnixMilli(),
+	}
+	if user.IsDisabled() {
+		res.DisabledAtMillis = user.DisabledAt.UnixMilli()
+	}
+	return res
+}
@@ -0,0 +1,177 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+type (
+	apiTokenRequest struct {
+		Name         string   `json:"name"`
+		SessionIDs   []uint64 `json:"session_ids,omitempty"`
+		Permissions  []string `json:"permissions,omitempty"`
+		ExpireInDays int      `json:"expire_in_days,omitempty"`
+	}
+
+	APIToken struct {
+		TokenID uint64 `json:"token_id"`
+		Name    string `json:"name"`
+		// Token is only returned when it is created
+		Token string `json:"token,omitempty"`
+		// SessionIDs and Permissions are omitted if token is not restricted by them
+		SessionIDs       []uint64 `json:"session_ids,omitempty"`
+		Permissions      []string `json:"permissions,omitempty"`
+		ExpiresAtMillis  int64    `json:"expires_at_millis,omitempty"`
+		LastUsedAtMillis int64    `json:"last_used_at_millis,omitempty"`
+		CreatedAtMillis  int64    `json:"created_at_millis"`
+	}
+
+	APITokenService interface {
+		APITokenAuthenticator
+		Create(ctx context.Context, userID uint64, name string, sessionIDs []uint64, permissions []string, expireIn time.Duration) (*domain.APIToken, error)
+		GetAll(ctx context.Context, userID uint64) ([]*domain.APIToken, error)
+		Revoke(ctx context.Context, userID, tokenID uint64) error
+	}
+
+	APITokenHandler struct {
+		resp    *responder
+		service APITokenService
+		log     log.TracedLogger
+	}
+)
+
+func NewAPITokenHandler(service APITokenService, resp *responder, log log.TracedLogger) *APITokenHandler {
+	return &APITokenHandler{
+		resp:    resp,
+		service: service,
+		log:     log,
+	}
+}
+
+func (h *APITokenHandler) Create(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	var req apiTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	token, err := h.service.Create(ctx, auth.UserID, req.Name, req.SessionIDs, req.Permissions, time.Duration(req.ExpireInDays)*24*time.Hour)
+	if err != nil {
+		if h.sendAPITokenError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to create api token", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Created api token", "tokenID", token.ID)
+	h.resp.Send(ctx, rw, http.StatusCreated, nil, toAPITokenDTO(token))
+}
+
+func (h *APITokenHandler) GetAll(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.service.GetAll(ctx, auth.UserID)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to get api tokens", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Got api tokens", "count", len(tokens))
+	res := make([]*APIToken, 0, len(tokens))
+	for _, t := range tokens {
+		res = append(res, toAPITokenDTO(t))
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &paginatedResponse{
+		Items:      res,
+		TotalItems: len(res),
+	})
+}
+
+func (h *APITokenHandler) Revoke(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse tokenID", err)
+		h.resp.SendBadRequest(ctx, rw, "tokenID param must be a valid uint64 value")
+		return
+	}
+
+	if err = h.service.Revoke(ctx, auth.UserID, tokenID); err != nil {
+		if h.sendAPITokenError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to revoke api token", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Revoked api token", "tokenID", tokenID)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APITokenHandler) sendAPITokenError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	var re *domain.RenderableError
+	switch {
+	case errors.Is(err, domain.ErrAPITokenNotFound):
+		h.resp.SendNotFound(ctx, rw, "API token not found")
+	case errors.As(err, &re):
+		h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+	default:
+		return false
+	}
+
+	h.log.Debugw(ctx, "api token request rejected", err)
+	return true
+}
+
+func toAPITokenDTO(token *domain.APIToken) *APIToken {
+	res := &APIToken{
+		TokenID:         token.ID,
+		Name:            token.Name,
+		Token:           token.Token,
+		SessionIDs:      token.SessionIDs,
+		Permissions:     token.Permissions,
+		CreatedAtMillis: token.CreatedAt.UnixMilli(),
+	}
+	if !token.ExpiresAt.IsZero() {
+		res.ExpiresAtMillis = token.ExpiresAt.UnixMilli()
+	}
+	if !token.LastUsedAt.IsZero() {
+		res.LastUsedAtMillis = token.LastUsedAt.UnixMilli()
+	}
+	return res
+}
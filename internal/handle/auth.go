@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,19 +19,50 @@ type (
 	User struct {
 		ID              uint64 `json:"id"`
 		Name            string `json:"name"`
+		Email           string `json:"email,omitempty"`
+		Role            string `json:"role"`
 		CreatedAtMillis int64  `json:"created_at_millis"`
 		UpdatedAtMillis int64  `json:"updated_at_millis"`
 	}
 
+	// Registration tells web app how users sign up
+	Registration struct {
+		// Mode is "open", "invite_only" or "closed"
+		Mode string `json:"mode"`
+	}
+
 	UserService interface {
-		Create(ctx context.Context, name, password string) (*domain.User, error)
+		RegistrationMode() string
+		Create(ctx context.Context, name, password, email, invitationCode string) (*domain.User, error)
+		GetByID(ctx context.Context, id uint64) (*domain.User, error)
 		VerifyPassword(ctx context.Context, name, password string) (*domain.User, error)
+		ChangePassword(ctx context.Context, userID uint64, currentPassword, newPassword string) error
+		UpdateEmail(ctx context.Context, userID uint64, email string) (*domain.User, error)
 	}
 
 	CookieProcessor interface {
-		ToAccessToken(id uint64, name string) (*http.Cookie, error)
+		ToAccessToken(id uint64, name string, generation uint64) (*http.Cookie, *jwt.RegisteredClaims, error)
 		ExpireAccessToken() *http.Cookie
 		AccessTokenFromRequest(r *http.Request) (*jwt.Token, error)
+		ToRefreshToken(token string, expiresAt time.Time) *http.Cookie
+		ExpireRefreshToken() *http.Cookie
+		RefreshTokenFromRequest(r *http.Request) (string, error)
+		ToOIDCState(state string, expiresAt time.Time) *http.Cookie
+		ExpireOIDCState() *http.Cookie
+		OIDCStateFromRequest(r *http.Request) (string, error)
+		ToCSRFToken(token string) *http.Cookie
+		CSRFTokenFromRequest(r *http.Request) (string, error)
+	}
+
+	RefreshTokenService interface {
+		Issue(ctx context.Context, userID uint64) (*domain.RefreshToken, error)
+		Rotate(ctx context.Context, token string) (*domain.User, *domain.RefreshToken, error)
+		Revoke(ctx context.Context, token string) error
+	}
+
+	SignInLimiter interface {
+		Attempt(ctx context.Context, name, ip string) (*domain.SignInAttempt, error)
+		Succeed(ctx context.Context, name, ip string) error
 	}
 
 	JTIService interface {
@@ -41,9 +73,13 @@ type (
 	AuthHandler struct {
 		resp *responder
 
-		userService     UserService
-		cookieProcessor CookieProcessor
-		jtiService      JTIService
+		userService         UserService
+		cookieProcessor     CookieProcessor
+		jtiService          JTIService
+		refreshTokenService RefreshTokenService
+		loginService        LoginService
+		twoFactor           TwoFactorAuthenticator
+		signInLimiter       SignInLimiter
 
 		log log.TracedLogger
 	}
@@ -52,17 +88,43 @@ type (
 		Name     string `json:"name"`
 		Password string `json:"password"`
 	}
+
+	signUpRequest struct {
+		namePasswordRequest
+		// Email is optional, it is needed to reset forgotten password
+		Email string `json:"email"`
+		// InvitationCode is required if registration is invite only
+		InvitationCode string `json:"invitation_code"`
+	}
+
+	// TwoFactorChallenge is returned by sign in instead of user if sign in has to be completed with a code
+	TwoFactorChallenge struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		Challenge         string `json:"challenge"`
+		ExpiresAtMillis   int64  `json:"expires_at_millis"`
+	}
+
+	twoFactorSignInRequest struct {
+		Challenge string `json:"challenge"`
+		// Code is TOTP code or recovery code
+		Code string `json:"code"`
+	}
 )
 
 func NewAuthHandler(
-	userService UserService, cookieProcessor CookieProcessor, jwtRepository JTIService, resp *responder, log log.TracedLogger,
+	userService UserService, cookieProcessor CookieProcessor, jwtRepository JTIService, refreshTokenService RefreshTokenService,
+	loginService LoginService, twoFactor TwoFactorAuthenticator, signInLimiter SignInLimiter, resp *responder, log log.TracedLogger,
 ) *AuthHandler {
 	return &AuthHandler{
 		resp: resp,
 
-		userService:     userService,
-		cookieProcessor: cookieProcessor,
-		jtiService:      jwtRepository,
+		userService:         userService,
+		cookieProcessor:     cookieProcessor,
+		jtiService:          jwtRepository,
+		refreshTokenService: refreshTokenService,
+		loginService:        loginService,
+		twoFactor:           twoFactor,
+		signInLimiter:       signInLimiter,
 
 		log: log,
 	}
@@ -71,7 +133,7 @@ func NewAuthHandler(
 func (h *AuthHandler) SignUp(rw http.ResponseWriter, r *http.Request) {
 	var (
 		ctx = r.Context()
-		req namePasswordRequest
+		req signUpRequest
 		err error
 	)
 
@@ -81,12 +143,17 @@ func (h *AuthHandler) SignUp(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userService.Create(ctx, req.Name, req.Password)
+	user, err := h.userService.Create(ctx, req.Name, req.Password, req.Email, req.InvitationCode)
 	if err != nil {
 		var re *domain.RenderableError
 		if errors.As(err, &re) {
 			h.log.Infow(ctx, "failed to create user", err)
-			h.resp.SendError(ctx, rw, http.StatusConflict, re.Code.Value, re.Message, re.Details)
+			// validation and conflict errors have always been sent with 409, so clients relying on it keep working
+			status := http.StatusConflict
+			if re.Code == domain.ErrorCodeRegistrationClosed || re.Code == domain.ErrorCodeInvitationInvalid {
+				status = re.Code.StatusCode
+			}
+			h.resp.SendError(ctx, rw, status, re.Code.Value, re.Message, re.Details)
 			return
 		}
 
@@ -95,17 +162,18 @@ func (h *AuthHandler) SignUp(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userCookie, err := h.cookieProcessor.ToAccessToken(user.ID, user.Name)
-	if err != nil {
-		h.log.Errorw(ctx, "failed to create cookie", err)
-		h.resp.SendInternalServerError(ctx, rw)
+	if !h.setTokens(rw, r, user, nil) {
 		return
 	}
-	http.SetCookie(rw, userCookie)
 
 	h.resp.Send(ctx, rw, http.StatusCreated, nil, userToDTO(user))
 }
 
+// GetRegistration tells whether anyone can sign up, only users with invitation code can or nobody can
+func (h *AuthHandler) GetRegistration(rw http.ResponseWriter, r *http.Request) {
+	h.resp.Send(r.Context(), rw, http.StatusOK, nil, &Registration{Mode: h.userService.RegistrationMode()})
+}
+
 func (h *AuthHandler) SignIn(rw http.ResponseWriter, r *http.Request) {
 	var (
 		ctx = r.Context()
@@ -119,11 +187,23 @@ func (h *AuthHandler) SignIn(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	attempt, ok := h.resp.attemptSignIn(ctx, rw, h.signInLimiter, req.Name, ip)
+	if !ok {
+		return
+	}
+
 	user, err := h.userService.VerifyPassword(ctx, req.Name, req.Password)
 	if err != nil {
+		if errors.Is(err, domain.ErrUserDisabled) {
+			h.log.Debugw(ctx, "user is disabled")
+			h.resp.SendError(ctx, rw, domain.ErrorCodeUserDisabled.StatusCode, domain.ErrorCodeUserDisabled.Value, "Account is disabled", nil)
+			return
+		}
 		var re *domain.RenderableError
 		if errors.As(err, &re) {
 			h.log.Debugw(ctx, "failed to verify password", err)
+			setRetryAfter(rw, attempt)
 			h.resp.SendError(ctx, rw, http.StatusUnauthorized, re.Code.Value, re.Message, re.Details)
 			return
 		}
@@ -133,13 +213,145 @@ func (h *AuthHandler) SignIn(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userCookie, err := h.cookieProcessor.ToAccessToken(user.ID, user.Name)
+	twoFactorEnabled, err := h.twoFactor.IsEnabled(ctx, user.ID)
 	if err != nil {
-		h.log.Errorw(ctx, "failed to create cookie", err)
+		h.log.Errorw(ctx, "failed to check two factor", err)
 		h.resp.SendInternalServerError(ctx, rw)
 		return
 	}
-	http.SetCookie(rw, userCookie)
+	if twoFactorEnabled {
+		challenge, err := h.twoFactor.CreateChallenge(ctx, user.ID)
+		if err != nil {
+			h.log.Errorw(ctx, "failed to create two factor challenge", err)
+			h.resp.SendInternalServerError(ctx, rw)
+			return
+		}
+
+		// failed attempts are kept until the code is verified, so codes can not be guessed with the known password
+		h.log.Debugw(ctx, "two factor required", "userID", user.ID)
+		h.resp.Send(ctx, rw, http.StatusAccepted, map[string][]string{CacheControlHeader: {"no-store"}}, &TwoFactorChallenge{
+			TwoFactorRequired: true,
+			Challenge:         challenge.Token,
+			ExpiresAtMillis:   challenge.ExpiresAt.UnixMilli(),
+		})
+		return
+	}
+
+	if !h.setTokens(rw, r, user, nil) {
+		return
+	}
+	if err = h.signInLimiter.Succeed(ctx, user.Name, ip); err != nil {
+		h.log.Errorw(ctx, "failed to reset failed sign ins", err)
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, userToDTO(user))
+}
+
+// SignInTwoFactor completes sign in challenged by SignIn with TOTP code or recovery code
+func (h *AuthHandler) SignInTwoFactor(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		req twoFactorSignInRequest
+		err error
+	)
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode request", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	userID, err := h.twoFactor.ChallengeUserID(ctx, req.Challenge)
+	if err != nil {
+		if h.sendTwoFactorChallengeError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to get two factor challenge", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	user, err := h.userService.GetByID(ctx, userID)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to get user", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	// codes are limited together with passwords, otherwise every new challenge would give more attempts to guess
+	ip := clientIP(r)
+	attempt, ok := h.resp.attemptSignIn(ctx, rw, h.signInLimiter, user.Name, ip)
+	if !ok {
+		return
+	}
+
+	if _, err = h.twoFactor.CompleteChallenge(ctx, req.Challenge, req.Code); err != nil {
+		var re *domain.RenderableError
+		if errors.As(err, &re) {
+			h.log.Debugw(ctx, "failed to verify two factor code", err)
+			setRetryAfter(rw, attempt)
+			h.resp.SendError(ctx, rw, http.StatusUnauthorized, re.Code.Value, re.Message, re.Details)
+			return
+		}
+		if h.sendTwoFactorChallengeError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to complete two factor challenge", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	if !h.setTokens(rw, r, user, nil) {
+		return
+	}
+	if err = h.signInLimiter.Succeed(ctx, user.Name, ip); err != nil {
+		h.log.Errorw(ctx, "failed to reset failed sign ins", err)
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, userToDTO(user))
+}
+
+func (h *AuthHandler) sendTwoFactorChallengeError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	if !errors.Is(err, domain.ErrTwoFactorChallengeNotFound) {
+		return false
+	}
+
+	h.log.Debugw(ctx, "two factor challenge not found")
+	h.resp.SendError(ctx, rw, http.StatusUnauthorized, domain.ErrorCodeUnauthorized.Value, "Sign in challenge is not valid or expired, sign in again", nil)
+	return true
+}
+
+// Refresh exchanges refresh token cookie for a new access token and a new refresh token
+func (h *AuthHandler) Refresh(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	h.log.Debugw(ctx, "refreshing tokens")
+
+	token, err := h.cookieProcessor.RefreshTokenFromRequest(r)
+	if err != nil {
+		h.log.Debugw(ctx, "refresh token cookie not found")
+		h.resp.SendError(ctx, rw, http.StatusUnauthorized, domain.ErrorCodeUnauthorized.Value, "Refresh token is missing", nil)
+		return
+	}
+
+	user, refreshToken, err := h.refreshTokenService.Rotate(ctx, token)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidRefreshToken) {
+			h.log.Debugw(ctx, "refresh token is not valid")
+			http.SetCookie(rw, h.cookieProcessor.ExpireRefreshToken())
+			h.resp.SendError(ctx, rw, http.StatusUnauthorized, domain.ErrorCodeUnauthorized.Value, "Refresh token is not valid or expired", nil)
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to rotate refresh token", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	if !h.setTokens(rw, r, user, refreshToken) {
+		return
+	}
 
 	h.resp.Send(ctx, rw, http.StatusOK, nil, userToDTO(user))
 }
@@ -150,6 +362,13 @@ func (h *AuthHandler) SignOut(rw http.ResponseWriter, r *http.Request) {
 	)
 	h.log.Debugw(ctx, "signing out")
 
+	if refreshToken, err := h.cookieProcessor.RefreshTokenFromRequest(r); err == nil {
+		if err = h.refreshTokenService.Revoke(ctx, refreshToken); err != nil {
+			h.log.Errorw(ctx, "failed to revoke refresh token", err)
+		}
+	}
+	http.SetCookie(rw, h.cookieProcessor.ExpireRefreshToken())
+
 	token, err := h.cookieProcessor.AccessTokenFromRequest(r)
 	if err != nil {
 		if errors.Is(err, cookie.ErrAccessTokenNotFound) {
@@ -190,10 +409,84 @@ func (h *AuthHandler) SignOut(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusNoContent)
 }
 
+// setTokens sets access token cookie and refresh token cookie. If refresh token is nil, it issues a new one
+// and records a new login, otherwise the login of refresh token is updated with the new access token.
+func (h *AuthHandler) setTokens(rw http.ResponseWriter, r *http.Request, user *domain.User, refreshToken *domain.RefreshToken) bool {
+	ctx := r.Context()
+
+	userCookie, claims, err := h.cookieProcessor.ToAccessToken(user.ID, user.Name, user.TokenGeneration)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to create cookie", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return false
+	}
+
+	signIn := refreshToken == nil
+	if signIn {
+		if refreshToken, err = h.refreshTokenService.Issue(ctx, user.ID); err != nil {
+			h.log.Errorw(ctx, "failed to issue refresh token", err)
+			h.resp.SendInternalServerError(ctx, rw)
+			return false
+		}
+	}
+
+	tokens := domain.LoginTokens{
+		FamilyID:        refreshToken.FamilyID,
+		AccessTokenID:   claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+	}
+	if signIn {
+		if _, err = h.loginService.Create(ctx, user.ID, tokens, r.UserAgent(), clientIP(r)); err != nil {
+			h.log.Errorw(ctx, "failed to create login", err)
+			h.resp.SendInternalServerError(ctx, rw)
+			return false
+		}
+	} else if err = h.loginService.Touch(ctx, tokens, clientIP(r)); err != nil {
+		// failing to track the login must not fail the refresh
+		h.log.Errorw(ctx, "failed to touch login", err)
+	}
+
+	http.SetCookie(rw, userCookie)
+	http.SetCookie(rw, h.cookieProcessor.ToRefreshToken(refreshToken.Token, refreshToken.ExpiresAt))
+	return true
+}
+
+// attemptSignIn counts sign in attempt with the limiter, it responds with error if sign in is locked or limiter failed
+func (r *responder) attemptSignIn(ctx context.Context, rw http.ResponseWriter, limiter SignInLimiter, name, ip string) (*domain.SignInAttempt, bool) {
+	attempt, err := limiter.Attempt(ctx, name, ip)
+	if err != nil {
+		r.log.Errorw(ctx, "failed to count sign in attempt", err)
+		r.SendInternalServerError(ctx, rw)
+		return nil, false
+	}
+	if attempt.LockedFor > 0 {
+		r.log.Debugw(ctx, "sign in is locked", "lockout", attempt.LockedFor)
+		rw.Header().Set(RetryAfterHeader, retryAfter(attempt.LockedFor))
+		r.SendError(ctx, rw, domain.ErrorCodeSignInLocked.StatusCode, domain.ErrorCodeSignInLocked.Value, "Too many failed sign in attempts, try again later", nil)
+		return nil, false
+	}
+
+	return attempt, true
+}
+
+// setRetryAfter tells the client when it can try again if the failed attempt locked sign in
+func setRetryAfter(rw http.ResponseWriter, attempt *domain.SignInAttempt) {
+	if attempt.LockoutOnFailure > 0 {
+		rw.Header().Set(RetryAfterHeader, retryAfter(attempt.LockoutOnFailure))
+	}
+}
+
+// retryAfter formats duration as Retry-After seconds, rounded up so retrying at that time is not locked anymore
+func retryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
 func userToDTO(user *domain.User) *User {
 	return &User{
 		ID:              user.ID,
 		Name:            user.Name,
+		Email:           user.Email,
+		Role:            user.Role,
 		CreatedAtMillis: user.CreatedAt.UnixMilli(),
 		UpdatedAtMillis: user.UpdatedAt.UnixMilli(),
 	}
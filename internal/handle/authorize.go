@@ -0,0 +1,103 @@
+package handle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	ac "github.com/Roma7-7-7/shared-clipboard/internal/context"
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+type sessionAuthorizer struct {
+	service SessionService
+	resp    *responder
+	log     log.TracedLogger
+}
+
+// authorize checks that authenticated user has required role in the session and responds with error otherwise
+func (a *sessionAuthorizer) authorize(rw http.ResponseWriter, r *http.Request, sessionID uint64, role string) (*ac.Authority, bool) {
+	ctx := r.Context()
+
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		a.log.Debugw(ctx, "user not found in context")
+		a.resp.SendUnauthorized(ctx, rw)
+		return nil, false
+	}
+
+	if _, err := a.service.Authorize(ctx, auth.UserID, sessionID, role); err != nil {
+		if a.resp.sendSessionAccessError(ctx, rw, err) {
+			a.log.Debugw(ctx, "session access denied", "sessionID", sessionID, "role", role, err)
+			return nil, false
+		}
+
+		a.log.Errorw(ctx, "failed to authorize session access", err)
+		a.resp.SendInternalServerError(ctx, rw)
+		return nil, false
+	}
+
+	return auth, true
+}
+
+// sendSessionAccessError responds with 404 if session does not exist and 403 if user lacks permissions for it.
+// It returns false if err is not related to session access.
+func (r *responder) sendSessionAccessError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, domain.ErrSessionNotFound):
+		r.SendNotFound(ctx, rw, "Session with provided ID not found")
+	case errors.Is(err, domain.ErrSessionPermissionDenied):
+		r.SendForbidden(ctx, rw, "Not enough permissions for the session")
+	default:
+		return false
+	}
+	return true
+}
+
+// checkScope responds with 403 if request was authenticated with API token which scope does not cover the session
+// or lacks the permission, empty permission is granted by any scope. Requests authenticated otherwise are not restricted.
+func (r *responder) checkScope(ctx context.Context, rw http.ResponseWriter, sessionID uint64, permission string) bool {
+	scope, ok := ac.ScopeFrom(ctx)
+	if !ok || scope.AllowsSession(sessionID) && scope.Allows(permission) {
+		return true
+	}
+
+	r.log.Debugw(ctx, "request is out of api token scope", "sessionID", sessionID, "permission", permission)
+	r.SendForbidden(ctx, rw, "API token scope does not allow the request")
+	return false
+}
+
+// checkUnboundScope is checkScope for requests not bound to a single session, which requires scope not restricted to specific sessions
+func (r *responder) checkUnboundScope(ctx context.Context, rw http.ResponseWriter, permission string) bool {
+	scope, ok := ac.ScopeFrom(ctx)
+	if !ok || scope.AllowsAllSessions() && scope.Allows(permission) {
+		return true
+	}
+
+	r.log.Debugw(ctx, "request is out of api token scope", "permission", permission)
+	r.SendForbidden(ctx, rw, "API token scope does not allow the request")
+	return false
+}
+
+// unrestrictedAuthority returns authenticated user if request is not restricted by API token scope.
+// Account management is not allowed for restricted scopes, otherwise they could e.g. mint a token without restrictions.
+func (r *responder) unrestrictedAuthority(ctx context.Context, rw http.ResponseWriter) (*ac.Authority, bool) {
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		r.log.Debugw(ctx, "user not found in context")
+		r.SendUnauthorized(ctx, rw)
+		return nil, false
+	}
+	if scope, ok := ac.ScopeFrom(ctx); ok && scope.IsRestricted() {
+		r.log.Debugw(ctx, "account management is not allowed for restricted scope")
+		r.SendForbidden(ctx, rw, "API token scope does not allow managing the account")
+		return nil, false
+	}
+
+	return auth, true
+}
+
+func isSessionAccessError(err error) bool {
+	return errors.Is(err, domain.ErrSessionNotFound) || errors.Is(err, domain.ErrSessionPermissionDenied)
+}
@@ -0,0 +1,111 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	ac "github.com/Roma7-7-7/shared-clipboard/internal/context"
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+// fakeSessionService only implements Authorize, other methods panic
+type fakeSessionService struct {
+	SessionService
+	err error
+}
+
+func (s *fakeSessionService) Authorize(context.Context, uint64, uint64, string) (*domain.Session, error) {
+	return &domain.Session{}, s.err
+}
+
+func newTestResponder() *responder {
+	return &responder{log: log.NewZapTracedLogger(zap.NewNop().Sugar())}
+}
+
+func TestSendSessionAccessError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		handled    bool
+		wantStatus int
+		wantCode   string
+	}{
+		{"not found", fmt.Errorf("authorize: %w", domain.ErrSessionNotFound), true, http.StatusNotFound, domain.ErrorCodeNotFound.Value},
+		{"permission denied", fmt.Errorf("authorize: %w", domain.ErrSessionPermissionDenied), true, http.StatusForbidden, domain.ErrorCodeForbidden.Value},
+		{"unrelated", errors.New("connection refused"), false, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+
+			if handled := newTestResponder().sendSessionAccessError(context.Background(), rw, tt.err); handled != tt.handled {
+				t.Fatalf("expected handled=%t, got %t", tt.handled, handled)
+			}
+			if rw.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rw.Code)
+			}
+			if !tt.handled {
+				return
+			}
+
+			var body genericErrorResponse
+			if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("expected code %q, got %q", tt.wantCode, body.Code)
+			}
+		})
+	}
+}
+
+func TestSessionAuthorizer_Authorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		authorized bool
+		wantStatus int
+	}{
+		{"member", nil, true, http.StatusOK},
+		{"not a member", domain.ErrSessionNotFound, false, http.StatusNotFound},
+		{"role is not sufficient", domain.ErrSessionPermissionDenied, false, http.StatusForbidden},
+		{"unexpected error", errors.New("connection refused"), false, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := newTestResponder()
+			authorizer := &sessionAuthorizer{service: &fakeSessionService{err: tt.err}, resp: resp, log: resp.log}
+			r := httptest.NewRequest(http.MethodGet, "/v1/sessions/1/clipboard", nil)
+			r = r.WithContext(ac.WithAuthority(r.Context(), &ac.Authority{UserID: 1}))
+			rw := httptest.NewRecorder()
+
+			if _, ok := authorizer.authorize(rw, r, 1, domain.SessionRoleEditor); ok != tt.authorized {
+				t.Fatalf("expected authorized=%t, got %t", tt.authorized, ok)
+			}
+			if rw.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rw.Code)
+			}
+		})
+	}
+}
+
+func TestSessionAuthorizer_Unauthenticated(t *testing.T) {
+	resp := newTestResponder()
+	authorizer := &sessionAuthorizer{service: &fakeSessionService{}, resp: resp, log: resp.log}
+	rw := httptest.NewRecorder()
+
+	if _, ok := authorizer.authorize(rw, httptest.NewRequest(http.MethodGet, "/v1/sessions/1/clipboard", nil), 1, domain.SessionRoleViewer); ok {
+		t.Fatal("expected request without authority to be refused")
+	}
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rw.Code)
+	}
+}
@@ -0,0 +1,200 @@
+package handle
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	ContentDispositionHeader = "Content-Disposition"
+
+	clipboardFileName = "clipboard"
+	// envelopeOverhead is a room for JSON fields or multipart headers and boundaries around the content
+	envelopeOverhead = 64 << 10
+)
+
+var errMalformedClipboardRequest = errors.New("malformed clipboard request")
+
+type (
+	ClipboardRepresentation struct {
+		ContentType string `json:"content_type"`
+		Content     []byte `json:"content"`
+	}
+
+	clipboardRequest struct {
+		Representations []ClipboardRepresentation `json:"representations"`
+	}
+)
+
+var (
+	clipboardFileExtensions = map[string]string{
+		"text/plain": ".txt",
+		"text/html":  ".html",
+		"image/png":  ".png",
+		"image/jpeg": ".jpg",
+	}
+)
+
+// readRepresentations reads clipboard representations from request body, which is either a single representation,
+// a multipart/mixed (or multipart/alternative) body with a part per representation
+// or application/json envelope with base64 encoded representations.
+func readRepresentations(rw http.ResponseWriter, r *http.Request, maxContentBytes int64) ([]domain.Representation, error) {
+	contentType := r.Header.Get(ContentTypeHeader)
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parse content type: %w", errMalformedClipboardRequest, err)
+	}
+
+	switch mediaType {
+	case ContentTypeJSON:
+		body := http.MaxBytesReader(rw, r.Body, int64(base64.StdEncoding.EncodedLen(int(maxContentBytes)))+envelopeOverhead)
+		var req clipboardRequest
+		if err = json.NewDecoder(body).Decode(&req); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, fmt.Errorf("read body: %w", err)
+			}
+			return nil, fmt.Errorf("%w: decode body: %w", errMalformedClipboardRequest, err)
+		}
+		return fromRepresentationDTOs(req.Representations), nil
+	case "multipart/mixed", "multipart/alternative":
+		if params["boundary"] == "" {
+			return nil, fmt.Errorf("%w: multipart boundary is missing", errMalformedClipboardRequest)
+		}
+		return readMultipartRepresentations(multipart.NewReader(http.MaxBytesReader(rw, r.Body, maxContentBytes+envelopeOverhead), params["boundary"]))
+	default:
+		content, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, maxContentBytes))
+		if err != nil {
+			return nil, fmt.Errorf("read body: %w", err)
+		}
+		return []domain.Representation{{ContentType: contentType, Content: content}}, nil
+	}
+}
+
+func readMultipartRepresentations(reader *multipart.Reader) ([]domain.Representation, error) {
+	res := make([]domain.Representation, 0, 3)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, fmt.Errorf("read part: %w", err)
+			}
+			return nil, fmt.Errorf("%w: read part: %w", errMalformedClipboardRequest, err)
+		}
+
+		contentType := part.Header.Get(ContentTypeHeader)
+		if contentType == "" {
+			return nil, fmt.Errorf("%w: part %d has no content type", errMalformedClipboardRequest, len(res))
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("read part content: %w", err)
+		}
+
+		res = append(res, domain.Representation{ContentType: contentType, Content: content})
+	}
+}
+
+// writeClipboardContent writes the representation of the clipboard that is preferred by request's Accept header.
+func writeClipboardContent(ctx context.Context, rw http.ResponseWriter, r *http.Request, clipboard *domain.Clipboard, log log.TracedLogger) {
+	rw.Header().Add(VaryHeader, AcceptHeader)
+
+	representation := negotiateRepresentation(r.Header.Get(AcceptHeader), clipboard.Representations)
+	if representation == nil {
+		log.Debugw(ctx, "clipboard has no representations")
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rw.Header().Set(ContentTypeHeader, representation.ContentType)
+	rw.Header().Set(ContentDispositionHeader, contentDisposition(representation.ContentType))
+	if _, err := rw.Write(representation.Content); err != nil {
+		log.Errorw(ctx, "failed to write content", err)
+	}
+}
+
+func toRepresentationDTOs(representations []domain.Representation) []ClipboardRepresentation {
+	res := make([]ClipboardRepresentation, 0, len(representations))
+	for _, r := range representations {
+		res = append(res, ClipboardRepresentation{ContentType: r.ContentType, Content: r.Content})
+	}
+	return res
+}
+
+func fromRepresentationDTOs(representations []ClipboardRepresentation) []domain.Representation {
+	res := make([]domain.Representation, 0, len(representations))
+	for _, r := range representations {
+		res = append(res, domain.Representation{ContentType: r.ContentType, Content: r.Content})
+	}
+	return res
+}
+
+// contentDisposition lets browsers display plain text and raster images, while anything else is downloaded.
+// SVG is downloaded too, since it can carry scripts.
+func contentDisposition(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	disposition := "attachment"
+	if mediaType == "text/plain" || (strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml") {
+		disposition = "inline"
+	}
+
+	return mime.FormatMediaType(disposition, map[string]string{"filename": clipboardFileName + clipboardFileExtension(mediaType)})
+}
+
+func clipboardFileExtension(mediaType string) string {
+	if ext, ok := clipboardFileExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// sendContentError responds with an error if err is caused by rejected clipboard content and reports whether it did.
+func (r *responder) sendContentError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	code, message, ok := contentErrorCode(err)
+	if ok {
+		r.SendError(ctx, rw, code.StatusCode, code.Value, message, nil)
+	}
+	return ok
+}
+
+func contentErrorCode(err error) (domain.ErrorCode, string, bool) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, domain.ErrContentTooLarge), errors.As(err, &maxBytesErr):
+		return domain.ErrorCodeContentTooLarge, "Content is too large", true
+	case errors.Is(err, domain.ErrUnsupportedContentType):
+		return domain.ErrorCodeUnsupportedMediaType, "Content-Type is not supported", true
+	case errors.Is(err, domain.ErrContentTypeMismatch):
+		return domain.ErrorCodeContentTypeMismatch, "Content does not match Content-Type", true
+	case errors.Is(err, domain.ErrNoRepresentations):
+		return domain.ErrorCodeNoRepresentations, "At least one representation is required", true
+	case errors.Is(err, domain.ErrDuplicateRepresentation):
+		return domain.ErrorCodeDuplicateRepresentation, "Only one representation per content type is allowed", true
+	case errors.Is(err, errMalformedClipboardRequest):
+		return domain.ErrorBadRequest, "Failed to parse request", true
+	default:
+		return domain.ErrorCode{}, "", false
+	}
+}
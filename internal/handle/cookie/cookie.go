@@ -3,6 +3,7 @@ package cookie
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,52 +12,68 @@ import (
 )
 
 const (
-	accessTokenCookieName = "accessToken"
+	accessTokenCookieName  = "accessToken"
+	refreshTokenCookieName = "refreshToken"
+	oidcStateCookieName    = "oidcState"
+	csrfTokenCookieName    = "csrfToken"
 )
 
 var (
-	ErrAccessTokenNotFound = fmt.Errorf("access token not found")
-	ErrParseAccessToken    = fmt.Errorf("parse access token")
+	ErrAccessTokenNotFound  = fmt.Errorf("access token not found")
+	ErrParseAccessToken     = fmt.Errorf("parse access token")
+	ErrRefreshTokenNotFound = fmt.Errorf("refresh token not found")
+	ErrOIDCStateNotFound    = fmt.Errorf("oidc state not found")
+	ErrCSRFTokenNotFound    = fmt.Errorf("csrf token not found")
 )
 
 type (
 	JWTProcessor interface {
-		ToAccessToken(userID uint64, name string) (string, error)
+		ToAccessToken(userID uint64, name string, generation uint64) (string, *jwt.RegisteredClaims, error)
 		ParseAccessToken(tokenString string) (*jwt.Token, error)
 	}
 
+	// Processor creates cookies which are not accessible to scripts, so tokens can not be stolen with XSS
 	Processor struct {
 		jwtProcessor JWTProcessor
 
-		path   string
-		domain string
+		path     string
+		domain   string
+		secure   bool
+		sameSite http.SameSite
 	}
 )
 
 func NewProcessor(jwtProcessor JWTProcessor, conf config.Cookie) *Processor {
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(conf.SameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
 	return &Processor{
 		jwtProcessor: jwtProcessor,
 
-		path:   conf.Path,
-		domain: conf.Domain,
+		path:     conf.Path,
+		domain:   conf.Domain,
+		secure:   conf.Secure,
+		sameSite: sameSite,
 	}
 }
 
-func (p *Processor) ToAccessToken(id uint64, name string) (*http.Cookie, error) {
-	var (
-		res = &http.Cookie{Name: accessTokenCookieName, Path: p.path, Domain: p.domain}
-		err error
-	)
-
-	if res.Value, err = p.jwtProcessor.ToAccessToken(id, name); err != nil {
-		return res, fmt.Errorf("create access token: %w", err)
+// ToAccessToken returns cookie with access token, it expires along with the token
+func (p *Processor) ToAccessToken(id uint64, name string, generation uint64) (*http.Cookie, *jwt.RegisteredClaims, error) {
+	value, claims, err := p.jwtProcessor.ToAccessToken(id, name, generation)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create access token: %w", err)
 	}
 
-	return res, nil
+	return p.cookie(accessTokenCookieName, value, claims.ExpiresAt.Time, p.sameSite), claims, nil
 }
 
 func (p *Processor) ExpireAccessToken() *http.Cookie {
-	return &http.Cookie{Name: accessTokenCookieName, Path: p.path, Domain: p.domain, Expires: time.Now()}
+	return p.cookie(accessTokenCookieName, "", time.Now(), p.sameSite)
 }
 
 func (p *Processor) AccessTokenFromRequest(r *http.Request) (*jwt.Token, error) {
@@ -71,3 +88,65 @@ func (p *Processor) AccessTokenFromRequest(r *http.Request) (*jwt.Token, error)
 	}
 	return token, err
 }
+
+// ToRefreshToken returns cookie with opaque refresh token, it is strict regardless of configuration
+func (p *Processor) ToRefreshToken(token string, expiresAt time.Time) *http.Cookie {
+	return p.cookie(refreshTokenCookieName, token, expiresAt, http.SameSiteStrictMode)
+}
+
+func (p *Processor) ExpireRefreshToken() *http.Cookie {
+	return p.cookie(refreshTokenCookieName, "", time.Now(), http.SameSiteStrictMode)
+}
+
+func (p *Processor) RefreshTokenFromRequest(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(refreshTokenCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", ErrRefreshTokenNotFound
+	}
+	return cookie.Value, nil
+}
+
+// ToOIDCState returns cookie binding OpenID Connect authorization to the browser which started it.
+// It is lax, so it is sent with the redirect from the provider.
+func (p *Processor) ToOIDCState(state string, expiresAt time.Time) *http.Cookie {
+	return p.cookie(oidcStateCookieName, state, expiresAt, http.SameSiteLaxMode)
+}
+
+func (p *Processor) ExpireOIDCState() *http.Cookie {
+	return p.cookie(oidcStateCookieName, "", time.Now(), http.SameSiteLaxMode)
+}
+
+func (p *Processor) OIDCStateFromRequest(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", ErrOIDCStateNotFound
+	}
+	return cookie.Value, nil
+}
+
+// ToCSRFToken returns cookie requests authenticated with cookies have to repeat in X-CSRF-Token header.
+// It lives as long as the browser session, client fetches the token again if it is gone.
+func (p *Processor) ToCSRFToken(token string) *http.Cookie {
+	return p.cookie(csrfTokenCookieName, token, time.Time{}, p.sameSite)
+}
+
+func (p *Processor) CSRFTokenFromRequest(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(csrfTokenCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", ErrCSRFTokenNotFound
+	}
+	return cookie.Value, nil
+}
+
+func (p *Processor) cookie(name, value string, expires time.Time, sameSite http.SameSite) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     p.path,
+		Domain:   p.domain,
+		Expires:  expires,
+		Secure:   p.secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
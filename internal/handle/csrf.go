@@ -0,0 +1,89 @@
+package handle
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const csrfTokenBytes = 32
+
+type (
+	CSRFToken struct {
+		Token string `json:"csrf_token"`
+	}
+
+	// CSRFHandler protects requests authenticated with cookies with double submit tokens. Token is stored in a cookie
+	// and has to be repeated in X-CSRF-Token header, other sites can send the cookie but can not read it.
+	CSRFHandler struct {
+		resp            *responder
+		cookieProcessor CookieProcessor
+		log             log.TracedLogger
+	}
+)
+
+func NewCSRFHandler(cookieProcessor CookieProcessor, resp *responder, log log.TracedLogger) *CSRFHandler {
+	return &CSRFHandler{
+		resp:            resp,
+		cookieProcessor: cookieProcessor,
+		log:             log,
+	}
+}
+
+// GetToken returns token from the cookie, a new one is issued if there is no cookie yet
+func (h *CSRFHandler) GetToken(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	token, err := h.cookieProcessor.CSRFTokenFromRequest(r)
+	if err != nil {
+		if token, err = newCSRFToken(); err != nil {
+			h.log.Errorw(ctx, "failed to generate csrf token", err)
+			h.resp.SendInternalServerError(ctx, rw)
+			return
+		}
+		http.SetCookie(rw, h.cookieProcessor.ToCSRFToken(token))
+	}
+
+	rw.Header().Set(CacheControlHeader, "no-store")
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &CSRFToken{Token: token})
+}
+
+// Handle rejects mutating requests authenticated with cookies if header does not match the cookie.
+// Requests with bearer tokens are not sent by browsers automatically, so they are not checked.
+func (h *CSRFHandler) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		ctx := r.Context()
+		header := r.Header.Get(CSRFTokenHeader)
+		token, err := h.cookieProcessor.CSRFTokenFromRequest(r)
+		if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+			h.log.Debugw(ctx, "csrf token does not match csrf cookie")
+			h.resp.SendError(ctx, rw, http.StatusForbidden, domain.ErrorCodeCSRFTokenInvalid.Value, "CSRF token is missing or invalid", nil)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, csrfTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
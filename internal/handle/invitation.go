@@ -0,0 +1,198 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+type (
+	invitationRequest struct {
+		MaxUses       int `json:"max_uses"`
+		ExpireInHours int `json:"expire_in_hours"`
+	}
+
+	Invitation struct {
+		InvitationID  uint64 `json:"invitation_id"`
+		CreatedBy     uint64 `json:"created_by"`
+		CreatedByName string `json:"created_by_name"`
+		// Code is only returned when invitation is created
+		Code            string `json:"code,omitempty"`
+		MaxUses         int    `json:"max_uses"`
+		Uses            int    `json:"uses"`
+		ExpiresAtMillis int64  `json:"expires_at_millis"`
+		// RevokedAtMillis is omitted if invitation was not revoked
+		RevokedAtMillis int64 `json:"revoked_at_millis,omitempty"`
+		CreatedAtMillis int64 `json:"created_at_millis"`
+	}
+
+	InvitationService interface {
+		Create(ctx context.Context, userID uint64, maxUses int, expireIn time.Duration) (*domain.Invitation, error)
+		GetAll(ctx context.Context, createdBy uint64) ([]*domain.Invitation, error)
+		Revoke(ctx context.Context, createdBy, id uint64) error
+	}
+
+	// InvitationHandler lets users manage invitations they created and admins manage invitations of everyone
+	InvitationHandler struct {
+		resp    *responder
+		service InvitationService
+		log     log.TracedLogger
+	}
+)
+
+func NewInvitationHandler(service InvitationService, resp *responder, log log.TracedLogger) *InvitationHandler {
+	return &InvitationHandler{
+		resp:    resp,
+		service: service,
+		log:     log,
+	}
+}
+
+func (h *InvitationHandler) Create(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	var req invitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	invitation, err := h.service.Create(ctx, auth.UserID, req.MaxUses, time.Duration(req.ExpireInHours)*time.Hour)
+	if err != nil {
+		if h.sendInvitationError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to create invitation", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Created invitation", "invitationID", invitation.ID)
+	rw.Header().Set(CacheControlHeader, "no-store")
+	h.resp.Send(ctx, rw, http.StatusCreated, nil, toInvitationDTO(invitation))
+}
+
+// GetAll returns invitations created by the user
+func (h *InvitationHandler) GetAll(rw http.ResponseWriter, r *http.Request) {
+	auth, ok := h.resp.unrestrictedAuthority(r.Context(), rw)
+	if !ok {
+		return
+	}
+
+	h.getAll(rw, r, auth.UserID)
+}
+
+// Revoke revokes invitation created by the user
+func (h *InvitationHandler) Revoke(rw http.ResponseWriter, r *http.Request) {
+	auth, ok := h.resp.unrestrictedAuthority(r.Context(), rw)
+	if !ok {
+		return
+	}
+
+	h.revoke(rw, r, auth.UserID)
+}
+
+// GetAllOfAll returns invitations created by all users, it has to be behind AdminHandler.Handle
+func (h *InvitationHandler) GetAllOfAll(rw http.ResponseWriter, r *http.Request) {
+	h.getAll(rw, r, 0)
+}
+
+// RevokeAny revokes invitation created by any user, it has to be behind AdminHandler.Handle
+func (h *InvitationHandler) RevokeAny(rw http.ResponseWriter, r *http.Request) {
+	h.revoke(rw, r, 0)
+}
+
+func (h *InvitationHandler) getAll(rw http.ResponseWriter, r *http.Request, createdBy uint64) {
+	ctx := r.Context()
+
+	invitations, err := h.service.GetAll(ctx, createdBy)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to get invitations", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Got invitations", "count", len(invitations))
+	res := make([]*Invitation, 0, len(invitations))
+	for _, i := range invitations {
+		res = append(res, toInvitationDTO(i))
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &paginatedResponse{
+		Items:      res,
+		TotalItems: len(res),
+	})
+}
+
+func (h *InvitationHandler) revoke(rw http.ResponseWriter, r *http.Request, createdBy uint64) {
+	ctx := r.Context()
+
+	invitationID, err := strconv.ParseUint(chi.URLParam(r, "invitationID"), 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse invitationID", err)
+		h.resp.SendBadRequest(ctx, rw, "invitationID param must be a valid uint64 value")
+		return
+	}
+
+	if err = h.service.Revoke(ctx, createdBy, invitationID); err != nil {
+		if h.sendInvitationError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to revoke invitation", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Revoked invitation", "invitationID", invitationID)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *InvitationHandler) sendInvitationError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	var re *domain.RenderableError
+	switch {
+	case errors.Is(err, domain.ErrInvitationNotFound):
+		h.resp.SendNotFound(ctx, rw, "Invitation not found")
+	case errors.Is(err, domain.ErrInvitationPermissionDenied):
+		h.resp.SendForbidden(ctx, rw, "Only admins can create invitations")
+	case errors.As(err, &re):
+		h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+	default:
+		return false
+	}
+
+	h.log.Debugw(ctx, "invitation request rejected", err)
+	return true
+}
+
+func toInvitationDTO(invitation *domain.Invitation) *Invitation {
+	res := &Invitation{
+		InvitationID:    invitation.ID,
+		CreatedBy:       invitation.CreatedBy,
+		CreatedByName:   invitation.CreatedByName,
+		Code:            invitation.Code,
+		MaxUses:         invitation.MaxUses,
+		Uses:            invitation.Uses,
+		ExpiresAtMillis: invitation.ExpiresAt.UnixMilli(),
+		CreatedAtMillis: invitation.CreatedAt.UnixMilli(),
+	}
+	if !invitation.RevokedAt.IsZero() {
+		res.RevokedAtMillis = invitation.RevokedAt.UnixMilli()
+	}
+	return res
+}
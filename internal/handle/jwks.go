@@ -0,0 +1,100 @@
+package handle
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"sort"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+// jwksMaxAge lets verifiers cache keys, new keys must be published at least that long before they start signing
+const jwksMaxAge = "public, max-age=300"
+
+type (
+	// JSONWebKey is a public key in RFC 7517 format
+	JSONWebKey struct {
+		KeyType   string `json:"kty"`
+		KeyID     string `json:"kid"`
+		Use       string `json:"use"`
+		Algorithm string `json:"alg"`
+		// N and E are set for RSA keys
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+		// Curve and X are set for Ed25519 keys
+		Curve string `json:"crv,omitempty"`
+		X     string `json:"x,omitempty"`
+	}
+
+	JSONWebKeySet struct {
+		Keys []*JSONWebKey `json:"keys"`
+	}
+
+	PublicKeysProvider interface {
+		PublicKeys() map[string]crypto.PublicKey
+	}
+
+	JWKSHandler struct {
+		resp *responder
+		keys PublicKeysProvider
+		log  log.TracedLogger
+	}
+)
+
+func NewJWKSHandler(keys PublicKeysProvider, resp *responder, log log.TracedLogger) *JWKSHandler {
+	return &JWKSHandler{
+		resp: resp,
+		keys: keys,
+		log:  log,
+	}
+}
+
+// GetJWKS returns public keys access tokens are verified with, so other services can verify them too
+func (h *JWKSHandler) GetJWKS(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	keys := h.keys.PublicKeys()
+	res := &JSONWebKeySet{Keys: make([]*JSONWebKey, 0, len(keys))}
+	for id, key := range keys {
+		jwk := toJSONWebKey(id, key)
+		if jwk == nil {
+			h.log.Errorw(ctx, "Unsupported public key type", "keyID", id)
+			continue
+		}
+		res.Keys = append(res.Keys, jwk)
+	}
+	sort.Slice(res.Keys, func(i, j int) bool {
+		return res.Keys[i].KeyID < res.Keys[j].KeyID
+	})
+
+	h.resp.Send(ctx, rw, http.StatusOK, map[string][]string{CacheControlHeader: {jwksMaxAge}}, res)
+}
+
+func toJSONWebKey(id string, key crypto.PublicKey) *JSONWebKey {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &JSONWebKey{
+			KeyType:   "RSA",
+			KeyID:     id,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return &JSONWebKey{
+			KeyType:   "OKP",
+			KeyID:     id,
+			Use:       "sig",
+			Algorithm: "EdDSA",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(k),
+		}
+	default:
+		return nil
+	}
+}
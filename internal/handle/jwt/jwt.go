@@ -1,6 +1,8 @@
 package jwt
 
 import (
+	"crypto"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,36 +12,68 @@ import (
 	"github.com/Roma7-7-7/shared-clipboard/internal/config"
 )
 
+const keyIDHeader = "kid"
+
 type (
+	// Processor signs access tokens with the signing key of the keyring and verifies them with any of its keys.
+	// If keyring is empty, tokens are signed and verified with HS256 secret.
 	Processor struct {
 		issuer          string
 		audience        []string
 		expireInMinutes uint64
 
-		secret []byte
+		keyring *keyring
+		secret  []byte
+		parser  *jwt.Parser
 	}
 
 	Claims struct {
 		Username string `json:"username"`
+		// Generation is token generation of the user at the moment token was issued
+		Generation uint64 `json:"gen"`
 		jwt.RegisteredClaims
 	}
 )
 
-func NewProcessor(conf config.JWT) *Processor {
+func NewProcessor(conf config.JWT) (*Processor, error) {
+	keyring, err := newKeyring(conf.Keys, conf.SigningKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("create keyring: %w", err)
+	}
+
+	methods := []string{jwt.SigningMethodHS256.Alg()}
+	if keyring.signing != nil {
+		methods = keyring.methods()
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(conf.Issuer),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	}
+	if len(conf.Audience) > 0 {
+		// the first audience identifies this API, others are services tokens are also meant for
+		options = append(options, jwt.WithAudience(conf.Audience[0]))
+	}
+
 	return &Processor{
 		issuer:          conf.Issuer,
 		audience:        conf.Audience,
 		expireInMinutes: conf.ExpireInMinutes,
 
-		secret: []byte(conf.Secret),
-	}
+		keyring: keyring,
+		secret:  []byte(conf.Secret),
+		parser:  jwt.NewParser(options...),
+	}, nil
 }
 
-func (p *Processor) ToAccessToken(userID uint64, name string) (string, error) {
+// ToAccessToken returns signed token and its claims, e.g. to keep track of its ID
+func (p *Processor) ToAccessToken(userID uint64, name string, generation uint64) (string, *jwt.RegisteredClaims, error) {
 	now := time.Now()
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		Username: name,
+	claims := Claims{
+		Username:   name,
+		Generation: generation,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    p.issuer,
 			Subject:   fmt.Sprintf("%d", userID),
@@ -49,18 +83,66 @@ func (p *Processor) ToAccessToken(userID uint64, name string) (string, error) {
 			IssuedAt:  jwt.NewNumericDate(now),
 			ID:        uuid.New().String(),
 		},
-	})
+	}
 
-	signedString, err := token.SignedString(p.secret)
+	var (
+		signedString string
+		err          error
+	)
+	if signing := p.keyring.signing; signing != nil {
+		token := jwt.NewWithClaims(signing.method, claims)
+		token.Header[keyIDHeader] = signing.id
+		signedString, err = token.SignedString(signing.private)
+	} else {
+		signedString, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(p.secret)
+	}
 	if err != nil {
-		return "", fmt.Errorf("sign token: %w", err)
+		return "", nil, fmt.Errorf("sign token: %w", err)
 	}
 
-	return signedString, nil
+	return signedString, &claims.RegisteredClaims, nil
 }
 
+// ParseAccessToken verifies token signature, algorithm, issuer, audience and validity period
 func (p *Processor) ParseAccessToken(token string) (*jwt.Token, error) {
-	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+	res, err := p.parser.Parse(token, p.verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if nbf, err := res.Claims.GetNotBefore(); err != nil || nbf == nil {
+		return nil, errors.New("token has no valid not before claim")
+	}
+
+	return res, nil
+}
+
+// PublicKeys returns public keys tokens are verified with by their IDs, it is empty if tokens are signed with HS256 secret
+func (p *Processor) PublicKeys() map[string]crypto.PublicKey {
+	res := make(map[string]crypto.PublicKey, len(p.keyring.keys))
+	for id, k := range p.keyring.keys {
+		res[id] = k.public
+	}
+	return res
+}
+
+func (p *Processor) verificationKey(token *jwt.Token) (interface{}, error) {
+	if p.keyring.signing == nil {
 		return p.secret, nil
-	})
+	}
+
+	kid, ok := token.Header[keyIDHeader].(string)
+	if !ok || kid == "" {
+		return nil, errors.New("token has no key id")
+	}
+	k, ok := p.keyring.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id=%q", kid)
+	}
+	// algorithm is checked against the key as well, so a key can not be used with algorithm of another key
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("algorithm %q does not match key id=%q", token.Method.Alg(), kid)
+	}
+
+	return k.public, nil
 }
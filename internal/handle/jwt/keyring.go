@@ -0,0 +1,129 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/config"
+)
+
+// minRSAKeyBits is the smallest RSA key size accepted for signing and verification
+const minRSAKeyBits = 2048
+
+type (
+	// key is either a private key tokens are signed and verified with or a public key tokens are only verified with
+	key struct {
+		id      string
+		method  jwt.SigningMethod
+		private crypto.Signer
+		public  crypto.PublicKey
+	}
+
+	// keyring holds keys by their IDs. Keeping previous keys after rotation lets tokens signed with them
+	// stay valid until they expire.
+	keyring struct {
+		signing *key
+		keys    map[string]*key
+	}
+)
+
+func newKeyring(conf []config.JWTKey, signingKeyID string) (*keyring, error) {
+	res := &keyring{
+		keys: make(map[string]*key, len(conf)),
+	}
+
+	for _, c := range conf {
+		if _, ok := res.keys[c.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id=%q", c.ID)
+		}
+
+		k, err := loadKey(c.ID, c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("load key id=%q: %w", c.ID, err)
+		}
+		res.keys[c.ID] = k
+	}
+
+	if len(res.keys) == 0 {
+		return res, nil
+	}
+
+	signing, ok := res.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key id=%q not found", signingKeyID)
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("signing key id=%q is not a private key", signingKeyID)
+	}
+	res.signing = signing
+
+	return res, nil
+}
+
+func loadKey(id, path string) (*key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", block.Type, err)
+	}
+
+	res := &key{id: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		res.method, res.private, res.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		res.method, res.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		res.method, res.private, res.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		res.method, res.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only RSA and Ed25519 keys are supported", parsed)
+	}
+
+	if pub, ok := res.public.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+	}
+
+	return res, nil
+}
+
+// methods returns names of algorithms of the keys
+func (k *keyring) methods() []string {
+	res := make([]string, 0, 2)
+	seen := make(map[string]bool, 2)
+	for _, key := range k.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			res = append(res, alg)
+		}
+	}
+	return res
+}
@@ -0,0 +1,154 @@
+package handle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+type (
+	Login struct {
+		LoginID    uint64 `json:"login_id"`
+		DeviceName string `json:"device_name"`
+		UserAgent  string `json:"user_agent"`
+		IP         string `json:"ip"`
+		// Current is true for the login request was made from
+		Current          bool  `json:"current"`
+		CreatedAtMillis  int64 `json:"created_at_millis"`
+		LastSeenAtMillis int64 `json:"last_seen_at_millis"`
+	}
+
+	LoginService interface {
+		TokenGenerationChecker
+		Create(ctx context.Context, userID uint64, tokens domain.LoginTokens, userAgent, ip string) (*domain.Login, error)
+		Touch(ctx context.Context, tokens domain.LoginTokens, ip string) error
+		GetAll(ctx context.Context, userID uint64) ([]*domain.Login, error)
+		Revoke(ctx context.Context, userID, loginID uint64) error
+		RevokeAll(ctx context.Context, userID uint64) error
+	}
+
+	LoginHandler struct {
+		resp            *responder
+		service         LoginService
+		cookieProcessor CookieProcessor
+		log             log.TracedLogger
+	}
+)
+
+func NewLoginHandler(service LoginService, cookieProcessor CookieProcessor, resp *responder, log log.TracedLogger) *LoginHandler {
+	return &LoginHandler{
+		resp:            resp,
+		service:         service,
+		cookieProcessor: cookieProcessor,
+		log:             log,
+	}
+}
+
+func (h *LoginHandler) GetAll(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	logins, err := h.service.GetAll(ctx, auth.UserID)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to get logins", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Got logins", "count", len(logins))
+	res := make([]*Login, 0, len(logins))
+	for _, l := range logins {
+		dto := toLoginDTO(l)
+		dto.Current = auth.TokenID != "" && auth.TokenID == l.AccessTokenID
+		res = append(res, dto)
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &paginatedResponse{
+		Items:      res,
+		TotalItems: len(res),
+	})
+}
+
+// Revoke signs out the login, its access token stops working immediately
+func (h *LoginHandler) Revoke(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	loginID, err := strconv.ParseUint(chi.URLParam(r, "loginID"), 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse loginID", err)
+		h.resp.SendBadRequest(ctx, rw, "loginID param must be a valid uint64 value")
+		return
+	}
+
+	if err = h.service.Revoke(ctx, auth.UserID, loginID); err != nil {
+		if errors.Is(err, domain.ErrLoginNotFound) {
+			h.log.Debugw(ctx, "login not found", "loginID", loginID)
+			h.resp.SendNotFound(ctx, rw, "Login not found")
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to revoke login", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Revoked login", "loginID", loginID)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeAll signs the user out everywhere including the current login
+func (h *LoginHandler) RevokeAll(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeAll(ctx, auth.UserID); err != nil {
+		h.log.Errorw(ctx, "failed to revoke all logins", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Revoked all logins")
+	http.SetCookie(rw, h.cookieProcessor.ExpireAccessToken())
+	http.SetCookie(rw, h.cookieProcessor.ExpireRefreshToken())
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func toLoginDTO(login *domain.Login) *Login {
+	return &Login{
+		LoginID:          login.ID,
+		DeviceName:       login.DeviceName,
+		UserAgent:        login.UserAgent,
+		IP:               login.IP,
+		CreatedAtMillis:  login.CreatedAt.UnixMilli(),
+		LastSeenAtMillis: login.LastSeenAt.UnixMilli(),
+	}
+}
+
+// clientIP returns address of the client the request originates from, see ClientIP middleware
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -6,24 +6,51 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/golang-jwt/jwt/v5"
 
+	"github.com/Roma7-7-7/shared-clipboard/internal/config"
 	ac "github.com/Roma7-7-7/shared-clipboard/internal/context"
 	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
 	"github.com/Roma7-7-7/shared-clipboard/internal/handle/cookie"
 	"github.com/Roma7-7-7/shared-clipboard/internal/log"
 )
 
-type AuthorizedMiddleware struct {
-	resp            *responder
-	cookieProcessor CookieProcessor
-	jwtRepository   JTIService
-	log             log.TracedLogger
-}
+const bearerAuthScheme = "Bearer"
+
+var errAccessTokenNotValid = &authenticationError{code: domain.ErrorCodeForbidden, message: "JWT token is not valid or expired"}
+
+type (
+	APITokenAuthenticator interface {
+		Authenticate(ctx context.Context, token string) (*domain.APIToken, error)
+	}
+
+	TokenGenerationChecker interface {
+		IsCurrentTokenGeneration(ctx context.Context, userID, generation uint64) (bool, error)
+	}
+
+	// authenticationError is a reason request credentials are refused, it is sent to client as is
+	authenticationError struct {
+		code    domain.ErrorCode
+		message string
+		// invalidToken is set if API token is refused, so bearer scheme clients are told to get a new one
+		invalidToken bool
+	}
+
+	AuthorizedMiddleware struct {
+		resp            *responder
+		cookieProcessor CookieProcessor
+		jwtRepository   JTIService
+		generations     TokenGenerationChecker
+		apiTokens       APITokenAuthenticator
+		log             log.TracedLogger
+	}
+)
 
 func TraceID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -58,78 +85,250 @@ func Logger(l log.TracedLogger) func(next http.Handler) http.Handler {
 
 }
 
+// SecurityHeaders sets response headers restricting what browsers do with responses, headers with empty
+// configured values are not set
+func SecurityHeaders(conf config.Security) func(next http.Handler) http.Handler {
+	hsts := ""
+	if conf.HSTSMaxAgeSeconds > 0 {
+		hsts = "max-age=" + strconv.Itoa(conf.HSTSMaxAgeSeconds)
+		if conf.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set(ContentTypeOptionsHeader, "nosniff")
+			setIfNotEmpty(h, HSTSHeader, hsts)
+			setIfNotEmpty(h, FrameOptionsHeader, strings.ToUpper(conf.FrameOptions))
+			setIfNotEmpty(h, ReferrerPolicyHeader, conf.ReferrerPolicy)
+			setIfNotEmpty(h, CSPHeader, conf.ContentSecurityPolicy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ContentSecurityPolicy replaces policy set by SecurityHeaders for routes with specific needs
+func ContentSecurityPolicy(policy string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(CSPHeader, policy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIP replaces RemoteAddr with address of the client the request originates from. X-Forwarded-For entries are
+// only followed from the right while they are appended by trusted proxies, so clients can not forge their address.
+func ClientIP(trustedProxies []netip.Prefix) func(next http.Handler) http.Handler {
+	trusted := func(addr netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(clientIP(r))
+			if err != nil || !trusted(addr.Unmap()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			entries := strings.Split(strings.Join(r.Header.Values(ForwardedForHeader), ","), ",")
+			for i := len(entries) - 1; i >= 0; i-- {
+				forwarded, err := netip.ParseAddr(strings.TrimSpace(entries[i]))
+				if err != nil {
+					// address set by a proxy is never malformed, the rest of the header is not trusted
+					break
+				}
+				addr = forwarded.Unmap()
+				if !trusted(addr) {
+					break
+				}
+			}
+
+			r.RemoteAddr = addr.String()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ParseTrustedProxies parses CIDRs of proxies for ClientIP
+func ParseTrustedProxies(cidrs []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parse trusted proxy %q: %w", cidr, err)
+		}
+		res = append(res, p.Masked())
+	}
+	return res, nil
+}
+
+func setIfNotEmpty(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
+
 func NewAuthorizedMiddleware(
-	cookieProcessor CookieProcessor, jwtRepository JTIService, resp *responder, log log.TracedLogger,
+	cookieProcessor CookieProcessor, jwtRepository JTIService, generations TokenGenerationChecker, apiTokens APITokenAuthenticator,
+	resp *responder, log log.TracedLogger,
 ) *AuthorizedMiddleware {
 	return &AuthorizedMiddleware{
 		resp:            resp,
 		cookieProcessor: cookieProcessor,
 		jwtRepository:   jwtRepository,
+		generations:     generations,
+		apiTokens:       apiTokens,
 		log:             log,
 	}
 }
 
+// Handle authenticates request either by personal API token in Authorization header or by access token cookie
 func (m *AuthorizedMiddleware) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		var (
-			ctx   = r.Context()
-			token *jwt.Token
-			err   error
-		)
+		ctx := r.Context()
 		m.log.Debugw(ctx, "authorized middleware")
 
-		if token, err = m.cookieProcessor.AccessTokenFromRequest(r); err != nil {
-			if errors.Is(err, cookie.ErrAccessTokenNotFound) {
-				m.log.Debugw(ctx, "access token cookie not found")
-				m.resp.SendError(ctx, rw, http.StatusUnauthorized, domain.ErrorCodeUnauthorized.Value, "Request is not authorized", nil)
-				return
-			}
-			if errors.Is(err, cookie.ErrParseAccessToken) {
-				m.log.Debugw(ctx, "failed to parse access token cookie")
-				m.sendForbidden(ctx, rw, "JWT token is not valid or expired")
+		authority, scope, err := m.authenticate(r)
+		if err != nil {
+			var authErr *authenticationError
+			if !errors.As(err, &authErr) {
+				m.log.Errorw(ctx, "failed to authenticate request", err)
+				m.resp.SendInternalServerError(ctx, rw)
 				return
 			}
 
-			m.log.Errorw(ctx, "failed to get access token cookie from request", err)
-			m.resp.SendInternalServerError(ctx, rw)
+			if authErr.invalidToken {
+				rw.Header().Set(WWWAuthenticateHeader, bearerAuthScheme+` error="invalid_token"`)
+			}
+			m.resp.SendError(ctx, rw, authErr.code.StatusCode, authErr.code.Value, authErr.message, nil)
 			return
 		}
+		if scope != nil {
+			ctx = ac.WithScope(ctx, scope)
+		}
+
+		next.ServeHTTP(rw, r.WithContext(ac.WithAuthority(ctx, authority)))
+	})
+}
+
+// Verify authenticates request of long-lived connection again, so the connection does not outlive its credentials.
+// It returns *authenticationError if credentials expired or were revoked since the connection was opened.
+func (m *AuthorizedMiddleware) Verify(r *http.Request) error {
+	_, _, err := m.authenticate(r)
+	return err
+}
+
+func (m *AuthorizedMiddleware) authenticate(r *http.Request) (*ac.Authority, *ac.Scope, error) {
+	if token, found := bearerToken(r); found {
+		return m.apiTokenAuthority(r, token)
+	}
+
+	authority, err := m.cookieAuthority(r)
+	return authority, nil, err
+}
+
+func (m *AuthorizedMiddleware) apiTokenAuthority(r *http.Request, token string) (*ac.Authority, *ac.Scope, error) {
+	ctx := r.Context()
+
+	apiToken, err := m.apiTokens.Authenticate(ctx, token)
+	if err != nil {
+		if errors.Is(err, domain.ErrAPITokenNotFound) {
+			m.log.Debugw(ctx, "api token is not valid")
+			return nil, nil, &authenticationError{
+				code:         domain.ErrorCodeUnauthorized,
+				message:      "API token is not valid or expired",
+				invalidToken: true,
+			}
+		}
+
+		return nil, nil, fmt.Errorf("authenticate api token: %w", err)
+	}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
+	return &ac.Authority{
+		UserID:   apiToken.UserID,
+		UserName: apiToken.UserName,
+	}, &ac.Scope{
+		SessionIDs:  apiToken.SessionIDs,
+		Permissions: apiToken.Permissions,
+	}, nil
+}
+
+func (m *AuthorizedMiddleware) cookieAuthority(r *http.Request) (*ac.Authority, error) {
+	var (
+		ctx   = r.Context()
+		token *jwt.Token
+		err   error
+	)
+
+	if token, err = m.cookieProcessor.AccessTokenFromRequest(r); err != nil {
+		if errors.Is(err, cookie.ErrAccessTokenNotFound) {
+			m.log.Debugw(ctx, "access token cookie not found")
+			return nil, &authenticationError{code: domain.ErrorCodeUnauthorized, message: "Request is not authorized"}
+		}
+		if errors.Is(err, cookie.ErrParseAccessToken) {
 			m.log.Debugw(ctx, "failed to parse access token cookie")
-			m.sendForbidden(ctx, rw, "JWT token is not valid or expired")
-			return
+			return nil, errAccessTokenNotValid
 		}
 
-		authority, err := toAuthority(claims)
+		return nil, fmt.Errorf("get access token cookie from request: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		m.log.Debugw(ctx, "failed to parse access token cookie")
+		return nil, errAccessTokenNotValid
+	}
+
+	authority, err := toAuthority(claims)
+	if err != nil {
+		return nil, fmt.Errorf("parse authority: %w", err)
+	}
+
+	if authority.TokenID != "" {
+		ok, err = m.jwtRepository.IsBlockedJTIExists(ctx, authority.TokenID)
 		if err != nil {
-			m.log.Errorw(ctx, "failed to parse authority", err)
-			m.resp.SendInternalServerError(ctx, rw)
-			return
+			return nil, fmt.Errorf("check blocked jti: %w", err)
 		}
-
-		jti, ok := claims["jti"].(string)
-		if ok && jti != "" {
-			ok, err = m.jwtRepository.IsBlockedJTIExists(ctx, jti)
-			if err != nil {
-				m.log.Errorw(ctx, "failed to check blocked jti", err)
-				m.resp.SendInternalServerError(ctx, rw)
-				return
-			}
-			if ok {
-				m.log.Debugw(ctx, "blocked jti")
-				m.sendForbidden(ctx, rw, "JWT token is not valid or expired")
-				return
-			}
+		if ok {
+			m.log.Debugw(ctx, "blocked jti")
+			return nil, errAccessTokenNotValid
 		}
+	}
 
-		next.ServeHTTP(rw, r.WithContext(ac.WithAuthority(ctx, authority)))
-	})
+	// tokens issued before user signed out everywhere are of previous generation, tokens without generation are of the first one
+	generation, _ := claims["gen"].(float64)
+	if ok, err = m.generations.IsCurrentTokenGeneration(ctx, authority.UserID, uint64(generation)); err != nil {
+		return nil, fmt.Errorf("check token generation: %w", err)
+	}
+	if !ok {
+		m.log.Debugw(ctx, "outdated token generation", "generation", generation)
+		return nil, errAccessTokenNotValid
+	}
+
+	return authority, nil
 }
 
-func (m *AuthorizedMiddleware) sendForbidden(ctx context.Context, rw http.ResponseWriter, message string) {
-	m.resp.SendError(ctx, rw, http.StatusForbidden, domain.ErrorCodeForbidden.Value, message, nil)
+func (e *authenticationError) Error() string {
+	return e.message
+}
+
+// bearerToken returns token of Authorization header if it uses bearer scheme
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get(AuthorizationHeader), " ")
+	if !found || !strings.EqualFold(scheme, bearerAuthScheme) {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
 }
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -159,9 +358,11 @@ func toAuthority(claims jwt.MapClaims) (*ac.Authority, error) {
 	if name, ok = claims["username"].(string); !ok {
 		return nil, errors.New("name is not a string")
 	}
+	jti, _ := claims["jti"].(string)
 
 	return &ac.Authority{
 		UserID:   id,
 		UserName: name,
+		TokenID:  jti,
 	}, nil
 }
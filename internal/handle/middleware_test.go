@@ -0,0 +1,123 @@
+package handle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type (
+	// fakeCookieProcessor only implements AccessTokenFromRequest, other methods panic
+	fakeCookieProcessor struct {
+		CookieProcessor
+		claims jwt.MapClaims
+	}
+
+	// fakeJTIService only implements IsBlockedJTIExists, other methods panic
+	fakeJTIService struct {
+		JTIService
+		blocked map[string]bool
+		err     error
+	}
+
+	fakeTokenGenerationChecker struct {
+		generation uint64
+	}
+)
+
+func (p *fakeCookieProcessor) AccessTokenFromRequest(*http.Request) (*jwt.Token, error) {
+	return &jwt.Token{Claims: p.claims, Valid: true}, nil
+}
+
+func (s *fakeJTIService) IsBlockedJTIExists(_ context.Context, jti string) (bool, error) {
+	return s.blocked[jti], s.err
+}
+
+func (c *fakeTokenGenerationChecker) IsCurrentTokenGeneration(_ context.Context, _, generation uint64) (bool, error) {
+	return c.generation == generation, nil
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("parse trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"forged header from untrusted peer", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"forged entry before proxy entry", "10.0.0.2:1234", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.2:1234", []string{"203.0.113.7, 10.0.0.3"}, "203.0.113.7"},
+		{"multiple headers", "10.0.0.2:1234", []string{"198.51.100.1", "203.0.113.7, 10.0.0.3"}, "203.0.113.7"},
+		{"malformed entry", "10.0.0.2:1234", []string{"203.0.113.7, bogus"}, "10.0.0.2"},
+		{"only trusted proxies", "10.0.0.2:1234", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"trusted proxy without header", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::7"}, "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add(ForwardedForHeader, v)
+			}
+
+			var got string
+			ClientIP(trustedProxies)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies_Invalid(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"10.0.0.1"}); err == nil {
+		t.Error("expected address without prefix length to be refused")
+	}
+}
+
+func TestAuthorizedMiddleware_Verify(t *testing.T) {
+	var (
+		jtis        = &fakeJTIService{blocked: map[string]bool{}}
+		generations = &fakeTokenGenerationChecker{generation: 1}
+		cookies     = &fakeCookieProcessor{claims: jwt.MapClaims{"sub": "7", "username": "alice", "jti": "jti-1", "gen": float64(1)}}
+		resp        = newTestResponder()
+		m           = NewAuthorizedMiddleware(cookies, jtis, generations, nil, resp, resp.log)
+		r           = httptest.NewRequest(http.MethodGet, "/v1/sessions/1/ws", nil)
+	)
+
+	if err := m.Verify(r); err != nil {
+		t.Fatalf("expected credentials to be valid, got %v", err)
+	}
+
+	var authErr *authenticationError
+	jtis.blocked["jti-1"] = true
+	if err := m.Verify(r); !errors.As(err, &authErr) {
+		t.Errorf("expected blocked jti to be refused, got %v", err)
+	}
+
+	jtis.blocked["jti-1"] = false
+	generations.generation = 2
+	if err := m.Verify(r); !errors.As(err, &authErr) {
+		t.Errorf("expected token of previous generation to be refused, got %v", err)
+	}
+
+	jtis.err = errors.New("redis is down")
+	if err := m.Verify(r); err == nil || errors.As(err, &authErr) {
+		t.Errorf("expected internal error, got %v", err)
+	}
+}
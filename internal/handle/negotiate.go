@@ -0,0 +1,116 @@
+package handle
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+)
+
+const (
+	AcceptHeader = "Accept"
+	VaryHeader   = "Vary"
+
+	fallbackMediaType = "text/plain"
+)
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+	// specificity is 0 for */*, 1 for type/* and 2 for type/subtype, more specific ranges take precedence
+	specificity int
+}
+
+// negotiateRepresentation picks the representation preferred by Accept header value.
+// If nothing is acceptable, text/plain representation is returned, or the primary one if there is no text/plain.
+func negotiateRepresentation(accept string, representations []domain.Representation) *domain.Representation {
+	if len(representations) == 0 {
+		return nil
+	}
+
+	ranges := parseAccept(accept)
+	var (
+		best            *domain.Representation
+		bestQ           float64
+		bestSpecificity int
+	)
+	for i := range representations {
+		r := &representations[i]
+		mediaType, _, err := mime.ParseMediaType(r.ContentType)
+		if err != nil {
+			continue
+		}
+		// on equal quality the representation matched by more specific range wins, e.g. text/plain over */*
+		q, specificity := acceptQuality(ranges, mediaType)
+		if q > bestQ || (q > 0 && q == bestQ && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = r, q, specificity
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	for i := range representations {
+		if mediaType, _, err := mime.ParseMediaType(representations[i].ContentType); err == nil && mediaType == fallbackMediaType {
+			return &representations[i]
+		}
+	}
+	return &representations[0]
+}
+
+func parseAccept(accept string) []acceptRange {
+	res := make([]acceptRange, 0, strings.Count(accept, ",")+1)
+	for _, part := range strings.Split(accept, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+
+		specificity := 2
+		switch {
+		case mediaType == "*/*":
+			specificity = 0
+		case strings.HasSuffix(mediaType, "/*"):
+			specificity = 1
+		}
+
+		res = append(res, acceptRange{mediaType: mediaType, q: q, specificity: specificity})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].specificity > res[j].specificity
+	})
+	return res
+}
+
+func acceptQuality(ranges []acceptRange, mediaType string) (float64, int) {
+	for _, r := range ranges {
+		switch r.specificity {
+		case 2:
+			if r.mediaType == mediaType {
+				return r.q, r.specificity
+			}
+		case 1:
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")) {
+				return r.q, r.specificity
+			}
+		default:
+			return r.q, r.specificity
+		}
+	}
+	return 0, 0
+}
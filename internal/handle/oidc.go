@@ -0,0 +1,255 @@
+package handle
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	oidcErrorParam = "oidc_error"
+
+	// parameters web app is redirected with if sign in has to be completed with a code, same as TwoFactorChallenge
+	oidcTwoFactorChallengeParam = "two_factor_challenge"
+	oidcTwoFactorExpiresAtParam = "two_factor_expires_at_millis"
+
+	// errors web app is redirected with if sign in with provider failed
+	oidcErrorAccessDenied         = "access_denied"
+	oidcErrorInvalidState         = "invalid_state"
+	oidcErrorAuthenticationFailed = "authentication_failed"
+	oidcErrorIdentityConflict     = "identity_conflict"
+	oidcErrorAccountDisabled      = "account_disabled"
+	oidcErrorRegistrationClosed   = "registration_closed"
+	oidcErrorServerError          = "server_error"
+)
+
+type (
+	OIDCProviders struct {
+		Providers []string `json:"providers"`
+	}
+
+	UserIdentity struct {
+		ID              uint64 `json:"id"`
+		Provider        string `json:"provider"`
+		Email           string `json:"email,omitempty"`
+		CreatedAtMillis int64  `json:"created_at_millis"`
+	}
+
+	OIDCService interface {
+		Providers() []string
+		Authorize(ctx context.Context, provider string, userID uint64) (*domain.OIDCAuthorization, error)
+		Authenticate(ctx context.Context, state, code string) (*domain.OIDCAuthentication, error)
+		GetIdentities(ctx context.Context, userID uint64) ([]*domain.UserIdentity, error)
+	}
+
+	// OIDCHandler signs users in with OpenID Connect providers. Browser is redirected to the provider and back,
+	// so errors are reported to web app with oidc_error query parameter instead of response body.
+	OIDCHandler struct {
+		resp            *responder
+		service         OIDCService
+		auth            *AuthHandler
+		cookieProcessor CookieProcessor
+		redirectURL     string
+		log             log.TracedLogger
+	}
+)
+
+func NewOIDCHandler(
+	service OIDCService, auth *AuthHandler, cookieProcessor CookieProcessor, redirectURL string, resp *responder, log log.TracedLogger,
+) *OIDCHandler {
+	return &OIDCHandler{
+		resp:            resp,
+		service:         service,
+		auth:            auth,
+		cookieProcessor: cookieProcessor,
+		redirectURL:     redirectURL,
+		log:             log,
+	}
+}
+
+func (h *OIDCHandler) GetProviders(rw http.ResponseWriter, r *http.Request) {
+	h.resp.Send(r.Context(), rw, http.StatusOK, nil, &OIDCProviders{Providers: h.service.Providers()})
+}
+
+// Login redirects to the provider from provider query parameter to sign in
+func (h *OIDCHandler) Login(rw http.ResponseWriter, r *http.Request) {
+	h.authorize(rw, r, 0)
+}
+
+// Link redirects to the provider from provider query parameter to link identity to the signed-in user
+func (h *OIDCHandler) Link(rw http.ResponseWriter, r *http.Request) {
+	auth, ok := h.resp.unrestrictedAuthority(r.Context(), rw)
+	if !ok {
+		return
+	}
+
+	h.authorize(rw, r, auth.UserID)
+}
+
+// Callback completes authorization, the user is signed in unless identity was being linked
+func (h *OIDCHandler) Callback(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx   = r.Context()
+		query = r.URL.Query()
+	)
+
+	http.SetCookie(rw, h.cookieProcessor.ExpireOIDCState())
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		h.log.Debugw(ctx, "oidc provider returned error", "error", providerErr, "description", query.Get("error_description"))
+		h.redirect(rw, r, oidcErrorAccessDenied)
+		return
+	}
+
+	state := query.Get("state")
+	cookieState, err := h.cookieProcessor.OIDCStateFromRequest(r)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		h.log.Debugw(ctx, "oidc state does not match state cookie")
+		h.redirect(rw, r, oidcErrorInvalidState)
+		return
+	}
+
+	authentication, err := h.service.Authenticate(ctx, state, query.Get("code"))
+	if err != nil {
+		var re *domain.RenderableError
+		switch {
+		case errors.As(err, &re):
+			h.log.Debugw(ctx, "oidc authentication rejected", err)
+			h.redirect(rw, r, oidcErrorIdentityConflict)
+		case errors.Is(err, domain.ErrUserDisabled):
+			h.log.Debugw(ctx, "user is disabled")
+			h.redirect(rw, r, oidcErrorAccountDisabled)
+		case errors.Is(err, domain.ErrRegistrationClosed):
+			h.log.Debugw(ctx, "registration is closed for users without linked identity")
+			h.redirect(rw, r, oidcErrorRegistrationClosed)
+		case errors.Is(err, domain.ErrOIDCStateNotFound), errors.Is(err, domain.ErrOIDCProviderNotFound):
+			h.log.Debugw(ctx, "oidc state is not valid", err)
+			h.redirect(rw, r, oidcErrorInvalidState)
+		case errors.Is(err, domain.ErrOIDCAuthenticationFailed):
+			h.log.Infow(ctx, "OIDC authentication failed", err)
+			h.redirect(rw, r, oidcErrorAuthenticationFailed)
+		default:
+			h.log.Errorw(ctx, "failed to authenticate with oidc provider", err)
+			h.redirect(rw, r, oidcErrorServerError)
+		}
+		return
+	}
+
+	if authentication.Linked {
+		h.redirect(rw, r, "")
+		return
+	}
+
+	twoFactorEnabled, err := h.auth.twoFactor.IsEnabled(ctx, authentication.User.ID)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to check two factor", err)
+		h.redirect(rw, r, oidcErrorServerError)
+		return
+	}
+	if twoFactorEnabled {
+		// provider does not prove the second factor, so sign in is completed with /signin/2fa same as with password
+		challenge, err := h.auth.twoFactor.CreateChallenge(ctx, authentication.User.ID)
+		if err != nil {
+			h.log.Errorw(ctx, "failed to create two factor challenge", err)
+			h.redirect(rw, r, oidcErrorServerError)
+			return
+		}
+
+		h.log.Debugw(ctx, "two factor required", "userID", authentication.User.ID)
+		h.redirectWithQuery(rw, r, url.Values{
+			oidcTwoFactorChallengeParam: {challenge.Token},
+			oidcTwoFactorExpiresAtParam: {strconv.FormatInt(challenge.ExpiresAt.UnixMilli(), 10)},
+		})
+		return
+	}
+
+	if !h.auth.setTokens(rw, r, authentication.User, nil) {
+		return
+	}
+
+	h.redirect(rw, r, "")
+}
+
+func (h *OIDCHandler) GetIdentities(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	identities, err := h.service.GetIdentities(ctx, auth.UserID)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to get user identities", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	res := make([]*UserIdentity, 0, len(identities))
+	for _, i := range identities {
+		res = append(res, &UserIdentity{
+			ID:              i.ID,
+			Provider:        i.Provider,
+			Email:           i.Email,
+			CreatedAtMillis: i.CreatedAt.UnixMilli(),
+		})
+	}
+	h.resp.Send(ctx, rw, http.StatusOK, nil, res)
+}
+
+func (h *OIDCHandler) authorize(rw http.ResponseWriter, r *http.Request, userID uint64) {
+	ctx := r.Context()
+
+	authorization, err := h.service.Authorize(ctx, r.URL.Query().Get("provider"), userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrOIDCProviderNotFound) {
+			h.log.Debugw(ctx, "oidc provider not found")
+			h.resp.SendNotFound(ctx, rw, "Provider not found")
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to authorize with oidc provider", err)
+		h.redirect(rw, r, oidcErrorServerError)
+		return
+	}
+
+	http.SetCookie(rw, h.cookieProcessor.ToOIDCState(authorization.State, authorization.ExpiresAt))
+	rw.Header().Set(CacheControlHeader, "no-store")
+	http.Redirect(rw, r, authorization.URL, http.StatusFound)
+}
+
+// redirect sends browser back to web app, with oidc_error query parameter if errCode is not empty
+func (h *OIDCHandler) redirect(rw http.ResponseWriter, r *http.Request, errCode string) {
+	var params url.Values
+	if errCode != "" {
+		params = url.Values{oidcErrorParam: {errCode}}
+	}
+	h.redirectWithQuery(rw, r, params)
+}
+
+// redirectWithQuery sends browser back to web app with params added to query of the redirect url
+func (h *OIDCHandler) redirectWithQuery(rw http.ResponseWriter, r *http.Request, params url.Values) {
+	target, err := url.Parse(h.redirectURL)
+	if err != nil {
+		h.log.Errorw(r.Context(), "failed to parse oidc redirect url", err)
+		h.resp.SendInternalServerError(r.Context(), rw)
+		return
+	}
+
+	if len(params) > 0 {
+		query := target.Query()
+		for k, v := range params {
+			query[k] = v
+		}
+		target.RawQuery = query.Encode()
+	}
+
+	rw.Header().Set(CacheControlHeader, "no-store")
+	http.Redirect(rw, r, target.String(), http.StatusFound)
+}
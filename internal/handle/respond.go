@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
@@ -11,10 +12,24 @@ import (
 )
 
 const (
-	ContentTypeHeader     = "Content-Type"
-	ContentTypeJSON       = "application/json"
-	LastModifiedHeader    = "Last-Modified"
-	IfModifiedSinceHeader = "If-Modified-Since"
+	ContentTypeHeader        = "Content-Type"
+	ContentTypeJSON          = "application/json"
+	ContentTypeEventStream   = "text/event-stream"
+	LastModifiedHeader       = "Last-Modified"
+	ExpiresHeader            = "Expires"
+	IfModifiedSinceHeader    = "If-Modified-Since"
+	LastEventIDHeader        = "Last-Event-ID"
+	CacheControlHeader       = "Cache-Control"
+	ReferrerPolicyHeader     = "Referrer-Policy"
+	HSTSHeader               = "Strict-Transport-Security"
+	ContentTypeOptionsHeader = "X-Content-Type-Options"
+	FrameOptionsHeader       = "X-Frame-Options"
+	CSPHeader                = "Content-Security-Policy"
+	AuthorizationHeader      = "Authorization"
+	WWWAuthenticateHeader    = "WWW-Authenticate"
+	RetryAfterHeader         = "Retry-After"
+	CSRFTokenHeader          = "X-CSRF-Token"
+	ForwardedForHeader       = "X-Forwarded-For"
 )
 
 type genericErrorResponse struct {
@@ -86,6 +101,14 @@ func (r *responder) SendBadRequest(ctx context.Context, rw http.ResponseWriter,
 	})
 }
 
+func (r *responder) SendForbidden(ctx context.Context, rw http.ResponseWriter, message string) {
+	r.Send(ctx, rw, http.StatusForbidden, nil, genericErrorResponse{
+		Error:   true,
+		Code:    domain.ErrorCodeForbidden.Value,
+		Message: message,
+	})
+}
+
 func (r *responder) SendNotFound(ctx context.Context, rw http.ResponseWriter, message string) {
 	r.Send(ctx, rw, http.StatusNotFound, nil, genericErrorResponse{
 		Error:   true,
@@ -101,3 +124,30 @@ func (r *responder) SendInternalServerError(ctx context.Context, rw http.Respons
 		Message: "Internal server error",
 	})
 }
+
+// parsePagination parses limit and offset query parameters, it responds with 400 if they are not numbers
+func (r *responder) parsePagination(rw http.ResponseWriter, req *http.Request, defaultLimit int) (int, int, bool) {
+	ctx := req.Context()
+
+	limit := defaultLimit
+	if limitStr := req.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil {
+			r.log.Debugw(ctx, "failed to parse limit", "limit", limitStr, err)
+			r.SendBadRequest(ctx, rw, "limit param must be a valid int value")
+			return 0, 0, false
+		}
+	}
+
+	offset := 0
+	if offsetStr := req.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		if offset, err = strconv.Atoi(offsetStr); err != nil {
+			r.log.Debugw(ctx, "failed to parse offset", "offset", offsetStr, err)
+			r.SendBadRequest(ctx, rw, "offset param must be a valid int value")
+			return 0, 0, false
+		}
+	}
+
+	return limit, offset, true
+}
@@ -17,12 +17,26 @@ import (
 )
 
 type Dependencies struct {
-	Config config.App
+	Config  config.App
+	Streams *Streams
 	CookieProcessor
+	PublicKeysProvider
 	UserService
 	JTIService
+	RefreshTokenService
+	LoginService
+	TwoFactorService
+	SignInLimiter
+	OIDCService
+	PasswordResetService
+	AccountService
+	AdminService
+	InvitationService
 	SessionService
 	ClipboardService
+	ClipboardSubscriber
+	ShareLinkService
+	APITokenService
 }
 
 func NewRouter(ctx context.Context, deps Dependencies, log log.TracedLogger) (*chi.Mux, error) {
@@ -31,11 +45,18 @@ func NewRouter(ctx context.Context, deps Dependencies, log log.TracedLogger) (*c
 	r := chi.NewRouter()
 	conf := deps.Config
 
+	trustedProxies, err := ParseTrustedProxies(conf.Security.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Use(ClientIP(trustedProxies))
 	r.Use(TraceID)
 	r.Use(Logger(log))
 	r.Use(httprate.LimitByIP(10, 1*time.Second))
 	r.Use(middleware.RedirectSlashes)
 	r.Use(middleware.Recoverer)
+	r.Use(SecurityHeaders(conf.Security))
 	r.Use(middleware.Compress(5, "text/html", "text/css", "text/javascript"))
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   conf.CORS.AllowOrigins,
@@ -47,25 +68,118 @@ func NewRouter(ctx context.Context, deps Dependencies, log log.TracedLogger) (*c
 	}))
 
 	resp := &responder{log: log}
-
-	authHandler := NewAuthHandler(deps.UserService, deps.CookieProcessor, deps.JTIService, resp, log)
+	// clipboard content is served as is, so it must not be able to run scripts or load anything with API origin
+	clipboardCSP := ContentSecurityPolicy(conf.Security.ClipboardContentSecurityPolicy)
+
+	authHandler := NewAuthHandler(
+		deps.UserService, deps.CookieProcessor, deps.JTIService, deps.RefreshTokenService, deps.LoginService, deps.TwoFactorService, deps.SignInLimiter,
+		resp, log,
+	)
+	r.Get("/registration", authHandler.GetRegistration)
 	r.Post("/signup", authHandler.SignUp)
 	r.Post("/signin", authHandler.SignIn)
+	r.Post("/signin/2fa", authHandler.SignInTwoFactor)
 	r.Post("/signout", authHandler.SignOut)
-
-	authorizedRouter := r.With(NewAuthorizedMiddleware(deps.CookieProcessor, deps.JTIService, resp, log).Handle)
-
-	sessionHandler := NewSessionHandler(deps.SessionService, deps.ClipboardService, resp, log)
+	r.Post("/token/refresh", authHandler.Refresh)
+
+	accountHandler := NewAccountHandler(
+		deps.UserService, deps.LoginService, deps.PasswordResetService, deps.AccountService, authHandler, resp, log,
+	)
+	r.Post("/password-reset/request", accountHandler.RequestPasswordReset)
+	r.Post("/password-reset/confirm", accountHandler.ConfirmPasswordReset)
+
+	oidcHandler := NewOIDCHandler(deps.OIDCService, authHandler, deps.CookieProcessor, conf.OIDC.RedirectURL, resp, log)
+	r.Get("/auth/oidc/providers", oidcHandler.GetProviders)
+	r.Get("/auth/oidc/login", oidcHandler.Login)
+	r.Get("/auth/oidc/callback", oidcHandler.Callback)
+
+	csrfHandler := NewCSRFHandler(deps.CookieProcessor, resp, log)
+	r.Get("/csrf-token", csrfHandler.GetToken)
+
+	jwksHandler := NewJWKSHandler(deps.PublicKeysProvider, resp, log)
+	r.Get("/.well-known/jwks.json", jwksHandler.GetJWKS)
+
+	shareLinkHandler := NewShareLinkHandler(deps.ShareLinkService, deps.ClipboardService, resp, log)
+	r.With(clipboardCSP).Get(shareLinkPathPrefix+"{token}", shareLinkHandler.GetClipboard)
+
+	authorizedMiddleware := NewAuthorizedMiddleware(deps.CookieProcessor, deps.JTIService, deps.LoginService, deps.APITokenService, resp, log)
+	authorizedRouter := r.With(
+		authorizedMiddleware.Handle,
+		csrfHandler.Handle,
+	)
+
+	sessionHandler := NewSessionHandler(
+		deps.SessionService, deps.ClipboardService, deps.ClipboardSubscriber, deps.Streams, authorizedMiddleware, conf.Clipboard.MaxContentBytes,
+		resp, log,
+	)
 	authorizedRouter.Post("/v1/sessions", sessionHandler.Create)
 	authorizedRouter.Get("/v1/sessions", sessionHandler.FilterBy)
 	authorizedRouter.Get("/v1/sessions/{sessionID}", sessionHandler.GetByID)
 	authorizedRouter.Put("/v1/sessions/{sessionID}", sessionHandler.Update)
 	authorizedRouter.Delete("/v1/sessions/{sessionID}", sessionHandler.Delete)
-	authorizedRouter.Get("/v1/sessions/{sessionID}/clipboard", sessionHandler.GetClipboard)
+	authorizedRouter.Get("/v1/sessions/{sessionID}/members", sessionHandler.GetMembers)
+	authorizedRouter.Post("/v1/sessions/{sessionID}/members", sessionHandler.AddMember)
+	authorizedRouter.Put("/v1/sessions/{sessionID}/members/{userID}", sessionHandler.UpdateMember)
+	authorizedRouter.Delete("/v1/sessions/{sessionID}/members/{userID}", sessionHandler.RemoveMember)
+	authorizedRouter.Get("/v1/sessions/{sessionID}/share-links", shareLinkHandler.GetAll)
+	authorizedRouter.Post("/v1/sessions/{sessionID}/share-links", shareLinkHandler.Create)
+	authorizedRouter.Delete("/v1/sessions/{sessionID}/share-links/{linkID}", shareLinkHandler.Revoke)
+	authorizedRouter.With(clipboardCSP).Get("/v1/sessions/{sessionID}/clipboard", sessionHandler.GetClipboard)
 	authorizedRouter.Put("/v1/sessions/{sessionID}/clipboard", sessionHandler.SetClipboard)
+	authorizedRouter.Get("/v1/sessions/{sessionID}/clipboard/events", sessionHandler.ClipboardEvents)
+	authorizedRouter.Get("/v1/sessions/{sessionID}/clipboard/history", sessionHandler.GetClipboardHistory)
+	authorizedRouter.With(clipboardCSP).Get("/v1/sessions/{sessionID}/clipboard/history/{version}", sessionHandler.GetClipboardVersion)
+	authorizedRouter.Post("/v1/sessions/{sessionID}/clipboard/history/{version}/restore", sessionHandler.RestoreClipboardVersion)
+
+	syncHandler := NewSyncHandler(
+		deps.SessionService, deps.ClipboardService, deps.ClipboardSubscriber, deps.Streams, authorizedMiddleware, conf.CORS.AllowOrigins,
+		conf.Clipboard.MaxContentBytes, resp, log,
+	)
+	authorizedRouter.Get("/v1/sessions/{sessionID}/ws", syncHandler.Connect)
 
 	userHandler := NewUserHandler(resp, log)
 	authorizedRouter.Get("/v1/user/info", userHandler.GetUserInfo)
+	authorizedRouter.Delete("/v1/user", accountHandler.Delete)
+	authorizedRouter.Get("/v1/user/export", accountHandler.Export)
+	authorizedRouter.Put("/v1/user/password", accountHandler.ChangePassword)
+	authorizedRouter.Put("/v1/user/email", accountHandler.UpdateEmail)
+
+	apiTokenHandler := NewAPITokenHandler(deps.APITokenService, resp, log)
+	authorizedRouter.Get("/v1/user/tokens", apiTokenHandler.GetAll)
+	authorizedRouter.Post("/v1/user/tokens", apiTokenHandler.Create)
+	authorizedRouter.Delete("/v1/user/tokens/{tokenID}", apiTokenHandler.Revoke)
+
+	loginHandler := NewLoginHandler(deps.LoginService, deps.CookieProcessor, resp, log)
+	authorizedRouter.Get("/v1/user/logins", loginHandler.GetAll)
+	authorizedRouter.Delete("/v1/user/logins", loginHandler.RevokeAll)
+	authorizedRouter.Delete("/v1/user/logins/{loginID}", loginHandler.Revoke)
+
+	twoFactorHandler := NewTwoFactorHandler(deps.TwoFactorService, deps.SignInLimiter, resp, log)
+	authorizedRouter.Get("/v1/user/2fa", twoFactorHandler.GetStatus)
+	authorizedRouter.Post("/v1/user/2fa/totp", twoFactorHandler.Enroll)
+	authorizedRouter.Get("/v1/user/2fa/totp/qr", twoFactorHandler.GetQRCode)
+	authorizedRouter.Post("/v1/user/2fa/totp/confirm", twoFactorHandler.Confirm)
+	authorizedRouter.Post("/v1/user/2fa/disable", twoFactorHandler.Disable)
+	authorizedRouter.Post("/v1/user/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+
+	authorizedRouter.Get("/v1/user/identities", oidcHandler.GetIdentities)
+	authorizedRouter.Get("/v1/user/identities/link", oidcHandler.Link)
+
+	invitationHandler := NewInvitationHandler(deps.InvitationService, resp, log)
+	authorizedRouter.Get("/v1/user/invitations", invitationHandler.GetAll)
+	authorizedRouter.Post("/v1/user/invitations", invitationHandler.Create)
+	authorizedRouter.Delete("/v1/user/invitations/{invitationID}", invitationHandler.Revoke)
+
+	adminHandler := NewAdminHandler(deps.AdminService, resp, log)
+	adminRouter := authorizedRouter.With(adminHandler.Handle)
+	adminRouter.Get("/v1/admin/users", adminHandler.SearchUsers)
+	adminRouter.Delete("/v1/admin/users/{userID}", adminHandler.DeleteUser)
+	adminRouter.Post("/v1/admin/users/{userID}/disable", adminHandler.DisableUser)
+	adminRouter.Post("/v1/admin/users/{userID}/enable", adminHandler.EnableUser)
+	adminRouter.Post("/v1/admin/users/{userID}/password-reset", adminHandler.ForcePasswordReset)
+	adminRouter.Get("/v1/admin/users/{userID}/usage", adminHandler.GetUsage)
+	adminRouter.Get("/v1/admin/invitations", invitationHandler.GetAllOfAll)
+	adminRouter.Delete("/v1/admin/invitations/{invitationID}", invitationHandler.RevokeAny)
 
 	r.NotFound(handleNotFound(resp))
 	r.MethodNotAllowed(handleMethodNotAllowed(resp))
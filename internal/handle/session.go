@@ -4,10 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
@@ -16,46 +16,101 @@ import (
 	"github.com/Roma7-7-7/shared-clipboard/internal/log"
 )
 
+const (
+	defaultSessionsLimit = 100
+	defaultHistoryLimit  = 20
+)
+
 type (
 	sessionRequest struct {
-		Name string `json:"name"`
+		Name      string     `json:"name"`
+		Retention *Retention `json:"retention,omitempty"`
+	}
+
+	Retention struct {
+		Policy string `json:"policy"`
+		Value  int    `json:"value,omitempty"`
 	}
 
 	Session struct {
-		SessionID       uint64 `json:"session_id"`
-		Name            string `json:"name"`
-		CreatedAtMillis int64  `json:"created_at_millis"`
-		UpdatedAtMillis int64  `json:"updated_at_millis"`
+		SessionID       uint64    `json:"session_id"`
+		Name            string    `json:"name"`
+		Retention       Retention `json:"retention"`
+		Role            string    `json:"role,omitempty"`
+		CreatedAtMillis int64     `json:"created_at_millis"`
+		UpdatedAtMillis int64     `json:"updated_at_millis"`
 	}
 
 	SessionService interface {
 		GetByID(ctx context.Context, userID, id uint64) (*domain.Session, error)
 		FilterBy(ctx context.Context, userID uint64, filter domain.SessionFilter) ([]*domain.Session, int, error)
 		Create(ctx context.Context, userID uint64, name string) (*domain.Session, error)
-		Update(ctx context.Context, userID, sessionID uint64, name string) (*domain.Session, error)
+		Update(ctx context.Context, userID, sessionID uint64, name string, retention *domain.Retention) (*domain.Session, error)
 		UpdateUpdatedAt(ctx context.Context, sessionID uint64) error
 		Delete(ctx context.Context, userID, sessionID uint64) error
+		Authorize(ctx context.Context, userID, sessionID uint64, role string) (*domain.Session, error)
+		GetMembers(ctx context.Context, userID, sessionID uint64) ([]*domain.SessionMember, error)
+		AddMember(ctx context.Context, userID, sessionID uint64, memberName, role string) (*domain.SessionMember, error)
+		UpdateMemberRole(ctx context.Context, userID, sessionID, memberID uint64, role string) (*domain.SessionMember, error)
+		RemoveMember(ctx context.Context, userID, sessionID, memberID uint64) error
+	}
+
+	ClipboardEntry struct {
+		Version         uint64   `json:"version"`
+		ContentTypes    []string `json:"content_types"`
+		Size            int      `json:"size"`
+		AuthorID        uint64   `json:"author_id"`
+		UpdatedAtMillis int64    `json:"updated_at_millis"`
 	}
 
 	ClipboardService interface {
-		GetBySessionID(ctx context.Context, id uint64) (*domain.Clipboard, error)
-		SetBySessionID(ctx context.Context, id uint64, contentType string, content []byte) (*domain.Clipboard, error)
+		GetBySessionID(ctx context.Context, userID, id uint64) (*domain.Clipboard, error)
+		ReadBySessionID(ctx context.Context, userID, id uint64) (*domain.Clipboard, error)
+		ReadByShareLink(ctx context.Context, token, password string) (*domain.Clipboard, error)
+		SetBySessionID(ctx context.Context, userID, id uint64, representations []domain.Representation) (*domain.Clipboard, error)
+		GetHistory(ctx context.Context, userID, id uint64, limit, offset int) ([]*domain.Clipboard, int, error)
+		GetVersion(ctx context.Context, userID, id, version uint64) (*domain.Clipboard, error)
+		Restore(ctx context.Context, userID, id, version uint64) (*domain.Clipboard, error)
+	}
+
+	ClipboardSubscriber interface {
+		Subscribe(sessionID uint64) (<-chan *domain.ClipboardEvent, func())
 	}
 
 	SessionHandler struct {
-		resp             *responder
-		service          SessionService
-		clipboardService ClipboardService
-		log              log.TracedLogger
+		resp                *responder
+		service             SessionService
+		clipboardService    ClipboardService
+		clipboardSubscriber ClipboardSubscriber
+		streams             *Streams
+		verifier            AuthorityVerifier
+		maxContentBytes     int64
+		log                 log.TracedLogger
+	}
+
+	clipboardEvent struct {
+		SessionID       uint64   `json:"session_id"`
+		Version         uint64   `json:"version"`
+		AuthorID        uint64   `json:"author_id"`
+		ContentTypes    []string `json:"content_types"`
+		Size            int      `json:"size"`
+		UpdatedAtMillis int64    `json:"updated_at_millis"`
 	}
 )
 
-func NewSessionHandler(sessionService SessionService, clipboardService ClipboardService, resp *responder, log log.TracedLogger) *SessionHandler {
+func NewSessionHandler(
+	sessionService SessionService, clipboardService ClipboardService, clipboardSubscriber ClipboardSubscriber,
+	streams *Streams, verifier AuthorityVerifier, maxContentBytes int64, resp *responder, log log.TracedLogger,
+) *SessionHandler {
 	return &SessionHandler{
-		resp:             resp,
-		service:          sessionService,
-		clipboardService: clipboardService,
-		log:              log,
+		resp:                resp,
+		service:             sessionService,
+		clipboardService:    clipboardService,
+		clipboardSubscriber: clipboardSubscriber,
+		streams:             streams,
+		verifier:            verifier,
+		maxContentBytes:     maxContentBytes,
+		log:                 log,
 	}
 }
 
@@ -86,6 +141,10 @@ func (h *SessionHandler) GetByID(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.resp.checkScope(ctx, rw, sid, "") {
+		return
+	}
+
 	session, err := h.service.GetByID(ctx, auth.UserID, sid)
 	if err != nil {
 		if errors.Is(err, domain.ErrSessionNotFound) {
@@ -94,6 +153,12 @@ func (h *SessionHandler) GetByID(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if errors.Is(err, domain.ErrSessionPermissionDenied) {
+			h.log.Debugw(ctx, "permission denied", "sessionID", sessionID)
+			h.resp.SendForbidden(ctx, rw, "Not enough permissions for the session")
+			return
+		}
+
 		h.log.Errorw(ctx, "failed to get session", "sessionID", sessionID, err)
 		h.resp.SendInternalServerError(ctx, rw)
 		return
@@ -118,24 +183,12 @@ func (h *SessionHandler) FilterBy(rw http.ResponseWriter, r *http.Request) {
 	}
 	h.log.Debugw(ctx, "Get all sessions by user", "userID", auth.UserID)
 
-	limitStr := r.URL.Query().Get("limit")
-	if limitStr == "" {
-		limitStr = "100"
-	}
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil {
-		h.log.Debugw(ctx, "failed to parse limit", "limit", limitStr, err)
-		h.resp.SendBadRequest(ctx, rw, "limit param must be a valid int value")
+	if !h.resp.checkUnboundScope(ctx, rw, "") {
 		return
 	}
-	offsetStr := r.URL.Query().Get("offset")
-	if offsetStr == "" {
-		offsetStr = "0"
-	}
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil {
-		h.log.Debugw(ctx, "failed to parse offset", "offset", offset, err)
-		h.resp.SendBadRequest(ctx, rw, "offset param must be a valid int value")
+
+	limit, offset, ok := h.resp.parsePagination(rw, r, defaultSessionsLimit)
+	if !ok {
 		return
 	}
 
@@ -176,6 +229,10 @@ func (h *SessionHandler) Create(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.resp.checkUnboundScope(ctx, rw, domain.PermissionSessionsManage) {
+		return
+	}
+
 	var req sessionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.log.Debugw(ctx, "failed to decode body", err)
@@ -228,6 +285,10 @@ func (h *SessionHandler) Update(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionSessionsManage) {
+		return
+	}
+
 	var req sessionRequest
 	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.log.Debugw(ctx, "failed to decode body", err)
@@ -235,8 +296,19 @@ func (h *SessionHandler) Update(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := h.service.Update(ctx, auth.UserID, sid, req.Name)
+	var retention *domain.Retention
+	if req.Retention != nil {
+		retention = &domain.Retention{Policy: req.Retention.Policy, Value: req.Retention.Value}
+	}
+
+	session, err := h.service.Update(ctx, auth.UserID, sid, req.Name, retention)
 	if err != nil {
+		if errors.Is(err, domain.ErrInvalidRetention) {
+			h.log.Debugw(ctx, "invalid retention", "sessionID", sessionID, err)
+			h.resp.SendBadRequest(ctx, rw, err.Error())
+			return
+		}
+
 		if errors.Is(err, domain.ErrSessionNotFound) {
 			h.log.Debugw(ctx, "session not found", "sessionID", sessionID)
 			h.resp.SendNotFound(ctx, rw, "Session with provided ID not found")
@@ -245,7 +317,7 @@ func (h *SessionHandler) Update(rw http.ResponseWriter, r *http.Request) {
 
 		if errors.Is(err, domain.ErrSessionPermissionDenied) {
 			h.log.Debugw(ctx, "permission denied", "sessionID", sessionID)
-			h.resp.SendNotFound(ctx, rw, "session not found")
+			h.resp.SendForbidden(ctx, rw, "Not enough permissions for the session")
 			return
 		}
 
@@ -286,6 +358,10 @@ func (h *SessionHandler) Delete(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionSessionsManage) {
+		return
+	}
+
 	if err = h.service.Delete(ctx, auth.UserID, sid); err != nil {
 		if errors.Is(err, domain.ErrSessionNotFound) {
 			h.log.Debugw(ctx, "session not found", "sessionID", sessionID)
@@ -295,7 +371,7 @@ func (h *SessionHandler) Delete(rw http.ResponseWriter, r *http.Request) {
 
 		if errors.Is(err, domain.ErrSessionPermissionDenied) {
 			h.log.Debugw(ctx, "permission denied", "sessionID", sessionID)
-			h.resp.SendNotFound(ctx, rw, "session not found")
+			h.resp.SendForbidden(ctx, rw, "Not enough permissions for the session")
 			return
 		}
 
@@ -327,13 +403,28 @@ func (h *SessionHandler) GetClipboard(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clipboard, err := h.clipboardService.GetBySessionID(ctx, sid)
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionClipboardRead) {
+		return
+	}
+
+	clipboard, err := h.clipboardService.GetBySessionID(ctx, auth.UserID, sid)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			h.log.Debugw(ctx, "clipboard not found", "id", sessionID)
 			rw.WriteHeader(http.StatusNoContent)
 			return
 		}
+		if h.resp.sendSessionAccessError(ctx, rw, err) {
+			h.log.Debugw(ctx, "session access denied", "id", sessionID, err)
+			return
+		}
 
 		h.log.Errorw(ctx, "failed to get clipboard", err)
 		h.resp.SendInternalServerError(ctx, rw)
@@ -347,12 +438,29 @@ func (h *SessionHandler) GetClipboard(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// content is delivered only now, so only now it counts as a read
+	if clipboard, err = h.clipboardService.ReadBySessionID(ctx, auth.UserID, sid); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			h.log.Debugw(ctx, "clipboard not found", "id", sessionID)
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if h.resp.sendSessionAccessError(ctx, rw, err) {
+			h.log.Debugw(ctx, "session access denied", "id", sessionID, err)
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to read clipboard", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
 	h.log.Debugw(ctx, "Got session", "id", sid)
-	rw.Header().Set(LastModifiedHeader, lastModified)
-	rw.Header().Set(ContentTypeHeader, clipboard.ContentType)
-	if _, err = rw.Write(clipboard.Content); err != nil {
-		h.log.Errorw(ctx, "failed to write content", err)
+	rw.Header().Set(LastModifiedHeader, clipboard.UpdatedAt.UTC().Format(http.TimeFormat))
+	if !clipboard.ExpiresAt.IsZero() {
+		rw.Header().Set(ExpiresHeader, clipboard.ExpiresAt.UTC().Format(http.TimeFormat))
 	}
+	writeClipboardContent(ctx, rw, r, clipboard, h.log)
 }
 
 func (h *SessionHandler) SetClipboard(rw http.ResponseWriter, r *http.Request) {
@@ -362,9 +470,9 @@ func (h *SessionHandler) SetClipboard(rw http.ResponseWriter, r *http.Request) {
 		sessionID   = chi.URLParam(r, "sessionID")
 	)
 
-	if strings.ToLower(contentType) != "text/plain" {
-		h.log.Debugw(ctx, "Content-Type is not text/plain")
-		h.resp.SendBadRequest(ctx, rw, "Content-Type text/plain is required")
+	if contentType == "" {
+		h.log.Debugw(ctx, "Content-Type is empty")
+		h.resp.SendBadRequest(ctx, rw, "Content-Type header is required")
 		return
 	}
 
@@ -374,13 +482,74 @@ func (h *SessionHandler) SetClipboard(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	sid, err := strconv.ParseUint(sessionID, 10, 64)
 	if err != nil {
+		h.log.Errorw(ctx, "failed to parse sessionID", err)
+		h.resp.SendBadRequest(ctx, rw, "sessionID param must be a valid uint64 value")
+		return
+	}
+
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionClipboardWrite) {
+		return
+	}
+
+	representations, err := readRepresentations(rw, r, h.maxContentBytes)
+	if err != nil {
+		if h.resp.sendContentError(ctx, rw, err) {
+			h.log.Debugw(ctx, "failed to read representations", err)
+			return
+		}
+
 		h.log.Errorw(ctx, "failed to read body", err)
 		h.resp.SendInternalServerError(ctx, rw)
 		return
 	}
 
+	clipboard, err := h.clipboardService.SetBySessionID(ctx, auth.UserID, sid, representations)
+	if err != nil {
+		if h.resp.sendSessionAccessError(ctx, rw, err) {
+			h.log.Debugw(ctx, "session access denied", "id", sessionID, err)
+			return
+		}
+		if h.resp.sendContentError(ctx, rw, err) {
+			h.log.Debugw(ctx, "content rejected", "id", sessionID, err)
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to set content", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+	go func() {
+		if err := h.service.UpdateUpdatedAt(ctx, sid); err != nil {
+			h.log.Errorw(ctx, "failed to update session updated_at", err)
+		}
+	}()
+
+	h.log.Debugw(ctx, "Set content", "id", sessionID)
+	rw.Header().Set(LastModifiedHeader, clipboard.UpdatedAt.UTC().Format(http.TimeFormat))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SessionHandler) GetClipboardHistory(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx       = r.Context()
+		sessionID = chi.URLParam(r, "sessionID")
+	)
+
+	if sessionID == "" {
+		h.log.Debugw(ctx, "sessionID is empty")
+		h.resp.SendBadRequest(ctx, rw, "sessionID param is required")
+		return
+	}
+
 	sid, err := strconv.ParseUint(sessionID, 10, 64)
 	if err != nil {
 		h.log.Errorw(ctx, "failed to parse sessionID", err)
@@ -388,15 +557,131 @@ func (h *SessionHandler) SetClipboard(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clipboard, err := h.clipboardService.SetBySessionID(ctx, sid, contentType, body)
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionClipboardRead) {
+		return
+	}
+
+	limit, offset, ok := h.resp.parsePagination(rw, r, defaultHistoryLimit)
+	if !ok {
+		return
+	}
+
+	entries, total, err := h.clipboardService.GetHistory(ctx, auth.UserID, sid, limit, offset)
+	if err != nil {
+		if h.resp.sendSessionAccessError(ctx, rw, err) {
+			h.log.Debugw(ctx, "session access denied", "id", sessionID, err)
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to get clipboard history", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Got clipboard history", "id", sid, "count", len(entries))
+	res := make([]*ClipboardEntry, 0, len(entries))
+	for _, entry := range entries {
+		res = append(res, toClipboardEntryDTO(entry))
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &paginatedResponse{
+		Items:      res,
+		TotalItems: total,
+	})
+}
+
+func (h *SessionHandler) GetClipboardVersion(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx       = r.Context()
+		sessionID = chi.URLParam(r, "sessionID")
+		version   = chi.URLParam(r, "version")
+	)
+
+	sid, ver, ok := h.parseSessionIDAndVersion(rw, r, sessionID, version)
+	if !ok {
+		return
+	}
+
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionClipboardRead) {
+		return
+	}
+
+	clipboard, err := h.clipboardService.GetVersion(ctx, auth.UserID, sid, ver)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
-			h.log.Debugw(ctx, "session not found", "id", sessionID)
-			h.resp.SendNotFound(ctx, rw, "Session with provided ID not found")
+			h.log.Debugw(ctx, "clipboard version not found", "id", sessionID, "version", version)
+			h.resp.SendNotFound(ctx, rw, "Clipboard version not found")
+			return
+		}
+		if h.resp.sendSessionAccessError(ctx, rw, err) {
+			h.log.Debugw(ctx, "session access denied", "id", sessionID, err)
 			return
 		}
 
-		h.log.Errorw(ctx, "failed to set content", err)
+		h.log.Errorw(ctx, "failed to get clipboard version", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Got clipboard version", "id", sid, "version", ver)
+	rw.Header().Set(LastModifiedHeader, clipboard.UpdatedAt.UTC().Format(http.TimeFormat))
+	writeClipboardContent(ctx, rw, r, clipboard, h.log)
+}
+
+func (h *SessionHandler) RestoreClipboardVersion(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx       = r.Context()
+		sessionID = chi.URLParam(r, "sessionID")
+		version   = chi.URLParam(r, "version")
+	)
+
+	sid, ver, ok := h.parseSessionIDAndVersion(rw, r, sessionID, version)
+	if !ok {
+		return
+	}
+
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionClipboardWrite) {
+		return
+	}
+
+	clipboard, err := h.clipboardService.Restore(ctx, auth.UserID, sid, ver)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			h.log.Debugw(ctx, "clipboard version not found", "id", sessionID, "version", version)
+			h.resp.SendNotFound(ctx, rw, "Clipboard version not found")
+			return
+		}
+		if h.resp.sendSessionAccessError(ctx, rw, err) {
+			h.log.Debugw(ctx, "session access denied", "id", sessionID, err)
+			return
+		}
+		if h.resp.sendContentError(ctx, rw, err) {
+			h.log.Debugw(ctx, "content rejected", "id", sessionID, err)
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to restore clipboard version", err)
 		h.resp.SendInternalServerError(ctx, rw)
 		return
 	}
@@ -406,15 +691,199 @@ func (h *SessionHandler) SetClipboard(rw http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	h.log.Debugw(ctx, "Set content", "id", sessionID)
-	rw.Header().Set(LastModifiedHeader, clipboard.UpdatedAt.UTC().Format(http.TimeFormat))
-	rw.WriteHeader(http.StatusNoContent)
+	h.log.Debugw(ctx, "Restored clipboard version", "id", sid, "version", ver, "newVersion", clipboard.Version)
+	h.resp.Send(ctx, rw, http.StatusOK, map[string][]string{
+		LastModifiedHeader: {clipboard.UpdatedAt.UTC().Format(http.TimeFormat)},
+	}, toClipboardEntryDTO(clipboard))
+}
+
+func (h *SessionHandler) ClipboardEvents(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx         = r.Context()
+		lastEventID = r.Header.Get(LastEventIDHeader)
+		sessionID   = chi.URLParam(r, "sessionID")
+	)
+
+	if sessionID == "" {
+		h.log.Debugw(ctx, "sessionID is empty")
+		h.resp.SendBadRequest(ctx, rw, "sessionID param is required")
+		return
+	}
+
+	sid, err := strconv.ParseUint(sessionID, 10, 64)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to parse sessionID", err)
+		h.resp.SendBadRequest(ctx, rw, "sessionID param must be a valid uint64 value")
+		return
+	}
+
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionClipboardRead) {
+		return
+	}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		h.log.Errorw(ctx, "response writer does not support flushing")
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	release := h.streams.Track()
+	defer release()
+
+	// subscribe before reading the current clipboard, so no update is lost in between
+	events, unsubscribe := h.clipboardSubscriber.Subscribe(sid)
+	defer unsubscribe()
+
+	// clipboard is read before response is started, so access errors can still be sent
+	clipboard, err := h.clipboardService.GetBySessionID(ctx, auth.UserID, sid)
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		if h.resp.sendSessionAccessError(ctx, rw, err) {
+			h.log.Debugw(ctx, "session access denied", "id", sessionID, err)
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to get clipboard", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Streaming clipboard events", "id", sid, "lastEventID", lastEventID)
+	rw.Header().Set(ContentTypeHeader, ContentTypeEventStream)
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+
+	sse := &sseWriter{rw: rw, flusher: flusher}
+	if err = sse.retry(sseRetryInterval); err != nil {
+		h.log.Debugw(ctx, "failed to write retry", err)
+		return
+	}
+
+	if clipboard != nil {
+		event := domain.NewClipboardEvent(clipboard)
+		if isNewerEvent(event, lastEventID) {
+			if err = h.writeClipboardEvent(sse, event); err != nil {
+				h.log.Debugw(ctx, "failed to write clipboard event", err)
+				return
+			}
+			lastEventID = event.ID()
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	authorityCheck := time.NewTicker(streamAuthorityCheckPeriod)
+	defer authorityCheck.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.log.Debugw(ctx, "Client disconnected", "id", sid)
+			return
+		case <-h.streams.Done():
+			h.log.Debugw(ctx, "Server is shutting down, closing clipboard events stream", "id", sid)
+			return
+		case <-heartbeat.C:
+			if err = sse.comment("heartbeat"); err != nil {
+				h.log.Debugw(ctx, "failed to write heartbeat", err)
+				return
+			}
+		case <-authorityCheck.C:
+			if err = h.verifier.Verify(r); err != nil {
+				// client reconnects and is refused if credentials expired or were revoked
+				var authErr *authenticationError
+				if errors.As(err, &authErr) {
+					h.log.Debugw(ctx, "Credentials are not valid anymore, closing clipboard events stream", "id", sid, err)
+				} else {
+					h.log.Errorw(ctx, "failed to verify clipboard events stream credentials", err)
+				}
+				return
+			}
+		case event := <-events:
+			if !isNewerEvent(event, lastEventID) {
+				continue
+			}
+			if err = h.writeClipboardEvent(sse, event); err != nil {
+				h.log.Debugw(ctx, "failed to write clipboard event", err)
+				return
+			}
+			lastEventID = event.ID()
+		}
+	}
+}
+
+func (h *SessionHandler) writeClipboardEvent(sse *sseWriter, event *domain.ClipboardEvent) error {
+	return sse.event(event.ID(), clipboardEventName, &clipboardEvent{
+		SessionID:       event.SessionID,
+		Version:         event.Version,
+		AuthorID:        event.AuthorID,
+		ContentTypes:    event.ContentTypes,
+		Size:            event.Size,
+		UpdatedAtMillis: event.UpdatedAt.UnixMilli(),
+	})
+}
+
+func isNewerEvent(event *domain.ClipboardEvent, lastEventID string) bool {
+	if lastEventID == "" {
+		return true
+	}
+	last, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return true
+	}
+	return event.Version > last
+}
+
+func (h *SessionHandler) parseSessionIDAndVersion(rw http.ResponseWriter, r *http.Request, sessionID, version string) (uint64, uint64, bool) {
+	ctx := r.Context()
+
+	if sessionID == "" {
+		h.log.Debugw(ctx, "sessionID is empty")
+		h.resp.SendBadRequest(ctx, rw, "sessionID param is required")
+		return 0, 0, false
+	}
+
+	sid, err := strconv.ParseUint(sessionID, 10, 64)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to parse sessionID", err)
+		h.resp.SendBadRequest(ctx, rw, "sessionID param must be a valid uint64 value")
+		return 0, 0, false
+	}
+
+	ver, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse version", err)
+		h.resp.SendBadRequest(ctx, rw, "version param must be a valid uint64 value")
+		return 0, 0, false
+	}
+
+	return sid, ver, true
+}
+
+func toClipboardEntryDTO(clipboard *domain.Clipboard) *ClipboardEntry {
+	return &ClipboardEntry{
+		Version:         clipboard.Version,
+		ContentTypes:    clipboard.ContentTypes(),
+		Size:            clipboard.Size(),
+		AuthorID:        clipboard.AuthorID,
+		UpdatedAtMillis: clipboard.UpdatedAt.UnixMilli(),
+	}
 }
 
 func toDTO(session *domain.Session) *Session {
 	return &Session{
 		SessionID:       session.ID,
 		Name:            session.Name,
+		Retention:       Retention{Policy: session.Retention.Policy, Value: session.Retention.Value},
+		Role:            session.Role,
 		CreatedAtMillis: session.CreatedAt.UnixMilli(),
 		UpdatedAtMillis: session.UpdatedAt.UnixMilli(),
 	}
@@ -0,0 +1,221 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	ac "github.com/Roma7-7-7/shared-clipboard/internal/context"
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+)
+
+type (
+	memberRequest struct {
+		UserName string `json:"user_name"`
+		Role     string `json:"role"`
+	}
+
+	SessionMember struct {
+		UserID          uint64 `json:"user_id"`
+		UserName        string `json:"user_name"`
+		Role            string `json:"role"`
+		CreatedAtMillis int64  `json:"created_at_millis"`
+		UpdatedAtMillis int64  `json:"updated_at_millis"`
+	}
+)
+
+func (h *SessionHandler) GetMembers(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, sid, ok := h.parseMemberRequest(rw, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.service.GetMembers(ctx, auth.UserID, sid)
+	if err != nil {
+		if h.sendMemberError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to get session members", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Got session members", "id", sid, "count", len(members))
+	res := make([]*SessionMember, 0, len(members))
+	for _, m := range members {
+		res = append(res, toMemberDTO(m))
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &paginatedResponse{
+		Items:      res,
+		TotalItems: len(res),
+	})
+}
+
+func (h *SessionHandler) AddMember(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, sid, ok := h.parseMemberRequest(rw, r)
+	if !ok {
+		return
+	}
+
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+	if req.UserName == "" {
+		h.log.Debugw(ctx, "user name is empty")
+		h.resp.SendBadRequest(ctx, rw, "user_name param is required")
+		return
+	}
+
+	member, err := h.service.AddMember(ctx, auth.UserID, sid, req.UserName, req.Role)
+	if err != nil {
+		if h.sendMemberError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to add session member", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Added session member", "id", sid, "memberID", member.UserID)
+	h.resp.Send(ctx, rw, http.StatusCreated, nil, toMemberDTO(member))
+}
+
+func (h *SessionHandler) UpdateMember(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, sid, ok := h.parseMemberRequest(rw, r)
+	if !ok {
+		return
+	}
+	memberID, ok := h.parseMemberID(rw, r)
+	if !ok {
+		return
+	}
+
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	member, err := h.service.UpdateMemberRole(ctx, auth.UserID, sid, memberID, req.Role)
+	if err != nil {
+		if h.sendMemberError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to update session member", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Updated session member", "id", sid, "memberID", memberID)
+	h.resp.Send(ctx, rw, http.StatusOK, nil, toMemberDTO(member))
+}
+
+func (h *SessionHandler) RemoveMember(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, sid, ok := h.parseMemberRequest(rw, r)
+	if !ok {
+		return
+	}
+	memberID, ok := h.parseMemberID(rw, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RemoveMember(ctx, auth.UserID, sid, memberID); err != nil {
+		if h.sendMemberError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to remove session member", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Removed session member", "id", sid, "memberID", memberID)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SessionHandler) parseMemberRequest(rw http.ResponseWriter, r *http.Request) (*ac.Authority, uint64, bool) {
+	var (
+		ctx       = r.Context()
+		sessionID = chi.URLParam(r, "sessionID")
+	)
+
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return nil, 0, false
+	}
+
+	sid, err := strconv.ParseUint(sessionID, 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse sessionID", err)
+		h.resp.SendBadRequest(ctx, rw, "sessionID param must be a valid uint64 value")
+		return nil, 0, false
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionSessionsManage) {
+		return nil, 0, false
+	}
+
+	return auth, sid, true
+}
+
+func (h *SessionHandler) parseMemberID(rw http.ResponseWriter, r *http.Request) (uint64, bool) {
+	ctx := r.Context()
+
+	memberID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse userID", err)
+		h.resp.SendBadRequest(ctx, rw, "userID param must be a valid uint64 value")
+		return 0, false
+	}
+
+	return memberID, true
+}
+
+func (h *SessionHandler) sendMemberError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	var re *domain.RenderableError
+	switch {
+	case h.resp.sendSessionAccessError(ctx, rw, err):
+	case errors.Is(err, domain.ErrSessionMemberNotFound):
+		h.resp.SendNotFound(ctx, rw, "Session member not found")
+	case errors.As(err, &re):
+		h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+	default:
+		return false
+	}
+
+	h.log.Debugw(ctx, "session member request rejected", err)
+	return true
+}
+
+func toMemberDTO(member *domain.SessionMember) *SessionMember {
+	return &SessionMember{
+		UserID:          member.UserID,
+		UserName:        member.UserName,
+		Role:            member.Role,
+		CreatedAtMillis: member.CreatedAt.UnixMilli(),
+		UpdatedAtMillis: member.UpdatedAt.UnixMilli(),
+	}
+}
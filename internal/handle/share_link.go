@@ -0,0 +1,258 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	ac "github.com/Roma7-7-7/shared-clipboard/internal/context"
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const shareLinkPathPrefix = "/s/"
+
+type (
+	shareLinkRequest struct {
+		ExpireInMinutes int    `json:"expire_in_minutes,omitempty"`
+		SingleUse       bool   `json:"single_use"`
+		Password        string `json:"password,omitempty"`
+	}
+
+	ShareLink struct {
+		ShareLinkID uint64 `json:"share_link_id"`
+		// Token and Path are only returned when link is created
+		Token                string `json:"token,omitempty"`
+		Path                 string `json:"path,omitempty"`
+		PasswordProtected    bool   `json:"password_protected"`
+		SingleUse            bool   `json:"single_use"`
+		AccessCount          int    `json:"access_count"`
+		ExpiresAtMillis      int64  `json:"expires_at_millis"`
+		RevokedAtMillis      int64  `json:"revoked_at_millis,omitempty"`
+		LastAccessedAtMillis int64  `json:"last_accessed_at_millis,omitempty"`
+		CreatedAtMillis      int64  `json:"created_at_millis"`
+	}
+
+	ShareLinkService interface {
+		Create(ctx context.Context, userID, sessionID uint64, expireIn time.Duration, singleUse bool, password string) (*domain.ShareLink, error)
+		GetAll(ctx context.Context, userID, sessionID uint64) ([]*domain.ShareLink, error)
+		Revoke(ctx context.Context, userID, sessionID, linkID uint64) error
+	}
+
+	ShareLinkHandler struct {
+		resp             *responder
+		service          ShareLinkService
+		clipboardService ClipboardService
+		log              log.TracedLogger
+	}
+)
+
+func NewShareLinkHandler(service ShareLinkService, clipboardService ClipboardService, resp *responder, log log.TracedLogger) *ShareLinkHandler {
+	return &ShareLinkHandler{
+		resp:             resp,
+		service:          service,
+		clipboardService: clipboardService,
+		log:              log,
+	}
+}
+
+func (h *ShareLinkHandler) Create(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, sid, ok := h.parseShareLinkRequest(rw, r)
+	if !ok {
+		return
+	}
+
+	var req shareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	link, err := h.service.Create(ctx, auth.UserID, sid, time.Duration(req.ExpireInMinutes)*time.Minute, req.SingleUse, req.Password)
+	if err != nil {
+		if h.sendShareLinkError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to create share link", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Created share link", "id", sid, "linkID", link.ID)
+	h.resp.Send(ctx, rw, http.StatusCreated, nil, toShareLinkDTO(link))
+}
+
+func (h *ShareLinkHandler) GetAll(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, sid, ok := h.parseShareLinkRequest(rw, r)
+	if !ok {
+		return
+	}
+
+	links, err := h.service.GetAll(ctx, auth.UserID, sid)
+	if err != nil {
+		if h.sendShareLinkError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to get share links", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Got share links", "id", sid, "count", len(links))
+	res := make([]*ShareLink, 0, len(links))
+	for _, l := range links {
+		res = append(res, toShareLinkDTO(l))
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &paginatedResponse{
+		Items:      res,
+		TotalItems: len(res),
+	})
+}
+
+func (h *ShareLinkHandler) Revoke(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, sid, ok := h.parseShareLinkRequest(rw, r)
+	if !ok {
+		return
+	}
+
+	linkID, err := strconv.ParseUint(chi.URLParam(r, "linkID"), 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse linkID", err)
+		h.resp.SendBadRequest(ctx, rw, "linkID param must be a valid uint64 value")
+		return
+	}
+
+	if err = h.service.Revoke(ctx, auth.UserID, sid, linkID); err != nil {
+		if h.sendShareLinkError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to revoke share link", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.log.Debugw(ctx, "Revoked share link", "id", sid, "linkID", linkID)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// GetClipboard is a public endpoint returning clipboard content of the session the link was created for.
+// Password of protected links is accepted as password of basic authorization, so browsers can prompt for it.
+func (h *ShareLinkHandler) GetClipboard(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx   = r.Context()
+		token = chi.URLParam(r, "token")
+		re    *domain.RenderableError
+	)
+	_, password, _ := r.BasicAuth()
+
+	// links are secrets, they must not leak through caches or referrers
+	rw.Header().Set(CacheControlHeader, "no-store")
+	rw.Header().Set(ReferrerPolicyHeader, "no-referrer")
+
+	clipboard, err := h.clipboardService.ReadByShareLink(ctx, token, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrShareLinkNotFound):
+			h.log.Debugw(ctx, "share link not found")
+			h.resp.SendNotFound(ctx, rw, "Share link not found or expired")
+		case errors.Is(err, domain.ErrNotFound):
+			h.log.Debugw(ctx, "shared clipboard not found")
+			rw.WriteHeader(http.StatusNoContent)
+		case errors.As(err, &re):
+			h.log.Debugw(ctx, "share link access rejected", err)
+			if re.Code.StatusCode == http.StatusUnauthorized {
+				rw.Header().Set(WWWAuthenticateHeader, `Basic realm="share link", charset="UTF-8"`)
+			}
+			h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+		default:
+			h.log.Errorw(ctx, "failed to read shared clipboard", err)
+			h.resp.SendInternalServerError(ctx, rw)
+		}
+		return
+	}
+
+	h.log.Debugw(ctx, "Got shared clipboard", "id", clipboard.SessionID)
+	rw.Header().Set(LastModifiedHeader, clipboard.UpdatedAt.UTC().Format(http.TimeFormat))
+	if !clipboard.ExpiresAt.IsZero() {
+		rw.Header().Set(ExpiresHeader, clipboard.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	writeClipboardContent(ctx, rw, r, clipboard, h.log)
+}
+
+func (h *ShareLinkHandler) parseShareLinkRequest(rw http.ResponseWriter, r *http.Request) (*ac.Authority, uint64, bool) {
+	ctx := r.Context()
+
+	auth, ok := ac.AuthorityFrom(ctx)
+	if !ok {
+		h.log.Debugw(ctx, "user not found in context")
+		h.resp.SendUnauthorized(ctx, rw)
+		return nil, 0, false
+	}
+
+	sid, err := strconv.ParseUint(chi.URLParam(r, "sessionID"), 10, 64)
+	if err != nil {
+		h.log.Debugw(ctx, "failed to parse sessionID", err)
+		h.resp.SendBadRequest(ctx, rw, "sessionID param must be a valid uint64 value")
+		return nil, 0, false
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionSessionsManage) {
+		return nil, 0, false
+	}
+
+	return auth, sid, true
+}
+
+func (h *ShareLinkHandler) sendShareLinkError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	var re *domain.RenderableError
+	switch {
+	case h.resp.sendSessionAccessError(ctx, rw, err):
+	case errors.Is(err, domain.ErrShareLinkNotFound):
+		h.resp.SendNotFound(ctx, rw, "Share link not found")
+	case errors.As(err, &re):
+		h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+	default:
+		return false
+	}
+
+	h.log.Debugw(ctx, "share link request rejected", err)
+	return true
+}
+
+func toShareLinkDTO(link *domain.ShareLink) *ShareLink {
+	res := &ShareLink{
+		ShareLinkID:       link.ID,
+		Token:             link.Token,
+		PasswordProtected: link.PasswordProtected,
+		SingleUse:         link.SingleUse,
+		AccessCount:       link.AccessCount,
+		ExpiresAtMillis:   link.ExpiresAt.UnixMilli(),
+		CreatedAtMillis:   link.CreatedAt.UnixMilli(),
+	}
+	if link.Token != "" {
+		res.Path = shareLinkPathPrefix + link.Token
+	}
+	if !link.RevokedAt.IsZero() {
+		res.RevokedAtMillis = link.RevokedAt.UnixMilli()
+	}
+	if !link.LastAccessedAt.IsZero() {
+		res.LastAccessedAtMillis = link.LastAccessedAt.UnixMilli()
+	}
+	return res
+}
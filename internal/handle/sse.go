@@ -0,0 +1,44 @@
+package handle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	clipboardEventName   = "clipboard"
+	sseRetryInterval     = 3 * time.Second
+	sseHeartbeatInterval = 15 * time.Second
+)
+
+type sseWriter struct {
+	rw      http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (w *sseWriter) retry(interval time.Duration) error {
+	return w.write(fmt.Sprintf("retry: %d\n\n", interval.Milliseconds()))
+}
+
+func (w *sseWriter) comment(text string) error {
+	return w.write(fmt.Sprintf(": %s\n\n", text))
+}
+
+func (w *sseWriter) event(id, name string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal event data: %w", err)
+	}
+
+	return w.write(fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", id, name, body))
+}
+
+func (w *sseWriter) write(s string) error {
+	if _, err := w.rw.Write([]byte(s)); err != nil {
+		return fmt.Errorf("write event stream: %w", err)
+	}
+	w.flusher.Flush()
+	return nil
+}
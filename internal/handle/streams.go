@@ -0,0 +1,63 @@
+package handle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamAuthorityCheckPeriod is how often credentials of long-lived connections are authenticated again
+const streamAuthorityCheckPeriod = time.Minute
+
+// AuthorityVerifier authenticates request of long-lived connection again, so the connection is closed once
+// credentials it was opened with expire or are revoked. It returns *authenticationError if they are not valid anymore.
+type AuthorityVerifier interface {
+	Verify(r *http.Request) error
+}
+
+// Streams keeps track of long-lived connections (event streams, web sockets) and signals them that the server
+// is shutting down, as http.Server.Shutdown neither interrupts active handlers nor waits for hijacked connections.
+type Streams struct {
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func NewStreams() *Streams {
+	return &Streams{
+		done: make(chan struct{}),
+	}
+}
+
+// Track registers a connection and returns a function that must be called once it is closed.
+func (s *Streams) Track() func() {
+	s.wg.Add(1)
+	return s.wg.Done
+}
+
+func (s *Streams) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Streams) Close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *Streams) Wait(ctx context.Context) error {
+	closed := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for streams to close: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,340 @@
+package handle
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+
+	ac "github.com/Roma7-7-7/shared-clipboard/internal/context"
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	wsMessageTypePublish   = "publish"
+	wsMessageTypePull      = "pull"
+	wsMessageTypeClipboard = "clipboard"
+	wsMessageTypeAck       = "ack"
+	wsMessageTypeError     = "error"
+
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMessageOverhead is a room for message fields other than base64 encoded content
+	wsMessageOverhead = 4 << 10
+)
+
+type (
+	// wsMessage is a single message of the sync protocol.
+	// Client sends "publish" (representations) and "pull" messages with an optional id.
+	// Server replies with "ack" (updated_at_millis) to "publish", with "clipboard" to "pull"
+	// and with "error" (code, message) to any message it failed to process, echoing the id.
+	// Server also pushes "clipboard" messages whenever clipboard is changed from another device.
+	// Connection is closed with policy violation code once credentials it was opened with expire or are revoked.
+	// Representations carry the same copy in several formats (content_type and base64 encoded content),
+	// the first one is the primary.
+	wsMessage struct {
+		Type            string                    `json:"type"`
+		ID              string                    `json:"id,omitempty"`
+		SessionID       uint64                    `json:"session_id,omitempty"`
+		Representations []ClipboardRepresentation `json:"representations,omitempty"`
+		UpdatedAtMillis int64                     `json:"updated_at_millis,omitempty"`
+		Code            string                    `json:"code,omitempty"`
+		Message         string                    `json:"message,omitempty"`
+	}
+
+	SyncHandler struct {
+		upgrader            websocket.Upgrader
+		resp                *responder
+		authorizer          *sessionAuthorizer
+		verifier            AuthorityVerifier
+		clipboardService    ClipboardService
+		clipboardSubscriber ClipboardSubscriber
+		streams             *Streams
+		maxMessageSize      int64
+		log                 log.TracedLogger
+	}
+
+	syncConnection struct {
+		ctx       context.Context
+		userID    uint64
+		sessionID uint64
+		conn      *websocket.Conn
+		// request is the upgraded request, its credentials are verified while connection is open
+		request *http.Request
+		// scope restricts connection opened with API token, it is nil otherwise
+		scope *ac.Scope
+		// lastEventID is an ID of the last clipboard state the client is aware of
+		lastEventID string
+	}
+)
+
+func NewSyncHandler(
+	sessionService SessionService, clipboardService ClipboardService, clipboardSubscriber ClipboardSubscriber, streams *Streams,
+	verifier AuthorityVerifier, allowedOrigins []string, maxContentBytes int64, resp *responder, log log.TracedLogger,
+) *SyncHandler {
+	return &SyncHandler{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				// non-browser clients do not send origin
+				origin := r.Header.Get("Origin")
+				return origin == "" || slices.Contains(allowedOrigins, origin)
+			},
+		},
+		resp:                resp,
+		authorizer:          &sessionAuthorizer{service: sessionService, resp: resp, log: log},
+		verifier:            verifier,
+		clipboardService:    clipboardService,
+		clipboardSubscriber: clipboardSubscriber,
+		streams:             streams,
+		maxMessageSize:      int64(base64.StdEncoding.EncodedLen(int(maxContentBytes))) + wsMessageOverhead,
+		log:                 log,
+	}
+}
+
+func (h *SyncHandler) Connect(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx       = r.Context()
+		sessionID = chi.URLParam(r, "sessionID")
+	)
+
+	if sessionID == "" {
+		h.log.Debugw(ctx, "sessionID is empty")
+		h.resp.SendBadRequest(ctx, rw, "sessionID param is required")
+		return
+	}
+
+	sid, err := strconv.ParseUint(sessionID, 10, 64)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to parse sessionID", err)
+		h.resp.SendBadRequest(ctx, rw, "sessionID param must be a valid uint64 value")
+		return
+	}
+
+	if !h.resp.checkScope(ctx, rw, sid, domain.PermissionClipboardRead) {
+		return
+	}
+
+	auth, ok := h.authorizer.authorize(rw, r, sid, domain.SessionRoleViewer)
+	if !ok {
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// upgrader has already replied with an error
+		h.log.Debugw(ctx, "failed to upgrade connection", err)
+		return
+	}
+	release := h.streams.Track()
+	defer release()
+	defer func() {
+		if err := conn.Close(); err != nil {
+			h.log.Debugw(ctx, "failed to close connection", err)
+		}
+	}()
+
+	h.log.Debugw(ctx, "Sync connection opened", "id", sid)
+	scope, _ := ac.ScopeFrom(ctx)
+	h.serve(&syncConnection{ctx: ctx, request: r, userID: auth.UserID, sessionID: sid, scope: scope, conn: conn})
+	h.log.Debugw(ctx, "Sync connection closed", "id", sid)
+}
+
+func (h *SyncHandler) serve(c *syncConnection) {
+	events, unsubscribe := h.clipboardSubscriber.Subscribe(c.sessionID)
+	defer unsubscribe()
+
+	incoming := make(chan *wsMessage)
+	readDone := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go h.read(c, incoming, readDone, stop)
+
+	if err := h.pushClipboard(c, ""); err != nil {
+		h.log.Debugw(c.ctx, "failed to push initial clipboard", err)
+		return
+	}
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	authorityCheck := time.NewTicker(streamAuthorityCheckPeriod)
+	defer authorityCheck.Stop()
+	for {
+		var err error
+		select {
+		case <-readDone:
+			return
+		case <-h.streams.Done():
+			h.log.Debugw(c.ctx, "Server is shutting down, closing sync connection", "id", c.sessionID)
+			h.close(c, websocket.CloseGoingAway, "server is shutting down")
+			return
+		case <-ping.C:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		case <-authorityCheck.C:
+			if !h.verifyAuthority(c) {
+				return
+			}
+		case event := <-events:
+			if event.ID() == c.lastEventID {
+				continue
+			}
+			err = h.pushClipboard(c, "")
+		case msg := <-incoming:
+			err = h.handle(c, msg)
+		}
+		if err != nil {
+			h.log.Debugw(c.ctx, "failed to write message", err)
+			return
+		}
+	}
+}
+
+// verifyAuthority closes connection unless credentials it was opened with are still valid
+func (h *SyncHandler) verifyAuthority(c *syncConnection) bool {
+	err := h.verifier.Verify(c.request)
+	if err == nil {
+		return true
+	}
+
+	var authErr *authenticationError
+	if errors.As(err, &authErr) {
+		h.log.Debugw(c.ctx, "Credentials are not valid anymore, closing sync connection", "id", c.sessionID, err)
+		h.close(c, websocket.ClosePolicyViolation, authErr.message)
+		return false
+	}
+
+	h.log.Errorw(c.ctx, "failed to verify sync connection credentials", err)
+	h.close(c, websocket.CloseInternalServerErr, "internal server error")
+	return false
+}
+
+func (h *SyncHandler) read(c *syncConnection, incoming chan<- *wsMessage, done chan<- struct{}, stop <-chan struct{}) {
+	defer close(done)
+
+	c.conn.SetReadLimit(h.maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var msg wsMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				h.log.Debugw(c.ctx, "failed to read message", err)
+			}
+			return
+		}
+
+		select {
+		case incoming <- &msg:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (h *SyncHandler) handle(c *syncConnection, msg *wsMessage) error {
+	switch msg.Type {
+	case wsMessageTypePull:
+		return h.pushClipboard(c, msg.ID)
+	case wsMessageTypePublish:
+		if c.scope != nil && !c.scope.Allows(domain.PermissionClipboardWrite) {
+			h.log.Debugw(c.ctx, "publish is out of api token scope")
+			return h.write(c, newWSError(msg.ID, domain.ErrorCodeForbidden.Value, "API token scope does not allow the request"))
+		}
+		// role is checked on every publish, as it may change while connection is open
+		clipboard, err := h.clipboardService.SetBySessionID(c.ctx, c.userID, c.sessionID, fromRepresentationDTOs(msg.Representations))
+		if err != nil {
+			if isSessionAccessError(err) {
+				h.log.Debugw(c.ctx, "publish is not permitted", err)
+				return h.write(c, newWSError(msg.ID, domain.ErrorCodeForbidden.Value, "Not enough permissions for the session"))
+			}
+			if code, message, ok := contentErrorCode(err); ok {
+				h.log.Debugw(c.ctx, "content rejected", err)
+				return h.write(c, newWSError(msg.ID, code.Value, message))
+			}
+
+			h.log.Errorw(c.ctx, "failed to set content", err)
+			return h.write(c, newWSError(msg.ID, domain.ErrorCodeInternalServerError.Value, "Internal server error"))
+		}
+		c.lastEventID = domain.NewClipboardEvent(clipboard).ID()
+
+		return h.write(c, &wsMessage{
+			Type:            wsMessageTypeAck,
+			ID:              msg.ID,
+			SessionID:       c.sessionID,
+			UpdatedAtMillis: clipboard.UpdatedAt.UnixMilli(),
+		})
+	default:
+		return h.write(c, newWSError(msg.ID, domain.ErrorBadRequest.Value, "unknown message type"))
+	}
+}
+
+func (h *SyncHandler) pushClipboard(c *syncConnection, replyTo string) error {
+	clipboard, err := h.clipboardService.ReadBySessionID(c.ctx, c.userID, c.sessionID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			if replyTo == "" {
+				return nil
+			}
+			return h.write(c, newWSError(replyTo, domain.ErrorCodeNotFound.Value, "Clipboard is empty"))
+		}
+		if isSessionAccessError(err) {
+			h.log.Debugw(c.ctx, "read is not permitted", err)
+			return h.write(c, newWSError(replyTo, domain.ErrorCodeForbidden.Value, "Not enough permissions for the session"))
+		}
+
+		h.log.Errorw(c.ctx, "failed to get clipboard", err)
+		return h.write(c, newWSError(replyTo, domain.ErrorCodeInternalServerError.Value, "Internal server error"))
+	}
+
+	eventID := domain.NewClipboardEvent(clipboard).ID()
+	if replyTo == "" && eventID == c.lastEventID {
+		return nil
+	}
+	c.lastEventID = eventID
+
+	return h.write(c, &wsMessage{
+		Type:            wsMessageTypeClipboard,
+		ID:              replyTo,
+		SessionID:       clipboard.SessionID,
+		Representations: toRepresentationDTOs(clipboard.Representations),
+		UpdatedAtMillis: clipboard.UpdatedAt.UnixMilli(),
+	})
+}
+
+func (h *SyncHandler) write(c *syncConnection, msg *wsMessage) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+		return fmt.Errorf("set write deadline: %w", err)
+	}
+	if err := c.conn.WriteJSON(msg); err != nil {
+		return fmt.Errorf("write %s message: %w", msg.Type, err)
+	}
+	return nil
+}
+
+func (h *SyncHandler) close(c *syncConnection, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait)); err != nil {
+		h.log.Debugw(c.ctx, "failed to write close message", err)
+	}
+}
+
+func newWSError(id, code, message string) *wsMessage {
+	return &wsMessage{
+		Type:    wsMessageTypeError,
+		ID:      id,
+		Code:    code,
+		Message: message,
+	}
+}
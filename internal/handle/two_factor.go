@@ -0,0 +1,240 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/domain"
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+const (
+	contentTypePNG = "image/png"
+	qrCodeSize     = 256
+)
+
+type (
+	TwoFactorStatus struct {
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}
+
+	TOTPEnrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	RecoveryCodes struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	twoFactorCodeRequest struct {
+		// Code is TOTP code or recovery code
+		Code string `json:"code"`
+	}
+
+	TwoFactorAuthenticator interface {
+		IsEnabled(ctx context.Context, userID uint64) (bool, error)
+		CreateChallenge(ctx context.Context, userID uint64) (*domain.TwoFactorChallenge, error)
+		ChallengeUserID(ctx context.Context, token string) (uint64, error)
+		CompleteChallenge(ctx context.Context, token, code string) (uint64, error)
+	}
+
+	TwoFactorService interface {
+		TwoFactorAuthenticator
+		Status(ctx context.Context, userID uint64) (*domain.TwoFactorStatus, error)
+		Enroll(ctx context.Context, userID uint64, account string) (*domain.TOTPEnrollment, error)
+		PendingEnrollment(ctx context.Context, userID uint64, account string) (*domain.TOTPEnrollment, error)
+		Confirm(ctx context.Context, userID uint64, code string) ([]string, error)
+		Disable(ctx context.Context, userID uint64, code string) error
+		RegenerateRecoveryCodes(ctx context.Context, userID uint64, code string) ([]string, error)
+	}
+
+	TwoFactorHandler struct {
+		resp          *responder
+		service       TwoFactorService
+		signInLimiter SignInLimiter
+		log           log.TracedLogger
+	}
+)
+
+func NewTwoFactorHandler(service TwoFactorService, signInLimiter SignInLimiter, resp *responder, log log.TracedLogger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		resp:          resp,
+		service:       service,
+		signInLimiter: signInLimiter,
+		log:           log,
+	}
+}
+
+func (h *TwoFactorHandler) GetStatus(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	status, err := h.service.Status(ctx, auth.UserID)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to get two factor status", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.resp.Send(ctx, rw, http.StatusOK, nil, &TwoFactorStatus{
+		Enabled:           status.Enabled,
+		RecoveryCodesLeft: status.RecoveryCodesLeft,
+	})
+}
+
+// Enroll starts TOTP enrollment, the secret has to be confirmed with a code from authenticator app
+func (h *TwoFactorHandler) Enroll(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.service.Enroll(ctx, auth.UserID, auth.UserName)
+	if err != nil {
+		if h.sendTwoFactorError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to enroll totp", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	h.resp.Send(ctx, rw, http.StatusCreated, map[string][]string{CacheControlHeader: {"no-store"}}, &TOTPEnrollment{
+		Secret: enrollment.Secret,
+		URI:    enrollment.URI,
+	})
+}
+
+// GetQRCode renders pending enrollment as PNG to scan with authenticator app
+func (h *TwoFactorHandler) GetQRCode(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.service.PendingEnrollment(ctx, auth.UserID, auth.UserName)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			h.resp.SendNotFound(ctx, rw, "Pending two-factor authentication enrollment not found")
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to get pending totp enrollment", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	png, err := qrcode.Encode(enrollment.URI, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		h.log.Errorw(ctx, "failed to encode qr code", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+
+	rw.Header().Set(ContentTypeHeader, contentTypePNG)
+	rw.Header().Set(CacheControlHeader, "no-store")
+	rw.WriteHeader(http.StatusOK)
+	if n, err := rw.Write(png); err != nil {
+		h.log.Errorw(ctx, "Failed to write qr code", "bytesWritten", n, err)
+	}
+}
+
+// Confirm enables two-factor authentication and returns recovery codes, they are not shown again
+func (h *TwoFactorHandler) Confirm(rw http.ResponseWriter, r *http.Request) {
+	h.withCode(rw, r, false, func(ctx context.Context, userID uint64, code string) ([]string, error) {
+		return h.service.Confirm(ctx, userID, code)
+	})
+}
+
+func (h *TwoFactorHandler) Disable(rw http.ResponseWriter, r *http.Request) {
+	h.withCode(rw, r, true, func(ctx context.Context, userID uint64, code string) ([]string, error) {
+		return nil, h.service.Disable(ctx, userID, code)
+	})
+}
+
+// RegenerateRecoveryCodes replaces recovery codes, e.g. when most of them were used
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(rw http.ResponseWriter, r *http.Request) {
+	h.withCode(rw, r, true, h.service.RegenerateRecoveryCodes)
+}
+
+// withCode handles requests which require a code, it responds with recovery codes returned by fn or with no content.
+// If limited is set, codes are limited as sign in attempts of the user, otherwise stolen access token would allow
+// guessing codes of enabled two-factor authentication without limits.
+func (h *TwoFactorHandler) withCode(
+	rw http.ResponseWriter, r *http.Request, limited bool, fn func(ctx context.Context, userID uint64, code string) ([]string, error),
+) {
+	ctx := r.Context()
+
+	auth, ok := h.resp.unrestrictedAuthority(ctx, rw)
+	if !ok {
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Debugw(ctx, "failed to decode body", err)
+		h.resp.SendBadRequest(ctx, rw, "failed to parse request")
+		return
+	}
+
+	var (
+		ip      = clientIP(r)
+		attempt *domain.SignInAttempt
+	)
+	if limited {
+		if attempt, ok = h.resp.attemptSignIn(ctx, rw, h.signInLimiter, auth.UserName, ip); !ok {
+			return
+		}
+	}
+
+	codes, err := fn(ctx, auth.UserID, req.Code)
+	if err != nil {
+		if attempt != nil {
+			setRetryAfter(rw, attempt)
+		}
+		if h.sendTwoFactorError(ctx, rw, err) {
+			return
+		}
+
+		h.log.Errorw(ctx, "failed to handle two factor request", err)
+		h.resp.SendInternalServerError(ctx, rw)
+		return
+	}
+	if attempt != nil {
+		if err = h.signInLimiter.Succeed(ctx, auth.UserName, ip); err != nil {
+			h.log.Errorw(ctx, "failed to reset failed sign ins", err)
+		}
+	}
+
+	if codes == nil {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.resp.Send(ctx, rw, http.StatusOK, map[string][]string{CacheControlHeader: {"no-store"}}, &RecoveryCodes{RecoveryCodes: codes})
+}
+
+func (h *TwoFactorHandler) sendTwoFactorError(ctx context.Context, rw http.ResponseWriter, err error) bool {
+	var re *domain.RenderableError
+	if !errors.As(err, &re) {
+		return false
+	}
+
+	h.log.Debugw(ctx, "two factor request rejected", err)
+	h.resp.SendError(ctx, rw, re.Code.StatusCode, re.Code.Value, re.Message, re.Details)
+	return true
+}
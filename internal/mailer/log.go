@@ -0,0 +1,24 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/log"
+)
+
+// Log writes messages to the app log instead of sending them. It is only meant for development,
+// messages may contain secrets like password reset links.
+type Log struct {
+	log log.TracedLogger
+}
+
+func NewLog(log log.TracedLogger) *Log {
+	return &Log{
+		log: log,
+	}
+}
+
+func (m *Log) Send(ctx context.Context, to, subject, body string) error {
+	m.log.Infow(ctx, "Mail", "to", to, "subject", subject, "body", body)
+	return nil
+}
@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Roma7-7-7/shared-clipboard/internal/config"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// SMTP sends plain text messages through SMTP server. Connection is upgraded with STARTTLS if server supports it,
+// credentials are only sent over TLS.
+type SMTP struct {
+	host     string
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+func NewSMTP(conf config.SMTP, from string) *SMTP {
+	return &SMTP{
+		host:     conf.Host,
+		addr:     net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port)),
+		from:     from,
+		username: conf.Username,
+		password: conf.Password,
+	}
+}
+
+func (m *SMTP) Send(ctx context.Context, to, subject, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return fmt.Errorf("dial smtp server: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("set smtp connection deadline: %w", err)
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("create smtp client: %w", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("start tls: %w", err)
+		}
+	}
+	if m.username != "" {
+		// PlainAuth refuses to send credentials over connection without TLS, unless server is localhost
+		if err = c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	if err = c.Mail(m.from); err != nil {
+		return fmt.Errorf("set sender: %w", err)
+	}
+	if err = c.Rcpt(to); err != nil {
+		return fmt.Errorf("set recipient: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("start data: %w", err)
+	}
+	if _, err = w.Write(message(m.from, to, subject, body)); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+
+	return c.Quit()
+}
+
+// message formats plain text message, header values are stripped of line breaks so they can not inject headers
+func message(from, to, subject, body string) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+
+	var b strings.Builder
+	b.WriteString("From: " + header.Replace(from) + "\r\n")
+	b.WriteString("To: " + header.Replace(to) + "\r\n")
+	b.WriteString("Subject: " + header.Replace(subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,8 @@
+// Package migrations embeds SQL migrations into the binary, they are applied with "app migrate" command
+// or on start if auto migrate is enabled
+package migrations
+
+import "embed"
+
+//go:embed sql/*.sql
+var FS embed.FS
@@ -0,0 +1,2 @@
+drop table if exists clipboard_representations;
+drop table if exists clipboards;
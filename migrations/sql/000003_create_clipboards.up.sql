@@ -0,0 +1,19 @@
+create table if not exists clipboards
+(
+    session_id int       not null references sessions (session_id) on delete cascade,
+    version    bigint    not null,
+    author_id  int       not null,
+    updated_at timestamp not null default now(),
+    primary key (session_id, version)
+);
+
+create table if not exists clipboard_representations
+(
+    session_id   int          not null,
+    version      bigint       not null,
+    position     int          not null,
+    content_type varchar(255) not null,
+    content      bytea        not null,
+    primary key (session_id, version, position),
+    foreign key (session_id, version) references clipboards (session_id, version) on delete cascade
+);
@@ -0,0 +1,8 @@
+alter table clipboards
+    drop column if exists reads,
+    drop column if exists expires_at;
+
+alter table sessions
+    drop column if exists clipboard_version,
+    drop column if exists retention_value,
+    drop column if exists retention_policy;
@@ -0,0 +1,13 @@
+alter table sessions
+    add column retention_policy  varchar(32) not null default 'hours',
+    add column retention_value   int         not null default 24,
+    add column clipboard_version bigint      not null default 0;
+
+update sessions s
+set clipboard_version = c.version
+from (select session_id, max(version) as version from clipboards group by session_id) c
+where c.session_id = s.session_id;
+
+alter table clipboards
+    add column expires_at timestamp null,
+    add column reads      int       not null default 0;
@@ -0,0 +1,16 @@
+create table if not exists session_members
+(
+    session_id int         not null references sessions (session_id) on delete cascade,
+    user_id    int         not null references users (user_id) on delete cascade,
+    role       varchar(16) not null,
+    created_at timestamp   not null default now(),
+    updated_at timestamp   not null default now(),
+    primary key (session_id, user_id)
+);
+
+create index session_members_user_id_idx on session_members (user_id);
+
+insert into session_members (session_id, user_id, role, created_at, updated_at)
+select session_id, user_id, 'owner', created_at, updated_at
+from sessions
+on conflict do nothing;
@@ -0,0 +1,16 @@
+create table if not exists share_links
+(
+    share_link_id    serial primary key,
+    session_id       int         not null references sessions (session_id) on delete cascade,
+    created_by       int         not null references users (user_id) on delete cascade,
+    token_hash       varchar(64) not null unique,
+    password         varchar(255) null,
+    single_use       boolean     not null default false,
+    access_count     int         not null default 0,
+    expires_at       timestamp   not null,
+    revoked_at       timestamp   null,
+    last_accessed_at timestamp   null,
+    created_at       timestamp   not null default now()
+);
+
+create index share_links_session_id_idx on share_links (session_id);
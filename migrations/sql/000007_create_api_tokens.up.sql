@@ -0,0 +1,11 @@
+create table if not exists api_tokens
+(
+    api_token_id serial primary key,
+    user_id      int          not null references users (user_id) on delete cascade,
+    name         varchar(256) not null,
+    token_hash   varchar(64)  not null unique,
+    expires_at   timestamp    null,
+    last_used_at timestamp    null,
+    created_at   timestamp    not null default now(),
+    unique (user_id, name)
+);
@@ -0,0 +1,3 @@
+alter table api_tokens
+    drop column if exists session_ids,
+    drop column if exists permissions;
@@ -0,0 +1,3 @@
+alter table api_tokens
+    add column session_ids int[]         null,
+    add column permissions varchar(32)[] null;
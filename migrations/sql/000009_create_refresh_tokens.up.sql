@@ -0,0 +1,14 @@
+create table if not exists refresh_tokens
+(
+    refresh_token_id serial primary key,
+    user_id          int         not null references users (user_id) on delete cascade,
+    family_id        uuid        not null,
+    token_hash       varchar(64) not null unique,
+    expires_at       timestamp   not null,
+    used_at          timestamp   null,
+    revoked_at       timestamp   null,
+    created_at       timestamp   not null default now()
+);
+
+create index refresh_tokens_family_id_idx on refresh_tokens (family_id);
+create index refresh_tokens_user_id_idx on refresh_tokens (user_id);
@@ -0,0 +1,4 @@
+drop table if exists logins;
+
+alter table users
+    drop column if exists token_generation;
@@ -0,0 +1,18 @@
+alter table users
+    add column token_generation int not null default 0;
+
+create table if not exists logins
+(
+    login_id          serial primary key,
+    user_id           int          not null references users (user_id) on delete cascade,
+    family_id         uuid         not null unique,
+    jti               varchar(64)  not null,
+    access_expires_at timestamp    not null,
+    user_agent        varchar(512) not null default '',
+    ip                varchar(64)  not null default '',
+    device_name       varchar(128) not null default '',
+    created_at        timestamp    not null default now(),
+    last_seen_at      timestamp    not null default now()
+);
+
+create index logins_user_id_idx on logins (user_id);
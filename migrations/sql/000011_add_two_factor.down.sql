@@ -0,0 +1,6 @@
+drop table if exists recovery_codes;
+
+alter table users
+    drop column if exists totp_secret,
+    drop column if exists totp_confirmed_at,
+    drop column if exists totp_last_step;
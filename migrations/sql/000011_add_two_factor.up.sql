@@ -0,0 +1,15 @@
+alter table users
+    add column totp_secret       varchar(64) null,
+    add column totp_confirmed_at timestamp   null,
+    add column totp_last_step    bigint      not null default 0;
+
+create table if not exists recovery_codes
+(
+    recovery_code_id serial primary key,
+    user_id          int         not null references users (user_id) on delete cascade,
+    code_hash        varchar(64) not null,
+    used_at          timestamp   null,
+    created_at       timestamp   not null default now()
+);
+
+create index recovery_codes_user_id_idx on recovery_codes (user_id);
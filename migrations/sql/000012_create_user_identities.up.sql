@@ -0,0 +1,12 @@
+create table if not exists user_identities
+(
+    user_identity_id serial primary key,
+    user_id          int          not null references users (user_id) on delete cascade,
+    provider         varchar(64)  not null,
+    subject          varchar(255) not null,
+    email            varchar(320) not null default '',
+    created_at       timestamp    not null default now(),
+    unique (provider, subject)
+);
+
+create index user_identities_user_id_idx on user_identities (user_id);
@@ -0,0 +1,6 @@
+drop table if exists password_resets;
+
+drop index if exists users_email_idx;
+
+alter table users
+    drop column if exists email;
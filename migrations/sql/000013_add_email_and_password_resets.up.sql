@@ -0,0 +1,16 @@
+alter table users
+    add column email varchar(320) null;
+
+create unique index users_email_idx on users (lower(email));
+
+create table if not exists password_resets
+(
+    password_reset_id serial primary key,
+    user_id           int         not null references users (user_id) on delete cascade,
+    token_hash        varchar(64) not null unique,
+    expires_at        timestamp   not null,
+    used_at           timestamp   null,
+    created_at        timestamp   not null default now()
+);
+
+create index password_resets_user_id_idx on password_resets (user_id);
@@ -0,0 +1,4 @@
+alter table users
+    drop column if exists disabled_at;
+alter table users
+    drop column if exists role;
@@ -0,0 +1,4 @@
+alter table users
+    add column role varchar(16) not null default 'user';
+alter table users
+    add column disabled_at timestamp null;
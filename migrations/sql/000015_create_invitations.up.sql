@@ -0,0 +1,13 @@
+create table if not exists invitations
+(
+    invitation_id serial primary key,
+    created_by    int         not null references users (user_id) on delete cascade,
+    code_hash     varchar(64) not null unique,
+    max_uses      int         not null,
+    uses          int         not null default 0,
+    expires_at    timestamp   not null,
+    revoked_at    timestamp   null,
+    created_at    timestamp   not null default now()
+);
+
+create index invitations_created_by_idx on invitations (created_by);
@@ -0,0 +1,2 @@
+alter table sessions
+    drop constraint if exists sessions_user_id_fkey;
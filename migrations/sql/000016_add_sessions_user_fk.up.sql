@@ -0,0 +1,15 @@
+-- sessions of users deleted before are passed to another owner, those without one are deleted
+update sessions s
+set user_id = o.user_id
+from (select distinct on (m.session_id) m.session_id, m.user_id
+      from session_members m
+      where m.role = 'owner'
+      order by m.session_id, m.created_at) o
+where s.session_id = o.session_id
+  and not exists (select 1 from users u where u.user_id = s.user_id);
+delete
+from sessions s
+where not exists (select 1 from users u where u.user_id = s.user_id);
+
+alter table sessions
+    add constraint sessions_user_id_fkey foreign key (user_id) references users (user_id);
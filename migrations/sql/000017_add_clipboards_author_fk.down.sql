@@ -0,0 +1,4 @@
+drop index if exists clipboards_author_id_idx;
+
+alter table clipboards
+    drop constraint if exists clipboards_author_id_fkey;
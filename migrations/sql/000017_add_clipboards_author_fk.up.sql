@@ -0,0 +1,9 @@
+-- clipboards written by users deleted before are personal data left behind
+delete
+from clipboards c
+where not exists (select 1 from users u where u.user_id = c.author_id);
+
+alter table clipboards
+    add constraint clipboards_author_id_fkey foreign key (author_id) references users (user_id) on delete cascade;
+
+create index clipboards_author_id_idx on clipboards (author_id);
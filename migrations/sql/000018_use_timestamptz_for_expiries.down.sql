@@ -0,0 +1,34 @@
+alter table invitations
+    alter column expires_at type timestamp,
+    alter column revoked_at type timestamp,
+    alter column created_at type timestamp;
+
+alter table password_resets
+    alter column expires_at type timestamp,
+    alter column used_at type timestamp,
+    alter column created_at type timestamp;
+
+alter table logins
+    alter column access_expires_at type timestamp,
+    alter column created_at type timestamp,
+    alter column last_seen_at type timestamp;
+
+alter table refresh_tokens
+    alter column expires_at type timestamp,
+    alter column used_at type timestamp,
+    alter column revoked_at type timestamp,
+    alter column created_at type timestamp;
+
+alter table api_tokens
+    alter column expires_at type timestamp,
+    alter column last_used_at type timestamp,
+    alter column created_at type timestamp;
+
+alter table share_links
+    alter column expires_at type timestamp,
+    alter column revoked_at type timestamp,
+    alter column last_accessed_at type timestamp,
+    alter column created_at type timestamp;
+
+alter table clipboards
+    alter column expires_at type timestamp;
@@ -0,0 +1,37 @@
+-- expiries are written from the app with its offset and compared with now(), timestamp without time zone drops the
+-- offset, so they are only right when the app and the database run in the same time zone.
+-- existing values are read in the session time zone, the same way comparisons with now() read them before
+alter table clipboards
+    alter column expires_at type timestamptz;
+
+alter table share_links
+    alter column expires_at type timestamptz,
+    alter column revoked_at type timestamptz,
+    alter column last_accessed_at type timestamptz,
+    alter column created_at type timestamptz;
+
+alter table api_tokens
+    alter column expires_at type timestamptz,
+    alter column last_used_at type timestamptz,
+    alter column created_at type timestamptz;
+
+alter table refresh_tokens
+    alter column expires_at type timestamptz,
+    alter column used_at type timestamptz,
+    alter column revoked_at type timestamptz,
+    alter column created_at type timestamptz;
+
+alter table logins
+    alter column access_expires_at type timestamptz,
+    alter column created_at type timestamptz,
+    alter column last_seen_at type timestamptz;
+
+alter table password_resets
+    alter column expires_at type timestamptz,
+    alter column used_at type timestamptz,
+    alter column created_at type timestamptz;
+
+alter table invitations
+    alter column expires_at type timestamptz,
+    alter column revoked_at type timestamptz,
+    alter column created_at type timestamptz;
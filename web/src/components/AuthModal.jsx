@@ -1,4 +1,4 @@
-import {useState} from "react";
+import {useEffect, useState} from "react";
 import {Alert, Button, Col, Form, InputGroup, Modal, Row} from "react-bootstrap";
 import {apiBaseURL} from "../env.jsx";
 import axios from "axios";
@@ -13,9 +13,40 @@ export default function AuthModal({title, onHide, onSignedIn}) {
     const [password, setPassword] = useState("");
     const [passwordFeedback, setPasswordFeedback] = useState(null);
 
+    const [challenge, setChallenge] = useState(null);
+    const [code, setCode] = useState("");
+
     const [alertMsg, setAlertMsg] = useState(null);
 
+    const [providers, setProviders] = useState([]);
+
+    const [registrationMode, setRegistrationMode] = useState("open");
+    const [invitationCode, setInvitationCode] = useState("");
+
+    useEffect(() => {
+        if (title !== signInTitle) {
+            return;
+        }
+        axios.get(apiBaseURL + '/auth/oidc/providers')
+            .then(response => setProviders(response.data.providers))
+            .catch(error => console.error('Error:', error))
+    }, [title]);
+
+    useEffect(() => {
+        if (title === null || title === signInTitle) {
+            return;
+        }
+        axios.get(apiBaseURL + '/registration')
+            .then(response => setRegistrationMode(response.data.mode))
+            .catch(error => console.error('Error:', error))
+    }, [title]);
+
     function cleanup() {
+        setChallenge(null);
+        setCode("");
+
+        setInvitationCode("");
+
         setUserName("");
         setUsernameFeedback(null);
 
@@ -52,25 +83,58 @@ export default function AuthModal({title, onHide, onSignedIn}) {
             return;
         }
 
+        if (challenge !== null) {
+            axios.post(apiBaseURL + '/signin/2fa', {
+                challenge: challenge,
+                code: code
+            }, {withCredentials: true})
+                .then(response => {
+                    onSignedIn(response.data);
+                })
+                .catch(error => {
+                    if (!error.response || error.response.status !== 401) {
+                        console.error('Error:', error)
+                        setAlertMsg("Unexpected error occurred");
+                        return
+                    }
+                    if (error.response.data.code === "ERR_2403") {
+                        setAlertMsg("Code is incorrect");
+                        return;
+                    }
+                    setChallenge(null);
+                    setCode("");
+                    setAlertMsg("Sign in has expired, please sign in again");
+                })
+            return;
+        }
+
         axios.post(apiBaseURL + '/signin', {
             name: userName,
             password: password
         }, {withCredentials: true})
             .then(response => {
+                if (response.status === 202 && response.data.two_factor_required) {
+                    setChallenge(response.data.challenge);
+                    return;
+                }
                 onSignedIn(response.data);
             })
             .catch(error => {
+                if (error.response?.status === 429) {
+                    const retryAfter = error.response.headers['retry-after'];
+                    setAlertMsg(retryAfter
+                        ? `Too many failed attempts, try again in ${retryAfter} seconds`
+                        : "Too many failed attempts, try again later");
+                    return
+                }
                 if (!error.response || error.response.status !== 401) {
                     console.error('Error:', error)
                     setAlertMsg("Unexpected error occurred");
                     return
                 }
                 switch (error.response.data.code) {
-                    case "ERR_2201":
-                        setAlertMsg("User with such name does not exist");
-                        return;
-                    case "ERR_2103":
-                        setAlertMsg("Password is incorrect");
+                    case "ERR_2104":
+                        setAlertMsg("User name or password is incorrect");
                         return;
                     default:
                         return Promise.reject(response.data);
@@ -156,7 +220,8 @@ export default function AuthModal({title, onHide, onSignedIn}) {
 
             axios.post(apiBaseURL + '/signup', {
                 name: userName,
-                password: password
+                password: password,
+                invitation_code: invitationCode
             }, {withCredentials: true})
                 .then(response => {
                     onSignedIn(response.data);
@@ -174,6 +239,12 @@ export default function AuthModal({title, onHide, onSignedIn}) {
                         case "ERR_2102":
                             setAlertMsg("User with such name already exists")
                             return;
+                        case "ERR_2901":
+                            setAlertMsg("Registration is closed")
+                            return;
+                        case "ERR_2902":
+                            setAlertMsg("Invitation code is not valid, expired or used up")
+                            return;
                         default:
                             setAlertMsg("Unexpected error occurred")
                             return
@@ -251,13 +322,51 @@ export default function AuthModal({title, onHide, onSignedIn}) {
                             </Col>
                         </InputGroup>
                     </Form.Group>
+                    {title !== signInTitle && registrationMode === "invite_only" &&
+                        <Form.Group as={Row} className="mb-3">
+                            <Form.Label column sm="3">Invitation</Form.Label>
+                            <Col sm="8">
+                                <Form.Control type="text" value={invitationCode} placeholder="Invitation code"
+                                              onChange={(event) => {
+                                                  setAlertMsg("");
+                                                  setInvitationCode(event.target.value);
+                                              }}/>
+                            </Col>
+                        </Form.Group>
+                    }
+                    {title !== signInTitle && registrationMode === "closed" &&
+                        <Alert variant="info">Registration is closed</Alert>
+                    }
+                    {challenge !== null &&
+                        <Form.Group as={Row} className="mb-3">
+                            <Form.Label column sm="3">Code</Form.Label>
+                            <Col sm="8">
+                                <Form.Control type="text" autoComplete="one-time-code" autoFocus value={code}
+                                              placeholder="Authenticator or recovery code"
+                                              onChange={(event) => {
+                                                  setAlertMsg("");
+                                                  setCode(event.target.value);
+                                              }}/>
+                            </Col>
+                        </Form.Group>
+                    }
                 </Form>
+                {title === signInTitle && challenge === null && providers.map(provider =>
+                    <Button key={provider} variant="outline-primary" className="w-100 mb-2"
+                            href={apiBaseURL + '/auth/oidc/login?provider=' + encodeURIComponent(provider)}>
+                        Sign in with {provider}
+                    </Button>
+                )}
+                {title === signInTitle && challenge === null &&
+                    <p><a href="/password-reset">Forgot password?</a></p>
+                }
                 <Alert variant="warning" show={alertMsg !== null && alertMsg !== ""}>{alertMsg}</Alert>
             </Modal.Body>
             <Modal.Footer>
                 <Button variant="secondary" onClick={onHide}>Close</Button>
                 <Button variant="primary" onClick={handleSubmit}
-                        disabled={usernameFeedback !== "" || passwordFeedback !== ""}>{title}</Button>
+                        disabled={usernameFeedback !== "" || passwordFeedback !== "" ||
+                            (title !== signInTitle && registrationMode === "closed")}>{title}</Button>
             </Modal.Footer>
         </Modal>
     )
@@ -6,6 +6,55 @@ import HomeRoute from "./routes/Home.jsx";
 import SessionsRoute from "./routes/SessionsRoute.jsx";
 import SessionRoute from "./routes/SessionRoute.jsx";
 import ClipboardRoute from "./routes/ClipboardRoute.jsx";
+import PasswordResetRoute from "./routes/PasswordResetRoute.jsx";
+import axios from "axios";
+import {apiBaseURL} from "./env.jsx";
+
+const refreshURL = apiBaseURL + '/token/refresh'
+// sign in failures are not related to access tokens
+const signInURL = apiBaseURL + '/signin'
+const csrfTokenURL = apiBaseURL + '/csrf-token'
+const safeMethods = ['get', 'head', 'options']
+
+// cookie with CSRF token belongs to API domain, so the token is fetched once and repeated in header of mutating requests
+let csrfToken = null
+const fetchCSRFToken = () => axios.get(csrfTokenURL, {withCredentials: true})
+    .then(response => csrfToken = response.data.csrf_token)
+
+axios.interceptors.request.use(config => {
+    if (safeMethods.includes((config.method || 'get').toLowerCase())) {
+        return config
+    }
+    return (csrfToken ? Promise.resolve(csrfToken) : fetchCSRFToken()).then(token => {
+        config.headers['X-CSRF-Token'] = token
+        return config
+    })
+})
+
+// cookie with CSRF token lives as long as browser session, so requests rejected because of it are retried once with a new one
+axios.interceptors.response.use(undefined, error => {
+    const config = error.config
+    if (!config || config.csrfRetried || error.response?.data?.code !== 'ERR_2701') {
+        return Promise.reject(error)
+    }
+
+    config.csrfRetried = true
+    csrfToken = null
+    return fetchCSRFToken().then(() => axios(config), () => Promise.reject(error))
+})
+
+// access tokens are short-lived, so requests rejected as unauthorized are retried once after refreshing them
+axios.interceptors.response.use(undefined, error => {
+    const config = error.config
+    const status = error.response?.status
+    if (!config || config.retried || error.response?.data?.code === 'ERR_2701' || config.url === refreshURL || config.url.startsWith(signInURL) || (status !== 401 && status !== 403)) {
+        return Promise.reject(error)
+    }
+
+    config.retried = true
+    return axios.post(refreshURL, {}, {withCredentials: true})
+        .then(() => axios(config), () => Promise.reject(error))
+})
 
 export const router = createBrowserRouter([
     {
@@ -15,6 +64,7 @@ export const router = createBrowserRouter([
             {name: 'sessions/new', path: 'sessions/new', element: <SessionRoute action="new"/>},
             {name: 'sessions/edit', path: 'sessions/:sessionId/edit', element: <SessionRoute action="edit"/>},
             {name: 'clipboard', path: 'sessions/:sessionId/clipboard', element: <ClipboardRoute />},
+            {name: 'password-reset', path: 'password-reset', element: <PasswordResetRoute/>},
         ]
     },
 ])
@@ -9,14 +9,14 @@ export default function ClipboardRoute() {
     const [alertMsg, setAlertMsg] = useState("")
     const content = useRef("")
     const lastModified = useRef("");
-    const timer = useRef()
+    const events = useRef()
 
     function refresh() {
         const headers = {}
         if (lastModified.current) {
             headers['If-Modified-Since'] = lastModified
         }
-        axios.get(apiBaseURL + `/v1/sessions/${params.sessionId}/clipboard`, {
+        return axios.get(apiBaseURL + `/v1/sessions/${params.sessionId}/clipboard`, {
             headers: headers,
             withCredentials: true,
         })
@@ -68,18 +68,39 @@ export default function ClipboardRoute() {
             })
     }
 
+    // stream is closed once access token it was opened with expires, so it is reopened after clipboard request
+    // refreshes the token, unless the stream is refused again before it is opened
+    function openEvents(reopen) {
+        const source = new EventSource(apiBaseURL + `/v1/sessions/${params.sessionId}/clipboard/events`, {
+            withCredentials: true,
+        })
+        source.addEventListener("clipboard", refresh)
+        source.addEventListener("open", () => reopen = true)
+        source.addEventListener("error", () => {
+            if (source.readyState !== EventSource.CLOSED || !reopen || events.current !== source) {
+                return
+            }
+            refresh().finally(() => {
+                if (events.current === source) {
+                    openEvents(false)
+                }
+            })
+        })
+        events.current = source
+    }
+
     useEffect(() => {
-        if (timer.current) {
+        if (events.current) {
             return;
         }
 
         lastModified.current = "";
         refresh()
-        timer.current = setInterval(refresh, 1000)
+        openEvents(true)
 
         return () => {
-            clearInterval(timer.current)
-            timer.current = null
+            events.current.close()
+            events.current = null
         }
     })
 
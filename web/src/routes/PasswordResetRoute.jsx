@@ -0,0 +1,63 @@
+import {useState} from "react";
+import {Alert, Button, Container, Form, Row} from "react-bootstrap";
+import {useSearchParams} from "react-router-dom";
+import axios from "axios";
+import {apiBaseURL} from "../env.jsx";
+
+export default function PasswordResetRoute() {
+    const [searchParams] = useSearchParams();
+    const token = searchParams.get("token");
+
+    const [value, setValue] = useState("");
+    const [alert, setAlert] = useState(null);
+
+    const handleSubmit = (event) => {
+        event.preventDefault();
+        setAlert(null);
+
+        if (token === null) {
+            axios.post(apiBaseURL + '/password-reset/request', {email: value})
+                .then(() => setAlert({variant: "success", message: "If the email is registered, a reset link was sent to it"}))
+                .catch(error => {
+                    console.error('Error:', error)
+                    setAlert({variant: "warning", message: "Unexpected error occurred"});
+                })
+            return;
+        }
+
+        axios.post(apiBaseURL + '/password-reset/confirm', {token: token, password: value})
+            .then(() => setAlert({variant: "success", message: "Password is changed, you can sign in with it now"}))
+            .catch(error => {
+                switch (error.response?.data?.code) {
+                    case "ERR_2601":
+                        setAlert({variant: "warning", message: error.response.data.details.password});
+                        return;
+                    case "ERR_2602":
+                        setAlert({variant: "warning", message: "Reset link is not valid or expired, request a new one"});
+                        return;
+                    default:
+                        console.error('Error:', error)
+                        setAlert({variant: "warning", message: "Unexpected error occurred"});
+                }
+            })
+    }
+
+    return (
+        <Container>
+            <Row>
+                <p className="fs-5">{token === null ? "Forgot password?" : "Set a new password"}</p>
+            </Row>
+            <Form onSubmit={handleSubmit}>
+                <Form.Group className="mb-3">
+                    <Form.Control type={token === null ? "email" : "password"} value={value}
+                                  placeholder={token === null ? "Email" : "New password"}
+                                  onChange={(event) => setValue(event.target.value)}/>
+                </Form.Group>
+                <Button variant="primary" type="submit" disabled={value === ""}>
+                    {token === null ? "Send reset link" : "Change password"}
+                </Button>
+            </Form>
+            <Alert className="mt-3" variant={alert?.variant} show={alert !== null}>{alert?.message}</Alert>
+        </Container>
+    )
+}